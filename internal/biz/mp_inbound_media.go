@@ -0,0 +1,261 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/message"
+	"github.com/seth16888/wxbusiness/internal/model"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.uber.org/zap"
+)
+
+// 媒体消息保存状态
+const (
+	InboundPending  = "pending"
+	InboundMirrored = "mirrored"
+	InboundFailed   = "failed"
+)
+
+const (
+	// inboundMirrorTimeout 保存单条消息的超时时间，视频下载较慢，不使用消息分发的超时时间
+	inboundMirrorTimeout = 2 * time.Minute
+	inboundMediaTTL      = 3 * 24 * time.Hour // 消息中 MediaId 的有效期
+	inboundMaxAttempts   = 5                  // 最多下载次数
+	inboundRetryBatch    = 100                // 每次定时任务最多重试的数量
+	inboundWorkers       = 4                  // 下载媒体消息的并发数
+	inboundQueueSize     = 256                // 等待下载的消息数，队列已满时留给定时任务重试
+)
+
+// inboundMsgTypes 需要保存的消息类型
+var inboundMsgTypes = []message.MsgType{
+	message.MsgTypeImage, message.MsgTypeVoice, message.MsgTypeVideo, message.MsgTypeShortVideo,
+}
+
+type InboundMediaRepo interface {
+	// Create 创建记录，消息重试推送时已存在，返回 false
+	Create(c context.Context, media *entities.InboundMedia) (bool, error)
+	Update(c context.Context, media *entities.InboundMedia) error
+	Get(c context.Context, appId string, id string) (*entities.InboundMedia, error)
+	Query(c context.Context, appId string,
+		params *request.InboundMediaQuery) (*model.PageResult[*entities.InboundMedia], error)
+	// FindByMembers 粉丝的全部媒体消息，用于删除粉丝数据
+	FindByMembers(c context.Context, targets []*ErasureTarget) ([]*entities.InboundMedia, error)
+	DeleteMany(c context.Context, ids []string) (int64, error)
+	// FindRetry receivedAfter 之后收到且下载次数少于 maxAttempts 的记录，包括保存失败的记录
+	// 和 pendingBefore 之前创建仍未下载的记录(队列已满或服务重启)
+	FindRetry(c context.Context, appId string, receivedAfter, pendingBefore int64, maxAttempts int,
+		limit int64) ([]*entities.InboundMedia, error)
+}
+
+// InboundMediaUsecase 保存粉丝发送的图片、语音、视频
+//
+// 粉丝消息中的 MediaId 3天后失效，收到消息时下载到素材存储，便于客服之后查看。
+// 下载较慢，由单独的 worker 执行，不占用消息分发的 worker。
+type InboundMediaUsecase struct {
+	log        *zap.Logger
+	repo       InboundMediaRepo
+	materialUc *MaterialUsecase
+	queue      chan *entities.InboundMedia
+}
+
+func NewInboundMediaUsecase(log *zap.Logger, repo InboundMediaRepo,
+	materialUc *MaterialUsecase,
+) *InboundMediaUsecase {
+	u := &InboundMediaUsecase{log: log, repo: repo, materialUc: materialUc,
+		queue: make(chan *entities.InboundMedia, inboundQueueSize)}
+	for i := 0; i < inboundWorkers; i++ {
+		go u.worker()
+	}
+	return u
+}
+
+// worker 下载队列中的媒体消息
+func (u *InboundMediaUsecase) worker() {
+	for doc := range u.queue {
+		// 等待过久的消息已由定时任务重试
+		if time.Since(time.Unix(doc.UpdatedAt, 0)) > inboundMirrorTimeout {
+			continue
+		}
+		c, cancel := context.WithTimeout(context.Background(), inboundMirrorTimeout)
+		u.mirror(c, doc)
+		cancel()
+		if err := u.repo.Update(context.Background(), doc); err != nil {
+			u.log.Error("update inbound media error", zap.Error(err))
+		}
+	}
+}
+
+// OnMessage 实现 message.Subscriber，记录媒体消息并加入下载队列
+func (u *InboundMediaUsecase) OnMessage(c context.Context, app *entities.PlatformApp,
+	msg *message.MixMessage,
+) error {
+	if msg.MediaId == "" || !slices.Contains(inboundMsgTypes, msg.MsgType) {
+		return nil
+	}
+
+	now := time.Now().Unix()
+	doc := &entities.InboundMedia{
+		AppId:      app.ID.Hex(),
+		MpId:       app.MpId,
+		OpenId:     msg.GetOpenID(),
+		MsgId:      msg.MsgId,
+		MsgType:    string(msg.MsgType),
+		MediaId:    msg.MediaId,
+		MediaId16K: msg.MediaId16K,
+		Format:     msg.Format,
+		Status:     InboundPending,
+		ReceivedAt: msg.CreateTime,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	created, err := u.repo.Create(c, doc)
+	if err != nil || !created {
+		return err
+	}
+
+	select {
+	case u.queue <- doc:
+	default:
+		u.log.Warn("inbound media queue full", zap.String("appId", doc.AppId),
+			zap.Int64("msgId", doc.MsgId))
+	}
+	return nil
+}
+
+// mirror 下载文件，结果保存在 doc 中
+func (u *InboundMediaUsecase) mirror(c context.Context, doc *entities.InboundMedia) {
+	name := path.Join(doc.AppId, "inbound", strconv.FormatInt(doc.MsgId, 10))
+	file, err := u.materialUc.MirrorMedia(c, doc.AppId, doc.MpId, doc.MediaId, name)
+	doc.Attempts++
+	doc.UpdatedAt = time.Now().Unix()
+	if err != nil {
+		u.log.Error("mirror inbound media error", zap.String("appId", doc.AppId),
+			zap.Int64("msgId", doc.MsgId), zap.Error(err))
+		doc.Status = InboundFailed
+		doc.Error = err.Error()
+		return
+	}
+	doc.Status = InboundMirrored
+	doc.Error = ""
+	doc.StorageKey = file.Key
+	doc.ContentType = file.ContentType
+	doc.Size = file.Size
+
+	// 16K 语音保存失败不影响普通语音
+	if doc.MediaId16K != "" {
+		file, err := u.materialUc.MirrorMedia(c, doc.AppId, doc.MpId, doc.MediaId16K, name+"_16k")
+		if err != nil {
+			u.log.Error("mirror inbound 16k voice error", zap.String("appId", doc.AppId),
+				zap.Int64("msgId", doc.MsgId), zap.Error(err))
+			return
+		}
+		doc.StorageKey16K = file.Key
+	}
+}
+
+// RetryFailed 重新保存失败和未下载的媒体消息，返回保存成功的数量
+//
+// 只重试 MediaId 仍在有效期内的消息，每条最多下载 inboundMaxAttempts 次。
+// 未下载的消息创建超过 inboundMirrorTimeout 后才重试，避免与队列中的下载重复。
+func (u *InboundMediaUsecase) RetryFailed(c context.Context, appId string) (int, error) {
+	now := time.Now()
+	receivedAfter := now.Add(-inboundMediaTTL).Unix()
+	pendingBefore := now.Add(-inboundMirrorTimeout).Unix()
+	docs, err := u.repo.FindRetry(c, appId, receivedAfter, pendingBefore, inboundMaxAttempts,
+		inboundRetryBatch)
+	if err != nil {
+		u.log.Error("find failed inbound media error", zap.Error(err))
+		return 0, fmt.Errorf("find failed inbound media error")
+	}
+
+	mirrored := 0
+	for _, doc := range docs {
+		if err := c.Err(); err != nil {
+			return mirrored, err
+		}
+		mc, cancel := context.WithTimeout(c, inboundMirrorTimeout)
+		u.mirror(mc, doc)
+		cancel()
+		if err := u.repo.Update(c, doc); err != nil {
+			u.log.Error("update inbound media error", zap.Error(err))
+			return mirrored, fmt.Errorf("update inbound media error")
+		}
+		if doc.Status == InboundMirrored {
+			mirrored++
+		}
+	}
+	u.log.Info("inbound media retried", zap.String("appId", appId),
+		zap.Int("total", len(docs)), zap.Int("mirrored", mirrored))
+	return mirrored, nil
+}
+
+// Query 媒体消息列表
+func (u *InboundMediaUsecase) Query(c context.Context, appId string,
+	params *request.InboundMediaQuery,
+) (*model.PageResult[*entities.InboundMedia], error) {
+	result, err := u.repo.Query(c, appId, params)
+	if err != nil {
+		u.log.Error("query inbound media error", zap.Error(err))
+		return nil, fmt.Errorf("query inbound media error")
+	}
+	return result, nil
+}
+
+// Open 读取已保存的文件，hd 为 true 时读取 16K 语音
+func (u *InboundMediaUsecase) Open(c context.Context, appId string, id string, hd bool,
+) (*MediaContent, error) {
+	doc, err := u.repo.Get(c, appId, id)
+	if err != nil {
+		return nil, fmt.Errorf("media not found")
+	}
+	key := doc.StorageKey
+	if hd {
+		key = doc.StorageKey16K
+	}
+	if key == "" {
+		return nil, fmt.Errorf("media not mirrored")
+	}
+	body, err := u.materialUc.OpenStored(c, key)
+	if err != nil {
+		u.log.Error("open inbound media error", zap.String("key", key), zap.Error(err))
+		return nil, fmt.Errorf("open media error")
+	}
+	content := &MediaContent{
+		ContentType: doc.ContentType,
+		Filename:    path.Base(key),
+		Size:        -1,
+		Body:        body,
+	}
+	if !hd {
+		content.Size = doc.Size
+	}
+	return content, nil
+}
+
+// Erase 删除粉丝的媒体消息及文件，dryRun 时只统计数量
+func (u *InboundMediaUsecase) Erase(c context.Context, targets []*ErasureTarget, dryRun bool,
+) (int64, error) {
+	docs, err := u.repo.FindByMembers(c, targets)
+	if err != nil {
+		return 0, err
+	}
+	if dryRun || len(docs) == 0 {
+		return int64(len(docs)), nil
+	}
+	ids := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		for _, key := range []string{doc.StorageKey, doc.StorageKey16K} {
+			if key != "" {
+				u.materialUc.discard(c, key)
+			}
+		}
+		ids = append(ids, doc.ID.Hex())
+	}
+	return u.repo.DeleteMany(c, ids)
+}
@@ -2,16 +2,22 @@ package biz
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
+	"net/http"
+	"path"
 	"time"
 
 	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model"
 	"github.com/seth16888/wxbusiness/internal/model/request"
 	"github.com/seth16888/wxbusiness/pkg/material"
 	"github.com/seth16888/wxcommon/domain"
-	"github.com/seth16888/wxcommon/hc"
+	"github.com/seth16888/wxcommon/helpers"
 	"github.com/seth16888/wxcommon/mp"
 	"github.com/seth16888/wxcommon/paths"
 	v1 "github.com/seth16888/wxproxy/api/v1"
@@ -22,16 +28,174 @@ type MaterialRepo interface {
 	Insert(c context.Context, material *entities.MPMaterial) error
 	Find(c context.Context, appId string, IsPermanent bool, mediaType string,
 		pageNo int64, pageSize int64) ([]*entities.MPMaterial, error)
-  SaveMany(c context.Context, materials []*entities.MPMaterial) error
+	// SaveMany 保存从微信同步的素材，按 media_id 和 article_idx 更新，不存在时插入，
+	// 只更新微信返回的字段，保留本地上传时记录的存储key等信息
+	SaveMany(c context.Context, materials []*entities.MPMaterial) error
+	// FindStale 同步开始前已存在、本次同步未更新的永久素材，即微信中已删除的素材
+	FindStale(c context.Context, appId string, mediaType string, syncedAt int64) ([]*entities.MPMaterial, error)
+	SaveCount(c context.Context, count *entities.MaterialCount) error
+	// GetCount 未同步过时返回 nil
+	GetCount(c context.Context, appId string) (*entities.MaterialCount, error)
+	// FindByHash 查找内容相同的素材，临时素材需在 validAfter 之后过期，未找到时返回 nil
+	FindByHash(c context.Context, appId string, mediaType string, isPermanent bool,
+		hash string, validAfter int64) (*entities.MPMaterial, error)
+	// Get 按素材ID或 media_id 查找
+	Get(c context.Context, appId string, ref string) (*entities.MPMaterial, error)
+	// FindExpiring 在 before 之前过期、有源文件且在 usedAfter 之后被引用过的临时素材，
+	// 按最近一次尝试重新上传的时间排序
+	FindExpiring(c context.Context, appId string, before int64, usedAfter int64,
+		limit int64) ([]*entities.MPMaterial, error)
+	// UpdateMedia 重新上传后更新 media_id 和有效期，保留之前的 media_id
+	UpdateMedia(c context.Context, material *entities.MPMaterial, previousMediaId string) error
+	// MarkRefreshFailed 记录重新上传失败的时间，下次优先处理其他素材
+	MarkRefreshFailed(c context.Context, id string, ts int64) error
+	// MarkUsed 记录临时素材被引用的时间
+	MarkUsed(c context.Context, id string, ts int64) error
+	// PurgeExpired 删除 before 之前已过期且没有源文件的临时素材
+	PurgeExpired(c context.Context, appId string, before int64) (int64, error)
+	Delete(c context.Context, id string) error
+	// CountPermanent 统计 createdAfter 之后创建的永久素材数，图文素材按 media_id 统计，
+	// uploadedOnly 为 true 时只统计通过本服务上传的素材
+	CountPermanent(c context.Context, appId string, mediaTypes []string, createdAfter int64,
+		uploadedOnly bool) (int64, error)
+	// FindByMediaId 永久素材的全部记录，图文素材每篇文章一条
+	FindByMediaId(c context.Context, appId string, mediaId string) ([]*entities.MPMaterial, error)
+	// FindNewsThumbs 设置了封面的图文素材文章
+	FindNewsThumbs(c context.Context, appId string) ([]*entities.MPMaterial, error)
+	// FindUnused media_id 不在 exclude 中的永久素材
+	FindUnused(c context.Context, appId string, mediaTypes []string, exclude []string,
+		pageNo int64, pageSize int64) (*model.PageResult[*entities.MPMaterial], error)
+}
+
+// StoredFile 已保存到素材存储的文件
+type StoredFile struct {
+	Key         string // 存储key
+	Hash        string // 文件内容的 SHA-256
+	Size        int64
+	ContentType string
 }
 
 type MaterialUsecase struct {
 	log      *zap.Logger
 	repo     MaterialRepo
 	apiProxy *APIProxyUsecase
-	hc       *hc.Client
+	storage  material.Storage
+	quota    *MaterialQuotaUsecase
+	refUc    *MaterialRefUsecase
+	// 上传、下载素材文件使用，视频等文件耗时较长，不设置总超时，由 context 控制
+	mediaClient *http.Client
+}
+
+// Store 保存上传的文件，同时计算文件内容的 SHA-256
+func (m *MaterialUsecase) Store(c context.Context, appId string, ext string,
+	r io.Reader, size int64, contentType string,
+) (*StoredFile, error) {
+	return m.put(c, path.Join(appId, helpers.UUID()+ext), r, size, contentType)
+}
+
+// put 保存文件到 key，同时计算文件内容的 SHA-256
+func (m *MaterialUsecase) put(c context.Context, key string, r io.Reader, size int64,
+	contentType string,
+) (*StoredFile, error) {
+	h := &hashCounter{Hash: sha256.New()}
+	if err := m.storage.Put(c, key, io.TeeReader(r, h), size, contentType); err != nil {
+		m.log.Error("store material file error", zap.Error(err))
+		return nil, fmt.Errorf("store file error")
+	}
+	return &StoredFile{
+		Key:         key,
+		Hash:        hex.EncodeToString(h.Sum(nil)),
+		Size:        h.n,
+		ContentType: contentType,
+	}, nil
+}
+
+// hashCounter 计算哈希的同时统计字节数，用于大小未知的文件
+type hashCounter struct {
+	hash.Hash
+	n int64
+}
+
+func (h *hashCounter) Write(p []byte) (int, error) {
+	h.n += int64(len(p))
+	return h.Hash.Write(p)
+}
+
+// findDuplicate 查找内容相同的有效素材，找到时删除本次保存的文件
+//
+// force 为 true 时不去重，查询出错时按未找到处理
+func (m *MaterialUsecase) findDuplicate(c context.Context, appId string, mediaType string,
+	isPermanent bool, file *StoredFile, force bool,
+) *entities.MPMaterial {
+	if force || file.Hash == "" {
+		return nil
+	}
+	var validAfter int64
+	if !isPermanent {
+		validAfter = time.Now().Add(temporaryRefreshAhead).Unix()
+	}
+	doc, err := m.repo.FindByHash(c, appId, mediaType, isPermanent, file.Hash, validAfter)
+	if err != nil {
+		m.log.Error("find material by hash error", zap.Error(err))
+		return nil
+	}
+	if doc == nil {
+		return nil
+	}
+	m.log.Debug("duplicate material", zap.String("appId", appId),
+		zap.String("mediaId", doc.MediaId), zap.String("hash", file.Hash))
+	m.discard(c, file.Key)
+	return doc
+}
+
+// videoDescription 视频素材的描述
+type videoDescription struct {
+	Title        string `json:"title"`
+	Introduction string `json:"introduction"`
+}
+
+// upload 从存储中读取文件，边读边上传到微信
+//
+// description 不为空时作为 JSON 字段一起上传
+func (m *MaterialUsecase) upload(c context.Context, url string, filename string, key string,
+	description *videoDescription,
+) ([]byte, error) {
+	size, err := m.storage.Size(c, key)
+	if err != nil {
+		return nil, fmt.Errorf("stat stored file error: %w", err)
+	}
+	file, err := m.storage.Open(c, key)
+	if err != nil {
+		return nil, fmt.Errorf("open stored file error: %w", err)
+	}
+	defer file.Close()
+
+	fields := []material.MultipartFormField{
+		{IsFile: true, Fieldname: "media", Filename: filename, FileReader: file, Size: size},
+	}
+	if description != nil {
+		field, err := material.JSONField("description", description)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	progress := func(sent, total int64) {
+		if sent == total {
+			m.log.Debug("upload file sent", zap.String("key", key), zap.Int64("bytes", sent))
+		}
+	}
+	return material.PostMultipartForm(c, fields, url, m.mediaClient, progress)
 }
 
+// discard 上传微信失败时删除已保存的文件
+func (m *MaterialUsecase) discard(c context.Context, key string) {
+	if err := m.storage.Delete(c, key); err != nil {
+		m.log.Error("delete stored file error", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// Pull 拉取一页永久素材
 func (m *MaterialUsecase) Pull(c context.Context, appId string, req *request.PullMaterialReq) error {
 	mpIdVar := c.Value("MP_ID")
 	if mpIdVar == nil {
@@ -46,26 +210,48 @@ func (m *MaterialUsecase) Pull(c context.Context, appId string, req *request.Pul
 		return fmt.Errorf("get access token error")
 	}
 
-	if req.Type == "news" {
-		return m.pullNewsList(c, token, req, appId, mpId)
+	docs, _, err := m.listPage(c, token, req.Type, req.Offset, req.Count, appId, mpId)
+	if err != nil {
+		return err
+	}
+	// save to db
+	if len(docs) > 0 {
+		err = m.repo.SaveMany(c, docs)
+		if err != nil {
+			m.log.Error("save material list error", zap.Error(err))
+			return fmt.Errorf("save material list error")
+		}
+	}
+	return nil
+}
+
+// listPage 获取一页永久素材，返回素材和本页的素材数，图文素材每篇文章一条记录
+func (m *MaterialUsecase) listPage(c context.Context, token string, mediaType string,
+	offset int64, count int64, appId string, mpId string,
+) ([]*entities.MPMaterial, int, error) {
+	if mediaType == "news" {
+		return m.pullNewsList(c, token, offset, count, appId, mpId)
 	}
-	return m.pullMediaList(c, token, req, appId, mpId)
+	return m.pullMediaList(c, token, mediaType, offset, count, appId, mpId)
 }
 
-func (m *MaterialUsecase) pullNewsList(c context.Context, token string, req *request.PullMaterialReq, appId string, mpId string) error {
+func (m *MaterialUsecase) pullNewsList(c context.Context, token string, offset int64, count int64,
+	appId string, mpId string,
+) ([]*entities.MPMaterial, int, error) {
 	params := &v1.GetMaterialListRequest{
 		AccessToken: token,
-		Offset:      req.Offset,
-		Count:       req.Count,
-		Type:        req.Type,
+		Offset:      offset,
+		Count:       count,
+		Type:        "news",
 	}
 	stream, err := m.apiProxy.cli.GetMaterialNewsList(c, params)
 	if err != nil {
 		m.log.Error("get material news list error", zap.Error(err))
-		return fmt.Errorf("get material news list error")
+		return nil, 0, fmt.Errorf("get material news list error")
 	}
 
 	var docs []*entities.MPMaterial
+	items := 0
 	for {
 		resp, err := stream.Recv()
 		if err == io.EOF {
@@ -73,20 +259,22 @@ func (m *MaterialUsecase) pullNewsList(c context.Context, token string, req *req
 		}
 		if err != nil {
 			m.log.Error("receive material news list error", zap.Error(err))
-			return fmt.Errorf("receive material news list error")
+			return nil, 0, fmt.Errorf("receive material news list error")
 		}
 		if resp == nil || resp.Item == nil {
 			continue
 		}
 		// 转换为MPMaterial
 		for _, item := range resp.Item {
-			for _, article := range item.Articles {
+			items++
+			for idx, article := range item.Articles {
 				doc := &entities.MPMaterial{
 					AppId:            appId,
 					MpId:             mpId,
 					Type:             "news",
 					IsPermanent:      true,
 					MediaId:          item.MediaId,
+					ArticleIdx:       idx,
 					ThumbMediaId:     article.ThumbMediaId,
 					Title:            article.Title,
 					Content:          article.Content,
@@ -94,7 +282,6 @@ func (m *MaterialUsecase) pullNewsList(c context.Context, token string, req *req
 					CreatedAt:        item.UpdateTime,
 					UpdatedAt:        item.UpdateTime,
 					Filename:         "",
-					Path:             "",
 					Author:           article.Author,
 					Digest:           article.Digest,
 					ShowCoverPic:     article.ShowCoverPic,
@@ -104,28 +291,22 @@ func (m *MaterialUsecase) pullNewsList(c context.Context, token string, req *req
 			}
 		}
 	}
-	// save to db
-	if len(docs) > 0 {
-		err = m.repo.SaveMany(c, docs)
-		if err!= nil {
-			m.log.Error("save material news list error", zap.Error(err))
-			return fmt.Errorf("save material news list error")
-		}
-	}
-	return nil
+	return docs, items, nil
 }
 
-func (m *MaterialUsecase) pullMediaList(c context.Context, token string, req *request.PullMaterialReq, appId string, mpId string) error {
+func (m *MaterialUsecase) pullMediaList(c context.Context, token string, mediaType string,
+	offset int64, count int64, appId string, mpId string,
+) ([]*entities.MPMaterial, int, error) {
 	params := &v1.GetMaterialListRequest{
 		AccessToken: token,
-		Offset:      req.Offset,
-		Count:       req.Count,
-		Type:        req.Type,
+		Offset:      offset,
+		Count:       count,
+		Type:        mediaType,
 	}
 	stream, err := m.apiProxy.cli.GetMaterialList(c, params)
 	if err != nil {
 		m.log.Error("get material list error", zap.Error(err))
-		return fmt.Errorf("get material list error")
+		return nil, 0, fmt.Errorf("get material list error")
 	}
 
 	var docs []*entities.MPMaterial
@@ -135,8 +316,8 @@ func (m *MaterialUsecase) pullMediaList(c context.Context, token string, req *re
 			break
 		}
 		if err != nil {
-			m.log.Error("receive material news list error", zap.Error(err))
-			return fmt.Errorf("receive material news list error")
+			m.log.Error("receive material list error", zap.Error(err))
+			return nil, 0, fmt.Errorf("receive material list error")
 		}
 		if resp == nil || resp.Item == nil {
 			continue
@@ -146,7 +327,7 @@ func (m *MaterialUsecase) pullMediaList(c context.Context, token string, req *re
 			doc := &entities.MPMaterial{
 				AppId:       appId,
 				MpId:        mpId,
-				Type:        req.Type,
+				Type:        mediaType,
 				IsPermanent: true,
 				MediaId:     item.MediaId,
 				CreatedAt:   item.UpdateTime,
@@ -157,23 +338,28 @@ func (m *MaterialUsecase) pullMediaList(c context.Context, token string, req *re
 			docs = append(docs, doc)
 		}
 	}
-	// save to db
-  if len(docs) > 0 {
-		err = m.repo.SaveMany(c, docs)
-		if err!= nil {
-			m.log.Error("save material news list error", zap.Error(err))
-			return fmt.Errorf("save material news list error")
-		}
-	}
-	return nil
+	return docs, len(docs), nil
 }
 
 // UploadNewsImage 上传图文素材图片
 //
+// file 为 Store 返回的文件，已上传过相同图片时直接返回其url，force 为 true 时重新上传
+//
 // 返回: url
 func (m *MaterialUsecase) UploadNewsImage(c context.Context, appId string,
-	filename string, path string,
+	filename string, file *StoredFile, force bool,
 ) (string, error) {
+	if doc := m.findDuplicate(c, appId, "ArticleImage", true, file, force); doc != nil {
+		return doc.URL, nil
+	}
+	// 之后任一步骤失败都删除已保存的文件，保存素材记录后保留
+	keep := false
+	defer func() {
+		if !keep {
+			m.discard(c, file.Key)
+		}
+	}()
+
 	mpIdVar := c.Value("MP_ID")
 	if mpIdVar == nil {
 		m.log.Error("get mp id error")
@@ -195,7 +381,7 @@ func (m *MaterialUsecase) UploadNewsImage(c context.Context, appId string,
 	m.log.Debug("UploadNewsImage", zap.String("url", url))
 
 	var respBytes []byte
-	respBytes, err = material.UploadFile("media", path, url, m.hc)
+	respBytes, err = m.upload(c, url, filename, file.Key, nil)
 	if err != nil {
 		m.log.Debug("UploadNewsImage", zap.Error(err))
 		return "", fmt.Errorf("upload file error")
@@ -226,7 +412,8 @@ func (m *MaterialUsecase) UploadNewsImage(c context.Context, appId string,
 		ThumbMediaId: "",
 		URL:          resultVar.URL,
 		Filename:     filename,
-		Path:         path,
+		StorageKey:   file.Key,
+		Hash:         file.Hash,
 		CreatedAt:    now,
 		UpdatedAt:    now,
 	}
@@ -235,7 +422,8 @@ func (m *MaterialUsecase) UploadNewsImage(c context.Context, appId string,
 		m.log.Error("insert material error", zap.Error(err))
 		return "", fmt.Errorf("insert material error")
 	}
-	return url, nil
+	keep = true
+	return resultVar.URL, nil
 }
 
 // GetMaterialList 返回素材列表-永久素材
@@ -252,10 +440,30 @@ func (m *MaterialUsecase) GetMaterialList(c context.Context, appId string,
 // 视频素材的标题 title，不超过128个字节，超过会自动截断,
 // 视频素材的描述 introduction，不超过512个字节，超过会自动截断
 //
+// file 为 Store 返回的文件，已上传过相同内容的同类素材时直接返回，force 为 true 时重新上传，
+// 素材数量或每日上传次数达到上限时返回 ErrMaterialQuota
+//
 // 返回: media_id, url(只有图片素材有)
 func (m *MaterialUsecase) UploadMedia(c context.Context, appId string,
-	mediaType string, filename string, path string, videoTitle string, videoIntro string,
+	mediaType string, filename string, file *StoredFile, force bool,
+	videoTitle string, videoIntro string,
 ) (*mp.UploadMaterialRes, error) {
+	if doc := m.findDuplicate(c, appId, mediaType, true, file, force); doc != nil {
+		return &mp.UploadMaterialRes{MediaID: doc.MediaId, Url: doc.URL}, nil
+	}
+	// 之后任一步骤失败都删除已保存的文件，保存素材记录后保留
+	keep := false
+	defer func() {
+		if !keep {
+			m.discard(c, file.Key)
+		}
+	}()
+
+	// 相同内容的素材不占用配额，去重后再检查
+	if err := m.quota.Check(c, appId, mediaType); err != nil {
+		return nil, err
+	}
+
 	mpIdVar := c.Value("MP_ID")
 	if mpIdVar == nil {
 		m.log.Error("get mp id error")
@@ -277,36 +485,14 @@ func (m *MaterialUsecase) UploadMedia(c context.Context, appId string,
 	)
 	m.log.Debug("UploadMedia", zap.String("url", url))
 
-	var respBytes []byte
+	var description *videoDescription
 	if mediaType == "video" {
-		videoForm := fmt.Sprintf(`{"title":"%s","introduction":"%s"}`, videoTitle, videoIntro)
-		fields := []material.MultipartFormField{
-			{
-				IsFile:    true,
-				Fieldname: "media",
-				FilePath:  path,
-				Filename:  filename,
-				Value:     nil,
-			},
-			{
-				IsFile:    false,
-				Fieldname: "description",
-				FilePath:  "",
-				Filename:  "",
-				Value:     []byte(videoForm),
-			},
-		}
-		respBytes, err = material.PostMultipartForm(fields, url, m.hc)
-		if err != nil {
-			m.log.Debug("UploadMedia(video)", zap.Error(err))
-			return nil, fmt.Errorf("upload video file error")
-		}
-	} else {
-		respBytes, err = material.UploadFile("media", path, url, m.hc)
-		if err != nil {
-			m.log.Debug("UploadMedia", zap.Error(err))
-			return nil, fmt.Errorf("upload media file error")
-		}
+		description = &videoDescription{Title: videoTitle, Introduction: videoIntro}
+	}
+	respBytes, err := m.upload(c, url, filename, file.Key, description)
+	if err != nil {
+		m.log.Debug("UploadMedia", zap.String("type", mediaType), zap.Error(err))
+		return nil, fmt.Errorf("upload media file error")
 	}
 	// 返回结果
 	type result struct {
@@ -334,7 +520,8 @@ func (m *MaterialUsecase) UploadMedia(c context.Context, appId string,
 		ThumbMediaId: "",
 		URL:          resultVar.Url,
 		Filename:     filename,
-		Path:         path,
+		StorageKey:   file.Key,
+		Hash:         file.Hash,
 		CreatedAt:    now,
 		UpdatedAt:    now,
 	}
@@ -343,15 +530,33 @@ func (m *MaterialUsecase) UploadMedia(c context.Context, appId string,
 		m.log.Error("insert material error", zap.Error(err))
 		return nil, fmt.Errorf("insert material error")
 	}
+	keep = true
 	return &resultVar.UploadMaterialRes, nil
 }
 
 // UploadTemporaryMedia 上传临时素材
 //
-// 3天后过期
+// 3天后过期，file 为 Store 返回的文件，相同内容的临时素材未过期时直接返回，force 为 true 时重新上传
 func (m *MaterialUsecase) UploadTemporaryMedia(c context.Context,
-	appId string, mediaType string, filename string, path string,
+	appId string, mediaType string, filename string, file *StoredFile, force bool,
 ) (*mp.UploadMediaRes, error) {
+	if doc := m.findDuplicate(c, appId, mediaType, false, file, force); doc != nil {
+		return &mp.UploadMediaRes{
+			Type:         doc.Type,
+			MediaID:      doc.MediaId,
+			ThumbMediaId: doc.ThumbMediaId,
+			URL:          doc.URL,
+			CreatedAt:    doc.CreatedAt,
+		}, nil
+	}
+	// 之后任一步骤失败都删除已保存的文件，保存素材记录后保留
+	keep := false
+	defer func() {
+		if !keep {
+			m.discard(c, file.Key)
+		}
+	}()
+
 	mpIdVar := c.Value("MP_ID")
 	if mpIdVar == nil {
 		m.log.Error("get mp id error")
@@ -359,77 +564,75 @@ func (m *MaterialUsecase) UploadTemporaryMedia(c context.Context,
 	}
 	mpId := mpIdVar.(string)
 
-	token, err := m.apiProxy.GetAccessToken(c, appId, mpId)
-	if err != nil {
-		m.log.Error("get access token error", zap.Error(err))
-		return nil, fmt.Errorf("get access token error")
-	}
-
-	url := fmt.Sprintf("https://%s%s?access_token=%s&type=%s",
-		domain.GetWXAPIDomain(),
-		paths.Path_Upload_Temporary_Media,
-		token,
-		mediaType,
-	)
-	m.log.Debug("UploadTemporaryMedia", zap.String("url", url))
-
-	var respBytes []byte
-	respBytes, err = material.UploadFile("media", path, url, m.hc)
-	if err != nil {
-		m.log.Debug("UploadTemporaryMedia", zap.Error(err))
-		return nil, fmt.Errorf("upload file error")
-	}
-	// 返回结果
-	type result struct {
-		mp.WXError
-		mp.UploadMediaRes
-	}
-	var resultVar result
-	err = json.Unmarshal(respBytes, &resultVar)
+	res, err := m.postTemporary(c, appId, mpId, mediaType, filename, file.Key)
 	if err != nil {
-		m.log.Debug("UploadTemporaryMedia", zap.Error(err))
-		return nil, fmt.Errorf("unmarshal response error")
-	}
-	if resultVar.ErrCode != 0 { // business error
-		m.log.Error("UploadTemporaryMedia", zap.Any("ApiError", resultVar))
-		return nil, fmt.Errorf("upload file error: %d %s", resultVar.ErrCode, resultVar.ErrMsg)
+		return nil, err
 	}
 
 	doc := entities.MPMaterial{
 		AppId:        appId,
 		MpId:         mpId,
-		Type:         resultVar.Type,
+		Type:         res.Type,
 		IsPermanent:  false,
-		MediaId:      resultVar.MediaID,
-		ThumbMediaId: resultVar.ThumbMediaId,
-		URL:          resultVar.URL,
+		MediaId:      res.MediaID,
+		ThumbMediaId: res.ThumbMediaId,
+		URL:          res.URL,
 		Filename:     filename,
-		Path:         path,
-		CreatedAt:    resultVar.CreatedAt,
-		UpdatedAt:    resultVar.CreatedAt,
+		StorageKey:   file.Key,
+		Hash:         file.Hash,
+		CreatedAt:    res.CreatedAt,
+		UpdatedAt:    res.CreatedAt,
+		ExpiresAt:    temporaryExpiresAt(res.CreatedAt),
+		LastUsedAt:   res.CreatedAt,
 	}
 	err = m.repo.Insert(c, &doc)
 	if err != nil {
 		m.log.Error("insert material error", zap.Error(err))
 		return nil, fmt.Errorf("insert material error")
 	}
+	keep = true
+
+	return res, nil
+}
 
-	return &resultVar.UploadMediaRes, nil
+// MaterialDeleteResult 删除永久素材的结果
+type MaterialDeleteResult struct {
+	MediaId string                  `json:"media_id"`
+	Removed int                     `json:"removed"` // 删除的本地记录数
+	Refs    []*entities.MaterialRef `json:"refs"`    // 引用该素材的位置，强制删除后这些引用将失效
 }
 
-// DeleteMaterial 删除素材(永久)
-func (m *MaterialUsecase) DeleteMaterial(c context.Context, appId string, mediaId string) error {
+// DeleteMaterial 删除永久素材，同时删除本地记录和保存的文件
+//
+// 素材仍被菜单或图文封面引用时返回 ErrMaterialInUse 和引用位置，force 为 true 时仍然删除，
+// 自动回复、草稿中的引用不做检查。微信中素材已不存在时只删除本地记录
+func (m *MaterialUsecase) DeleteMaterial(c context.Context, appId string, mediaId string,
+	force bool,
+) (*MaterialDeleteResult, error) {
+	// 索引可能未建立或已过期，检查前先从各模块更新，更新失败时不删除
+	if _, err := m.refUc.Rebuild(c, appId); err != nil {
+		return nil, err
+	}
+	refs, err := m.refUc.Find(c, appId, mediaId)
+	if err != nil {
+		return nil, err
+	}
+	result := &MaterialDeleteResult{MediaId: mediaId, Refs: refs}
+	if len(refs) > 0 && !force {
+		return result, ErrMaterialInUse
+	}
+
 	mpIdVar := c.Value("MP_ID")
 	if mpIdVar == nil {
 		m.log.Error("get mp id error")
-		return fmt.Errorf("get mp id error")
+		return nil, fmt.Errorf("get mp id error")
 	}
 	mpId := mpIdVar.(string)
 
 	token, err := m.apiProxy.GetAccessToken(c, appId, mpId)
 	if err != nil {
 		m.log.Error("get access token error", zap.Error(err))
-		return fmt.Errorf("get access token error")
+		return nil, fmt.Errorf("get access token error")
 	}
 
 	params := &v1.DeleteMaterialReq{
@@ -439,17 +642,63 @@ func (m *MaterialUsecase) DeleteMaterial(c context.Context, appId string, mediaI
 	wxErr, err := m.apiProxy.cli.DeleteMaterial(c, params)
 	if err != nil {
 		m.log.Error("DeleteMaterial error", zap.Error(err))
-		return fmt.Errorf("DeleteMaterial error")
+		return nil, fmt.Errorf("DeleteMaterial error")
 	}
-	if wxErr.Errcode != 0 {
+	if wxErr.Errcode != 0 && wxErr.Errcode != errCodeInvalidMediaId {
 		m.log.Error("DeleteMaterial", zap.Any("ApiError", wxErr))
-		return fmt.Errorf("DeleteMaterial error: %d %s", wxErr.Errcode, wxErr.Errmsg)
+		return nil, fmt.Errorf("DeleteMaterial error: %d %s", wxErr.Errcode, wxErr.Errmsg)
 	}
-	return nil
+	if len(refs) > 0 {
+		m.log.Warn("referenced material deleted", zap.String("appId", appId),
+			zap.String("mediaId", mediaId), zap.Any("refs", refs))
+	}
+
+	// 删除本地记录
+	docs, err := m.repo.FindByMediaId(c, appId, mediaId)
+	if err != nil {
+		m.log.Error("find material error", zap.Error(err))
+		return nil, fmt.Errorf("delete local material error")
+	}
+	news := false
+	for _, doc := range docs {
+		if err := m.repo.Delete(c, doc.ID.Hex()); err != nil {
+			m.log.Error("delete material error", zap.Error(err))
+			return nil, fmt.Errorf("delete local material error")
+		}
+		if doc.StorageKey != "" {
+			m.discard(c, doc.StorageKey)
+		}
+		news = news || doc.Type == "news"
+		result.Removed++
+	}
+	if news {
+		m.refUc.Refresh(c, appId, m)
+	}
+	return result, nil
+}
+
+// MaterialRefSource 实现 MaterialReferrer
+func (m *MaterialUsecase) MaterialRefSource() string {
+	return MaterialRefNews
+}
+
+// MaterialRefs 实现 MaterialReferrer，返回图文素材封面引用的素材
+func (m *MaterialUsecase) MaterialRefs(c context.Context, appId string) ([]*entities.MaterialRef, error) {
+	docs, err := m.repo.FindNewsThumbs(c, appId)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]*entities.MaterialRef, 0, len(docs))
+	for _, doc := range docs {
+		refs = append(refs, &entities.MaterialRef{MediaId: doc.ThumbMediaId, Location: doc.Title})
+	}
+	return refs, nil
 }
 
 func NewMaterialUsecase(log *zap.Logger, repo MaterialRepo,
-	apiProxy *APIProxyUsecase, hc *hc.Client,
+	apiProxy *APIProxyUsecase, storage material.Storage,
+	quota *MaterialQuotaUsecase, refUc *MaterialRefUsecase,
 ) *MaterialUsecase {
-	return &MaterialUsecase{log: log, repo: repo, apiProxy: apiProxy, hc: hc}
+	return &MaterialUsecase{log: log, repo: repo, apiProxy: apiProxy, storage: storage,
+		quota: quota, refUc: refUc, mediaClient: &http.Client{}}
 }
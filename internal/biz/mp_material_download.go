@@ -0,0 +1,209 @@
+package biz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/seth16888/wxbusiness/pkg/material"
+	"github.com/seth16888/wxcommon/domain"
+	"github.com/seth16888/wxcommon/mp"
+	"go.uber.org/zap"
+)
+
+// mediaJSONLimit 微信返回 JSON 时读取的最大字节数，图文素材内容较大
+const mediaJSONLimit = 4 << 20
+
+// MediaContent 从微信下载的素材
+//
+// 图片、语音、缩略图等文件内容在 Body 中，调用方负责关闭；
+// 图文素材、视频素材微信返回 JSON（视频为标题、描述和下载地址），保存在 JSON 中。
+type MediaContent struct {
+	ContentType string
+	Filename    string
+	Size        int64 // 未知时为 -1
+	Body        io.ReadCloser
+	JSON        json.RawMessage
+}
+
+// DownloadMedia 下载素材
+//
+// permanent 为 true 时调用获取永久素材接口，否则调用获取临时素材接口，
+// 语音消息的 MediaId16K 也可以通过临时素材接口下载。
+func (m *MaterialUsecase) DownloadMedia(c context.Context, appId string, mediaId string,
+	permanent bool,
+) (*MediaContent, error) {
+	mpId, _ := c.Value("MP_ID").(string)
+	if mpId == "" {
+		m.log.Error("get mp id error")
+		return nil, fmt.Errorf("get mp id error")
+	}
+	token, err := m.apiProxy.GetAccessToken(c, appId, mpId)
+	if err != nil {
+		m.log.Error("get access token error", zap.Error(err))
+		return nil, fmt.Errorf("get access token error")
+	}
+	return m.fetchMedia(c, token, mediaId, permanent)
+}
+
+func (m *MaterialUsecase) fetchMedia(c context.Context, token string, mediaId string, permanent bool,
+) (*MediaContent, error) {
+	var req *http.Request
+	var err error
+	if permanent {
+		apiURL := fmt.Sprintf("https://%s%s?access_token=%s",
+			domain.GetWXAPIDomain(), pathGetMaterial, token)
+		body, _ := json.Marshal(map[string]string{"media_id": mediaId})
+		req, err = http.NewRequestWithContext(c, http.MethodPost, apiURL, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	} else {
+		apiURL := fmt.Sprintf("https://%s%s?access_token=%s&media_id=%s",
+			domain.GetWXAPIDomain(), pathGetMedia, token, url.QueryEscape(mediaId))
+		req, err = http.NewRequestWithContext(c, http.MethodGet, apiURL, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.mediaClient.Do(req)
+	if err != nil {
+		m.log.Error("download media error", zap.String("mediaId", mediaId), zap.Error(err))
+		return nil, fmt.Errorf("download media error")
+	}
+	return m.readMedia(resp)
+}
+
+// readMedia 解析下载结果，JSON 中包含错误码时返回错误
+func (m *MaterialUsecase) readMedia(resp *http.Response) (*MediaContent, error) {
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download media error: http status %d", resp.StatusCode)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/json" || mediaType == "text/plain" {
+		defer resp.Body.Close()
+		data, err := io.ReadAll(io.LimitReader(resp.Body, mediaJSONLimit))
+		if err != nil {
+			return nil, fmt.Errorf("read response error")
+		}
+		var wxErr mp.WXError
+		if err := json.Unmarshal(data, &wxErr); err != nil {
+			m.log.Debug("DownloadMedia", zap.Error(err))
+			return nil, fmt.Errorf("unmarshal response error")
+		}
+		if wxErr.ErrCode != 0 { // business error
+			m.log.Error("DownloadMedia", zap.Any("ApiError", wxErr))
+			return nil, fmt.Errorf("download media error: %d %s", wxErr.ErrCode, wxErr.ErrMsg)
+		}
+		return &MediaContent{ContentType: "application/json", Size: int64(len(data)), JSON: data}, nil
+	}
+
+	content := &MediaContent{
+		ContentType: contentType,
+		Size:        resp.ContentLength,
+		Body:        resp.Body,
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		content.Filename = path.Base(params["filename"])
+	}
+	return content, nil
+}
+
+// MirrorMedia 下载临时素材保存到素材存储，用于保存粉丝发送的图片、语音、视频
+//
+// 视频消息微信返回下载地址，需要再下载一次。name 为不含扩展名的存储key。
+func (m *MaterialUsecase) MirrorMedia(c context.Context, appId string, mpId string,
+	mediaId string, name string,
+) (*StoredFile, error) {
+	token, err := m.apiProxy.GetAccessToken(c, appId, mpId)
+	if err != nil {
+		m.log.Error("get access token error", zap.Error(err))
+		return nil, fmt.Errorf("get access token error")
+	}
+	content, err := m.fetchMedia(c, token, mediaId, false)
+	if err != nil {
+		return nil, err
+	}
+	if content.Body == nil {
+		var video struct {
+			VideoURL string `json:"video_url"`
+		}
+		if err := json.Unmarshal(content.JSON, &video); err != nil || video.VideoURL == "" {
+			return nil, fmt.Errorf("unexpected media response")
+		}
+		req, err := http.NewRequestWithContext(c, http.MethodGet, video.VideoURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected media response")
+		}
+		resp, err := m.mediaClient.Do(req)
+		if err != nil {
+			m.log.Error("download video error", zap.String("mediaId", mediaId), zap.Error(err))
+			return nil, fmt.Errorf("download video error")
+		}
+		if content, err = m.readMedia(resp); err != nil {
+			return nil, err
+		}
+		if content.Body == nil {
+			return nil, fmt.Errorf("unexpected media response")
+		}
+	}
+	defer content.Body.Close()
+
+	ext := strings.ToLower(filepath.Ext(content.Filename))
+	if ext == "" {
+		if exts, _ := mime.ExtensionsByType(content.ContentType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	return m.put(c, name+ext, content.Body, content.Size, content.ContentType)
+}
+
+// OpenStored 读取素材存储中的文件，调用方负责关闭
+func (m *MaterialUsecase) OpenStored(c context.Context, key string) (io.ReadCloser, error) {
+	return m.storage.Open(c, key)
+}
+
+// LoadImage 读取图片素材的源文件并解码，ref 为素材ID或 media_id
+//
+// 只支持通过本服务上传、保存了源文件的 image、thumb 素材。
+func (m *MaterialUsecase) LoadImage(c context.Context, appId string, ref string) (image.Image, error) {
+	doc, err := m.repo.Get(c, appId, ref)
+	if err != nil {
+		m.log.Error("get material error", zap.String("ref", ref), zap.Error(err))
+		return nil, fmt.Errorf("material not found")
+	}
+	if doc.Type != "image" && doc.Type != "thumb" {
+		return nil, fmt.Errorf("material is not an image")
+	}
+	if doc.StorageKey == "" {
+		return nil, fmt.Errorf("material source file not stored")
+	}
+	rc, err := m.storage.Open(c, doc.StorageKey)
+	if err != nil {
+		m.log.Error("open stored material error", zap.String("key", doc.StorageKey), zap.Error(err))
+		return nil, fmt.Errorf("open material error")
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		m.log.Error("read stored material error", zap.String("key", doc.StorageKey), zap.Error(err))
+		return nil, fmt.Errorf("read material error")
+	}
+	img, err := material.DecodeImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode material image error: %w", err)
+	}
+	return img, nil
+}
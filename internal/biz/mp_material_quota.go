@@ -0,0 +1,199 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.uber.org/zap"
+)
+
+// ErrMaterialQuota 永久素材数量或每日上传次数已达上限
+var ErrMaterialQuota = errors.New("material quota exceeded")
+
+// materialDailyUploads 每日新增永久素材的次数上限，只统计通过本服务上传的素材
+const materialDailyUploads = 1000
+
+// materialQuota 一类永久素材的数量上限，缩略图计入图片
+type materialQuota struct {
+	Type  string
+	Types []string // 计入该上限的素材类型
+	Limit int64
+}
+
+var materialQuotas = []*materialQuota{
+	{Type: "image", Types: []string{"image", "thumb"}, Limit: 100000},
+	{Type: "news", Types: []string{"news"}, Limit: 100000},
+	{Type: "voice", Types: []string{"voice"}, Limit: 1000},
+	{Type: "video", Types: []string{"video"}, Limit: 1000},
+}
+
+// materialUploadTypes 可以上传的永久素材类型
+var materialUploadTypes = []string{"image", "thumb", "voice", "video"}
+
+func quotaOf(mediaType string) *materialQuota {
+	for _, quota := range materialQuotas {
+		for _, t := range quota.Types {
+			if t == mediaType {
+				return quota
+			}
+		}
+	}
+	return nil
+}
+
+// MaterialQuotaUsage 一类永久素材的使用情况
+type MaterialQuotaUsage struct {
+	Type      string `json:"type"`
+	Limit     int64  `json:"limit"`
+	Used      int64  `json:"used"`
+	Remaining int64  `json:"remaining"`
+}
+
+// MaterialUsage 永久素材的使用情况
+type MaterialUsage struct {
+	Quotas         []*MaterialQuotaUsage   `json:"quotas"`
+	DailyLimit     int64                   `json:"daily_limit"`
+	UploadedToday  int64                   `json:"uploaded_today"`
+	DailyRemaining int64                   `json:"daily_remaining"`
+	SyncedAt       int64                   `json:"synced_at"` // 最近一次同步素材总数的时间，0 表示未同步，数量只包括本地记录
+	References     []*entities.MaterialRef `json:"references"`
+}
+
+// MaterialQuotaUsecase 永久素材配额
+//
+// 已使用数量为最近一次同步时微信返回的总数，加上之后通过本服务上传的素材数。
+type MaterialQuotaUsecase struct {
+	log  *zap.Logger
+	repo MaterialRepo
+	refs *MaterialRefUsecase
+}
+
+func NewMaterialQuotaUsecase(log *zap.Logger, repo MaterialRepo,
+	refs *MaterialRefUsecase,
+) *MaterialQuotaUsecase {
+	return &MaterialQuotaUsecase{log: log, repo: repo, refs: refs}
+}
+
+// Check 上传永久素材前检查配额，已达上限时返回 ErrMaterialQuota
+func (u *MaterialQuotaUsecase) Check(c context.Context, appId string, mediaType string) error {
+	quota := quotaOf(mediaType)
+	if quota == nil {
+		return nil
+	}
+	uploaded, err := u.uploadedToday(c, appId)
+	if err != nil {
+		return err
+	}
+	if uploaded >= materialDailyUploads {
+		return fmt.Errorf("%w: daily upload limit %d reached", ErrMaterialQuota, materialDailyUploads)
+	}
+
+	count, err := u.repo.GetCount(c, appId)
+	if err != nil {
+		u.log.Error("get material count error", zap.Error(err))
+		return fmt.Errorf("check material quota error")
+	}
+	used, err := u.used(c, appId, quota, count)
+	if err != nil {
+		return err
+	}
+	if used >= quota.Limit {
+		return fmt.Errorf("%w: %s limit %d reached", ErrMaterialQuota, quota.Type, quota.Limit)
+	}
+	return nil
+}
+
+// Usage 各类永久素材的剩余数量，以及被菜单等引用的素材
+func (u *MaterialQuotaUsecase) Usage(c context.Context, appId string) (*MaterialUsage, error) {
+	count, err := u.repo.GetCount(c, appId)
+	if err != nil {
+		u.log.Error("get material count error", zap.Error(err))
+		return nil, fmt.Errorf("get material usage error")
+	}
+	usage := &MaterialUsage{
+		Quotas:     make([]*MaterialQuotaUsage, 0, len(materialQuotas)),
+		DailyLimit: materialDailyUploads,
+	}
+	if count != nil {
+		usage.SyncedAt = count.SyncedAt
+	}
+	for _, quota := range materialQuotas {
+		used, err := u.used(c, appId, quota, count)
+		if err != nil {
+			return nil, err
+		}
+		usage.Quotas = append(usage.Quotas, &MaterialQuotaUsage{
+			Type:      quota.Type,
+			Limit:     quota.Limit,
+			Used:      used,
+			Remaining: max(0, quota.Limit-used),
+		})
+	}
+	if usage.UploadedToday, err = u.uploadedToday(c, appId); err != nil {
+		return nil, err
+	}
+	usage.DailyRemaining = max(0, usage.DailyLimit-usage.UploadedToday)
+	if usage.References, err = u.refs.List(c, appId); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// Unused 未被引用的永久素材，可以清理
+func (u *MaterialQuotaUsecase) Unused(c context.Context, appId string,
+	params *request.UnusedMaterialQuery,
+) (*model.PageResult[*entities.MPMaterial], error) {
+	refs, err := u.refs.List(c, appId)
+	if err != nil {
+		return nil, err
+	}
+	exclude := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		exclude = append(exclude, ref.MediaId)
+	}
+	types := []string{"image", "thumb", "voice", "video", "news"}
+	if params.Type != "" {
+		types = []string{params.Type}
+	}
+	result, err := u.repo.FindUnused(c, appId, types, exclude, params.PageNo, params.PageSize)
+	if err != nil {
+		u.log.Error("find unused material error", zap.Error(err))
+		return nil, fmt.Errorf("find unused material error")
+	}
+	return result, nil
+}
+
+// used 已使用数量，未同步过时只统计本地记录
+func (u *MaterialQuotaUsecase) used(c context.Context, appId string, quota *materialQuota,
+	count *entities.MaterialCount,
+) (int64, error) {
+	var synced, since int64
+	if count != nil {
+		for _, t := range quota.Types {
+			synced += countOf(count, t)
+		}
+		since = count.SyncedAt
+	}
+	local, err := u.repo.CountPermanent(c, appId, quota.Types, since, count != nil)
+	if err != nil {
+		u.log.Error("count material error", zap.Error(err))
+		return 0, fmt.Errorf("count material error")
+	}
+	return synced + local, nil
+}
+
+func (u *MaterialQuotaUsecase) uploadedToday(c context.Context, appId string) (int64, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Unix()
+	uploaded, err := u.repo.CountPermanent(c, appId, materialUploadTypes, today, true)
+	if err != nil {
+		u.log.Error("count uploaded material error", zap.Error(err))
+		return 0, fmt.Errorf("count material error")
+	}
+	return uploaded, nil
+}
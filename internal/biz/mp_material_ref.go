@@ -0,0 +1,110 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"go.uber.org/zap"
+)
+
+// ErrMaterialInUse 素材仍被菜单等引用，不能删除
+var ErrMaterialInUse = errors.New("material in use")
+
+// 素材引用来源
+const (
+	MaterialRefMenu = "menu"
+	MaterialRefNews = "news" // 图文素材的封面
+)
+
+// MaterialReferrer 引用永久素材的模块，如菜单、自动回复、草稿
+type MaterialReferrer interface {
+	// MaterialRefSource 引用来源，每个模块一个
+	MaterialRefSource() string
+	// MaterialRefs 当前引用的全部素材
+	MaterialRefs(c context.Context, appId string) ([]*entities.MaterialRef, error)
+}
+
+type MaterialRefRepo interface {
+	// Replace 替换一个来源的全部引用，替换过程中不会出现引用缺失
+	Replace(c context.Context, appId string, source string, refs []*entities.MaterialRef) error
+	List(c context.Context, appId string) ([]*entities.MaterialRef, error)
+	FindByMediaId(c context.Context, appId string, mediaId string) ([]*entities.MaterialRef, error)
+}
+
+// MaterialRefUsecase 素材引用索引
+//
+// 引用素材的模块在内容变化后调用 Refresh 更新自己的引用，删除素材前调用 Rebuild
+// 从各模块重新读取引用，再按索引检查是否仍被引用。
+// 目前只索引菜单和图文素材的封面，自动回复、草稿尚未接入。
+type MaterialRefUsecase struct {
+	log       *zap.Logger
+	repo      MaterialRefRepo
+	referrers []MaterialReferrer
+}
+
+func NewMaterialRefUsecase(log *zap.Logger, repo MaterialRefRepo) *MaterialRefUsecase {
+	return &MaterialRefUsecase{log: log, repo: repo}
+}
+
+// Register 注册引用素材的模块，需在服务启动前完成
+func (u *MaterialRefUsecase) Register(referrer MaterialReferrer) {
+	u.referrers = append(u.referrers, referrer)
+}
+
+// Refresh 更新一个模块的引用，失败时只记录日志，不影响模块本身的操作
+func (u *MaterialRefUsecase) Refresh(c context.Context, appId string, referrer MaterialReferrer) {
+	if err := u.refresh(c, appId, referrer); err != nil {
+		u.log.Error("refresh material refs error", zap.String("appId", appId),
+			zap.String("source", referrer.MaterialRefSource()), zap.Error(err))
+	}
+}
+
+func (u *MaterialRefUsecase) refresh(c context.Context, appId string, referrer MaterialReferrer) error {
+	refs, err := referrer.MaterialRefs(c, appId)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, ref := range refs {
+		ref.AppId = appId
+		ref.Source = referrer.MaterialRefSource()
+		ref.UpdatedAt = now
+	}
+	return u.repo.Replace(c, appId, referrer.MaterialRefSource(), refs)
+}
+
+// Rebuild 从所有模块重新建立引用索引
+func (u *MaterialRefUsecase) Rebuild(c context.Context, appId string) ([]*entities.MaterialRef, error) {
+	for _, referrer := range u.referrers {
+		if err := u.refresh(c, appId, referrer); err != nil {
+			u.log.Error("rebuild material refs error", zap.String("appId", appId),
+				zap.String("source", referrer.MaterialRefSource()), zap.Error(err))
+			return nil, fmt.Errorf("rebuild material refs error")
+		}
+	}
+	return u.List(c, appId)
+}
+
+// List 全部引用
+func (u *MaterialRefUsecase) List(c context.Context, appId string) ([]*entities.MaterialRef, error) {
+	refs, err := u.repo.List(c, appId)
+	if err != nil {
+		u.log.Error("list material refs error", zap.Error(err))
+		return nil, fmt.Errorf("list material refs error")
+	}
+	return refs, nil
+}
+
+// Find 引用 mediaId 的位置
+func (u *MaterialRefUsecase) Find(c context.Context, appId string, mediaId string,
+) ([]*entities.MaterialRef, error) {
+	refs, err := u.repo.FindByMediaId(c, appId, mediaId)
+	if err != nil {
+		u.log.Error("find material refs error", zap.Error(err))
+		return nil, fmt.Errorf("find material refs error")
+	}
+	return refs, nil
+}
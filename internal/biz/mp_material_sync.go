@@ -0,0 +1,179 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"go.uber.org/zap"
+)
+
+// materialPageSize 素材列表每页数量，微信限制为 1-20
+const materialPageSize = 20
+
+// materialSyncTypes 同步的永久素材类型
+var materialSyncTypes = []string{"image", "voice", "video", "news"}
+
+// MaterialSyncResult 一种类型的同步结果
+type MaterialSyncResult struct {
+	Type    string `json:"type"`
+	Total   int64  `json:"total"`   // 微信返回的素材总数
+	Synced  int    `json:"synced"`  // 同步的素材数
+	Removed int    `json:"removed"` // 微信中已删除，从本地移除的记录数
+}
+
+// Sync 全量同步永久素材
+//
+// 先获取各类型素材总数并保存，再分页拉取全部素材，按 media_id 更新本地记录。
+// 分页期间素材有增删时可能漏掉部分素材，只有完整拉取且前后总数一致的类型，
+// 才删除微信中已不存在的本地记录。
+// 每页 20 条，素材较多时需要调用上千次接口，通过接口同步会受请求超时限制，
+// 应配置 material 定时任务执行。
+func (m *MaterialUsecase) Sync(c context.Context, appId string) ([]*MaterialSyncResult, error) {
+	mpId, _ := c.Value("MP_ID").(string)
+	if mpId == "" {
+		m.log.Error("get mp id error")
+		return nil, fmt.Errorf("get mp id error")
+	}
+	token, err := m.apiProxy.GetAccessToken(c, appId, mpId)
+	if err != nil {
+		m.log.Error("get access token error", zap.Error(err))
+		return nil, fmt.Errorf("get access token error")
+	}
+
+	started := time.Now().Unix()
+	count, err := m.getMaterialCount(c, token)
+	if err != nil {
+		return nil, err
+	}
+	count.AppId, count.MpId, count.SyncedAt = appId, mpId, started
+	if err := m.repo.SaveCount(c, count); err != nil {
+		m.log.Error("save material count error", zap.Error(err))
+		return nil, fmt.Errorf("save material count error")
+	}
+
+	results := make([]*MaterialSyncResult, 0, len(materialSyncTypes))
+	complete := make(map[string]bool, len(materialSyncTypes))
+	for _, mediaType := range materialSyncTypes {
+		result := &MaterialSyncResult{Type: mediaType, Total: countOf(count, mediaType)}
+		seen, err := m.syncType(c, token, appId, mpId, result, started)
+		if err != nil {
+			return results, err
+		}
+		complete[mediaType] = int64(seen) == result.Total
+		results = append(results, result)
+	}
+
+	// 删除微信中已不存在的素材，以及图文素材中已删除的文章
+	after, err := m.getMaterialCount(c, token)
+	if err != nil {
+		return results, err
+	}
+	for _, result := range results {
+		if !complete[result.Type] || countOf(after, result.Type) != result.Total {
+			m.log.Warn("material changed during sync, skip removing", zap.String("appId", appId),
+				zap.String("type", result.Type))
+			continue
+		}
+		if result.Removed, err = m.removeStale(c, appId, result.Type, started); err != nil {
+			return results, err
+		}
+	}
+	m.refUc.Refresh(c, appId, m)
+	m.log.Info("material synced", zap.String("appId", appId), zap.Any("results", results))
+	return results, nil
+}
+
+func (m *MaterialUsecase) getMaterialCount(c context.Context, token string) (*entities.MaterialCount, error) {
+	var count struct {
+		VoiceCount int64 `json:"voice_count"`
+		VideoCount int64 `json:"video_count"`
+		ImageCount int64 `json:"image_count"`
+		NewsCount  int64 `json:"news_count"`
+	}
+	if err := getWXAPI(c, pathGetMaterialCount, token, &count); err != nil {
+		m.log.Error("get material count error", zap.Error(err))
+		return nil, fmt.Errorf("get material count error")
+	}
+	return &entities.MaterialCount{
+		VoiceCount: count.VoiceCount,
+		VideoCount: count.VideoCount,
+		ImageCount: count.ImageCount,
+		NewsCount:  count.NewsCount,
+	}, nil
+}
+
+func countOf(count *entities.MaterialCount, mediaType string) int64 {
+	switch mediaType {
+	case "image":
+		return count.ImageCount
+	case "voice":
+		return count.VoiceCount
+	case "video":
+		return count.VideoCount
+	case "news":
+		return count.NewsCount
+	}
+	return 0
+}
+
+// syncType 分页同步一种类型的素材，started 为本次同步开始时间，返回拉取到的不同 media_id 数
+func (m *MaterialUsecase) syncType(c context.Context, token string, appId string, mpId string,
+	result *MaterialSyncResult, started int64,
+) (int, error) {
+	seen := make(map[string]bool, result.Total)
+	for offset := int64(0); offset < result.Total; offset += materialPageSize {
+		if err := c.Err(); err != nil {
+			return 0, err
+		}
+		docs, items, err := m.listPage(c, token, result.Type, offset, materialPageSize, appId, mpId)
+		if err != nil {
+			return 0, err
+		}
+		if items == 0 { // 同步过程中素材被删除，总数已变化
+			break
+		}
+		for _, doc := range docs {
+			doc.SyncedAt = started
+			seen[doc.MediaId] = true
+		}
+		if err := m.repo.SaveMany(c, docs); err != nil {
+			m.log.Error("save material list error", zap.Error(err))
+			return 0, fmt.Errorf("save material list error")
+		}
+		result.Synced += items
+	}
+	return len(seen), nil
+}
+
+// removeStale 删除本次同步未更新的素材记录和保存的文件
+func (m *MaterialUsecase) removeStale(c context.Context, appId string, mediaType string,
+	started int64,
+) (int, error) {
+	stale, err := m.repo.FindStale(c, appId, mediaType, started)
+	if err != nil {
+		m.log.Error("find stale material error", zap.Error(err))
+		return 0, fmt.Errorf("find stale material error")
+	}
+	for i, doc := range stale {
+		if err := m.repo.Delete(c, doc.ID.Hex()); err != nil {
+			m.log.Error("delete material error", zap.Error(err))
+			return i, fmt.Errorf("delete material error")
+		}
+		if doc.StorageKey != "" {
+			m.discard(c, doc.StorageKey)
+		}
+	}
+	return len(stale), nil
+}
+
+// GetCount 最近一次同步时保存的各类型素材总数，未同步过时返回 nil
+func (m *MaterialUsecase) GetCount(c context.Context, appId string) (*entities.MaterialCount, error) {
+	count, err := m.repo.GetCount(c, appId)
+	if err != nil {
+		m.log.Error("get material count error", zap.Error(err))
+		return nil, fmt.Errorf("get material count error")
+	}
+	return count, nil
+}
@@ -0,0 +1,188 @@
+package biz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/pkg/material"
+	"github.com/seth16888/wxcommon/domain"
+	"github.com/seth16888/wxcommon/mp"
+	"github.com/seth16888/wxcommon/paths"
+	"go.uber.org/zap"
+)
+
+const (
+	temporaryMediaTTL     = 3 * 24 * time.Hour  // 临时素材的有效期
+	temporaryRefreshAhead = 6 * time.Hour       // 距过期不足该时间的临时素材需要重新上传
+	temporaryRefreshBatch = 500                 // 每次定时任务最多处理的临时素材数
+	temporaryKeepAlive    = 30 * 24 * time.Hour // 最近被引用过的临时素材才由定时任务续期
+)
+
+// ErrMaterialExpired 临时素材已过期，且没有可重新上传的源文件
+var ErrMaterialExpired = errors.New("material expired")
+
+// errSourceMissing 素材存储中没有源文件
+var errSourceMissing = errors.New("material source missing")
+
+// TemporaryRefreshResult 临时素材刷新结果
+type TemporaryRefreshResult struct {
+	Refreshed int `json:"refreshed"` // 重新上传的数量
+	Purged    int `json:"purged"`    // 已过期且没有源文件被删除的数量
+	Failed    int `json:"failed"`    // 重新上传失败的数量，下次继续处理
+}
+
+func temporaryExpiresAt(createdAt int64) int64 {
+	return createdAt + int64(temporaryMediaTTL/time.Second)
+}
+
+// postTemporary 将素材存储中的文件上传为临时素材
+func (m *MaterialUsecase) postTemporary(c context.Context, appId string, mpId string,
+	mediaType string, filename string, key string,
+) (*mp.UploadMediaRes, error) {
+	token, err := m.apiProxy.GetAccessToken(c, appId, mpId)
+	if err != nil {
+		m.log.Error("get access token error", zap.Error(err))
+		return nil, fmt.Errorf("get access token error")
+	}
+
+	url := fmt.Sprintf("https://%s%s?access_token=%s&type=%s",
+		domain.GetWXAPIDomain(),
+		paths.Path_Upload_Temporary_Media,
+		token,
+		mediaType,
+	)
+	m.log.Debug("UploadTemporaryMedia", zap.String("url", url))
+
+	respBytes, err := m.upload(c, url, filename, key, nil)
+	if err != nil {
+		m.log.Debug("UploadTemporaryMedia", zap.Error(err))
+		if errors.Is(err, material.ErrNotFound) {
+			return nil, errSourceMissing
+		}
+		return nil, fmt.Errorf("upload file error")
+	}
+	// 返回结果
+	type result struct {
+		mp.WXError
+		mp.UploadMediaRes
+	}
+	var resultVar result
+	if err := json.Unmarshal(respBytes, &resultVar); err != nil {
+		m.log.Debug("UploadTemporaryMedia", zap.Error(err))
+		return nil, fmt.Errorf("unmarshal response error")
+	}
+	if resultVar.ErrCode != 0 { // business error
+		m.log.Error("UploadTemporaryMedia", zap.Any("ApiError", resultVar))
+		return nil, fmt.Errorf("upload file error: %d %s", resultVar.ErrCode, resultVar.ErrMsg)
+	}
+	return &resultVar.UploadMediaRes, nil
+}
+
+// reupload 用源文件重新上传临时素材，更新 media_id 和有效期
+func (m *MaterialUsecase) reupload(c context.Context, doc *entities.MPMaterial) error {
+	if doc.StorageKey == "" {
+		return errSourceMissing
+	}
+	res, err := m.postTemporary(c, doc.AppId, doc.MpId, doc.Type, doc.Filename, doc.StorageKey)
+	if err != nil {
+		return err
+	}
+	previous := doc.MediaId
+	doc.MediaId = res.MediaID
+	doc.ThumbMediaId = res.ThumbMediaId
+	doc.URL = res.URL
+	doc.CreatedAt = res.CreatedAt
+	doc.ExpiresAt = temporaryExpiresAt(res.CreatedAt)
+	doc.UpdatedAt = time.Now().Unix()
+	doc.RefreshedAt = doc.UpdatedAt
+	if err := m.repo.UpdateMedia(c, doc, previous); err != nil {
+		m.log.Error("update material error", zap.Error(err))
+		return fmt.Errorf("update material error")
+	}
+	return nil
+}
+
+// ResolveMediaId 返回素材当前有效的 media_id
+//
+// ref 为素材ID、media_id 或重新上传前的 media_id，自动回复、客服消息、菜单等引用素材时调用。
+// 临时素材即将过期时用源文件重新上传，源文件不存在时返回 ErrMaterialExpired。
+// 调用时记录临时素材的使用时间，长期未使用的临时素材不再由定时任务续期。
+func (m *MaterialUsecase) ResolveMediaId(c context.Context, appId string, ref string,
+) (*entities.MPMaterial, error) {
+	doc, err := m.repo.Get(c, appId, ref)
+	if err != nil {
+		m.log.Error("get material error", zap.String("ref", ref), zap.Error(err))
+		return nil, fmt.Errorf("material not found")
+	}
+	if doc.IsPermanent {
+		return doc, nil
+	}
+
+	now := time.Now()
+	if err := m.repo.MarkUsed(c, doc.ID.Hex(), now.Unix()); err != nil {
+		m.log.Error("mark material used error", zap.Error(err))
+	}
+	expiresAt := doc.ExpiresAt
+	if expiresAt == 0 { // 记录有效期之前上传的素材
+		expiresAt = temporaryExpiresAt(doc.CreatedAt)
+	}
+	// 留出发送消息的时间
+	if expiresAt > now.Add(time.Hour).Unix() {
+		return doc, nil
+	}
+	if err := m.reupload(c, doc); err != nil {
+		if errors.Is(err, errSourceMissing) {
+			return nil, ErrMaterialExpired
+		}
+		return nil, err
+	}
+	m.log.Info("temporary media refreshed", zap.String("appId", appId),
+		zap.String("id", doc.ID.Hex()), zap.String("mediaId", doc.MediaId))
+	return doc, nil
+}
+
+// RefreshTemporary 重新上传即将过期的临时素材，删除已过期且没有源文件的记录
+//
+// 只续期 temporaryKeepAlive 内被引用过的素材，其他素材过期后再被引用时由 ResolveMediaId 重新上传。
+func (m *MaterialUsecase) RefreshTemporary(c context.Context, appId string,
+) (*TemporaryRefreshResult, error) {
+	now := time.Now()
+	result := &TemporaryRefreshResult{}
+	purged, err := m.repo.PurgeExpired(c, appId, now.Unix())
+	if err != nil {
+		m.log.Error("purge expired material error", zap.Error(err))
+		return nil, fmt.Errorf("purge expired material error")
+	}
+	result.Purged = int(purged)
+
+	docs, err := m.repo.FindExpiring(c, appId, now.Add(temporaryRefreshAhead).Unix(),
+		now.Add(-temporaryKeepAlive).Unix(), temporaryRefreshBatch)
+	if err != nil {
+		m.log.Error("find expiring material error", zap.Error(err))
+		return nil, fmt.Errorf("find expiring material error")
+	}
+
+	for _, doc := range docs {
+		if err := c.Err(); err != nil {
+			return result, err
+		}
+		if err := m.reupload(c, doc); err != nil {
+			m.log.Error("refresh temporary media error", zap.String("id", doc.ID.Hex()), zap.Error(err))
+			result.Failed++
+			// 失败的素材排到后面，不影响其他素材
+			if err := m.repo.MarkRefreshFailed(c, doc.ID.Hex(), time.Now().Unix()); err != nil {
+				m.log.Error("update material error", zap.Error(err))
+			}
+			continue
+		}
+		result.Refreshed++
+	}
+	m.log.Info("temporary media refreshed", zap.String("appId", appId),
+		zap.Int("refreshed", result.Refreshed), zap.Int("purged", result.Purged),
+		zap.Int("failed", result.Failed))
+	return result, nil
+}
@@ -3,9 +3,11 @@ package biz
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/message"
 	"github.com/seth16888/wxbusiness/internal/model"
 	"github.com/seth16888/wxbusiness/internal/model/request"
 	v1 "github.com/seth16888/wxproxy/api/v1"
@@ -16,16 +18,52 @@ type MPMemberRepo interface {
 	Find(c context.Context, appId string,
 		params *request.MPMemberQuery) (*model.PageResult[*entities.MPMember], error)
 	FindById(c context.Context, id string) (*entities.MPMember, error)
+	FindByOpenId(c context.Context, appId, openid string) (*entities.MPMember, error)
+	FindOpenIds(c context.Context, appId string, filter *request.MPMemberFilter) ([]string, error)
+	Iterate(c context.Context, appId string, filter *request.MPMemberFilter,
+		fn func(member *entities.MPMember) error) error
+	ExistingOpenIds(c context.Context, appId string, openids []string) ([]string, error) // 返回本地存在的openid
 	UpdateRemark(c context.Context, id, remark string) error
-	Save(c context.Context, members []*entities.MPMember) error // 存在则更新，不存在则创建
+	UpdateLastMessageAt(c context.Context, appId, openid string, ts int64) error
+	UpdateProvince(c context.Context, appId, openid, province string) error
+	UpdateSubscribe(c context.Context, appId, openid string, subscribe int) error
+	UpdateAttributes(c context.Context, appId string, updates []*MemberAttributeUpdate) (int64, error) // 返回匹配的粉丝数
+	UnsetAttribute(c context.Context, appId, key string) error                                         // 删除所有粉丝的该属性
+	// IncrMessageCount 累加发送的消息数，msgId 已累加过时忽略
+	IncrMessageCount(c context.Context, appId, openid string, msgId int64) error
+	UpdateEngagement(c context.Context, appId string, scores map[string]int64, ts int64) error // scores: openid => 评分
+	Save(c context.Context, members []*entities.MPMember) error // 批量upsert，保留本地维护的字段
 	BatchTagging(c context.Context, appId string, ids []string, tagId int64) error
 	BatchUnTagging(c context.Context, appId string, ids []string, tagId int64) error
+	CountByQrScene(c context.Context, appId string) (map[string]int64, error) // 场景值 => 粉丝数，qr_scene_str 为空时使用 qr_scene
 }
 
 type MPBlackListRepo interface {
-	Block(c context.Context, appId string, openids []string) error
-	Unblock(c context.Context, appId string, openids []string) error
+	// Block 拉黑并保存拉黑记录，meta 为原因、操作人等信息
+	Block(c context.Context, appId string, openids []string, meta *entities.MPBlackList) error
+	Unblock(c context.Context, appId string, openids []string, meta *entities.MPBlackList) error
 	Query(c context.Context, appId string) ([]*entities.MPMember, error)
+	QueryRecords(c context.Context, appId string) ([]*entities.MPBlackList, error)
+	QueryHistory(c context.Context, appId string,
+		params *request.BlackListHistoryQuery) (*model.PageResult[*entities.MPBlackListHistory], error)
+	BlockedOpenIds(c context.Context, appId string) ([]string, error) // 有拉黑记录或已标记封禁的openid
+	// RecordedOpenIds 返回 openids 中有拉黑记录的openid
+	RecordedOpenIds(c context.Context, appId string, openids []string) ([]string, error)
+	// MarkMembersBlocked 将 openids 对应的粉丝标记为封禁，返回新标记的数量
+	MarkMembersBlocked(c context.Context, appId string, openids []string) (int64, error)
+}
+
+// 黑名单操作来源
+const (
+	BlackListSourceManual = "manual"
+	BlackListSourceSync   = "sync"
+)
+
+// BlackListSyncSummary 黑名单同步结果
+type BlackListSyncSummary struct {
+	Total     int `json:"total"`     // 微信后台黑名单数量
+	Blocked   int `json:"blocked"`   // 本地新增拉黑
+	Unblocked int `json:"unblocked"` // 本地取消拉黑
 }
 
 type MPMemberUsecase struct {
@@ -33,20 +71,23 @@ type MPMemberUsecase struct {
 	blackListRepo MPBlackListRepo
 	log           *zap.Logger
 	apiProxy      *APIProxyUsecase
+	timeline      *MemberTimelineUsecase
+	identity      *MemberIdentityUsecase
 }
 
-func (m *MPMemberUsecase) PullBlackList(c context.Context, appId string) error {
+// PullBlackList 同步微信黑名单，本地与微信后台保持一致
+func (m *MPMemberUsecase) PullBlackList(c context.Context, appId string) (*BlackListSyncSummary, error) {
 	mpIdVar := c.Value("MP_ID")
 	if mpIdVar == nil {
 		m.log.Error("get mp id error")
-		return fmt.Errorf("get mp id error")
+		return nil, fmt.Errorf("get mp id error")
 	}
 	mpId := mpIdVar.(string)
 
 	token, err := m.apiProxy.GetAccessToken(c, appId, mpId)
 	if err != nil {
 		m.log.Error("get access token error", zap.Error(err))
-		return fmt.Errorf("get access token error")
+		return nil, fmt.Errorf("get access token error")
 	}
 
   // 拉取微信黑名单
@@ -86,7 +127,7 @@ func (m *MPMemberUsecase) PullBlackList(c context.Context, appId string) error {
 		openids, next, err := fetchOpenIdFn(nextOpenid)
 		if err != nil {
 			m.log.Error("fetch blacklist error", zap.Error(err))
-			return fmt.Errorf("fetch blacklist error")
+			return nil, fmt.Errorf("fetch blacklist error")
 		}
 		if len(openids) == 0 {
 			break
@@ -99,13 +140,64 @@ func (m *MPMemberUsecase) PullBlackList(c context.Context, appId string) error {
 	}
 	m.log.Debug("get blacklist ids", zap.Int("count", len(memberIds)))
 
-  // 拉黑
-	if err := m.blackListRepo.Block(c, appId, memberIds); err != nil {
+	// 对比本地黑名单
+	locals, err := m.blackListRepo.BlockedOpenIds(c, appId)
+	if err != nil {
+		m.log.Error("query blacklist error", zap.Error(err))
+		return nil, fmt.Errorf("query blacklist error")
+	}
+	remoteSet := make(map[string]bool, len(memberIds))
+	for _, openid := range memberIds {
+		remoteSet[openid] = true
+	}
+	localSet := make(map[string]bool, len(locals))
+	unblocks := make([]string, 0)
+	for _, openid := range locals {
+		localSet[openid] = true
+		if !remoteSet[openid] {
+			unblocks = append(unblocks, openid)
+		}
+	}
+	blocks := make([]string, 0)
+	for openid := range remoteSet {
+		if !localSet[openid] {
+			blocks = append(blocks, openid)
+		}
+	}
+
+	now := time.Now().Unix()
+	meta := &entities.MPBlackList{
+		MpId:      mpId,
+		Source:    BlackListSourceSync,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := m.blackListRepo.Block(c, appId, blocks, meta); err != nil {
+		m.log.Error("save blacklist error", zap.Error(err))
+		return nil, fmt.Errorf("save blacklist error")
+	}
+	if err := m.blackListRepo.Unblock(c, appId, unblocks, meta); err != nil {
 		m.log.Error("save blacklist error", zap.Error(err))
-		return fmt.Errorf("save blacklist error")
+		return nil, fmt.Errorf("save blacklist error")
 	}
+	m.timeline.Record(c, appId, mpId, blocks, ActivityBlock, BlackListSourceSync, "")
+	m.timeline.Record(c, appId, mpId, unblocks, ActivityUnblock, BlackListSourceSync, "")
 
-	return nil
+	// 拉黑记录可能早于粉丝信息保存，粉丝同步时未标记封禁，按微信黑名单修正
+	marked, err := m.blackListRepo.MarkMembersBlocked(c, appId, memberIds)
+	if err != nil {
+		m.log.Error("mark members blocked error", zap.Error(err))
+		return nil, fmt.Errorf("save blacklist error")
+	}
+	if marked > 0 {
+		m.log.Info("members marked blocked", zap.String("appId", appId), zap.Int64("count", marked))
+	}
+
+	return &BlackListSyncSummary{
+		Total:     len(remoteSet),
+		Blocked:   len(blocks),
+		Unblocked: len(unblocks),
+	}, nil
 }
 
 func (m *MPMemberUsecase) BatchUnTagging(c context.Context, appId string, ids []string, id int64) error {
@@ -140,6 +232,7 @@ func (m *MPMemberUsecase) BatchUnTagging(c context.Context, appId string, ids []
 		m.log.Error("batch UnTagging error", zap.Error(err))
 		return fmt.Errorf("batch UnTagging error")
 	}
+	m.timeline.Record(c, appId, mpId, ids, ActivityTagRemove, strconv.FormatInt(id, 10), "")
 
 	return nil
 }
@@ -169,18 +262,22 @@ func (m *MPMemberUsecase) BatchTagging(c context.Context, appId string, ids []st
 	}
 	if wxErr != nil && wxErr.Errcode != 0 {
 		m.log.Error("api error", zap.Any("return", wxErr))
-		return fmt.Errorf("call api error")
+		return fmt.Errorf("call api error: %d %s", wxErr.Errcode, wxErr.Errmsg)
 	}
 
 	if err := m.repo.BatchTagging(c, appId, ids, id); err != nil {
 		m.log.Error("batch Tagging error", zap.Error(err))
 		return fmt.Errorf("batch Tagging error")
 	}
+	m.timeline.Record(c, appId, mpId, ids, ActivityTagAdd, strconv.FormatInt(id, 10), "")
 
 	return nil
 }
 
-func (m *MPMemberUsecase) BatchUnblock(c context.Context, appId string, openids []string) error {
+// BatchUnblock 批量取消拉黑，reason 为取消原因，operator 为操作用户UID
+func (m *MPMemberUsecase) BatchUnblock(c context.Context, appId string, openids []string,
+	reason, operator string,
+) error {
 	mpIdVar := c.Value("MP_ID")
 	if mpIdVar == nil {
 		m.log.Error("get mp id error")
@@ -207,14 +304,19 @@ func (m *MPMemberUsecase) BatchUnblock(c context.Context, appId string, openids
 		return fmt.Errorf("call api error")
 	}
 
-	if err := m.blackListRepo.Unblock(c, appId, openids); err != nil {
+	if err := m.blackListRepo.Unblock(c, appId, openids,
+		newBlackListMeta(mpId, reason, operator)); err != nil {
 		m.log.Error("unblock member error", zap.Error(err))
 		return fmt.Errorf("unblock member error")
 	}
+	m.timeline.Record(c, appId, mpId, openids, ActivityUnblock, reason, operator)
 	return nil
 }
 
-func (m *MPMemberUsecase) BatchBlock(c context.Context, appId string, openids []string) error {
+// BatchBlock 批量拉黑，reason 为拉黑原因，operator 为操作用户UID
+func (m *MPMemberUsecase) BatchBlock(c context.Context, appId string, openids []string,
+	reason, operator string,
+) error {
 	mpIdVar := c.Value("MP_ID")
 	if mpIdVar == nil {
 		m.log.Error("get mp id error")
@@ -241,13 +343,49 @@ func (m *MPMemberUsecase) BatchBlock(c context.Context, appId string, openids []
 		return fmt.Errorf("call api error")
 	}
 
-	if err := m.blackListRepo.Block(c, appId, openids); err != nil {
+	if err := m.blackListRepo.Block(c, appId, openids,
+		newBlackListMeta(mpId, reason, operator)); err != nil {
 		m.log.Error("block member error", zap.Error(err))
 		return fmt.Errorf("block member error")
 	}
+	m.timeline.Record(c, appId, mpId, openids, ActivityBlock, reason, operator)
 	return nil
 }
 
+func newBlackListMeta(mpId, reason, operator string) *entities.MPBlackList {
+	now := time.Now().Unix()
+	return &entities.MPBlackList{
+		MpId:      mpId,
+		Reason:    reason,
+		Operator:  operator,
+		Source:    BlackListSourceManual,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// GetBlackListRecords 黑名单记录，包含拉黑原因
+func (m *MPMemberUsecase) GetBlackListRecords(c context.Context, appId string) ([]*entities.MPBlackList, error) {
+	records, err := m.blackListRepo.QueryRecords(c, appId)
+	if err != nil {
+		m.log.Error("query black list error", zap.Error(err))
+		return nil, fmt.Errorf("query black list error")
+	}
+	return records, nil
+}
+
+// GetBlackListHistory 拉黑、取消拉黑操作记录
+func (m *MPMemberUsecase) GetBlackListHistory(c context.Context, appId string,
+	params *request.BlackListHistoryQuery,
+) (*model.PageResult[*entities.MPBlackListHistory], error) {
+	result, err := m.blackListRepo.QueryHistory(c, appId, params)
+	if err != nil {
+		m.log.Error("query black list history error", zap.Error(err))
+		return nil, fmt.Errorf("query black list history error")
+	}
+	return result, nil
+}
+
 func (m *MPMemberUsecase) GetBlackList(c context.Context, appId string) ([]*entities.MPMember, error) {
 	docs, err := m.blackListRepo.Query(c, appId)
 	if err != nil {
@@ -290,6 +428,7 @@ func (m *MPMemberUsecase) UpdateRemark(c context.Context, appId, id, openId, rem
 		m.log.Error("update member remark error", zap.Error(err))
 		return fmt.Errorf("update member remark error")
 	}
+	m.timeline.Record(c, appId, mpId, []string{openId}, ActivityRemark, remark, "")
 	return nil
 }
 
@@ -302,6 +441,9 @@ func (m *MPMemberUsecase) GetMemberInfo(c context.Context,
 func (m *MPMemberUsecase) Query(c context.Context, appId string,
 	params *request.MPMemberQuery,
 ) (*model.PageResult[*entities.MPMember], error) {
+	if err := ValidateMemberFilter(&params.MPMemberFilter); err != nil {
+		return nil, err
+	}
 	docs, err := m.repo.Find(c, appId, params)
 	if err != nil {
 		m.log.Error("query member error", zap.Error(err))
@@ -375,6 +517,12 @@ func (m *MPMemberUsecase) Pull(c context.Context, appId string) error {
 	}
 	m.log.Debug("get member ids", zap.Int("count", len(memberIds)))
 
+	// 按 unionid 关联其他公众号的粉丝
+	userId := ""
+	if app, err := GetAppInfoFromCtx(c); err == nil {
+		userId = app.UserId
+	}
+
 	// 获取粉丝信息
 	// 微信接口调用，每次最多拉取100条，需要多次调用
 	fetchMemberInfoFn := func(openids []string) ([]*entities.MPMember, error) {
@@ -416,7 +564,6 @@ func (m *MPMemberUsecase) Pull(c context.Context, appId string) error {
 				UnionId:        info.Unionid,
 				Remark:         info.Remark,
 				GroupId:        info.Groupid,
-				Tags:           []*entities.MemberTag{},
 				SubscribeScene: info.SubscribeScene,
 				QrScene:        info.QrScene,
 				QrSceneStr:     info.QrSceneStr,
@@ -429,8 +576,9 @@ func (m *MPMemberUsecase) Pull(c context.Context, appId string) error {
 				UpdatedAt:      now,
 				Blocked:        false,
 			}
-			// tags
+			// tags，微信未返回标签列表时保留本地标签
 			if info.TagidList != nil {
+				fans.Tags = make([]*entities.MemberTag, 0, len(info.TagidList))
 				for _, tagId := range info.TagidList {
 					fans.Tags = append(fans.Tags, &entities.MemberTag{
 						MpId:  mpId,
@@ -460,18 +608,86 @@ func (m *MPMemberUsecase) Pull(c context.Context, appId string) error {
 		if len(members) == 0 {
 			continue
 		}
+		// 已有拉黑记录的粉丝保存为封禁状态
+		if err := m.markBlocked(c, appId, members); err != nil {
+			return err
+		}
 		// 保存粉丝信息
 		if err := m.repo.Save(c, members); err != nil {
 			m.log.Error("save member error", zap.Error(err))
 			return fmt.Errorf("save member error")
 		}
+		// 关联身份失败不影响粉丝同步，下次同步时重新关联
+		if userId != "" {
+			if err := m.identity.Link(c, userId, members); err != nil {
+				m.log.Warn("link member identity error", zap.String("appId", appId),
+					zap.Int("count", len(members)), zap.Error(err))
+			}
+		}
 	}
 
 	return nil
 }
 
+// markBlocked 按拉黑记录设置粉丝的封禁状态
+func (m *MPMemberUsecase) markBlocked(c context.Context, appId string, members []*entities.MPMember) error {
+	openids := make([]string, 0, len(members))
+	for _, member := range members {
+		openids = append(openids, member.OpenId)
+	}
+	recorded, err := m.blackListRepo.RecordedOpenIds(c, appId, openids)
+	if err != nil {
+		m.log.Error("query blacklist error", zap.Error(err))
+		return fmt.Errorf("query blacklist error")
+	}
+	blocked := make(map[string]bool, len(recorded))
+	for _, openid := range recorded {
+		blocked[openid] = true
+	}
+	for _, member := range members {
+		member.Blocked = blocked[member.OpenId]
+	}
+	return nil
+}
+
+// OnMessage 实现 message.Subscriber，更新关注状态、所在省份和最后互动时间
+func (m *MPMemberUsecase) OnMessage(c context.Context, app *entities.PlatformApp,
+	msg *message.MixMessage,
+) error {
+	switch msg.Event {
+	case message.EventSubscribe:
+		if err := m.repo.UpdateSubscribe(c, app.ID.Hex(), msg.GetOpenID(), 1); err != nil {
+			return err
+		}
+	case message.EventUnsubscribe:
+		return m.repo.UpdateSubscribe(c, app.ID.Hex(), msg.GetOpenID(), 0)
+	}
+	if !msg.IsInteraction() {
+		return nil
+	}
+	// 微信不再返回用户的省份，从发送的位置解析
+	if province := msgProvince(msg); province != "" {
+		if err := m.repo.UpdateProvince(c, app.ID.Hex(), msg.GetOpenID(), province); err != nil {
+			return err
+		}
+	}
+	ts := msg.CreateTime
+	if ts == 0 {
+		ts = time.Now().Unix()
+	}
+	return m.repo.UpdateLastMessageAt(c, app.ID.Hex(), msg.GetOpenID(), ts)
+}
+
 func NewMPMemberUsecase(log *zap.Logger, repo MPMemberRepo,
-	apiProxy *APIProxyUsecase, block MPBlackListRepo,
+	apiProxy *APIProxyUsecase, block MPBlackListRepo, timeline *MemberTimelineUsecase,
+	identity *MemberIdentityUsecase,
 ) *MPMemberUsecase {
-	return &MPMemberUsecase{repo: repo, log: log, apiProxy: apiProxy, blackListRepo: block}
+	return &MPMemberUsecase{
+		repo:          repo,
+		log:           log,
+		apiProxy:      apiProxy,
+		blackListRepo: block,
+		timeline:      timeline,
+		identity:      identity,
+	}
 }
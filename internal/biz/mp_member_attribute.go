@@ -0,0 +1,479 @@
+package biz
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// 自定义属性类型
+const (
+	AttributeString = "string"
+	AttributeNumber = "number"
+	AttributeDate   = "date" // 2006-01-02
+	AttributeEnum   = "enum"
+)
+
+const (
+	MaxMemberAttributes   = 50  // 每个应用最多的自定义属性
+	maxAttributeStringLen = 256 // 字符串属性最大长度
+	attributeImportBatch  = 500
+)
+
+var allowedAttributeTypes = []string{AttributeString, AttributeNumber, AttributeDate, AttributeEnum}
+
+var attributeKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// IsValidAttributeKey 属性名：小写字母开头，只包含小写字母、数字和下划线
+func IsValidAttributeKey(key string) bool {
+	return attributeKeyPattern.MatchString(key)
+}
+
+// ErrInvalidFilter 粉丝筛选条件无效
+var ErrInvalidFilter = errors.New("invalid member filter")
+
+var attributeConditionOps = []string{"eq", "ne", "gt", "gte", "lt", "lte", "contains"}
+
+// ParseAttributeCondition 解析自定义属性条件 key:op:value
+func ParseAttributeCondition(s string) (key, op, value string, err error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || !IsValidAttributeKey(parts[0]) || !slices.Contains(attributeConditionOps, parts[1]) {
+		return "", "", "", fmt.Errorf("%w: attrs %q", ErrInvalidFilter, s)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// ValidateMemberFilter 校验粉丝筛选条件，无效的条件不能忽略，否则会扩大筛选范围
+func ValidateMemberFilter(f *request.MPMemberFilter) error {
+	if f == nil {
+		return nil
+	}
+	for _, attr := range f.Attrs {
+		if _, _, _, err := ParseAttributeCondition(attr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type MemberAttributeRepo interface {
+	Create(c context.Context, def *entities.MemberAttributeDef) (string, error)
+	Update(c context.Context, appId, id string, def *entities.MemberAttributeDef) error
+	Delete(c context.Context, appId, id string) error
+	Get(c context.Context, appId, id string) (*entities.MemberAttributeDef, error)
+	Query(c context.Context, appId string) ([]*entities.MemberAttributeDef, error)
+}
+
+// MemberAttributeUpdate 更新一个粉丝的自定义属性
+type MemberAttributeUpdate struct {
+	OpenId string
+	Set    map[string]any
+	Unset  []string
+}
+
+// AttributeImportResult 导入结果
+type AttributeImportResult struct {
+	Rows     int                       `json:"rows"`
+	Updated  int                       `json:"updated"`
+	Failures []*AttributeImportFailure `json:"failures"`
+}
+
+// AttributeImportFailure 导入失败的行
+type AttributeImportFailure struct {
+	Line   int    `json:"line"`
+	OpenId string `json:"openid"`
+	Reason string `json:"reason"`
+}
+
+// MemberAttributeUsecase 粉丝自定义属性
+type MemberAttributeUsecase struct {
+	log        *zap.Logger
+	repo       MemberAttributeRepo
+	memberRepo MPMemberRepo
+}
+
+func NewMemberAttributeUsecase(log *zap.Logger, repo MemberAttributeRepo,
+	memberRepo MPMemberRepo,
+) *MemberAttributeUsecase {
+	return &MemberAttributeUsecase{log: log, repo: repo, memberRepo: memberRepo}
+}
+
+func validateAttributeDef(req *request.MemberAttributeDefReq) error {
+	if !IsValidAttributeKey(req.Key) {
+		return fmt.Errorf("invalid key %s", req.Key)
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return fmt.Errorf("name required")
+	}
+	if !slices.Contains(allowedAttributeTypes, req.Type) {
+		return fmt.Errorf("type %s not allowed", req.Type)
+	}
+	if req.Type == AttributeEnum && len(req.Options) == 0 {
+		return fmt.Errorf("options required")
+	}
+	return nil
+}
+
+// CreateDef 创建属性
+func (u *MemberAttributeUsecase) CreateDef(c context.Context, appId string,
+	req *request.MemberAttributeDefReq,
+) (string, error) {
+	app, err := GetAppInfoFromCtx(c)
+	if err != nil {
+		u.log.Error("get app info error", zap.Error(err))
+		return "", fmt.Errorf("get app info error")
+	}
+	if err := validateAttributeDef(req); err != nil {
+		return "", err
+	}
+	defs, err := u.repo.Query(c, appId)
+	if err != nil {
+		u.log.Error("query attribute error", zap.Error(err))
+		return "", fmt.Errorf("query attribute error")
+	}
+	if len(defs) >= MaxMemberAttributes {
+		return "", fmt.Errorf("too many attributes, max %d", MaxMemberAttributes)
+	}
+	for _, def := range defs {
+		if def.Key == req.Key {
+			return "", fmt.Errorf("key %s exists", req.Key)
+		}
+	}
+
+	now := time.Now().Unix()
+	def := &entities.MemberAttributeDef{
+		AppId:     appId,
+		MpId:      app.MpId,
+		Key:       req.Key,
+		Name:      req.Name,
+		Type:      req.Type,
+		Options:   req.Options,
+		Required:  req.Required,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	id, err := u.repo.Create(c, def)
+	if err != nil {
+		u.log.Error("create attribute error", zap.Error(err))
+		return "", fmt.Errorf("create attribute error")
+	}
+	return id, nil
+}
+
+// UpdateDef 更新属性，属性名和类型不可修改
+func (u *MemberAttributeUsecase) UpdateDef(c context.Context, appId, id string,
+	req *request.MemberAttributeDefReq,
+) error {
+	def, err := u.repo.Get(c, appId, id)
+	if err != nil {
+		return fmt.Errorf("attribute not found")
+	}
+	req.Key = def.Key
+	if req.Type != def.Type {
+		return fmt.Errorf("type cannot be changed")
+	}
+	if err := validateAttributeDef(req); err != nil {
+		return err
+	}
+
+	def.Name = req.Name
+	def.Options = req.Options
+	def.Required = req.Required
+	def.UpdatedAt = time.Now().Unix()
+	if err := u.repo.Update(c, appId, id, def); err != nil {
+		u.log.Error("update attribute error", zap.Error(err))
+		return fmt.Errorf("update attribute error")
+	}
+	return nil
+}
+
+// DeleteDef 删除属性，同时删除粉丝的属性值
+func (u *MemberAttributeUsecase) DeleteDef(c context.Context, appId, id string) error {
+	def, err := u.repo.Get(c, appId, id)
+	if err != nil {
+		return fmt.Errorf("attribute not found")
+	}
+	if err := u.memberRepo.UnsetAttribute(c, appId, def.Key); err != nil {
+		u.log.Error("unset member attribute error", zap.Error(err))
+		return fmt.Errorf("unset member attribute error")
+	}
+	if err := u.repo.Delete(c, appId, id); err != nil {
+		u.log.Error("delete attribute error", zap.Error(err))
+		return fmt.Errorf("delete attribute error")
+	}
+	return nil
+}
+
+// QueryDefs 属性列表
+func (u *MemberAttributeUsecase) QueryDefs(c context.Context, appId string,
+) ([]*entities.MemberAttributeDef, error) {
+	defs, err := u.repo.Query(c, appId)
+	if err != nil {
+		u.log.Error("query attribute error", zap.Error(err))
+		return nil, fmt.Errorf("query attribute error")
+	}
+	return defs, nil
+}
+
+// SetMemberAttributes 设置粉丝的自定义属性，值为 nil 时删除
+//
+// memberId 为粉丝ID，也可以直接使用 openid
+func (u *MemberAttributeUsecase) SetMemberAttributes(c context.Context, appId, memberId string,
+	values map[string]any,
+) error {
+	openid := memberId
+	if primitive.IsValidObjectID(memberId) {
+		member, err := u.memberRepo.FindById(c, memberId)
+		if err != nil || member.AppId != appId {
+			return fmt.Errorf("member not found")
+		}
+		openid = member.OpenId
+	}
+
+	defs, err := u.defMap(c, appId)
+	if err != nil {
+		return err
+	}
+	update := &MemberAttributeUpdate{OpenId: openid, Set: map[string]any{}}
+	for key, value := range values {
+		def, ok := defs[key]
+		if !ok {
+			return fmt.Errorf("attribute %s not defined", key)
+		}
+		if value == nil {
+			if def.Required {
+				return fmt.Errorf("attribute %s required", key)
+			}
+			update.Unset = append(update.Unset, key)
+			continue
+		}
+		v, err := normalizeAttribute(def, value)
+		if err != nil {
+			return err
+		}
+		update.Set[key] = v
+	}
+
+	matched, err := u.memberRepo.UpdateAttributes(c, appId, []*MemberAttributeUpdate{update})
+	if err != nil {
+		u.log.Error("update member attribute error", zap.Error(err))
+		return fmt.Errorf("update member attribute error")
+	}
+	if matched == 0 {
+		return fmt.Errorf("member not found")
+	}
+	return nil
+}
+
+// Import 从CSV导入属性
+//
+// 第一列为 openid，表头为属性名；空白单元格不修改，必填属性不能为空，出错的行整行跳过。
+func (u *MemberAttributeUsecase) Import(c context.Context, appId string, r io.Reader,
+) (*AttributeImportResult, error) {
+	defs, err := u.defMap(c, appId)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header error")
+	}
+	if len(header) < 2 || strings.TrimPrefix(strings.TrimSpace(header[0]), "\ufeff") != "openid" {
+		return nil, fmt.Errorf("first column must be openid")
+	}
+	columns := make([]*entities.MemberAttributeDef, len(header))
+	for i, key := range header[1:] {
+		def, ok := defs[strings.TrimSpace(key)]
+		if !ok {
+			return nil, fmt.Errorf("attribute %s not defined", key)
+		}
+		columns[i+1] = def
+	}
+
+	result := &AttributeImportResult{Failures: []*AttributeImportFailure{}}
+	lines := make([]int, 0, attributeImportBatch)
+	updates := make([]*MemberAttributeUpdate, 0, attributeImportBatch)
+	flush := func() error {
+		if len(updates) == 0 {
+			return nil
+		}
+		if err := u.importBatch(c, appId, lines, updates, result); err != nil {
+			return err
+		}
+		lines = lines[:0]
+		updates = updates[:0]
+		return nil
+	}
+
+	line := 1
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line++
+		if err != nil {
+			result.Failures = append(result.Failures, &AttributeImportFailure{Line: line, Reason: err.Error()})
+			continue
+		}
+		result.Rows++
+		update, err := parseAttributeRow(record, columns)
+		if err != nil {
+			result.Failures = append(result.Failures, &AttributeImportFailure{
+				Line: line, OpenId: strings.TrimSpace(record[0]), Reason: err.Error(),
+			})
+			continue
+		}
+		lines = append(lines, line)
+		updates = append(updates, update)
+		if len(updates) >= attributeImportBatch {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func parseAttributeRow(record []string, columns []*entities.MemberAttributeDef) (*MemberAttributeUpdate, error) {
+	openid := strings.TrimSpace(record[0])
+	if openid == "" {
+		return nil, fmt.Errorf("openid required")
+	}
+	update := &MemberAttributeUpdate{OpenId: openid, Set: map[string]any{}}
+	for i := 1; i < len(record) && i < len(columns); i++ {
+		cell := strings.TrimSpace(record[i])
+		if cell == "" {
+			if columns[i].Required {
+				return nil, fmt.Errorf("attribute %s required", columns[i].Key)
+			}
+			continue
+		}
+		v, err := normalizeAttribute(columns[i], cell)
+		if err != nil {
+			return nil, err
+		}
+		update.Set[columns[i].Key] = v
+	}
+	if len(update.Set) == 0 {
+		return nil, fmt.Errorf("no attributes")
+	}
+	return update, nil
+}
+
+// importBatch 只更新本地存在的粉丝
+func (u *MemberAttributeUsecase) importBatch(c context.Context, appId string, lines []int,
+	updates []*MemberAttributeUpdate, result *AttributeImportResult,
+) error {
+	openids := make([]string, 0, len(updates))
+	for _, update := range updates {
+		openids = append(openids, update.OpenId)
+	}
+	existing, err := u.memberRepo.ExistingOpenIds(c, appId, openids)
+	if err != nil {
+		u.log.Error("query members error", zap.Error(err))
+		return fmt.Errorf("query members error")
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, openid := range existing {
+		exists[openid] = true
+	}
+
+	valid := make([]*MemberAttributeUpdate, 0, len(updates))
+	for i, update := range updates {
+		if !exists[update.OpenId] {
+			result.Failures = append(result.Failures, &AttributeImportFailure{
+				Line: lines[i], OpenId: update.OpenId, Reason: "member not found",
+			})
+			continue
+		}
+		valid = append(valid, update)
+	}
+	matched, err := u.memberRepo.UpdateAttributes(c, appId, valid)
+	if err != nil {
+		u.log.Error("update member attribute error", zap.Error(err))
+		return fmt.Errorf("update member attribute error")
+	}
+	result.Updated += int(matched)
+	return nil
+}
+
+func (u *MemberAttributeUsecase) defMap(c context.Context, appId string,
+) (map[string]*entities.MemberAttributeDef, error) {
+	defs, err := u.repo.Query(c, appId)
+	if err != nil {
+		u.log.Error("query attribute error", zap.Error(err))
+		return nil, fmt.Errorf("query attribute error")
+	}
+	m := make(map[string]*entities.MemberAttributeDef, len(defs))
+	for _, def := range defs {
+		m[def.Key] = def
+	}
+	return m, nil
+}
+
+// normalizeAttribute 校验属性值并转换为保存的类型
+//
+// value 可以是 JSON 解析后的值，也可以是 CSV 中的字符串。
+func normalizeAttribute(def *entities.MemberAttributeDef, value any) (any, error) {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = strings.TrimSpace(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		s = strconv.Itoa(v)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		return nil, fmt.Errorf("attribute %s: invalid value", def.Key)
+	}
+
+	switch def.Type {
+	case AttributeNumber:
+		n, err := strconv.ParseFloat(s, 64)
+		// ParseFloat 接受 NaN、Inf，无法比较和导出
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, fmt.Errorf("attribute %s: invalid number %s", def.Key, s)
+		}
+		return n, nil
+	case AttributeDate:
+		t, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: invalid date %s", def.Key, s)
+		}
+		return t.Format(time.DateOnly), nil
+	case AttributeEnum:
+		if !slices.Contains(def.Options, s) {
+			return nil, fmt.Errorf("attribute %s: %s not in options", def.Key, s)
+		}
+		return s, nil
+	default:
+		if len([]rune(s)) > maxAttributeStringLen {
+			return nil, fmt.Errorf("attribute %s: value too long", def.Key)
+		}
+		return s, nil
+	}
+}
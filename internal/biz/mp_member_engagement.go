@@ -0,0 +1,131 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/message"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.uber.org/zap"
+)
+
+const (
+	engagementWindowDays = 30 // 频率统计的时间范围
+	engagementBatch      = 500
+)
+
+// engagementActivities 计入互动频率的动态类型
+var engagementActivities = []string{
+	ActivitySubscribe, ActivityScan, ActivityMenuClick, ActivityEvent, ActivityMessage,
+}
+
+// 距最后互动的天数 => 最近度得分，满分 50
+var recencyLevels = []struct {
+	days  int64
+	score int64
+}{{1, 50}, {7, 40}, {14, 30}, {30, 20}, {90, 10}}
+
+// 统计范围内的互动次数 => 频率得分，满分 50
+var frequencyLevels = []struct {
+	count int64
+	score int64
+}{{20, 50}, {10, 40}, {5, 30}, {2, 20}, {1, 10}}
+
+// EngagementUsecase 粉丝活跃度
+//
+// 发送的消息数由推送消息实时累加；活跃度评分按最近度(R)和频率(F)由定时任务计算，
+// 评分范围 0-100，未关注的粉丝为 0。
+//
+// 微信不推送留言和赞赏，本项目也没有留言管理，留言数、精选留言数、赞赏次数和金额不统计。
+type EngagementUsecase struct {
+	log          *zap.Logger
+	memberRepo   MPMemberRepo
+	timelineRepo MemberTimelineRepo
+}
+
+func NewEngagementUsecase(log *zap.Logger, memberRepo MPMemberRepo,
+	timelineRepo MemberTimelineRepo,
+) *EngagementUsecase {
+	return &EngagementUsecase{
+		log:          log,
+		memberRepo:   memberRepo,
+		timelineRepo: timelineRepo,
+	}
+}
+
+// OnMessage 实现 message.Subscriber，累加粉丝发送的消息数
+//
+// 微信在未及时响应时会重试推送，按 MsgId 去重。
+func (u *EngagementUsecase) OnMessage(c context.Context, app *entities.PlatformApp,
+	msg *message.MixMessage,
+) error {
+	if msg.MsgType == message.MsgTypeEvent || !msg.IsInteraction() {
+		return nil
+	}
+	return u.memberRepo.IncrMessageCount(c, app.ID.Hex(), msg.GetOpenID(), msg.MsgId)
+}
+
+// Refresh 重新计算应用下全部粉丝的活跃度评分，返回评分有变化的粉丝数
+func (u *EngagementUsecase) Refresh(c context.Context, appId string) (int, error) {
+	now := time.Now()
+	since := now.AddDate(0, 0, -engagementWindowDays).Unix()
+	counts, err := u.timelineRepo.CountByMember(c, appId, engagementActivities, since)
+	if err != nil {
+		u.log.Error("count member activities error", zap.Error(err))
+		return 0, fmt.Errorf("count member activities error")
+	}
+
+	count := 0
+	scores := make(map[string]int64, engagementBatch)
+	filter := &request.MPMemberFilter{Blocked: "all"}
+	err = u.memberRepo.Iterate(c, appId, filter, func(member *entities.MPMember) error {
+		score := engagementScore(member, counts[member.OpenId], now)
+		if score == member.Engagement && member.EngagementAt > 0 {
+			return nil
+		}
+		scores[member.OpenId] = score
+		if len(scores) < engagementBatch {
+			return nil
+		}
+		count += len(scores)
+		err := u.memberRepo.UpdateEngagement(c, appId, scores, now.Unix())
+		clear(scores)
+		return err
+	})
+	if err == nil && len(scores) > 0 {
+		count += len(scores)
+		err = u.memberRepo.UpdateEngagement(c, appId, scores, now.Unix())
+	}
+	if err != nil {
+		u.log.Error("update member engagement error", zap.Error(err))
+		return count, fmt.Errorf("update member engagement error")
+	}
+	u.log.Info("member engagement refreshed", zap.String("appId", appId), zap.Int("updated", count))
+	return count, nil
+}
+
+// engagementScore 最近度 + 频率
+func engagementScore(member *entities.MPMember, interactions int64, now time.Time) int64 {
+	if member.Subscribe != 1 {
+		return 0
+	}
+	var score int64
+	if member.LastMessageAt > 0 {
+		days := (now.Unix() - member.LastMessageAt) / 86400
+		for _, level := range recencyLevels {
+			if days < level.days {
+				score += level.score
+				break
+			}
+		}
+	}
+	for _, level := range frequencyLevels {
+		if interactions >= level.count {
+			score += level.score
+			break
+		}
+	}
+	return score
+}
@@ -0,0 +1,234 @@
+package biz
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.uber.org/zap"
+)
+
+// 粉丝数据删除方式
+const (
+	ErasureDelete    = "delete"    // 删除粉丝记录
+	ErasureAnonymize = "anonymize" // 保留粉丝记录用于统计，清除个人信息，openid 替换为假名
+)
+
+// 单类数据的处理方式
+const (
+	ErasureActionDelete    = "delete"
+	ErasureActionAnonymize = "anonymize"
+	ErasureActionUnlink    = "unlink" // 从跨公众号身份中移除
+)
+
+// ErasureTarget 要删除数据的粉丝
+type ErasureTarget struct {
+	AppId     string
+	OpenId    string
+	Pseudonym string // 匿名化后的 openid
+}
+
+type MemberErasureRepo interface {
+	// UnionIdTargets 应用下 unionid 对应的粉丝
+	UnionIdTargets(c context.Context, appId, unionId string) ([]*ErasureTarget, error)
+	// Affected 统计将被处理的数据，不做修改
+	Affected(c context.Context, targets []*ErasureTarget, mode string) ([]*entities.ErasureItem, error)
+	// Erase 删除或匿名化数据，出错时返回已处理的部分
+	Erase(c context.Context, targets []*ErasureTarget, mode string) ([]*entities.ErasureItem, error)
+	SaveAudit(c context.Context, audit *entities.MemberErasure) (string, error)
+	QueryAudits(c context.Context, appId string,
+		params *request.PagingQuery) (*model.PageResult[*entities.MemberErasure], error)
+}
+
+// ErasureResult 删除结果，DryRun 时只列出将被处理的数据
+type ErasureResult struct {
+	DryRun   bool                    `json:"dry_run"`
+	Mode     string                  `json:"mode"`
+	Accounts []string                `json:"accounts"` // 涉及的平台应用ID
+	Items    []*entities.ErasureItem `json:"items"`
+	AuditId  string                  `json:"audit_id,omitempty"`
+}
+
+// MemberErasureUsecase 按粉丝要求删除其数据
+//
+// 按 openid 只处理当前公众号的数据；按 unionid 处理同一用户下所有已关联公众号的数据。
+// 粉丝记录、动态、黑名单、批量打标签失败记录、跨公众号身份和粉丝发送的媒体文件都会被处理，
+// 导出文件不落地保存。
+// 粉丝仍关注公众号时，下次同步会重新拉取其基本信息。
+// 微信后台的备注和标签不会清除，同步后会重新写入本地。
+type MemberErasureUsecase struct {
+	log          *zap.Logger
+	repo         MemberErasureRepo
+	identityRepo MemberIdentityRepo
+	inboundUc    *InboundMediaUsecase
+	secret       []byte
+}
+
+// NewMemberErasureUsecase secret 用于生成假名和审计记录中的摘要
+//
+// secret 必须配置且不能修改，否则同一 openid 的假名会变化，再次匿名化时不能替换之前的记录。
+func NewMemberErasureUsecase(log *zap.Logger, repo MemberErasureRepo,
+	identityRepo MemberIdentityRepo, inboundUc *InboundMediaUsecase, secret string,
+) (*MemberErasureUsecase, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("erasure secret not configured")
+	}
+	return &MemberErasureUsecase{log: log, repo: repo, identityRepo: identityRepo, inboundUc: inboundUc,
+		secret: []byte(secret)}, nil
+}
+
+// pseudonym 匿名化后的 openid，同一 openid 的假名固定，没有密钥时无法由 openid 推算
+func (u *MemberErasureUsecase) pseudonym(openid string) string {
+	return "erased_" + u.hashSubject(openid)[:24]
+}
+
+// hashSubject 使用 HMAC-SHA256 计算摘要
+func (u *MemberErasureUsecase) hashSubject(s string) string {
+	mac := hmac.New(sha256.New, u.secret)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Erase 删除粉丝数据，dryRun 时只返回将被处理的数据
+func (u *MemberErasureUsecase) Erase(c context.Context, appId, operator string,
+	req *request.MemberErasureReq,
+) (*ErasureResult, error) {
+	app, err := GetAppInfoFromCtx(c)
+	if err != nil {
+		u.log.Error("get app info error", zap.Error(err))
+		return nil, fmt.Errorf("get app info error")
+	}
+	mode := req.Mode
+	if mode == "" {
+		mode = ErasureDelete
+	}
+	if mode != ErasureDelete && mode != ErasureAnonymize {
+		return nil, fmt.Errorf("mode %s not allowed", req.Mode)
+	}
+	if (req.OpenId == "") == (req.UnionId == "") {
+		return nil, fmt.Errorf("one of openid and unionid required")
+	}
+
+	targets, err := u.targets(c, appId, app.UserId, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("member not found")
+	}
+	for _, target := range targets {
+		target.Pseudonym = u.pseudonym(target.OpenId)
+	}
+	result := &ErasureResult{DryRun: req.DryRun, Mode: mode, Accounts: []string{}}
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		if !seen[target.AppId] {
+			seen[target.AppId] = true
+			result.Accounts = append(result.Accounts, target.AppId)
+		}
+	}
+
+	if req.DryRun {
+		result.Items, err = u.repo.Affected(c, targets, mode)
+		if err == nil {
+			var count int64
+			count, err = u.inboundUc.Erase(c, targets, true)
+			result.Items = append(result.Items, &entities.ErasureItem{
+				Collection: "inbound_media", Action: ErasureActionDelete, Count: count,
+			})
+		}
+		if err != nil {
+			u.log.Error("count erasure data error", zap.Error(err))
+			return nil, fmt.Errorf("count erasure data error")
+		}
+		return result, nil
+	}
+
+	// 部分数据处理失败时也记录审计
+	items, eraseErr := u.repo.Erase(c, targets, mode)
+	if eraseErr == nil {
+		// 媒体文件不做匿名化，都删除
+		var count int64
+		count, eraseErr = u.inboundUc.Erase(c, targets, false)
+		items = append(items, &entities.ErasureItem{
+			Collection: "inbound_media", Action: ErasureActionDelete, Count: count,
+		})
+	}
+	result.Items = items
+	audit := &entities.MemberErasure{
+		AppId:     appId,
+		MpId:      app.MpId,
+		Mode:      mode,
+		Accounts:  result.Accounts,
+		Items:     items,
+		Reason:    req.Reason,
+		Operator:  operator,
+		CreatedAt: time.Now().Unix(),
+	}
+	if req.OpenId != "" {
+		audit.SubjectType, audit.SubjectHash = "openid", u.hashSubject(req.OpenId)
+	} else {
+		audit.SubjectType, audit.SubjectHash = "unionid", u.hashSubject(req.UnionId)
+	}
+	auditId, err := u.repo.SaveAudit(c, audit)
+	if err != nil {
+		u.log.Error("save erasure audit error", zap.Error(err))
+	}
+	result.AuditId = auditId
+
+	if eraseErr != nil {
+		u.log.Error("erase member data error", zap.Error(eraseErr))
+		return result, fmt.Errorf("erase member data error")
+	}
+	u.log.Info("member data erased", zap.String("appId", appId), zap.String("audit", auditId),
+		zap.String("mode", mode), zap.Int("accounts", len(result.Accounts)))
+	return result, nil
+}
+
+// targets 按 openid 或 unionid 查找要处理的粉丝
+func (u *MemberErasureUsecase) targets(c context.Context, appId, userId string,
+	req *request.MemberErasureReq,
+) ([]*ErasureTarget, error) {
+	if req.OpenId != "" {
+		return []*ErasureTarget{{AppId: appId, OpenId: req.OpenId}}, nil
+	}
+
+	targets, err := u.repo.UnionIdTargets(c, appId, req.UnionId)
+	if err != nil {
+		u.log.Error("find members by unionid error", zap.Error(err))
+		return nil, fmt.Errorf("find members error")
+	}
+	// 身份不存在时只处理当前应用
+	identity, err := u.identityRepo.GetByUnionId(c, userId, req.UnionId)
+	if err != nil {
+		return targets, nil
+	}
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		seen[target.AppId+":"+target.OpenId] = true
+	}
+	for _, account := range identity.Accounts {
+		if !seen[account.AppId+":"+account.OpenId] {
+			targets = append(targets, &ErasureTarget{AppId: account.AppId, OpenId: account.OpenId})
+		}
+	}
+	return targets, nil
+}
+
+// QueryAudits 删除记录
+func (u *MemberErasureUsecase) QueryAudits(c context.Context, appId string,
+	params *request.PagingQuery,
+) (*model.PageResult[*entities.MemberErasure], error) {
+	result, err := u.repo.QueryAudits(c, appId, params)
+	if err != nil {
+		u.log.Error("query erasure audits error", zap.Error(err))
+		return nil, fmt.Errorf("query erasure audits error")
+	}
+	return result, nil
+}
@@ -0,0 +1,151 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"github.com/seth16888/wxbusiness/pkg/export"
+	"go.uber.org/zap"
+)
+
+// 可导出的粉丝字段
+var memberExportColumns = map[string]string{
+	"openid":          "OpenID",
+	"unionid":         "UnionID",
+	"remark":          "备注",
+	"nick_name":       "昵称",
+	"tags":            "标签",
+	"subscribe_time":  "关注时间",
+	"subscribe_scene": "关注渠道",
+	"qr_scene":        "二维码场景值",
+	"qr_scene_str":    "二维码场景描述",
+	"language":        "语言",
+	"blocked":         "黑名单",
+}
+
+// DefaultMemberExportColumns 默认导出的粉丝字段
+var DefaultMemberExportColumns = []string{
+	"openid", "unionid", "remark", "tags", "subscribe_time", "subscribe_scene",
+}
+
+// MemberExportUsecase 粉丝导出
+type MemberExportUsecase struct {
+	log        *zap.Logger
+	memberRepo MPMemberRepo
+	tagRepo    MemberTagRepo
+}
+
+func NewMemberExportUsecase(log *zap.Logger, memberRepo MPMemberRepo,
+	tagRepo MemberTagRepo,
+) *MemberExportUsecase {
+	return &MemberExportUsecase{log: log, memberRepo: memberRepo, tagRepo: tagRepo}
+}
+
+// ValidateColumns 校验导出字段，为空时返回默认字段
+func (u *MemberExportUsecase) ValidateColumns(columns []string) ([]string, error) {
+	if len(columns) == 0 {
+		return DefaultMemberExportColumns, nil
+	}
+	for _, col := range columns {
+		if _, ok := memberExportColumns[col]; !ok {
+			return nil, fmt.Errorf("column %s not allowed", col)
+		}
+	}
+	return columns, nil
+}
+
+// Export 按筛选条件导出粉丝，逐条从数据库读取并写入 w
+//
+// 出错时 w 中可能已写入部分数据，调用方需要丢弃。
+func (u *MemberExportUsecase) Export(c context.Context, appId string,
+	filter *request.MPMemberFilter, columns []string, format string, w io.Writer,
+) error {
+	// 标签ID -> 名称
+	tagNames := make(map[int64]string)
+	if slices.Contains(columns, "tags") {
+		tags, err := u.tagRepo.Query(c, appId)
+		if err != nil {
+			u.log.Error("query tag error", zap.Error(err))
+			return fmt.Errorf("query tag error")
+		}
+		for _, tag := range tags {
+			tagNames[tag.TagId] = tag.Name
+		}
+	}
+
+	rw, err := export.NewRowWriter(format, w)
+	if err != nil {
+		return err
+	}
+	// 出错返回时释放 XLSX 的临时文件
+	defer rw.Close()
+
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = memberExportColumns[col]
+	}
+	if err := rw.WriteRow(header); err != nil {
+		return err
+	}
+
+	count := 0
+	err = u.memberRepo.Iterate(c, appId, filter, func(member *entities.MPMember) error {
+		count++
+		return rw.WriteRow(memberExportRow(member, columns, tagNames))
+	})
+	if err != nil {
+		u.log.Error("export member error", zap.Error(err), zap.Int("rows", count))
+		return fmt.Errorf("export member error")
+	}
+	u.log.Debug("export member", zap.String("appId", appId), zap.Int("rows", count))
+
+	return rw.Close()
+}
+
+func memberExportRow(m *entities.MPMember, columns []string, tagNames map[int64]string) []string {
+	row := make([]string, len(columns))
+	for i, col := range columns {
+		switch col {
+		case "openid":
+			row[i] = m.OpenId
+		case "unionid":
+			row[i] = m.UnionId
+		case "remark":
+			row[i] = m.Remark
+		case "nick_name":
+			row[i] = m.NickName
+		case "tags":
+			names := make([]string, 0, len(m.Tags))
+			for _, tag := range m.Tags {
+				if name, ok := tagNames[tag.TagId]; ok {
+					names = append(names, name)
+				} else {
+					names = append(names, strconv.FormatInt(tag.TagId, 10))
+				}
+			}
+			row[i] = strings.Join(names, ",")
+		case "subscribe_time":
+			if m.SubscribeTime > 0 {
+				row[i] = time.Unix(m.SubscribeTime, 0).Format(time.DateTime)
+			}
+		case "subscribe_scene":
+			row[i] = m.SubscribeScene
+		case "qr_scene":
+			row[i] = strconv.FormatInt(m.QrScene, 10)
+		case "qr_scene_str":
+			row[i] = m.QrSceneStr
+		case "language":
+			row[i] = m.Language
+		case "blocked":
+			row[i] = strconv.FormatBool(m.Blocked)
+		}
+	}
+	return row
+}
@@ -0,0 +1,197 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.uber.org/zap"
+)
+
+type MemberIdentityRepo interface {
+	Link(c context.Context, userId string, members []*entities.MPMember, ts int64) error // 忽略没有unionid的粉丝
+	GetByUnionId(c context.Context, userId, unionId string) (*entities.MemberIdentity, error)
+	GetByOpenId(c context.Context, userId, openid string) (*entities.MemberIdentity, error)
+}
+
+// MemberProfile 跨公众号汇总的用户信息
+type MemberProfile struct {
+	Identity       *entities.MemberIdentity   `json:"identity"`
+	Members        []*entities.MPMember       `json:"members"`         // 各公众号的粉丝信息
+	Tags           []*ProfileTag              `json:"tags"`            // 各公众号的标签
+	Subscribed     bool                       `json:"subscribed"`      // 是否关注了任一公众号
+	SubscribedApps []string                   `json:"subscribed_apps"` // 已关注的平台应用ID
+	Activities     []*entities.MemberActivity `json:"activities"`      // 最近的动态
+}
+
+// ProfileTag 粉丝在某个公众号下的标签
+type ProfileTag struct {
+	AppId string `json:"app_id"`
+	TagId int64  `json:"tag_id"`
+	Name  string `json:"name"`
+}
+
+// profileActivityLimit 汇总时返回的最近动态数量
+const profileActivityLimit = 20
+
+// MemberIdentityUsecase 跨公众号用户身份
+//
+// 同一开放平台下的公众号粉丝 unionid 相同，按 unionid 将同一用户(平台账号)下各应用的粉丝关联起来。
+type MemberIdentityUsecase struct {
+	log          *zap.Logger
+	repo         MemberIdentityRepo
+	memberRepo   MPMemberRepo
+	tagRepo      MemberTagRepo
+	timelineRepo MemberTimelineRepo
+}
+
+func NewMemberIdentityUsecase(log *zap.Logger, repo MemberIdentityRepo, memberRepo MPMemberRepo,
+	tagRepo MemberTagRepo, timelineRepo MemberTimelineRepo,
+) *MemberIdentityUsecase {
+	return &MemberIdentityUsecase{
+		log:          log,
+		repo:         repo,
+		memberRepo:   memberRepo,
+		tagRepo:      tagRepo,
+		timelineRepo: timelineRepo,
+	}
+}
+
+// Link 关联粉丝，同步粉丝后调用
+func (u *MemberIdentityUsecase) Link(c context.Context, userId string, members []*entities.MPMember) error {
+	if u == nil {
+		return nil
+	}
+	if err := u.repo.Link(c, userId, members, time.Now().Unix()); err != nil {
+		u.log.Error("link member identity error", zap.Error(err))
+		return fmt.Errorf("link member identity error")
+	}
+	return nil
+}
+
+// Rebuild 按本地粉丝数据重新关联应用下的全部粉丝
+func (u *MemberIdentityUsecase) Rebuild(c context.Context, appId string) (int, error) {
+	app, err := GetAppInfoFromCtx(c)
+	if err != nil {
+		u.log.Error("get app info error", zap.Error(err))
+		return 0, fmt.Errorf("get app info error")
+	}
+
+	const batch = 500
+	count := 0
+	members := make([]*entities.MPMember, 0, batch)
+	filter := &request.MPMemberFilter{Blocked: "all"}
+	err = u.memberRepo.Iterate(c, appId, filter, func(member *entities.MPMember) error {
+		if member.UnionId == "" {
+			return nil
+		}
+		members = append(members, member)
+		if len(members) < batch {
+			return nil
+		}
+		count += len(members)
+		err := u.Link(c, app.UserId, members)
+		members = members[:0]
+		return err
+	})
+	if err == nil && len(members) > 0 {
+		count += len(members)
+		err = u.Link(c, app.UserId, members)
+	}
+	if err != nil {
+		u.log.Error("rebuild member identity error", zap.Error(err))
+		return count, fmt.Errorf("rebuild member identity error")
+	}
+	return count, nil
+}
+
+// GetByUnionId 按 unionid 查询用户
+func (u *MemberIdentityUsecase) GetByUnionId(c context.Context, unionId string) (*MemberProfile, error) {
+	app, err := GetAppInfoFromCtx(c)
+	if err != nil {
+		u.log.Error("get app info error", zap.Error(err))
+		return nil, fmt.Errorf("get app info error")
+	}
+	identity, err := u.repo.GetByUnionId(c, app.UserId, unionId)
+	if err != nil {
+		return nil, fmt.Errorf("identity not found")
+	}
+	return u.profile(c, identity)
+}
+
+// GetByOpenId 按任一公众号的 openid 查询用户
+func (u *MemberIdentityUsecase) GetByOpenId(c context.Context, openid string) (*MemberProfile, error) {
+	app, err := GetAppInfoFromCtx(c)
+	if err != nil {
+		u.log.Error("get app info error", zap.Error(err))
+		return nil, fmt.Errorf("get app info error")
+	}
+	identity, err := u.repo.GetByOpenId(c, app.UserId, openid)
+	if err != nil {
+		return nil, fmt.Errorf("identity not found")
+	}
+	return u.profile(c, identity)
+}
+
+// profile 汇总各公众号的粉丝信息、标签、关注状态和动态
+func (u *MemberIdentityUsecase) profile(c context.Context, identity *entities.MemberIdentity,
+) (*MemberProfile, error) {
+	profile := &MemberProfile{
+		Identity:       identity,
+		Members:        []*entities.MPMember{},
+		Tags:           []*ProfileTag{},
+		SubscribedApps: []string{},
+		Activities:     []*entities.MemberActivity{},
+	}
+	for _, account := range identity.Accounts {
+		member, err := u.memberRepo.FindByOpenId(c, account.AppId, account.OpenId)
+		if err != nil {
+			// 粉丝数据已删除，只保留关联
+			u.log.Debug("find member error", zap.String("openid", account.OpenId), zap.Error(err))
+			continue
+		}
+		profile.Members = append(profile.Members, member)
+		if member.Subscribe == 1 {
+			profile.Subscribed = true
+			profile.SubscribedApps = append(profile.SubscribedApps, account.AppId)
+		}
+
+		if len(member.Tags) > 0 {
+			tags, err := u.tagRepo.Query(c, account.AppId)
+			if err != nil {
+				u.log.Error("query tag error", zap.Error(err))
+				return nil, fmt.Errorf("query tag error")
+			}
+			names := make(map[int64]string, len(tags))
+			for _, tag := range tags {
+				names[tag.TagId] = tag.Name
+			}
+			for _, tag := range member.Tags {
+				profile.Tags = append(profile.Tags, &ProfileTag{
+					AppId: account.AppId,
+					TagId: tag.TagId,
+					Name:  names[tag.TagId],
+				})
+			}
+		}
+
+		params := &request.MemberTimelineQuery{PagingQuery: request.PagingQuery{PageSize: profileActivityLimit}}
+		activities, err := u.timelineRepo.Query(c, account.AppId, account.OpenId, params)
+		if err != nil {
+			u.log.Error("query member timeline error", zap.Error(err))
+			return nil, fmt.Errorf("query member timeline error")
+		}
+		profile.Activities = append(profile.Activities, activities.List...)
+	}
+
+	sort.SliceStable(profile.Activities, func(i, j int) bool {
+		return profile.Activities[i].CreatedAt > profile.Activities[j].CreatedAt
+	})
+	if len(profile.Activities) > profileActivityLimit {
+		profile.Activities = profile.Activities[:profileActivityLimit]
+	}
+	return profile, nil
+}
@@ -0,0 +1,40 @@
+package biz
+
+import (
+	"strings"
+
+	"github.com/seth16888/wxbusiness/internal/message"
+)
+
+// 省级行政区的简称，地址以全称开头时也能匹配
+var provinces = []string{
+	"北京", "天津", "上海", "重庆", "河北", "山西", "辽宁", "吉林", "黑龙江",
+	"江苏", "浙江", "安徽", "福建", "江西", "山东", "河南", "湖北", "湖南",
+	"广东", "海南", "四川", "贵州", "云南", "陕西", "甘肃", "青海", "台湾",
+	"内蒙古", "广西", "西藏", "宁夏", "新疆", "香港", "澳门",
+}
+
+// ParseProvince 从地址中解析省份，返回简称，无法解析时返回空
+func ParseProvince(address string) string {
+	address = strings.TrimSpace(address)
+	address = strings.TrimPrefix(address, "中国")
+	for _, p := range provinces {
+		if strings.HasPrefix(address, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// msgProvince 从位置消息或地理位置选择器事件的地址中解析省份
+//
+// 上报地理位置事件（LOCATION）只有经纬度，没有地址，无法解析。
+func msgProvince(msg *message.MixMessage) string {
+	switch {
+	case msg.MsgType == message.MsgTypeLocation:
+		return ParseProvince(msg.Label)
+	case msg.MsgType == message.MsgTypeEvent && msg.Event == message.EventLocationSelect:
+		return ParseProvince(msg.SendLocationInfo.Label)
+	}
+	return ""
+}
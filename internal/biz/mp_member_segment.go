@@ -0,0 +1,168 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.uber.org/zap"
+)
+
+// MaxTaggingOpenIds 微信批量打标签每次最多50个openid
+const MaxTaggingOpenIds = 50
+
+type MemberSegmentRepo interface {
+	Create(c context.Context, segment *entities.MemberSegment) (string, error)
+	Update(c context.Context, appId, id string, segment *entities.MemberSegment) error
+	Delete(c context.Context, appId, id string) error
+	Get(c context.Context, appId, id string) (*entities.MemberSegment, error)
+	Query(c context.Context, appId string) ([]*entities.MemberSegment, error)
+}
+
+// MemberSegmentUsecase 粉丝分群
+//
+// 分群保存粉丝筛选条件，查询时实时计算，可用于批量打标签、群发。
+type MemberSegmentUsecase struct {
+	log          *zap.Logger
+	repo         MemberSegmentRepo
+	memberRepo   MPMemberRepo
+	memberUc     *MPMemberUsecase
+	taggingJobUc *TaggingJobUsecase
+}
+
+func NewMemberSegmentUsecase(log *zap.Logger, repo MemberSegmentRepo,
+	memberRepo MPMemberRepo, memberUc *MPMemberUsecase, taggingJobUc *TaggingJobUsecase,
+) *MemberSegmentUsecase {
+	return &MemberSegmentUsecase{log: log, repo: repo, memberRepo: memberRepo, memberUc: memberUc,
+		taggingJobUc: taggingJobUc}
+}
+
+// Create 创建分群
+func (u *MemberSegmentUsecase) Create(c context.Context, appId string,
+	req *request.MemberSegmentReq,
+) (string, error) {
+	if err := ValidateMemberFilter(&req.Filter); err != nil {
+		return "", err
+	}
+	app, err := GetAppInfoFromCtx(c)
+	if err != nil {
+		u.log.Error("get app info error", zap.Error(err))
+		return "", fmt.Errorf("get app info error")
+	}
+
+	now := time.Now().Unix()
+	filter := req.Filter
+	segment := &entities.MemberSegment{
+		AppId:       appId,
+		MpId:        app.MpId,
+		Name:        req.Name,
+		Description: req.Description,
+		Filter:      &filter,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	id, err := u.repo.Create(c, segment)
+	if err != nil {
+		u.log.Error("create segment error", zap.Error(err))
+		return "", fmt.Errorf("create segment error")
+	}
+	return id, nil
+}
+
+// Update 更新分群
+func (u *MemberSegmentUsecase) Update(c context.Context, appId, id string,
+	req *request.MemberSegmentReq,
+) error {
+	if err := ValidateMemberFilter(&req.Filter); err != nil {
+		return err
+	}
+	filter := req.Filter
+	segment := &entities.MemberSegment{
+		Name:        req.Name,
+		Description: req.Description,
+		Filter:      &filter,
+		UpdatedAt:   time.Now().Unix(),
+	}
+	if err := u.repo.Update(c, appId, id, segment); err != nil {
+		u.log.Error("update segment error", zap.Error(err))
+		return fmt.Errorf("update segment error")
+	}
+	return nil
+}
+
+// Delete 删除分群
+func (u *MemberSegmentUsecase) Delete(c context.Context, appId, id string) error {
+	if err := u.repo.Delete(c, appId, id); err != nil {
+		u.log.Error("delete segment error", zap.Error(err))
+		return fmt.Errorf("delete segment error")
+	}
+	return nil
+}
+
+// Query 分群列表
+func (u *MemberSegmentUsecase) Query(c context.Context, appId string) ([]*entities.MemberSegment, error) {
+	segments, err := u.repo.Query(c, appId)
+	if err != nil {
+		u.log.Error("query segment error", zap.Error(err))
+		return nil, fmt.Errorf("query segment error")
+	}
+	return segments, nil
+}
+
+// QueryMembers 查询分群下的粉丝，分页、排序参数使用 params，筛选条件使用分群保存的条件
+func (u *MemberSegmentUsecase) QueryMembers(c context.Context, appId, id string,
+	params *request.MPMemberQuery,
+) (*model.PageResult[*entities.MPMember], error) {
+	segment, err := u.repo.Get(c, appId, id)
+	if err != nil {
+		u.log.Error("get segment error", zap.Error(err))
+		return nil, fmt.Errorf("segment not found")
+	}
+	if segment.Filter != nil {
+		params.MPMemberFilter = *segment.Filter
+	}
+	return u.memberUc.Query(c, appId, params)
+}
+
+// ResolveOpenIds 返回分群下全部粉丝的openid
+func (u *MemberSegmentUsecase) ResolveOpenIds(c context.Context, appId, id string) ([]string, error) {
+	segment, err := u.repo.Get(c, appId, id)
+	if err != nil {
+		u.log.Error("get segment error", zap.Error(err))
+		return nil, fmt.Errorf("segment not found")
+	}
+	// 早期保存的分群可能包含无效条件
+	if err := ValidateMemberFilter(segment.Filter); err != nil {
+		return nil, err
+	}
+	openids, err := u.memberRepo.FindOpenIds(c, appId, segment.Filter)
+	if err != nil {
+		u.log.Error("find segment openids error", zap.Error(err))
+		return nil, fmt.Errorf("find segment members error")
+	}
+	return openids, nil
+}
+
+// Tagging 为分群下的全部粉丝打标签
+//
+// 创建批量打标签任务在后台执行，返回任务，进度通过任务查询。
+func (u *MemberSegmentUsecase) Tagging(c context.Context, appId, id string,
+	tagId int64,
+) (*entities.TaggingJob, error) {
+	segment, err := u.repo.Get(c, appId, id)
+	if err != nil {
+		u.log.Error("get segment error", zap.Error(err))
+		return nil, fmt.Errorf("segment not found")
+	}
+	openids, err := u.ResolveOpenIds(c, appId, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(openids) > MaxTaggingJobOpenIds {
+		return nil, fmt.Errorf("too many members, max %d", MaxTaggingJobOpenIds)
+	}
+	return u.taggingJobUc.Create(c, appId, tagId, "segment:"+segment.Name, openids)
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model/request"
 	v1 "github.com/seth16888/wxproxy/api/v1"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
@@ -22,23 +23,38 @@ type MemberTagRepo interface {
 
 // MemberTagUsecase 会员标签
 type MemberTagUsecase struct {
-	repo     MemberTagRepo
-	log      *zap.Logger
-	apiProxy *APIProxyUsecase
+	repo       MemberTagRepo
+	log        *zap.Logger
+	apiProxy   *APIProxyUsecase
+	memberRepo MPMemberRepo
 }
 
-func (u *MemberTagUsecase) Pull(ctx context.Context, appId string) error {
+// TagSyncSummary 标签同步结果
+type TagSyncSummary struct {
+	Created        []int64 `json:"created"`         // 新增的标签
+	Renamed        []int64 `json:"renamed"`         // 改名的标签
+	Deleted        []int64 `json:"deleted"`         // 微信后台已删除的标签
+	CountUpdated   int     `json:"count_updated"`   // 粉丝数有变化的标签数量
+	MembersAdded   int     `json:"members_added"`   // 新增的粉丝标签关系
+	MembersRemoved int     `json:"members_removed"` // 移除的粉丝标签关系
+}
+
+// Pull 双向同步公众号标签
+//
+// 对比本地和微信后台的标签，新增、改名、删除并更新粉丝数；
+// 再按标签拉取粉丝列表，重建粉丝的标签关系。
+func (u *MemberTagUsecase) Pull(ctx context.Context, appId string) (*TagSyncSummary, error) {
 	mpIdVar := ctx.Value("MP_ID")
 	if mpIdVar == nil {
 		u.log.Error("get mp id error")
-		return fmt.Errorf("get mp id error")
+		return nil, fmt.Errorf("get mp id error")
 	}
 	mpId := mpIdVar.(string)
 
   token, err := u.apiProxy.GetAccessToken(ctx, appId, mpId)
 	if err != nil {
 		u.log.Error("get access token error", zap.Error(err))
-		return fmt.Errorf("get access token error")
+		return nil, fmt.Errorf("get access token error")
 	}
 
   params := v1.AccessTokenParam {
@@ -47,29 +63,192 @@ func (u *MemberTagUsecase) Pull(ctx context.Context, appId string) error {
   reply, err := u.apiProxy.cli.GetTagList(ctx, &params)
 	if err != nil {
 		u.log.Error("get tag list error", zap.Error(err))
-		return fmt.Errorf("get tag list error")
-	}
-
-  now:= time.Now().Unix()
-  for _, tag := range reply.Tags {
-    u.repo.Save(ctx, &entities.MemberTag{
-      AppId:     appId,
-      MpId:      mpId,
-      Name:      tag.Name,
-      TagId:     tag.Id,
-      Count:     tag.Count,
-      CreatedAt: now,
-      UpdatedAt: now,
-    })
-  }
+		return nil, fmt.Errorf("get tag list error")
+	}
+
+	locals, err := u.repo.Query(ctx, appId)
+	if err != nil {
+		u.log.Error("query tag error", zap.Error(err))
+		return nil, fmt.Errorf("query tag error")
+	}
+	localMap := make(map[int64]*entities.MemberTag, len(locals))
+	for _, tag := range locals {
+		localMap[tag.TagId] = tag
+	}
 
-  return nil
+	summary := &TagSyncSummary{Created: []int64{}, Renamed: []int64{}, Deleted: []int64{}}
+	now := time.Now().Unix()
+	remoteIds := make(map[int64]bool, len(reply.Tags))
+	for _, tag := range reply.Tags {
+		remoteIds[tag.Id] = true
+
+		// 先重建粉丝关系，本地 BatchTagging 会修改 count，最后统一保存
+		added, removed, err := u.syncTagMembers(ctx, appId, token, tag.Id)
+		if err != nil {
+			return nil, err
+		}
+		summary.MembersAdded += added
+		summary.MembersRemoved += removed
+
+		local, ok := localMap[tag.Id]
+		switch {
+		case !ok:
+			summary.Created = append(summary.Created, tag.Id)
+		case local.Name != tag.Name:
+			summary.Renamed = append(summary.Renamed, tag.Id)
+		}
+		if ok && local.Count != tag.Count {
+			summary.CountUpdated++
+		}
+		err = u.repo.Save(ctx, &entities.MemberTag{
+			AppId:     appId,
+			MpId:      mpId,
+			Name:      tag.Name,
+			TagId:     tag.Id,
+			Count:     tag.Count,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+		if err != nil {
+			u.log.Error("save tag error", zap.Error(err), zap.Int64("tagId", tag.Id))
+			return nil, fmt.Errorf("save tag error")
+		}
+	}
+
+	// 微信后台已删除的标签
+	for _, tag := range locals {
+		if remoteIds[tag.TagId] {
+			continue
+		}
+		openids, err := u.tagOpenIds(ctx, appId, tag.TagId)
+		if err != nil {
+			return nil, err
+		}
+		if err := u.untagMembers(ctx, appId, openids, tag.TagId); err != nil {
+			return nil, err
+		}
+		summary.MembersRemoved += len(openids)
+		if err := u.repo.Delete(ctx, appId, tag.TagId); err != nil {
+			u.log.Error("delete tag error", zap.Error(err), zap.Int64("tagId", tag.TagId))
+			return nil, fmt.Errorf("delete tag error")
+		}
+		summary.Deleted = append(summary.Deleted, tag.TagId)
+	}
+
+	u.log.Info("pull tags", zap.String("appId", appId), zap.Any("summary", summary))
+	return summary, nil
+}
+
+// syncTagMembers 按微信后台的标签粉丝列表重建本地粉丝的标签关系
+func (u *MemberTagUsecase) syncTagMembers(ctx context.Context, appId, token string,
+	tagId int64,
+) (int, int, error) {
+	remote, err := u.fetchTagMembers(ctx, token, tagId)
+	if err != nil {
+		u.log.Error("get tag members error", zap.Error(err), zap.Int64("tagId", tagId))
+		return 0, 0, fmt.Errorf("get tag members error")
+	}
+	locals, err := u.tagOpenIds(ctx, appId, tagId)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	remoteSet := make(map[string]bool, len(remote))
+	for _, openid := range remote {
+		remoteSet[openid] = true
+	}
+	localSet := make(map[string]bool, len(locals))
+	removes := make([]string, 0)
+	for _, openid := range locals {
+		localSet[openid] = true
+		if !remoteSet[openid] {
+			removes = append(removes, openid)
+		}
+	}
+	adds := make([]string, 0)
+	for _, openid := range remote {
+		if !localSet[openid] {
+			adds = append(adds, openid)
+		}
+	}
+
+	for i := 0; i < len(adds); i += tagMemberBatch {
+		chunk := adds[i:min(i+tagMemberBatch, len(adds))]
+		if err := u.memberRepo.BatchTagging(ctx, appId, chunk, tagId); err != nil {
+			u.log.Error("batch tagging error", zap.Error(err))
+			return 0, 0, fmt.Errorf("batch tagging error")
+		}
+	}
+	if err := u.untagMembers(ctx, appId, removes, tagId); err != nil {
+		return 0, 0, err
+	}
+	return len(adds), len(removes), nil
+}
+
+// tagMemberBatch 本地更新粉丝标签时每批的数量
+const tagMemberBatch = 1000
+
+func (u *MemberTagUsecase) untagMembers(ctx context.Context, appId string,
+	openids []string, tagId int64,
+) error {
+	for i := 0; i < len(openids); i += tagMemberBatch {
+		chunk := openids[i:min(i+tagMemberBatch, len(openids))]
+		if err := u.memberRepo.BatchUnTagging(ctx, appId, chunk, tagId); err != nil {
+			u.log.Error("batch untagging error", zap.Error(err))
+			return fmt.Errorf("batch untagging error")
+		}
+	}
+	return nil
+}
+
+// tagOpenIds 本地打了该标签的粉丝，包括黑名单
+func (u *MemberTagUsecase) tagOpenIds(ctx context.Context, appId string, tagId int64) ([]string, error) {
+	filter := &request.MPMemberFilter{TagIds: []int64{tagId}, Blocked: "all"}
+	openids, err := u.memberRepo.FindOpenIds(ctx, appId, filter)
+	if err != nil {
+		u.log.Error("find members error", zap.Error(err))
+		return nil, fmt.Errorf("find members error")
+	}
+	return openids, nil
+}
+
+// fetchTagMembers 获取标签下的全部粉丝，每次最多返回10000个
+func (u *MemberTagUsecase) fetchTagMembers(ctx context.Context, token string, tagId int64) ([]string, error) {
+	type reqT struct {
+		TagId      int64  `json:"tagid"`
+		NextOpenId string `json:"next_openid"`
+	}
+	type resultT struct {
+		Count int `json:"count"`
+		Data  struct {
+			OpenId []string `json:"openid"`
+		} `json:"data"`
+		NextOpenId string `json:"next_openid"`
+	}
+
+	openids := make([]string, 0)
+	next := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var result resultT
+		if err := postWXAPI(ctx, pathGetTagMembers, token, reqT{TagId: tagId, NextOpenId: next}, &result); err != nil {
+			return nil, err
+		}
+		openids = append(openids, result.Data.OpenId...)
+		if result.Count == 0 || result.NextOpenId == "" || result.NextOpenId == next {
+			break
+		}
+		next = result.NextOpenId
+	}
+	return openids, nil
 }
 
 func NewMemberTagUsecase(repo MemberTagRepo, log *zap.Logger,
-	apiProxy *APIProxyUsecase,
+	apiProxy *APIProxyUsecase, memberRepo MPMemberRepo,
 ) *MemberTagUsecase {
-	return &MemberTagUsecase{repo: repo, log: log, apiProxy: apiProxy}
+	return &MemberTagUsecase{repo: repo, log: log, apiProxy: apiProxy, memberRepo: memberRepo}
 }
 
 func (u *MemberTagUsecase) Create(ctx context.Context, appId, tagName string) error {
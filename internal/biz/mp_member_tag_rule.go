@@ -0,0 +1,319 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/message"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.uber.org/zap"
+)
+
+// 自动打标签规则类型
+const (
+	TagRuleQrScene   = "qr_scene"   // 通过二维码场景值关注或扫码
+	TagRuleKeyword   = "keyword"    // 发送包含关键词的消息
+	TagRuleMenuClick = "menu_click" // 点击菜单KEY
+	TagRuleProvince  = "province"   // 所在省份
+	TagRuleInactive  = "inactive"   // 指定天数内未互动
+)
+
+// 自动打标签规则动作
+const (
+	TagRuleActionAdd    = "add"
+	TagRuleActionRemove = "remove"
+)
+
+var allowedTagRules = []string{TagRuleQrScene, TagRuleKeyword, TagRuleMenuClick, TagRuleProvince, TagRuleInactive}
+
+type TagRuleRepo interface {
+	Create(c context.Context, rule *entities.TagRule) (string, error)
+	Update(c context.Context, appId, id string, rule *entities.TagRule) error
+	Delete(c context.Context, appId, id string) error
+	Query(c context.Context, appId string) ([]*entities.TagRule, error)
+	QueryEnabled(c context.Context, appId string) ([]*entities.TagRule, error)
+	UpdateSweptAt(c context.Context, id string, ts int64) error
+}
+
+// TagRuleUsecase 自动打标签
+//
+// 规则在收到推送消息时实时执行，并由定时任务对已有粉丝批量执行。
+// 关键词、菜单点击只能从消息中判断，批量执行时跳过。
+type TagRuleUsecase struct {
+	log        *zap.Logger
+	repo       TagRuleRepo
+	memberRepo MPMemberRepo
+	memberUc   *MPMemberUsecase
+}
+
+func NewTagRuleUsecase(log *zap.Logger, repo TagRuleRepo, memberRepo MPMemberRepo,
+	memberUc *MPMemberUsecase,
+) *TagRuleUsecase {
+	return &TagRuleUsecase{log: log, repo: repo, memberRepo: memberRepo, memberUc: memberUc}
+}
+
+// validateTagRule 校验规则，返回规则实体
+func validateTagRule(req *request.TagRuleReq) (*entities.TagRule, error) {
+	if !slices.Contains(allowedTagRules, req.Type) {
+		return nil, fmt.Errorf("type %s not allowed", req.Type)
+	}
+	action := req.Action
+	if action == "" {
+		action = TagRuleActionAdd
+	}
+	if action != TagRuleActionAdd && action != TagRuleActionRemove {
+		return nil, fmt.Errorf("action %s not allowed", req.Action)
+	}
+	if req.TagId <= 0 {
+		return nil, fmt.Errorf("tag_id required")
+	}
+	if req.Type == TagRuleInactive {
+		if req.Days <= 0 {
+			return nil, fmt.Errorf("days required")
+		}
+		// 再次互动时自动取消标签
+		if action != TagRuleActionAdd {
+			return nil, fmt.Errorf("inactive rule only supports add")
+		}
+	} else if strings.TrimSpace(req.Value) == "" {
+		return nil, fmt.Errorf("value required")
+	}
+	value := strings.TrimSpace(req.Value)
+	if req.Type == TagRuleProvince {
+		// 与粉丝保存的省份一致，使用简称
+		if value = ParseProvince(value); value == "" {
+			return nil, fmt.Errorf("province %s not supported", req.Value)
+		}
+	}
+	return &entities.TagRule{
+		Name:    req.Name,
+		TagId:   req.TagId,
+		Type:    req.Type,
+		Value:   value,
+		Days:    req.Days,
+		Action:  action,
+		Enabled: req.Enabled,
+	}, nil
+}
+
+// Create 创建规则
+func (u *TagRuleUsecase) Create(c context.Context, appId string, req *request.TagRuleReq) (string, error) {
+	app, err := GetAppInfoFromCtx(c)
+	if err != nil {
+		u.log.Error("get app info error", zap.Error(err))
+		return "", fmt.Errorf("get app info error")
+	}
+	rule, err := validateTagRule(req)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().Unix()
+	rule.AppId = appId
+	rule.MpId = app.MpId
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	id, err := u.repo.Create(c, rule)
+	if err != nil {
+		u.log.Error("create tag rule error", zap.Error(err))
+		return "", fmt.Errorf("create tag rule error")
+	}
+	return id, nil
+}
+
+// Update 更新规则
+func (u *TagRuleUsecase) Update(c context.Context, appId, id string, req *request.TagRuleReq) error {
+	rule, err := validateTagRule(req)
+	if err != nil {
+		return err
+	}
+	rule.UpdatedAt = time.Now().Unix()
+	if err := u.repo.Update(c, appId, id, rule); err != nil {
+		u.log.Error("update tag rule error", zap.Error(err))
+		return fmt.Errorf("update tag rule error")
+	}
+	return nil
+}
+
+// Delete 删除规则，已打的标签不会取消
+func (u *TagRuleUsecase) Delete(c context.Context, appId, id string) error {
+	if err := u.repo.Delete(c, appId, id); err != nil {
+		u.log.Error("delete tag rule error", zap.Error(err))
+		return fmt.Errorf("delete tag rule error")
+	}
+	return nil
+}
+
+// Query 规则列表
+func (u *TagRuleUsecase) Query(c context.Context, appId string) ([]*entities.TagRule, error) {
+	rules, err := u.repo.Query(c, appId)
+	if err != nil {
+		u.log.Error("query tag rules error", zap.Error(err))
+		return nil, fmt.Errorf("query tag rules error")
+	}
+	return rules, nil
+}
+
+// OnMessage 实现 message.Subscriber，根据推送的消息执行规则
+func (u *TagRuleUsecase) OnMessage(c context.Context, app *entities.PlatformApp,
+	msg *message.MixMessage,
+) error {
+	if !msg.IsInteraction() {
+		return nil
+	}
+	appId := app.ID.Hex()
+	rules, err := u.repo.QueryEnabled(c, appId)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+
+	openid := msg.GetOpenID()
+	province := msgProvince(msg)
+	tagged := make(map[int64]bool)
+	// 新关注的粉丝可能还未同步到本地，按没有标签处理
+	if member, err := u.memberRepo.FindByOpenId(c, appId, openid); err == nil && member != nil {
+		for _, tag := range member.Tags {
+			tagged[tag.TagId] = true
+		}
+		// 上报地理位置事件只有经纬度，使用粉丝发送位置时保存的省份
+		if msg.MsgType == message.MsgTypeEvent && msg.Event == message.EventLocation {
+			province = member.Province
+		}
+	}
+
+	for _, rule := range rules {
+		action := matchTagRule(rule, msg, province)
+		if action == TagRuleActionAdd && !tagged[rule.TagId] {
+			if err := u.memberUc.BatchTagging(c, appId, []string{openid}, rule.TagId); err != nil {
+				u.log.Error("tag rule tagging error", zap.String("rule", rule.ID.Hex()), zap.Error(err))
+				continue
+			}
+			tagged[rule.TagId] = true
+		}
+		if action == TagRuleActionRemove && tagged[rule.TagId] {
+			if err := u.memberUc.BatchUnTagging(c, appId, []string{openid}, rule.TagId); err != nil {
+				u.log.Error("tag rule untagging error", zap.String("rule", rule.ID.Hex()), zap.Error(err))
+				continue
+			}
+			tagged[rule.TagId] = false
+		}
+	}
+	return nil
+}
+
+// matchTagRule 判断消息是否命中规则，命中时返回要执行的动作
+//
+// province 为消息对应的省份，不是位置相关的消息时为空。
+func matchTagRule(rule *entities.TagRule, msg *message.MixMessage, province string) string {
+	matched := false
+	switch rule.Type {
+	case TagRuleQrScene:
+		switch msg.Event {
+		case message.EventSubscribe:
+			matched = strings.TrimPrefix(msg.EventKey, "qrscene_") == rule.Value
+		case message.EventScan:
+			matched = msg.EventKey == rule.Value
+		}
+	case TagRuleKeyword:
+		matched = msg.MsgType == message.MsgTypeText && strings.Contains(msg.Content, rule.Value)
+	case TagRuleMenuClick:
+		matched = msg.MsgType == message.MsgTypeEvent && msg.Event == message.EventClick &&
+			msg.EventKey == rule.Value
+	case TagRuleProvince:
+		matched = province != "" && province == ParseProvince(rule.Value)
+	case TagRuleInactive:
+		// 有互动即不再是沉默粉丝
+		return TagRuleActionRemove
+	}
+	if !matched {
+		return ""
+	}
+	return rule.Action
+}
+
+// Sweep 对已有粉丝批量执行规则
+func (u *TagRuleUsecase) Sweep(c context.Context, appId string) error {
+	rules, err := u.repo.QueryEnabled(c, appId)
+	if err != nil {
+		u.log.Error("query tag rules error", zap.Error(err))
+		return fmt.Errorf("query tag rules error")
+	}
+
+	for _, rule := range rules {
+		now := time.Now()
+		var filter *request.MPMemberFilter
+		switch rule.Type {
+		case TagRuleQrScene:
+			filter = &request.MPMemberFilter{}
+			if scene, err := strconv.ParseInt(rule.Value, 10, 64); err == nil {
+				filter.QrScene = scene
+			} else {
+				filter.QrSceneStr = rule.Value
+			}
+		case TagRuleProvince:
+			filter = &request.MPMemberFilter{Province: rule.Value}
+		case TagRuleInactive:
+			// 关注和最后互动时间都早于截止时间
+			cutoff := now.AddDate(0, 0, -rule.Days).Unix()
+			filter = &request.MPMemberFilter{SubscribeTimeTo: cutoff, LastMessageTo: cutoff}
+			// 截止时间之后有互动的取消标签
+			active := &request.MPMemberFilter{TagIds: []int64{rule.TagId}, LastMessageFrom: cutoff + 1}
+			if err := u.sweep(c, appId, rule, active, TagRuleActionRemove); err != nil {
+				return err
+			}
+		default: // 关键词、菜单点击只在收到消息时执行
+			continue
+		}
+		if err := u.sweep(c, appId, rule, filter, rule.Action); err != nil {
+			return err
+		}
+		if err := u.repo.UpdateSweptAt(c, rule.ID.Hex(), now.Unix()); err != nil {
+			u.log.Error("update tag rule error", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (u *TagRuleUsecase) sweep(c context.Context, appId string, rule *entities.TagRule,
+	filter *request.MPMemberFilter, action string,
+) error {
+	// 只处理需要变更的粉丝
+	if action == TagRuleActionAdd {
+		filter.ExcludeTagIds = []int64{rule.TagId}
+	} else {
+		filter.TagIds = []int64{rule.TagId}
+	}
+	openids, err := u.memberRepo.FindOpenIds(c, appId, filter)
+	if err != nil {
+		u.log.Error("find members error", zap.Error(err))
+		return fmt.Errorf("find members error")
+	}
+
+	ticker := time.NewTicker(taggingJobInterval)
+	defer ticker.Stop()
+	for i := 0; i < len(openids); i += MaxTaggingOpenIds {
+		chunk := openids[i:min(i+MaxTaggingOpenIds, len(openids))]
+		select {
+		case <-c.Done():
+			return c.Err()
+		case <-ticker.C:
+		}
+		if action == TagRuleActionAdd {
+			err = u.memberUc.BatchTagging(c, appId, chunk, rule.TagId)
+		} else {
+			err = u.memberUc.BatchUnTagging(c, appId, chunk, rule.TagId)
+		}
+		if err != nil {
+			u.log.Error("tag rule sweep error", zap.String("rule", rule.ID.Hex()), zap.Error(err))
+			return err
+		}
+	}
+	u.log.Info("tag rule sweep", zap.String("appId", appId), zap.String("rule", rule.ID.Hex()),
+		zap.String("action", action), zap.Int("members", len(openids)))
+	return nil
+}
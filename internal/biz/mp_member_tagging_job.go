@@ -0,0 +1,258 @@
+package biz
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	MaxTaggingJobOpenIds = 50000                  // 单个任务最多的openid数量
+	taggingJobInterval   = 200 * time.Millisecond // 调用微信接口的间隔
+	taggingJobRetries    = 3                      // 整批失败时的重试次数，重试后仍失败则逐个打标签
+	taggingJobBackoff    = time.Second            // 首次重试的等待时间，每次翻倍
+	taggingJobStaleAfter = 5 * time.Minute        // 超过该时间没有更新进度的任务视为已中断
+)
+
+type TaggingJobRepo interface {
+	Create(c context.Context, job *entities.TaggingJob) (string, error)
+	// Update 更新任务进度，failures 为上次更新后新增的失败明细
+	Update(c context.Context, job *entities.TaggingJob, failures []*entities.TaggingFailure) error
+	// Interrupt 将 before 之前未更新且未完成的任务标记为失败，返回标记的数量
+	Interrupt(c context.Context, before int64, reason string) (int64, error)
+	Get(c context.Context, appId, id string) (*entities.TaggingJob, error)
+	Query(c context.Context, appId string) ([]*entities.TaggingJob, error) // 不返回失败明细
+}
+
+// TaggingJobUsecase 按上传的openid文件批量打标签
+//
+// 任务在后台执行，按微信每次50个openid的限制分批调用，执行进度和失败明细保存在任务中。
+type TaggingJobUsecase struct {
+	log        *zap.Logger
+	repo       TaggingJobRepo
+	memberRepo MPMemberRepo
+	memberUc   *MPMemberUsecase
+}
+
+func NewTaggingJobUsecase(log *zap.Logger, repo TaggingJobRepo,
+	memberRepo MPMemberRepo, memberUc *MPMemberUsecase,
+) *TaggingJobUsecase {
+	return &TaggingJobUsecase{log: log, repo: repo, memberRepo: memberRepo, memberUc: memberUc}
+}
+
+// ParseOpenIdFile 解析openid文件，每行一个openid，CSV文件取第一列，自动跳过表头和重复项
+func ParseOpenIdFile(r io.Reader) ([]string, error) {
+	seen := make(map[string]bool)
+	openids := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimPrefix(scanner.Text(), "\ufeff")
+		if i := strings.IndexAny(line, ",\t;"); i >= 0 {
+			line = line[:i]
+		}
+		openid := strings.Trim(strings.TrimSpace(line), `"'`)
+		if openid == "" || strings.EqualFold(openid, "openid") || seen[openid] {
+			continue
+		}
+		seen[openid] = true
+		openids = append(openids, openid)
+		if len(openids) > MaxTaggingJobOpenIds {
+			return nil, fmt.Errorf("too many openids, max %d", MaxTaggingJobOpenIds)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return openids, nil
+}
+
+// Create 创建批量打标签任务并在后台执行
+//
+// 本地不存在的粉丝直接记为失败，不调用微信接口。
+func (u *TaggingJobUsecase) Create(c context.Context, appId string, tagId int64,
+	filename string, openids []string,
+) (*entities.TaggingJob, error) {
+	app, err := GetAppInfoFromCtx(c)
+	if err != nil {
+		u.log.Error("get app info error", zap.Error(err))
+		return nil, fmt.Errorf("get app info error")
+	}
+
+	existing, err := u.memberRepo.ExistingOpenIds(c, appId, openids)
+	if err != nil {
+		u.log.Error("query members error", zap.Error(err))
+		return nil, fmt.Errorf("query members error")
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, openid := range existing {
+		exists[openid] = true
+	}
+	valid := make([]string, 0, len(existing))
+	failures := make([]*entities.TaggingFailure, 0)
+	for _, openid := range openids {
+		if exists[openid] {
+			valid = append(valid, openid)
+		} else {
+			failures = append(failures, &entities.TaggingFailure{OpenId: openid, Reason: "member not found"})
+		}
+	}
+
+	now := time.Now().Unix()
+	job := &entities.TaggingJob{
+		AppId:     appId,
+		MpId:      app.MpId,
+		TagId:     tagId,
+		Filename:  filename,
+		Status:    JobStatusPending,
+		Total:     len(openids),
+		Processed: len(failures),
+		Failed:    len(failures),
+		Failures:  failures,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	id, err := u.repo.Create(c, job)
+	if err != nil {
+		u.log.Error("create tagging job error", zap.Error(err))
+		return nil, fmt.Errorf("create tagging job error")
+	}
+	job.ID, _ = primitive.ObjectIDFromHex(id)
+
+	// 请求结束后 gin.Context 会被复用，后台任务使用新的 context
+	ctx := context.WithValue(context.Background(), "APP", app)
+	ctx = context.WithValue(ctx, "MP_ID", app.MpId)
+	// 返回的任务会被序列化，后台任务使用副本
+	running := *job
+	go u.run(ctx, &running, valid)
+
+	return job, nil
+}
+
+func (u *TaggingJobUsecase) run(c context.Context, job *entities.TaggingJob, openids []string) {
+	appId := job.AppId
+	defer func() {
+		if r := recover(); r != nil {
+			u.log.Error("tagging job panic", zap.String("id", job.ID.Hex()), zap.Any("panic", r))
+			job.Status = JobStatusFailed
+			job.Error = "internal error"
+			job.FinishedAt = time.Now().Unix()
+			u.save(c, job, nil)
+		}
+	}()
+
+	job.Status = JobStatusRunning
+	job.StartedAt = time.Now().Unix()
+	u.save(c, job, nil)
+
+	ticker := time.NewTicker(taggingJobInterval)
+	defer ticker.Stop()
+
+	// 整批失败多为频率限制或 access_token 错误，先等待后重试整批
+	tagging := func(ids []string) error {
+		var err error
+		for i := 0; i <= taggingJobRetries; i++ {
+			if i > 0 {
+				time.Sleep(taggingJobBackoff << (i - 1))
+			}
+			<-ticker.C
+			if err = u.memberUc.BatchTagging(c, appId, ids, job.TagId); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+
+	// 微信接口不返回每个 openid 的错误，整批重试仍失败时逐个打标签，
+	// 避免一个无效 openid 导致整批都记为失败
+	taggingEach := func(ids []string) []*entities.TaggingFailure {
+		var failures []*entities.TaggingFailure
+		for _, openid := range ids {
+			<-ticker.C
+			if err := u.memberUc.BatchTagging(c, appId, []string{openid}, job.TagId); err != nil {
+				failures = append(failures, &entities.TaggingFailure{OpenId: openid, Reason: err.Error()})
+			}
+		}
+		return failures
+	}
+
+	for i := 0; i < len(openids); i += MaxTaggingOpenIds {
+		chunk := openids[i:min(i+MaxTaggingOpenIds, len(openids))]
+		var failures []*entities.TaggingFailure
+		if err := tagging(chunk); err != nil {
+			u.log.Warn("tagging chunk failed, retry one by one", zap.String("id", job.ID.Hex()),
+				zap.Error(err))
+			failures = taggingEach(chunk)
+		}
+		job.Failed += len(failures)
+		job.Succeeded += len(chunk) - len(failures)
+		job.Processed += len(chunk)
+		u.save(c, job, failures)
+	}
+
+	job.Status = JobStatusSuccess
+	if job.Total > 0 && job.Failed == job.Total {
+		job.Status = JobStatusFailed
+		job.Error = "all openids failed"
+	}
+	job.FinishedAt = time.Now().Unix()
+	u.save(c, job, nil)
+	u.log.Info("tagging job finished", zap.String("appId", appId), zap.String("id", job.ID.Hex()),
+		zap.Int("succeeded", job.Succeeded), zap.Int("failed", job.Failed))
+}
+
+func (u *TaggingJobUsecase) save(c context.Context, job *entities.TaggingJob,
+	failures []*entities.TaggingFailure,
+) {
+	job.UpdatedAt = time.Now().Unix()
+	if err := u.repo.Update(c, job, failures); err != nil {
+		u.log.Error("update tagging job error", zap.Error(err))
+	}
+}
+
+// Recover 服务启动后调用，将重启前中断的任务标记为失败
+//
+// 任务在进程内执行，重启后不会继续。等待 taggingJobStaleAfter 后再检查，
+// 避免多实例部署时误判其他实例正在执行的任务。
+func (u *TaggingJobUsecase) Recover(c context.Context) {
+	select {
+	case <-c.Done():
+		return
+	case <-time.After(taggingJobStaleAfter):
+	}
+	before := time.Now().Add(-taggingJobStaleAfter).Unix()
+	count, err := u.repo.Interrupt(c, before, "interrupted by restart")
+	if err != nil {
+		u.log.Error("recover tagging jobs error", zap.Error(err))
+		return
+	}
+	if count > 0 {
+		u.log.Info("interrupted tagging jobs marked failed", zap.Int64("count", count))
+	}
+}
+
+// Get 查询任务详情，包含失败明细
+func (u *TaggingJobUsecase) Get(c context.Context, appId, id string) (*entities.TaggingJob, error) {
+	job, err := u.repo.Get(c, appId, id)
+	if err != nil {
+		u.log.Error("get tagging job error", zap.Error(err))
+		return nil, fmt.Errorf("get tagging job error")
+	}
+	return job, nil
+}
+
+// Query 查询任务列表
+func (u *TaggingJobUsecase) Query(c context.Context, appId string) ([]*entities.TaggingJob, error) {
+	jobs, err := u.repo.Query(c, appId)
+	if err != nil {
+		u.log.Error("query tagging jobs error", zap.Error(err))
+		return nil, fmt.Errorf("query tagging jobs error")
+	}
+	return jobs, nil
+}
@@ -0,0 +1,190 @@
+package biz
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/message"
+	"github.com/seth16888/wxbusiness/internal/model"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// 粉丝动态类型
+const (
+	ActivitySubscribe   = "subscribe"
+	ActivityUnsubscribe = "unsubscribe"
+	ActivityScan        = "scan"       // 扫描带参数二维码
+	ActivityMenuClick   = "menu_click" // 点击菜单
+	ActivityEvent       = "event"      // 其他事件：上报地理位置、扫码推事件等
+	ActivityMessage     = "message"    // 粉丝发送的消息
+	ActivityReply       = "reply"      // 被动回复
+	ActivityTagAdd      = "tag_add"
+	ActivityTagRemove   = "tag_remove"
+	ActivityRemark      = "remark"
+	ActivityBlock       = "block"
+	ActivityUnblock     = "unblock"
+)
+
+type MemberTimelineRepo interface {
+	Add(c context.Context, activities []*entities.MemberActivity) error
+	Query(c context.Context, appId, openid string,
+		params *request.MemberTimelineQuery) (*model.PageResult[*entities.MemberActivity], error)
+	// CountByMember 统计 since 之后各粉丝指定类型的动态数量，返回 openid => 数量
+	CountByMember(c context.Context, appId string, types []string, since int64) (map[string]int64, error)
+}
+
+// MemberTimelineUsecase 粉丝动态
+//
+// 动态来自推送消息和粉丝相关的操作，记录失败只打印日志，不影响业务。
+type MemberTimelineUsecase struct {
+	log        *zap.Logger
+	repo       MemberTimelineRepo
+	memberRepo MPMemberRepo
+}
+
+func NewMemberTimelineUsecase(log *zap.Logger, repo MemberTimelineRepo,
+	memberRepo MPMemberRepo,
+) *MemberTimelineUsecase {
+	return &MemberTimelineUsecase{log: log, repo: repo, memberRepo: memberRepo}
+}
+
+// Record 为多个粉丝记录同一条动态
+func (u *MemberTimelineUsecase) Record(c context.Context, appId, mpId string, openids []string,
+	activityType, content, operator string,
+) {
+	if u == nil || len(openids) == 0 {
+		return
+	}
+	now := time.Now().Unix()
+	activities := make([]*entities.MemberActivity, 0, len(openids))
+	for _, openid := range openids {
+		activities = append(activities, &entities.MemberActivity{
+			AppId:     appId,
+			MpId:      mpId,
+			OpenId:    openid,
+			Type:      activityType,
+			Content:   content,
+			Operator:  operator,
+			CreatedAt: now,
+		})
+	}
+	if err := u.repo.Add(c, activities); err != nil {
+		u.log.Error("add member activity error", zap.String("type", activityType), zap.Error(err))
+	}
+}
+
+// OnMessage 实现 message.Subscriber，记录粉丝发送的消息和事件
+func (u *MemberTimelineUsecase) OnMessage(c context.Context, app *entities.PlatformApp,
+	msg *message.MixMessage,
+) error {
+	if !msg.IsInteraction() && msg.Event != message.EventUnsubscribe {
+		return nil
+	}
+	activity := messageActivity(msg)
+	activity.AppId = app.ID.Hex()
+	activity.MpId = app.MpId
+	activity.OpenId = msg.GetOpenID()
+	// 微信未收到响应时会重试推送，消息按 MsgId 去重
+	activity.MsgId = msg.MsgId
+	activity.CreatedAt = msg.CreateTime
+	if activity.CreatedAt == 0 {
+		activity.CreatedAt = time.Now().Unix()
+	}
+	return u.repo.Add(c, []*entities.MemberActivity{activity})
+}
+
+// OnReply 实现 message.ReplySubscriber，记录被动回复
+func (u *MemberTimelineUsecase) OnReply(c context.Context, app *entities.PlatformApp,
+	msg *message.MixMessage, reply []byte,
+) error {
+	var replyMsg message.MixMessage
+	if err := xml.Unmarshal(reply, &replyMsg); err != nil {
+		return err
+	}
+	activity := &entities.MemberActivity{
+		AppId:     app.ID.Hex(),
+		MpId:      app.MpId,
+		OpenId:    msg.GetOpenID(),
+		Type:      ActivityReply,
+		Content:   replyMsg.Content,
+		Data:      map[string]string{"msg_type": string(replyMsg.MsgType)},
+		CreatedAt: time.Now().Unix(),
+	}
+	return u.repo.Add(c, []*entities.MemberActivity{activity})
+}
+
+// messageActivity 推送消息转换为动态
+func messageActivity(msg *message.MixMessage) *entities.MemberActivity {
+	activity := &entities.MemberActivity{Data: map[string]string{"msg_type": string(msg.MsgType)}}
+	if msg.MsgType != message.MsgTypeEvent {
+		activity.Type = ActivityMessage
+		if msg.MsgId > 0 {
+			activity.Data["msg_id"] = strconv.FormatInt(msg.MsgId, 10)
+		}
+		switch msg.MsgType {
+		case message.MsgTypeText:
+			activity.Content = msg.Content
+		case message.MsgTypeLocation:
+			activity.Content = msg.Label
+		case message.MsgTypeLink:
+			activity.Content = msg.Title
+			activity.Data["url"] = msg.URL
+		default:
+			activity.Content = "[" + string(msg.MsgType) + "]"
+			if msg.MediaId != "" {
+				activity.Data["media_id"] = msg.MediaId
+			}
+		}
+		return activity
+	}
+
+	activity.Data["event"] = string(msg.Event)
+	activity.Content = msg.EventKey
+	switch msg.Event {
+	case message.EventSubscribe:
+		activity.Type = ActivitySubscribe
+		activity.Content = strings.TrimPrefix(msg.EventKey, "qrscene_")
+	case message.EventUnsubscribe:
+		activity.Type = ActivityUnsubscribe
+	case message.EventScan:
+		activity.Type = ActivityScan
+	case message.EventClick, message.EventView, message.EventViewMiniprogram:
+		activity.Type = ActivityMenuClick
+	default:
+		activity.Type = ActivityEvent
+		if activity.Content == "" {
+			activity.Content = string(msg.Event)
+		}
+	}
+	return activity
+}
+
+// Query 查询粉丝动态
+//
+// memberId 为粉丝ID，也可以直接使用 openid
+func (u *MemberTimelineUsecase) Query(c context.Context, appId, memberId string,
+	params *request.MemberTimelineQuery,
+) (*model.PageResult[*entities.MemberActivity], error) {
+	openid := memberId
+	if primitive.IsValidObjectID(memberId) {
+		member, err := u.memberRepo.FindById(c, memberId)
+		if err != nil || member.AppId != appId {
+			return nil, fmt.Errorf("member not found")
+		}
+		openid = member.OpenId
+	}
+
+	result, err := u.repo.Query(c, appId, openid, params)
+	if err != nil {
+		u.log.Error("query member timeline error", zap.Error(err))
+		return nil, fmt.Errorf("query member timeline error")
+	}
+	return result, nil
+}
@@ -2,6 +2,7 @@ package biz
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/seth16888/wxbusiness/internal/data/entities"
 	"github.com/seth16888/wxbusiness/internal/model/r"
@@ -14,6 +15,8 @@ type MenuRepo interface {
 	GetMenuInfo(ctx context.Context, appId string) (*entities.MPMenu, error)
 	SaveMenu(ctx context.Context, apiMenu *entities.MPMenu) error
   DeleteMenu(ctx context.Context, pId string) error
+	// GetLatestMenu 最近保存的菜单，没有时返回 nil
+	GetLatestMenu(ctx context.Context, appId string) (*entities.MPMenu, error)
 }
 
 // MPMenuUsecase 微信公众号菜单业务逻辑处理类
@@ -27,6 +30,7 @@ type MPMenuUsecase struct {
 	tokenUc  *AccessTokenUsecase
 	apiProxy *APIProxyUsecase
 	log      *zap.Logger
+	refUc    *MaterialRefUsecase
 }
 
 func NewMPMenuUsecase(repo AppRepo,
@@ -34,8 +38,10 @@ func NewMPMenuUsecase(repo AppRepo,
 	apiProxy *APIProxyUsecase,
 	log *zap.Logger,
 	menuRepo MenuRepo,
+	refUc *MaterialRefUsecase,
 ) *MPMenuUsecase {
-	return &MPMenuUsecase{appRepo: repo, tokenUc: tokenUc, apiProxy: apiProxy, log: log, repo: menuRepo}
+	return &MPMenuUsecase{appRepo: repo, tokenUc: tokenUc, apiProxy: apiProxy, log: log, repo: menuRepo,
+		refUc: refUc}
 }
 
 func (u *MPMenuUsecase) Pull(ctx context.Context, appId string) *r.R {
@@ -73,6 +79,7 @@ func (u *MPMenuUsecase) Pull(ctx context.Context, appId string) *r.R {
 		u.log.Error("save menu error", zap.Error(err))
 		return r.Error(400, "save menu error")
 	}
+	u.refUc.Refresh(ctx, appId, u)
 
 	return r.SuccessData(nil)
 }
@@ -174,6 +181,7 @@ func (u *MPMenuUsecase) Create(ctx context.Context, pId string, params *mp.Creat
     u.log.Error("save menu error", zap.Error(err))
     return r.Error(400, "save menu error")
   }
+  u.refUc.Refresh(ctx, pId, u)
 
 	return r.Success()
 }
@@ -216,6 +224,35 @@ func (u *MPMenuUsecase) Delete(ctx context.Context, pId string) *r.R {
 		u.log.Error("delete db menu error", zap.Error(err))
 		return r.Error(400, "delete db menu error")
 	}
+	u.refUc.Refresh(ctx, pId, u)
 
 	return r.Success()
 }
+
+// MaterialRefSource 实现 MaterialReferrer
+func (u *MPMenuUsecase) MaterialRefSource() string {
+	return MaterialRefMenu
+}
+
+// MaterialRefs 实现 MaterialReferrer，返回菜单按钮引用的素材
+func (u *MPMenuUsecase) MaterialRefs(ctx context.Context, appId string) ([]*entities.MaterialRef, error) {
+	menu, err := u.repo.GetLatestMenu(ctx, appId)
+	if err != nil || menu == nil {
+		return nil, err
+	}
+	var refs []*entities.MaterialRef
+	var walk func(buttons []*entities.MenuButton, prefix string)
+	walk = func(buttons []*entities.MenuButton, prefix string) {
+		for _, btn := range buttons {
+			if btn.MediaID != "" {
+				refs = append(refs, &entities.MaterialRef{MediaId: btn.MediaID, Location: prefix + btn.Name})
+			}
+			walk(btn.SubButtons, prefix+btn.Name+"/")
+		}
+	}
+	walk(menu.Button, "")
+	for _, conditional := range menu.Conditionalmenu {
+		walk(conditional.Button, fmt.Sprintf("个性化菜单%d:", conditional.MenuID))
+	}
+	return refs, nil
+}
@@ -4,21 +4,48 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/seth16888/wxcommon/domain"
 	"github.com/seth16888/wxcommon/paths"
 	v1 "github.com/seth16888/wxproxy/api/v1"
 	"go.uber.org/zap"
 
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model"
 	"github.com/seth16888/wxbusiness/internal/model/request"
 	"github.com/seth16888/wxbusiness/internal/model/response"
+	"github.com/seth16888/wxbusiness/pkg/qrcode"
 )
 
+type QRCodeRepo interface {
+	Create(c context.Context, code *entities.QRCode) error
+	Get(c context.Context, appId string, id string) (*entities.QRCode, error)
+	Query(c context.Context, appId string,
+		params *request.QRCodeQuery) (*model.PageResult[*entities.QRCode], error)
+	FindByBatch(c context.Context, appId string, batchId string) ([]*entities.QRCode, error)
+	CreateBatch(c context.Context, batch *entities.QRCodeBatch) error
+	UpdateBatch(c context.Context, batch *entities.QRCodeBatch) error
+	GetBatch(c context.Context, appId string, id string) (*entities.QRCodeBatch, error)
+	QueryBatches(c context.Context, appId string) ([]*entities.QRCodeBatch, error) // 不返回失败明细
+	// ClaimStaleBatch 领取 before 之后未更新的未完成批次，并将更新时间设为 now，没有时返回 nil
+	ClaimStaleBatch(c context.Context, before int64, now int64) (*entities.QRCodeBatch, error)
+	Scenes(c context.Context, appId string) ([]*entities.QRScene, error)            // 按场景值汇总已生成的二维码
+}
+
+// MpQRCodeUsecase 带参数二维码
+//
+// 生成的二维码都保存在数据库中，可以按批次、场景值、名称查询。
+// 二维码图片在本地渲染，不依赖从微信下载。
 type MpQRCodeUsecase struct {
-	log      *zap.Logger
-	repo     AppRepo
-	tokenUc  *AccessTokenUsecase
-	apiProxy *APIProxyUsecase
+	log        *zap.Logger
+	repo       AppRepo
+	qrRepo     QRCodeRepo
+	tokenUc    *AccessTokenUsecase
+	apiProxy   *APIProxyUsecase
+	materialUc *MaterialUsecase
+	scenes     *QRSceneUsecase
+	renderer   *qrcode.Renderer
 }
 
 // GetURL 获取二维码URL
@@ -45,10 +72,18 @@ func (m *MpQRCodeUsecase) CreateLimit(c context.Context, appId string,
 		return nil, fmt.Errorf("get app info error")
 	}
 	mpId := app.MpId
+	// 登记场景值，检查永久二维码数量
+	_, reserved, err := m.scenes.Claim(c, appId, req, true)
+	if err != nil {
+		return nil, err
+	}
   // 获取access_token
   token,err:= m.tokenUc.FetchAccessToken(c, appId, mpId)
   if err!=nil{
     m.log.Error("GetToken error", zap.Error(err))
+    if reserved {
+      m.scenes.Release(c, appId, req.Scene)
+    }
     return nil,err
   }
 
@@ -60,8 +95,12 @@ func (m *MpQRCodeUsecase) CreateLimit(c context.Context, appId string,
 	res, err := m.apiProxy.cli.CreateLimitQRCode(c, &params)
 	if err != nil {
 		m.log.Error("CreateLimit error", zap.Error(err))
+		if reserved {
+			m.scenes.Release(c, appId, req.Scene)
+		}
 		return nil, err
 	}
+	m.save(c, app, req, true, res, "")
 
 	resp := response.Ticket{
 		Ticket:        res.Ticket,
@@ -84,6 +123,10 @@ func (m *MpQRCodeUsecase) CreateTemporary(c context.Context, appId string,
 		return nil, fmt.Errorf("get app info error")
 	}
 	mpId := app.MpId
+	// 登记场景值
+	if _, _, err := m.scenes.Claim(c, appId, req, false); err != nil {
+		return nil, err
+	}
   // 获取access_token
   token,err:= m.tokenUc.FetchAccessToken(c, appId, mpId)
   if err!=nil{
@@ -101,6 +144,7 @@ func (m *MpQRCodeUsecase) CreateTemporary(c context.Context, appId string,
 		m.log.Error("CreateTemporaryQRCode error", zap.Error(err))
 		return nil, err
 	}
+	m.save(c, app, req, false, res, "")
 
 	resp := response.Ticket{
 		Ticket:        res.Ticket,
@@ -111,16 +155,75 @@ func (m *MpQRCodeUsecase) CreateTemporary(c context.Context, appId string,
 	return &resp, nil
 }
 
+// save 保存生成的二维码，失败时只记录日志，二维码已生成，仍返回给调用方
+func (m *MpQRCodeUsecase) save(c context.Context, app *entities.PlatformApp,
+	req *request.CreateQRCodeReq, permanent bool, res *v1.CreateQRCodeReply, batchId string,
+) *entities.QRCode {
+	now := time.Now().Unix()
+	code := &entities.QRCode{
+		AppId:         app.ID.Hex(),
+		MpId:          app.MpId,
+		BatchId:       batchId,
+		Scene:         req.Scene,
+		Permanent:     permanent,
+		Ticket:        res.Ticket,
+		URL:           res.URL,
+		ExpireSeconds: res.ExpireSeconds,
+		Label:         req.Label,
+		Owner:         req.Owner,
+		CreatedAt:     now,
+	}
+	if !permanent {
+		code.ExpiresAt = now + res.ExpireSeconds
+	}
+	if err := m.qrRepo.Create(c, code); err != nil {
+		m.log.Error("save qrcode error", zap.String("appId", code.AppId),
+			zap.String("ticket", code.Ticket), zap.Error(err))
+	}
+	m.scenes.Issued(c, code.AppId, code.Scene)
+	return code
+}
+
+// Get 查询单个二维码
+func (m *MpQRCodeUsecase) Get(c context.Context, appId string, id string) (*entities.QRCode, error) {
+	code, err := m.qrRepo.Get(c, appId, id)
+	if err != nil {
+		m.log.Error("get qrcode error", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("get qrcode error")
+	}
+	return code, nil
+}
+
+// Query 查询已生成的二维码
+func (m *MpQRCodeUsecase) Query(c context.Context, appId string,
+	params *request.QRCodeQuery,
+) (*model.PageResult[*entities.QRCode], error) {
+	result, err := m.qrRepo.Query(c, appId, params)
+	if err != nil {
+		m.log.Error("query qrcode error", zap.Error(err))
+		return nil, fmt.Errorf("query qrcode error")
+	}
+	return result, nil
+}
+
 func NewMpQRCodeUsecase(
 	log *zap.Logger,
 	repo AppRepo,
+	qrRepo QRCodeRepo,
 	tokenUc *AccessTokenUsecase,
 	apiProxy *APIProxyUsecase,
+	materialUc *MaterialUsecase,
+	scenes *QRSceneUsecase,
+	renderer *qrcode.Renderer,
 ) *MpQRCodeUsecase {
 	return &MpQRCodeUsecase{
-		log:      log,
-		repo:     repo,
-		tokenUc:  tokenUc,
-		apiProxy: apiProxy,
+		log:        log,
+		repo:       repo,
+		qrRepo:     qrRepo,
+		tokenUc:    tokenUc,
+		apiProxy:   apiProxy,
+		materialUc: materialUc,
+		scenes:     scenes,
+		renderer:   renderer,
 	}
 }
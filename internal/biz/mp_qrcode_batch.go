@@ -0,0 +1,361 @@
+package biz
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"github.com/seth16888/wxbusiness/pkg/export"
+	v1 "github.com/seth16888/wxproxy/api/v1"
+	"go.uber.org/zap"
+)
+
+const (
+	MaxQRCodeBatch         = 1000                   // 单个批次最多生成的二维码数量
+	maxQRCodeScene         = 64                     // 场景值字符串的最大长度
+	maxQRCodeExpire        = 2592000                // 临时二维码的最长有效期，30天
+	qrcodeBatchInterval    = 100 * time.Millisecond // 调用微信接口的间隔
+	qrcodeBatchStaleAfter  = 5 * time.Minute        // 未完成的批次超过该时间未更新时视为中断
+	qrcodeScenePlaceholder = "{n}"                  // 场景值模板中的序号
+)
+
+// CreateBatch 创建批量生成二维码的任务并在后台执行
+//
+// 场景值由模板生成，{n} 依次替换为 start 开始的序号，如 store_{n}。
+// 临时二维码未指定有效期时使用最长的30天。
+// 场景值与已登记的冲突，或新增的永久二维码超过上限时，不创建任务。
+func (m *MpQRCodeUsecase) CreateBatch(c context.Context, appId string,
+	req *request.CreateQRCodeBatchReq,
+) (*entities.QRCodeBatch, error) {
+	if !strings.Contains(req.Template, qrcodeScenePlaceholder) {
+		return nil, fmt.Errorf("template must contain %s", qrcodeScenePlaceholder)
+	}
+	if req.Count < 1 || req.Count > MaxQRCodeBatch {
+		return nil, fmt.Errorf("count not in 1-%d", MaxQRCodeBatch)
+	}
+	if req.Start < 0 {
+		return nil, fmt.Errorf("start must not be negative")
+	}
+	if len(batchScene(req.Template, req.Start+req.Count-1)) > maxQRCodeScene {
+		return nil, fmt.Errorf("scene longer than %d", maxQRCodeScene)
+	}
+	exp := int64(req.Exp)
+	if req.Permanent {
+		exp = 0
+	} else if exp == 0 {
+		exp = maxQRCodeExpire
+	} else if exp < 60 || exp > maxQRCodeExpire {
+		return nil, fmt.Errorf("expire_seconds not in 60-%d", maxQRCodeExpire)
+	}
+
+	scenes := make([]string, 0, req.Count)
+	for n := req.Start; n < req.Start+req.Count; n++ {
+		scenes = append(scenes, batchScene(req.Template, n))
+	}
+	if err := m.scenes.CheckBatch(c, appId, scenes, req.Label, req.Permanent); err != nil {
+		return nil, err
+	}
+
+	app, err := GetAppInfoFromCtx(c)
+	if err != nil {
+		m.log.Error("get app info error", zap.Error(err))
+		return nil, fmt.Errorf("get app info error")
+	}
+	now := time.Now().Unix()
+	batch := &entities.QRCodeBatch{
+		AppId:         appId,
+		MpId:          app.MpId,
+		Template:      req.Template,
+		Start:         req.Start,
+		Permanent:     req.Permanent,
+		ExpireSeconds: exp,
+		Label:         req.Label,
+		Owner:         req.Owner,
+		Status:        JobStatusPending,
+		Total:         req.Count,
+		Failures:      make([]*entities.QRCodeFailure, 0),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := m.qrRepo.CreateBatch(c, batch); err != nil {
+		m.log.Error("create qrcode batch error", zap.Error(err))
+		return nil, fmt.Errorf("create qrcode batch error")
+	}
+
+	// 请求结束后 gin.Context 会被复用，后台任务使用新的 context
+	ctx := context.WithValue(context.Background(), "APP", app)
+	ctx = context.WithValue(ctx, "MP_ID", app.MpId)
+	go m.runBatch(ctx, app, batch)
+
+	return batch, nil
+}
+
+func batchScene(template string, n int) string {
+	return strings.ReplaceAll(template, qrcodeScenePlaceholder, strconv.Itoa(n))
+}
+
+// ResumeBatches 继续执行服务重启时中断的批次
+//
+// 批次在进程内执行，等待 qrcodeBatchStaleAfter 后再领取，避免多实例部署时
+// 接管其他实例正在执行的批次。
+func (m *MpQRCodeUsecase) ResumeBatches(c context.Context) {
+	select {
+	case <-c.Done():
+		return
+	case <-time.After(qrcodeBatchStaleAfter):
+	}
+	for {
+		now := time.Now()
+		batch, err := m.qrRepo.ClaimStaleBatch(c, now.Add(-qrcodeBatchStaleAfter).Unix(), now.Unix())
+		if err != nil {
+			m.log.Error("claim qrcode batch error", zap.Error(err))
+			return
+		}
+		if batch == nil {
+			return
+		}
+		app, err := m.repo.Get(c, batch.AppId)
+		if err != nil {
+			m.log.Error("get app info error", zap.String("appId", batch.AppId), zap.Error(err))
+			batch.Status = JobStatusFailed
+			batch.Error = "app not found"
+			batch.FinishedAt = time.Now().Unix()
+			m.saveBatch(c, batch)
+			continue
+		}
+		m.log.Info("resume qrcode batch", zap.String("appId", batch.AppId),
+			zap.String("id", batch.ID.Hex()), zap.Int("processed", batch.Processed))
+		ctx := context.WithValue(c, "APP", app)
+		ctx = context.WithValue(ctx, "MP_ID", app.MpId)
+		go m.runBatch(ctx, app, batch)
+	}
+}
+
+// runBatch 从第 Processed 个二维码开始生成，已保存到批次中的场景值不再生成
+func (m *MpQRCodeUsecase) runBatch(c context.Context, app *entities.PlatformApp,
+	batch *entities.QRCodeBatch,
+) {
+	appId := batch.AppId
+	batchId := batch.ID.Hex()
+	// 中断前已生成、但进度尚未保存的二维码
+	codes, err := m.qrRepo.FindByBatch(c, appId, batchId)
+	if err != nil {
+		m.log.Error("find batch qrcodes error", zap.Error(err))
+		batch.Status = JobStatusFailed
+		batch.Error = "find batch qrcodes error"
+		batch.FinishedAt = time.Now().Unix()
+		m.saveBatch(c, batch)
+		return
+	}
+	created := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		created[code.Scene] = true
+	}
+	batch.Status = JobStatusRunning
+	if batch.StartedAt == 0 {
+		batch.StartedAt = time.Now().Unix()
+	}
+	m.saveBatch(c, batch)
+
+	ticker := time.NewTicker(qrcodeBatchInterval)
+	defer ticker.Stop()
+
+	for n := batch.Start + batch.Processed; n < batch.Start+batch.Total; n++ {
+		if created[batchScene(batch.Template, n)] {
+			batch.Succeeded++
+			batch.Processed++
+			continue
+		}
+		<-ticker.C
+		req := &request.CreateQRCodeReq{
+			Exp:   int(batch.ExpireSeconds),
+			Scene: batchScene(batch.Template, n),
+			Label: batch.Label,
+			Owner: batch.Owner,
+		}
+		// 开始后登记的场景值仍可能冲突，逐个检查
+		_, reserved, err := m.scenes.Claim(c, appId, req, batch.Permanent)
+		var res *v1.CreateQRCodeReply
+		if err == nil {
+			res, err = m.create(c, appId, app.MpId, req, batch.Permanent)
+			if err != nil && reserved {
+				m.scenes.Release(c, appId, req.Scene)
+			}
+		}
+		if err != nil {
+			batch.Failures = append(batch.Failures, &entities.QRCodeFailure{Scene: req.Scene, Reason: err.Error()})
+			batch.Failed++
+		} else {
+			m.save(c, app, req, batch.Permanent, res, batchId)
+			batch.Succeeded++
+		}
+		batch.Processed++
+		if batch.Processed%20 == 0 {
+			m.saveBatch(c, batch)
+		}
+	}
+
+	batch.Status = JobStatusSuccess
+	if batch.Failed == batch.Total {
+		batch.Status = JobStatusFailed
+		batch.Error = "all qrcodes failed"
+	}
+	batch.FinishedAt = time.Now().Unix()
+	m.saveBatch(c, batch)
+	m.log.Info("qrcode batch finished", zap.String("appId", appId), zap.String("id", batchId),
+		zap.Int("succeeded", batch.Succeeded), zap.Int("failed", batch.Failed))
+}
+
+// create 调用微信接口生成二维码
+func (m *MpQRCodeUsecase) create(c context.Context, appId string, mpId string,
+	req *request.CreateQRCodeReq, permanent bool,
+) (*v1.CreateQRCodeReply, error) {
+	token, err := m.tokenUc.FetchAccessToken(c, appId, mpId)
+	if err != nil {
+		m.log.Error("GetToken error", zap.Error(err))
+		return nil, fmt.Errorf("fetch access token error")
+	}
+	params := &v1.CreateQRCodeRequest{
+		AccessToken:   token.AccessToken,
+		ExpireSeconds: int64(req.Exp),
+		Scene:         req.Scene,
+	}
+	var res *v1.CreateQRCodeReply
+	if permanent {
+		params.ExpireSeconds = 0
+		res, err = m.apiProxy.cli.CreateLimitQRCode(c, params)
+	} else {
+		res, err = m.apiProxy.cli.CreateTemporaryQRCode(c, params)
+	}
+	if err != nil {
+		m.log.Error("create qrcode error", zap.String("scene", req.Scene), zap.Error(err))
+		return nil, fmt.Errorf("create qrcode error")
+	}
+	return res, nil
+}
+
+func (m *MpQRCodeUsecase) saveBatch(c context.Context, batch *entities.QRCodeBatch) {
+	batch.UpdatedAt = time.Now().Unix()
+	if err := m.qrRepo.UpdateBatch(c, batch); err != nil {
+		m.log.Error("update qrcode batch error", zap.Error(err))
+	}
+}
+
+// GetBatch 查询批次详情，包含失败明细
+func (m *MpQRCodeUsecase) GetBatch(c context.Context, appId string, id string,
+) (*entities.QRCodeBatch, error) {
+	batch, err := m.qrRepo.GetBatch(c, appId, id)
+	if err != nil {
+		m.log.Error("get qrcode batch error", zap.Error(err))
+		return nil, fmt.Errorf("get qrcode batch error")
+	}
+	return batch, nil
+}
+
+// QueryBatches 查询批次列表
+func (m *MpQRCodeUsecase) QueryBatches(c context.Context, appId string,
+) ([]*entities.QRCodeBatch, error) {
+	batches, err := m.qrRepo.QueryBatches(c, appId)
+	if err != nil {
+		m.log.Error("query qrcode batches error", zap.Error(err))
+		return nil, fmt.Errorf("query qrcode batches error")
+	}
+	return batches, nil
+}
+
+// 批次清单的列
+var qrcodeManifestHeader = []string{
+	"场景值", "文件", "名称", "负责人", "永久二维码", "过期时间", "ticket", "url", "错误",
+}
+
+// ExportBatch 将批次中的二维码图片和清单 manifest.csv 打包为 zip 写入 w
+//
+// 图片在本地渲染，params 对批次中所有二维码生效，说明文字中的 {scene}、{label} 按二维码替换。
+// 参数不正确时在写入前返回 qrcode.ErrInvalidOptions；已过期的临时二维码不渲染，
+// 单个二维码渲染失败时清单中记录错误，继续处理其他二维码。
+func (m *MpQRCodeUsecase) ExportBatch(c context.Context, appId string, batchId string,
+	params *request.QRCodeImageQuery, w io.Writer,
+) error {
+	if _, err := m.qrRepo.GetBatch(c, appId, batchId); err != nil {
+		m.log.Error("get qrcode batch error", zap.Error(err))
+		return fmt.Errorf("qrcode batch not found")
+	}
+	opts, err := m.imageOptions(c, appId, params)
+	if err != nil {
+		return err
+	}
+	codes, err := m.qrRepo.FindByBatch(c, appId, batchId)
+	if err != nil {
+		m.log.Error("find batch qrcodes error", zap.Error(err))
+		return fmt.Errorf("find batch qrcodes error")
+	}
+
+	zw := zip.NewWriter(w)
+	rows := make([][]string, 0, len(codes))
+	for _, code := range codes {
+		if err := c.Err(); err != nil {
+			return err
+		}
+		filename, errMsg := "", ""
+		if qrcodeExpired(code) {
+			errMsg = ErrQRCodeExpired.Error()
+		} else if img, err := m.render(code, opts, params.Caption); err != nil {
+			m.log.Error("render qrcode error", zap.String("scene", code.Scene), zap.Error(err))
+			errMsg = err.Error()
+		} else {
+			filename = qrcodeFilename(code.Scene) + img.Ext
+			f, err := zw.Create(filename)
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write(img.Data); err != nil {
+				return err
+			}
+		}
+		expiresAt := ""
+		if code.ExpiresAt > 0 {
+			expiresAt = time.Unix(code.ExpiresAt, 0).Format(time.DateTime)
+		}
+		rows = append(rows, []string{
+			code.Scene, filename, code.Label, code.Owner, strconv.FormatBool(code.Permanent),
+			expiresAt, code.Ticket, code.URL, errMsg,
+		})
+	}
+
+	manifest, err := zw.Create("manifest.csv")
+	if err != nil {
+		return err
+	}
+	rw, err := export.NewCSVWriter(manifest)
+	if err != nil {
+		return err
+	}
+	if err := rw.WriteRow(qrcodeManifestHeader); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := rw.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	if err := rw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// qrcodeFilename 场景值中不能用于文件名的字符替换为 _
+func qrcodeFilename(scene string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, scene)
+}
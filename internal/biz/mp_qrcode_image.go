@@ -0,0 +1,97 @@
+package biz
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"github.com/seth16888/wxbusiness/pkg/qrcode"
+	"go.uber.org/zap"
+)
+
+// ErrQRCodeExpired 临时二维码已过期，不再渲染图片
+var ErrQRCodeExpired = errors.New("qrcode expired")
+
+// QRCodeImage 渲染的二维码图片
+type QRCodeImage struct {
+	ContentType string
+	Ext         string
+	Data        []byte
+}
+
+// imageOptions 将查询参数转为渲染参数，logo 从图片素材加载
+func (m *MpQRCodeUsecase) imageOptions(c context.Context, appId string,
+	params *request.QRCodeImageQuery,
+) (*qrcode.Options, error) {
+	opts := &qrcode.Options{
+		Format:  strings.ToLower(params.Format),
+		Size:    params.Size,
+		Level:   params.Level,
+		Caption: params.Caption,
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if params.Logo != "" {
+		logo, err := m.materialUc.LoadImage(c, appId, params.Logo)
+		if err != nil {
+			return nil, fmt.Errorf("%w: logo %s", qrcode.ErrInvalidOptions, err.Error())
+		}
+		opts.Logo = logo
+	}
+	return opts, nil
+}
+
+// captionOf 替换说明文字中的 {scene}、{label}
+func captionOf(caption string, code *entities.QRCode) string {
+	if caption == "" {
+		return ""
+	}
+	return strings.NewReplacer("{scene}", code.Scene, "{label}", code.Label).Replace(caption)
+}
+
+// render 渲染一个二维码，opts 在同一批次中复用，说明文字按二维码替换
+func (m *MpQRCodeUsecase) render(code *entities.QRCode, opts *qrcode.Options, caption string,
+) (*QRCodeImage, error) {
+	o := *opts
+	o.Caption = captionOf(caption, code)
+	var buf bytes.Buffer
+	if err := m.renderer.Render(&buf, code.URL, &o); err != nil {
+		return nil, err
+	}
+	return &QRCodeImage{ContentType: qrcode.ContentType(o.Format), Ext: "." + o.Format, Data: buf.Bytes()}, nil
+}
+
+// Image 在本地将二维码内容渲染为 PNG 或 SVG，可添加居中 logo 和说明文字
+//
+// 参数不正确时返回 qrcode.ErrInvalidOptions，临时二维码过期后返回 ErrQRCodeExpired。
+func (m *MpQRCodeUsecase) Image(c context.Context, appId string, code *entities.QRCode,
+	params *request.QRCodeImageQuery,
+) (*QRCodeImage, error) {
+	if qrcodeExpired(code) {
+		return nil, ErrQRCodeExpired
+	}
+	opts, err := m.imageOptions(c, appId, params)
+	if err != nil {
+		return nil, err
+	}
+	img, err := m.render(code, opts, params.Caption)
+	if err != nil {
+		if errors.Is(err, qrcode.ErrInvalidOptions) {
+			return nil, err
+		}
+		m.log.Error("render qrcode error", zap.String("id", code.ID.Hex()), zap.Error(err))
+		return nil, fmt.Errorf("render qrcode error")
+	}
+	return img, nil
+}
+
+// qrcodeExpired 临时二维码是否已过期
+func qrcodeExpired(code *entities.QRCode) bool {
+	return !code.Permanent && code.ExpiresAt > 0 && code.ExpiresAt <= time.Now().Unix()
+}
@@ -0,0 +1,437 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrQRSceneConflict 场景值已用于其他渠道，或已用于另一种二维码
+	ErrQRSceneConflict = errors.New("qrcode scene conflict")
+	// ErrQRCodeQuota 永久二维码数量已达上限
+	ErrQRCodeQuota = errors.New("permanent qrcode quota exceeded")
+)
+
+// qrcodeReserveStaleAfter 占用数量后超过该时间仍未生成二维码，视为释放失败
+const qrcodeReserveStaleAfter = 10 * time.Minute
+
+// MaxPermanentQRCodes 微信永久二维码的数量上限
+const MaxPermanentQRCodes = 100000
+
+type QRSceneRepo interface {
+	Get(c context.Context, appId string, scene string) (*entities.QRScene, error) // 不存在时返回 nil
+	GetById(c context.Context, appId string, id string) (*entities.QRScene, error)
+	// Create 登记场景值，已存在时返回 ErrQRSceneConflict
+	Create(c context.Context, scene *entities.QRScene) error
+	Update(c context.Context, scene *entities.QRScene) error // 更新名称、说明、负责人
+	MarkIssued(c context.Context, appId string, scene string, ts int64) error
+	CountIssuedPermanent(c context.Context, appId string) (int64, error) // 已生成或已占用数量的永久二维码场景值
+	// Reserve 为未生成过的永久二维码场景值占用数量，已达到 limit 时返回 ErrQRCodeQuota，
+	// 已被占用时不重复计数，返回 false
+	Reserve(c context.Context, appId string, scene string, limit int64) (bool, error)
+	// Release 释放已占用但未生成二维码的数量
+	Release(c context.Context, appId string, scene string) error
+	// ReleaseStale 释放 before 之前占用但仍未生成二维码的场景值，返回释放的数量，不修改计数
+	ReleaseStale(c context.Context, appId string, before int64) (int64, error)
+	// ResetQuota 删除数量计数，下次占用时按已登记的场景值重新统计
+	ResetQuota(c context.Context, appId string) error
+	Query(c context.Context, appId string,
+		params *request.QRSceneQuery) (*model.PageResult[*entities.QRScene], error)
+	FindByScenes(c context.Context, appId string, scenes []string) ([]*entities.QRScene, error)
+}
+
+// QRCodeQuotaUsage 永久二维码的使用情况
+type QRCodeQuotaUsage struct {
+	Max       int64 `json:"max"`   // 微信的上限
+	Limit     int64 `json:"limit"` // 配置的上限，不超过 Max
+	Issued    int64 `json:"issued"`
+	Remaining int64 `json:"remaining"`
+}
+
+// QRSceneChannel 粉丝来源渠道，按关注时扫码的场景值统计
+type QRSceneChannel struct {
+	Scene       string `json:"scene"`
+	Name        string `json:"name"` // 未登记的场景值为空
+	Description string `json:"description"`
+	Owner       string `json:"owner"`
+	Members     int64  `json:"members"`
+}
+
+// QRSceneUsecase 场景值登记
+//
+// 生成二维码前登记场景值，同一场景值不能用于不同的渠道，也不能同时用于永久和临时二维码，
+// 以免粉丝的 qr_scene_str 无法对应到唯一的渠道。
+// 永久二维码按登记的场景值计数，微信对同一场景值返回同一个二维码，重复生成不会占用数量。
+// 数量通过计数器原子占用，并发生成时不会超过上限。
+// 计数只包括通过本服务生成的二维码，在微信后台或其他系统生成过永久二维码时，应配置较小的上限。
+type QRSceneUsecase struct {
+	log            *zap.Logger
+	repo           QRSceneRepo
+	qrRepo         QRCodeRepo
+	memberRepo     MPMemberRepo
+	permanentLimit int64
+}
+
+// NewQRSceneUsecase permanentLimit 为永久二维码数量上限，不大于 0 或超过微信上限时使用微信上限
+func NewQRSceneUsecase(log *zap.Logger, repo QRSceneRepo, qrRepo QRCodeRepo,
+	memberRepo MPMemberRepo, permanentLimit int64,
+) *QRSceneUsecase {
+	if permanentLimit <= 0 || permanentLimit > MaxPermanentQRCodes {
+		permanentLimit = MaxPermanentQRCodes
+	}
+	return &QRSceneUsecase{
+		log:            log,
+		repo:           repo,
+		qrRepo:         qrRepo,
+		memberRepo:     memberRepo,
+		permanentLimit: permanentLimit,
+	}
+}
+
+// Claim 生成二维码前检查并登记场景值
+//
+// label 为二维码名称，与登记的渠道名称不同时返回 ErrQRSceneConflict；
+// 新的永久二维码场景值超过上限时返回 ErrQRCodeQuota，场景值仍保留登记。
+// reserved 表示本次占用了永久二维码数量，生成失败时需调用 Release 释放。
+func (u *QRSceneUsecase) Claim(c context.Context, appId string, req *request.CreateQRCodeReq,
+	permanent bool,
+) (scene *entities.QRScene, reserved bool, err error) {
+	if req.Scene == "" || len(req.Scene) > maxQRCodeScene {
+		return nil, false, fmt.Errorf("scene required, max=%d", maxQRCodeScene)
+	}
+	scene, err = u.register(c, appId, req, permanent)
+	if err != nil {
+		return nil, false, err
+	}
+	// 首次生成永久二维码时占用数量
+	if permanent && scene.FirstIssuedAt == 0 {
+		reserved, err = u.repo.Reserve(c, appId, scene.Scene, u.permanentLimit)
+		if errors.Is(err, ErrQRCodeQuota) {
+			return nil, false, fmt.Errorf("%w: limit %d", ErrQRCodeQuota, u.permanentLimit)
+		}
+		if err != nil {
+			u.log.Error("reserve qrcode quota error", zap.String("scene", req.Scene), zap.Error(err))
+			return nil, false, fmt.Errorf("reserve qrcode quota error")
+		}
+	}
+	return scene, reserved, nil
+}
+
+// register 返回已登记的场景值，未登记时登记
+func (u *QRSceneUsecase) register(c context.Context, appId string, req *request.CreateQRCodeReq,
+	permanent bool,
+) (*entities.QRScene, error) {
+	// 并发登记同一场景值时 Create 冲突，重新读取一次即可
+	for range 2 {
+		scene, err := u.repo.Get(c, appId, req.Scene)
+		if err != nil {
+			u.log.Error("get qrcode scene error", zap.String("scene", req.Scene), zap.Error(err))
+			return nil, fmt.Errorf("get qrcode scene error")
+		}
+		if scene != nil {
+			if err := u.check(c, scene, req.Label, permanent); err != nil {
+				return nil, err
+			}
+			return scene, nil
+		}
+
+		now := time.Now().Unix()
+		scene = &entities.QRScene{
+			AppId:     appId,
+			Scene:     req.Scene,
+			Name:      req.Label,
+			Owner:     req.Owner,
+			Permanent: permanent,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		err = u.repo.Create(c, scene)
+		if err == nil {
+			return scene, nil
+		}
+		if !errors.Is(err, ErrQRSceneConflict) {
+			u.log.Error("create qrcode scene error", zap.String("scene", req.Scene), zap.Error(err))
+			return nil, fmt.Errorf("create qrcode scene error")
+		}
+	}
+	return nil, fmt.Errorf("%w: scene %s is being registered", ErrQRSceneConflict, req.Scene)
+}
+
+// Release 永久二维码生成失败时释放 Claim 占用的数量，失败时只记录日志
+func (u *QRSceneUsecase) Release(c context.Context, appId string, scene string) {
+	if err := u.repo.Release(c, appId, scene); err != nil {
+		u.log.Error("release qrcode quota error", zap.String("appId", appId),
+			zap.String("scene", scene), zap.Error(err))
+	}
+}
+
+// check 检查已登记的场景值是否可以用于本次生成，渠道名称为空时使用 label
+func (u *QRSceneUsecase) check(c context.Context, scene *entities.QRScene, label string,
+	permanent bool,
+) error {
+	if scene.Permanent != permanent {
+		kind := "temporary"
+		if scene.Permanent {
+			kind = "permanent"
+		}
+		return fmt.Errorf("%w: scene %s registered for %s qrcodes", ErrQRSceneConflict, scene.Scene, kind)
+	}
+	if label == "" || label == scene.Name {
+		return nil
+	}
+	if scene.Name != "" {
+		return fmt.Errorf("%w: scene %s registered as %s", ErrQRSceneConflict, scene.Scene, scene.Name)
+	}
+	scene.Name = label
+	scene.UpdatedAt = time.Now().Unix()
+	if err := u.repo.Update(c, scene); err != nil {
+		u.log.Error("update qrcode scene error", zap.String("scene", scene.Scene), zap.Error(err))
+	}
+	return nil
+}
+
+// CheckBatch 批量生成前检查全部场景值，有冲突或新增的永久二维码超过上限时不生成
+//
+// 只做预先检查，生成每个二维码时仍由 Claim 占用数量
+func (u *QRSceneUsecase) CheckBatch(c context.Context, appId string, scenes []string,
+	label string, permanent bool,
+) error {
+	registered, err := u.repo.FindByScenes(c, appId, scenes)
+	if err != nil {
+		u.log.Error("find qrcode scenes error", zap.Error(err))
+		return fmt.Errorf("find qrcode scenes error")
+	}
+	issued := 0
+	for _, scene := range registered {
+		if scene.Permanent != permanent || (label != "" && scene.Name != "" && label != scene.Name) {
+			return fmt.Errorf("%w: scene %s registered as %s", ErrQRSceneConflict, scene.Scene, scene.Name)
+		}
+		if scene.FirstIssuedAt > 0 || scene.QuotaReserved {
+			issued++
+		}
+	}
+	if permanent {
+		return u.checkQuota(c, appId, int64(len(scenes)-issued))
+	}
+	return nil
+}
+
+// checkQuota 还可以新增 n 个永久二维码时返回 nil
+func (u *QRSceneUsecase) checkQuota(c context.Context, appId string, n int64) error {
+	usage, err := u.Usage(c, appId)
+	if err != nil {
+		return err
+	}
+	if usage.Remaining < n {
+		return fmt.Errorf("%w: %d of %d issued", ErrQRCodeQuota, usage.Issued, usage.Limit)
+	}
+	return nil
+}
+
+// Issued 二维码生成后更新场景值的生成次数，失败时只记录日志
+func (u *QRSceneUsecase) Issued(c context.Context, appId string, scene string) {
+	if err := u.repo.MarkIssued(c, appId, scene, time.Now().Unix()); err != nil {
+		u.log.Error("mark qrcode scene issued error", zap.String("appId", appId),
+			zap.String("scene", scene), zap.Error(err))
+	}
+}
+
+// Usage 永久二维码的使用情况
+func (u *QRSceneUsecase) Usage(c context.Context, appId string) (*QRCodeQuotaUsage, error) {
+	issued, err := u.repo.CountIssuedPermanent(c, appId)
+	if err != nil {
+		u.log.Error("count permanent qrcode error", zap.Error(err))
+		return nil, fmt.Errorf("count permanent qrcode error")
+	}
+	return &QRCodeQuotaUsage{
+		Max:       MaxPermanentQRCodes,
+		Limit:     u.permanentLimit,
+		Issued:    issued,
+		Remaining: max(0, u.permanentLimit-issued),
+	}, nil
+}
+
+// Register 预先登记场景值，供后续生成二维码使用
+func (u *QRSceneUsecase) Register(c context.Context, appId string, req *request.QRSceneReq,
+) (*entities.QRScene, error) {
+	if req.Scene == "" || len(req.Scene) > maxQRCodeScene {
+		return nil, fmt.Errorf("scene required, max=%d", maxQRCodeScene)
+	}
+	if err := checkSceneMeta(req.Name, req.Description); err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	scene := &entities.QRScene{
+		AppId:       appId,
+		Scene:       req.Scene,
+		Name:        req.Name,
+		Description: req.Description,
+		Owner:       req.Owner,
+		Permanent:   req.Permanent,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := u.repo.Create(c, scene); err != nil {
+		if errors.Is(err, ErrQRSceneConflict) {
+			return nil, fmt.Errorf("%w: scene %s already registered", ErrQRSceneConflict, req.Scene)
+		}
+		u.log.Error("create qrcode scene error", zap.Error(err))
+		return nil, fmt.Errorf("create qrcode scene error")
+	}
+	return scene, nil
+}
+
+// Update 修改场景值的名称、说明和负责人
+//
+// 已生成的二维码名称不会修改，之后用该场景值生成二维码时名称需与新的名称一致。
+func (u *QRSceneUsecase) Update(c context.Context, appId string, id string,
+	req *request.UpdateQRSceneReq,
+) (*entities.QRScene, error) {
+	if err := checkSceneMeta(req.Name, req.Description); err != nil {
+		return nil, err
+	}
+	scene, err := u.repo.GetById(c, appId, id)
+	if err != nil {
+		u.log.Error("get qrcode scene error", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("qrcode scene not found")
+	}
+	scene.Name = req.Name
+	scene.Description = req.Description
+	scene.Owner = req.Owner
+	scene.UpdatedAt = time.Now().Unix()
+	if err := u.repo.Update(c, scene); err != nil {
+		u.log.Error("update qrcode scene error", zap.Error(err))
+		return nil, fmt.Errorf("update qrcode scene error")
+	}
+	return scene, nil
+}
+
+func checkSceneMeta(name string, description string) error {
+	if name == "" || len([]rune(name)) > 64 {
+		return fmt.Errorf("name required, max=64")
+	}
+	if len([]rune(description)) > 256 {
+		return fmt.Errorf("description max=256")
+	}
+	return nil
+}
+
+// Query 查询登记的场景值
+func (u *QRSceneUsecase) Query(c context.Context, appId string, params *request.QRSceneQuery,
+) (*model.PageResult[*entities.QRScene], error) {
+	result, err := u.repo.Query(c, appId, params)
+	if err != nil {
+		u.log.Error("query qrcode scene error", zap.Error(err))
+		return nil, fmt.Errorf("query qrcode scene error")
+	}
+	return result, nil
+}
+
+// QRSceneRebuildResult 重建场景值登记的结果
+type QRSceneRebuildResult struct {
+	Added    int   `json:"added"`    // 新登记的场景值数量
+	Released int64 `json:"released"` // 释放的过期占用数量
+}
+
+// Rebuild 登记已生成但未登记的场景值，用于启用场景值登记之前生成的二维码
+//
+// 同一场景值同时用于永久和临时二维码时按永久二维码登记。
+// 同时释放占用超过 qrcodeReserveStaleAfter 仍未生成二维码的数量(生成失败后释放也失败)。
+func (u *QRSceneUsecase) Rebuild(c context.Context, appId string) (*QRSceneRebuildResult, error) {
+	before := time.Now().Add(-qrcodeReserveStaleAfter).Unix()
+	released, err := u.repo.ReleaseStale(c, appId, before)
+	if err != nil {
+		u.log.Error("release stale qrcode quota error", zap.String("appId", appId), zap.Error(err))
+		return nil, fmt.Errorf("rebuild qrcode scenes error")
+	}
+	result := &QRSceneRebuildResult{Released: released}
+
+	scenes, err := u.qrRepo.Scenes(c, appId)
+	if err != nil {
+		u.log.Error("aggregate qrcode scenes error", zap.Error(err))
+		return result, fmt.Errorf("rebuild qrcode scenes error")
+	}
+	// 释放后计数需要重新统计
+	permanent := released > 0
+	for _, scene := range scenes {
+		existing, err := u.repo.Get(c, appId, scene.Scene)
+		if err != nil {
+			u.log.Error("get qrcode scene error", zap.String("scene", scene.Scene), zap.Error(err))
+			return result, fmt.Errorf("rebuild qrcode scenes error")
+		}
+		if existing != nil {
+			continue
+		}
+		scene.AppId = appId
+		scene.UpdatedAt = time.Now().Unix()
+		err = u.repo.Create(c, scene)
+		if errors.Is(err, ErrQRSceneConflict) { // 已由其他请求登记
+			continue
+		}
+		if err != nil {
+			u.log.Error("create qrcode scene error", zap.String("scene", scene.Scene), zap.Error(err))
+			return result, fmt.Errorf("rebuild qrcode scenes error")
+		}
+		result.Added++
+		permanent = permanent || scene.Permanent
+	}
+	// 新登记的永久二维码未计入数量，重新统计
+	if permanent {
+		if err := u.repo.ResetQuota(c, appId); err != nil {
+			u.log.Error("reset qrcode quota error", zap.String("appId", appId), zap.Error(err))
+			return result, fmt.Errorf("rebuild qrcode scenes error")
+		}
+	}
+	u.log.Info("qrcode scenes rebuilt", zap.String("appId", appId), zap.Int("added", result.Added),
+		zap.Int64("released", released))
+	return result, nil
+}
+
+// Channels 按关注时扫码的场景值统计粉丝数，并对应到登记的渠道名称
+func (u *QRSceneUsecase) Channels(c context.Context, appId string) ([]*QRSceneChannel, error) {
+	counts, err := u.memberRepo.CountByQrScene(c, appId)
+	if err != nil {
+		u.log.Error("count members by qr scene error", zap.Error(err))
+		return nil, fmt.Errorf("count members error")
+	}
+	keys := make([]string, 0, len(counts))
+	for scene := range counts {
+		keys = append(keys, scene)
+	}
+	scenes, err := u.repo.FindByScenes(c, appId, keys)
+	if err != nil {
+		u.log.Error("find qrcode scenes error", zap.Error(err))
+		return nil, fmt.Errorf("find qrcode scenes error")
+	}
+	registered := make(map[string]*entities.QRScene, len(scenes))
+	for _, scene := range scenes {
+		registered[scene.Scene] = scene
+	}
+
+	channels := make([]*QRSceneChannel, 0, len(counts))
+	for scene, count := range counts {
+		channel := &QRSceneChannel{Scene: scene, Members: count}
+		if s, ok := registered[scene]; ok {
+			channel.Name = s.Name
+			channel.Description = s.Description
+			channel.Owner = s.Owner
+		}
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		if channels[i].Members != channels[j].Members {
+			return channels[i].Members > channels[j].Members
+		}
+		return strings.Compare(channels[i].Scene, channels[j].Scene) < 0
+	})
+	return channels, nil
+}
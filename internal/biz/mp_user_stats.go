@@ -0,0 +1,153 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"go.uber.org/zap"
+)
+
+// wxproxy 尚未提供的数据统计接口
+const (
+	pathGetUserSummary  = "/datacube/getusersummary"  // 用户增减数据
+	pathGetUserCumulate = "/datacube/getusercumulate" // 累计用户数据
+)
+
+const (
+	statsDateLayout  = time.DateOnly
+	userStatsMaxSpan = 7  // 用户分析接口一次最多查询的天数
+	userStatsMaxDays = 90 // 查询时最多返回的天数
+)
+
+// ErrInvalidDateRange 查询的日期格式或范围不正确
+var ErrInvalidDateRange = errors.New("invalid date range")
+
+type UserStatsRepo interface {
+	// Save 按 app_id、ref_date 更新，不存在时创建
+	Save(c context.Context, stats []*entities.UserStats) error
+	// Query 查询 [begin, end] 日期范围内的数据，按日期升序
+	Query(c context.Context, appId string, begin string, end string) ([]*entities.UserStats, error)
+}
+
+// UserStatsUsecase 导入公众号数据统计
+//
+// 微信只提供前一天及之前的数据，每次导入最近 7 天，重复导入时覆盖。
+type UserStatsUsecase struct {
+	log      *zap.Logger
+	repo     UserStatsRepo
+	apiProxy *APIProxyUsecase
+}
+
+func NewUserStatsUsecase(log *zap.Logger, repo UserStatsRepo, apiProxy *APIProxyUsecase,
+) *UserStatsUsecase {
+	return &UserStatsUsecase{log: log, repo: repo, apiProxy: apiProxy}
+}
+
+// Ingest 导入最近 7 天的用户增减和累计用户数据，返回导入的天数
+func (u *UserStatsUsecase) Ingest(c context.Context, appId string) (int, error) {
+	mpId, _ := c.Value("MP_ID").(string)
+	if mpId == "" {
+		u.log.Error("get mp id error")
+		return 0, fmt.Errorf("get mp id error")
+	}
+	token, err := u.apiProxy.GetAccessToken(c, appId, mpId)
+	if err != nil {
+		u.log.Error("get access token error", zap.Error(err))
+		return 0, fmt.Errorf("get access token error")
+	}
+
+	end := time.Now().AddDate(0, 0, -1)
+	params := map[string]string{
+		"begin_date": end.AddDate(0, 0, 1-userStatsMaxSpan).Format(statsDateLayout),
+		"end_date":   end.Format(statsDateLayout),
+	}
+	var summary struct {
+		List []struct {
+			RefDate    string `json:"ref_date"`
+			NewUser    int64  `json:"new_user"`
+			CancelUser int64  `json:"cancel_user"`
+		} `json:"list"`
+	}
+	if err := postWXAPI(c, pathGetUserSummary, token, params, &summary); err != nil {
+		u.log.Error("get user summary error", zap.String("appId", appId), zap.Error(err))
+		return 0, fmt.Errorf("get user summary error")
+	}
+	var cumulate struct {
+		List []struct {
+			RefDate      string `json:"ref_date"`
+			CumulateUser int64  `json:"cumulate_user"`
+		} `json:"list"`
+	}
+	if err := postWXAPI(c, pathGetUserCumulate, token, params, &cumulate); err != nil {
+		u.log.Error("get user cumulate error", zap.String("appId", appId), zap.Error(err))
+		return 0, fmt.Errorf("get user cumulate error")
+	}
+
+	// 用户增减数据按来源分多条，按日期合计
+	now := time.Now().Unix()
+	days := make(map[string]*entities.UserStats)
+	day := func(refDate string) *entities.UserStats {
+		if s, ok := days[refDate]; ok {
+			return s
+		}
+		s := &entities.UserStats{AppId: appId, MpId: mpId, RefDate: refDate, UpdatedAt: now}
+		days[refDate] = s
+		return s
+	}
+	for _, item := range summary.List {
+		s := day(item.RefDate)
+		s.NewUser += item.NewUser
+		s.CancelUser += item.CancelUser
+	}
+	for _, item := range cumulate.List {
+		day(item.RefDate).CumulateUser = item.CumulateUser
+	}
+
+	stats := make([]*entities.UserStats, 0, len(days))
+	for _, s := range days {
+		stats = append(stats, s)
+	}
+	if err := u.repo.Save(c, stats); err != nil {
+		u.log.Error("save user stats error", zap.Error(err))
+		return 0, fmt.Errorf("save user stats error")
+	}
+	u.log.Info("user stats ingested", zap.String("appId", appId), zap.Int("days", len(stats)))
+	return len(stats), nil
+}
+
+// Query 查询每日用户数据，begin、end 格式为 2006-01-02，为空时返回最近 30 天
+func (u *UserStatsUsecase) Query(c context.Context, appId string, begin string, end string,
+) ([]*entities.UserStats, error) {
+	endDate := time.Now().AddDate(0, 0, -1)
+	if end != "" {
+		t, err := time.Parse(statsDateLayout, end)
+		if err != nil {
+			return nil, fmt.Errorf("%w: end %s", ErrInvalidDateRange, end)
+		}
+		endDate = t
+	}
+	beginDate := endDate.AddDate(0, 0, -29)
+	if begin != "" {
+		t, err := time.Parse(statsDateLayout, begin)
+		if err != nil {
+			return nil, fmt.Errorf("%w: begin %s", ErrInvalidDateRange, begin)
+		}
+		beginDate = t
+	}
+	if beginDate.After(endDate) {
+		return nil, fmt.Errorf("%w: begin after end", ErrInvalidDateRange)
+	}
+	if endDate.Sub(beginDate) >= userStatsMaxDays*24*time.Hour {
+		return nil, fmt.Errorf("%w: longer than %d days", ErrInvalidDateRange, userStatsMaxDays)
+	}
+
+	stats, err := u.repo.Query(c, appId, beginDate.Format(statsDateLayout), endDate.Format(statsDateLayout))
+	if err != nil {
+		u.log.Error("query user stats error", zap.Error(err))
+		return nil, fmt.Errorf("query user stats error")
+	}
+	return stats, nil
+}
@@ -0,0 +1,229 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.uber.org/zap"
+)
+
+// 定时任务类型
+const (
+	JobMemberSync     = "member"          // 同步粉丝
+	JobTagSync        = "tag"             // 同步标签
+	JobBlackListSync  = "blacklist"       // 同步黑名单
+	JobMaterialSync   = "material"        // 同步永久素材
+	JobStatsIngest    = "stats"           // 导入数据统计
+	JobTagRuleSweep   = "tag_rule"        // 执行自动打标签规则
+	JobEngagement     = "engagement"      // 计算粉丝活跃度
+	JobTemporaryMedia = "temporary_media" // 刷新即将过期的临时素材
+	JobInboundMedia   = "inbound_media"   // 重新保存失败和未下载的粉丝媒体消息
+)
+
+// 任务执行状态
+const (
+	JobStatusPending = "pending"
+	JobStatusRunning = "running"
+	JobStatusSuccess = "success"
+	JobStatusFailed  = "failed"
+)
+
+// ErrJobNotSupported 任务类型暂不支持执行
+var ErrJobNotSupported = errors.New("job not supported")
+
+// allowedJobs 可以配置的任务类型
+var allowedJobs = []string{
+	JobMemberSync, JobTagSync, JobBlackListSync, JobMaterialSync, JobStatsIngest, JobTagRuleSweep,
+	JobEngagement, JobTemporaryMedia, JobInboundMedia,
+}
+
+type ScheduleRepo interface {
+	// ListScheduledApps 返回配置了定时任务的平台应用
+	ListScheduledApps(c context.Context) ([]*entities.PlatformApp, error)
+	UpdateSchedules(c context.Context, appId string, schedules []*entities.AppSchedule) error
+	SaveRun(c context.Context, run *entities.ScheduleRun) error // 存在则更新，不存在则创建
+	QueryRuns(c context.Context, appId string) ([]*entities.ScheduleRun, error)
+}
+
+// ScheduleUsecase 平台应用定时任务
+//
+// 定时任务配置保存在平台应用中，每个任务类型只保留最近一次执行结果。
+type ScheduleUsecase struct {
+	log        *zap.Logger
+	repo       ScheduleRepo
+	memberUc   *MPMemberUsecase
+	tagUc      *MemberTagUsecase
+	materialUc *MaterialUsecase
+	tagRuleUc  *TagRuleUsecase
+	engageUc   *EngagementUsecase
+	inboundUc  *InboundMediaUsecase
+	statsUc    *UserStatsUsecase
+	timeout    time.Duration
+}
+
+func NewScheduleUsecase(log *zap.Logger, repo ScheduleRepo,
+	memberUc *MPMemberUsecase, tagUc *MemberTagUsecase, materialUc *MaterialUsecase,
+	tagRuleUc *TagRuleUsecase, engageUc *EngagementUsecase, inboundUc *InboundMediaUsecase,
+	statsUc *UserStatsUsecase, timeout time.Duration,
+) *ScheduleUsecase {
+	return &ScheduleUsecase{
+		log:        log,
+		repo:       repo,
+		memberUc:   memberUc,
+		tagUc:      tagUc,
+		materialUc: materialUc,
+		tagRuleUc:  tagRuleUc,
+		engageUc:   engageUc,
+		inboundUc:  inboundUc,
+		statsUc:    statsUc,
+		timeout:    timeout,
+	}
+}
+
+// ValidateSchedule 校验任务类型和 cron 表达式
+func ValidateSchedule(s *entities.AppSchedule) error {
+	if !slices.Contains(allowedJobs, s.Job) {
+		return fmt.Errorf("job %s not allowed", s.Job)
+	}
+	if _, err := cron.ParseStandard(s.Spec); err != nil {
+		return fmt.Errorf("invalid cron spec %s: %s", s.Spec, err.Error())
+	}
+	return nil
+}
+
+// GetSchedules 返回定时任务配置及最近一次执行结果
+func (s *ScheduleUsecase) GetSchedules(c context.Context, appId string,
+) ([]*entities.AppSchedule, []*entities.ScheduleRun, error) {
+	app, err := GetAppInfoFromCtx(c)
+	if err != nil {
+		s.log.Error("get app info error", zap.Error(err))
+		return nil, nil, fmt.Errorf("get app info error")
+	}
+	runs, err := s.repo.QueryRuns(c, appId)
+	if err != nil {
+		s.log.Error("query schedule runs error", zap.Error(err))
+		return nil, nil, fmt.Errorf("query schedule runs error")
+	}
+	schedules := app.Schedules
+	if schedules == nil {
+		schedules = []*entities.AppSchedule{}
+	}
+	return schedules, runs, nil
+}
+
+// UpdateSchedules 更新定时任务配置，每个任务类型只能配置一次
+func (s *ScheduleUsecase) UpdateSchedules(c context.Context, appId string,
+	req *request.UpdateSchedulesReq,
+) error {
+	seen := make(map[string]bool, len(req.Schedules))
+	schedules := make([]*entities.AppSchedule, 0, len(req.Schedules))
+	for _, item := range req.Schedules {
+		doc := &entities.AppSchedule{Job: item.Job, Spec: item.Spec, Enabled: item.Enabled}
+		if err := ValidateSchedule(doc); err != nil {
+			return err
+		}
+		if seen[doc.Job] {
+			return fmt.Errorf("job %s duplicated", doc.Job)
+		}
+		seen[doc.Job] = true
+		schedules = append(schedules, doc)
+	}
+
+	if err := s.repo.UpdateSchedules(c, appId, schedules); err != nil {
+		s.log.Error("update schedules error", zap.Error(err))
+		return fmt.Errorf("update schedules error")
+	}
+	return nil
+}
+
+// ListScheduledApps 返回配置了定时任务的平台应用
+func (s *ScheduleUsecase) ListScheduledApps(c context.Context) ([]*entities.PlatformApp, error) {
+	return s.repo.ListScheduledApps(c)
+}
+
+// RunJob 执行定时任务并记录执行结果
+//
+// instance 为执行任务的实例标识
+func (s *ScheduleUsecase) RunJob(ctx context.Context, app *entities.PlatformApp,
+	schedule *entities.AppSchedule, instance string,
+) error {
+	appId := app.ID.Hex()
+	started := time.Now()
+	run := &entities.ScheduleRun{
+		AppId:     appId,
+		MpId:      app.MpId,
+		Job:       schedule.Job,
+		Spec:      schedule.Spec,
+		Instance:  instance,
+		Status:    JobStatusRunning,
+		StartedAt: started.Unix(),
+		UpdatedAt: started.Unix(),
+	}
+	if err := s.repo.SaveRun(ctx, run); err != nil {
+		s.log.Error("save schedule run error", zap.Error(err))
+	}
+
+	// 与 AppMiddleware 一致，后续业务从 context 中获取公众号信息
+	c := context.WithValue(ctx, "APP", app)
+	c = context.WithValue(c, "MP_ID", app.MpId)
+	c, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	s.log.Info("schedule job start", zap.String("appId", appId), zap.String("job", schedule.Job))
+	err := s.dispatch(c, appId, schedule.Job)
+
+	finished := time.Now()
+	run.Status = JobStatusSuccess
+	run.Error = ""
+	if err != nil {
+		run.Status = JobStatusFailed
+		run.Error = err.Error()
+		s.log.Error("schedule job error", zap.String("appId", appId),
+			zap.String("job", schedule.Job), zap.Error(err))
+	}
+	run.FinishedAt = finished.Unix()
+	run.Duration = finished.Sub(started).Milliseconds()
+	run.UpdatedAt = finished.Unix()
+	if err := s.repo.SaveRun(ctx, run); err != nil {
+		s.log.Error("save schedule run error", zap.Error(err))
+	}
+	return err
+}
+
+func (s *ScheduleUsecase) dispatch(c context.Context, appId string, job string) error {
+	switch job {
+	case JobMemberSync:
+		return s.memberUc.Pull(c, appId)
+	case JobTagSync:
+		_, err := s.tagUc.Pull(c, appId)
+		return err
+	case JobBlackListSync:
+		_, err := s.memberUc.PullBlackList(c, appId)
+		return err
+	case JobMaterialSync:
+		_, err := s.materialUc.Sync(c, appId)
+		return err
+	case JobStatsIngest:
+		_, err := s.statsUc.Ingest(c, appId)
+		return err
+	case JobTagRuleSweep:
+		return s.tagRuleUc.Sweep(c, appId)
+	case JobEngagement:
+		_, err := s.engageUc.Refresh(c, appId)
+		return err
+	case JobTemporaryMedia:
+		_, err := s.materialUc.RefreshTemporary(c, appId)
+		return err
+	case JobInboundMedia:
+		_, err := s.inboundUc.RetryFailed(c, appId)
+		return err
+	default:
+		return ErrJobNotSupported
+	}
+}
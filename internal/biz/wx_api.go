@@ -0,0 +1,81 @@
+package biz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/seth16888/wxcommon/domain"
+	"github.com/seth16888/wxcommon/mp"
+)
+
+// wxproxy 尚未提供的接口，直接调用微信接口
+const (
+	pathGetTagMembers    = "/cgi-bin/user/tag/get"               // 获取标签下粉丝列表
+	pathGetMedia         = "/cgi-bin/media/get"                  // 获取临时素材
+	pathGetMaterial      = "/cgi-bin/material/get_material"      // 获取永久素材
+	pathGetMaterialCount = "/cgi-bin/material/get_materialcount" // 获取永久素材总数
+)
+
+// errCodeInvalidMediaId 不合法的 media_id，素材已删除时也返回该错误
+const errCodeInvalidMediaId = 40007
+
+// wxAPIClient 直接调用微信接口使用的客户端，请求可以通过 context 取消
+var wxAPIClient = &http.Client{Timeout: 10 * time.Second}
+
+// postWXAPI 以 JSON 格式调用微信接口，业务错误转换为 error
+func postWXAPI(c context.Context, path, token string, body any, result any) error {
+	url := fmt.Sprintf("https://%s%s?access_token=%s", domain.GetWXAPIDomain(), path, token)
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(c, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := wxAPIClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return decodeWXResponse(resp, result)
+}
+
+// getWXAPI 以 GET 方式调用微信接口，业务错误转换为 error
+func getWXAPI(c context.Context, path, token string, result any) error {
+	url := fmt.Sprintf("https://%s%s?access_token=%s", domain.GetWXAPIDomain(), path, token)
+	req, err := http.NewRequestWithContext(c, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := wxAPIClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return decodeWXResponse(resp, result)
+}
+
+func decodeWXResponse(resp *http.Response, result any) error {
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var wxErr mp.WXError
+	if err := json.Unmarshal(respBytes, &wxErr); err != nil {
+		return fmt.Errorf("unmarshal response error")
+	}
+	if wxErr.ErrCode != 0 { // business error
+		return fmt.Errorf("call api error: %d %s", wxErr.ErrCode, wxErr.ErrMsg)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(respBytes, result)
+}
@@ -13,12 +13,21 @@ func StartApp(deps *di.Container) error {
 	srv := InitServer(deps)
 	deps.Server = srv
 
+	// Run scheduler
+	deps.Scheduler = InitScheduler(deps)
+	if deps.Scheduler != nil {
+		deps.Scheduler.Start()
+	}
+
 	// Run server
 	errChan := make(chan error, 1)
 	srv.Run(errChan)
 
 	quitFunc := func() {
 		srv.Shutdown()
+		if deps.Scheduler != nil {
+			deps.Scheduler.Stop()
+		}
 		deps.Log.Info("Server shutdown completed")
 	}
 	quit := make(chan os.Signal, 1)
@@ -0,0 +1,43 @@
+package bootstrap
+
+import (
+	"context"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/di"
+	"github.com/seth16888/wxbusiness/internal/schedule"
+)
+
+// InitScheduler 初始化定时任务调度，未启用时返回 nil
+//
+// 恢复中断的后台任务只在主节点执行；未启用调度时视为单实例部署，直接执行。
+func InitScheduler(deps *di.Container) *schedule.Scheduler {
+	recoveries := []func(ctx context.Context){
+		deps.TaggingJobUsecase.Recover,
+		deps.MpQRCodeUsecase.ResumeBatches,
+	}
+	conf := deps.Conf.Schedule
+	if conf == nil || !conf.Enabled {
+		deps.Log.Info("Scheduler disabled")
+		for _, fn := range recoveries {
+			go fn(context.Background())
+		}
+		return nil
+	}
+
+	lockTTL := 30 * time.Second
+	if conf.LockTTL > 0 {
+		lockTTL = time.Duration(conf.LockTTL) * time.Second
+	}
+	reloadInterval := time.Minute
+	if conf.ReloadInterval > 0 {
+		reloadInterval = time.Duration(conf.ReloadInterval) * time.Second
+	}
+
+	s := schedule.NewScheduler(deps.Log, deps.ScheduleUsecase, deps.Redis,
+		lockTTL, reloadInterval)
+	for _, fn := range recoveries {
+		s.AddRecovery(fn)
+	}
+	return s
+}
@@ -9,9 +9,14 @@ import (
 	"github.com/seth16888/wxbusiness/internal/bootstrap"
 	"github.com/seth16888/wxbusiness/internal/data"
 	"github.com/seth16888/wxbusiness/internal/di"
+	"github.com/seth16888/wxbusiness/internal/message"
+	"github.com/seth16888/wxbusiness/pkg/material"
+	"github.com/seth16888/wxbusiness/pkg/qrcode"
+	"github.com/seth16888/wxbusiness/pkg/redis"
 	"github.com/seth16888/wxbusiness/pkg/validator"
 	"github.com/seth16888/wxcommon/hc"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 )
 
 var configFile string
@@ -88,7 +93,11 @@ var rootCmd = &cobra.Command{
 		}
 		apiProxy := biz.NewAPIProxyUsecase(apiProxyClient, di.Get().Log, gRPCTimeout, tokenProxy)
     menuRepo := data.NewMPMenuData(di.Get().Log, di.Get().DB)
-		menuUsecase := biz.NewMPMenuUsecase(platformAppRepo, tokenProxy, apiProxy, di.Get().Log, menuRepo)
+		refRepo := data.NewMaterialRefData(di.Get().DB, di.Get().Log)
+		refUc := biz.NewMaterialRefUsecase(di.Get().Log, refRepo)
+		di.Get().MaterialRefUsecase = refUc
+		menuUsecase := biz.NewMPMenuUsecase(platformAppRepo, tokenProxy, apiProxy, di.Get().Log,
+			menuRepo, refUc)
 		di.Get().MenuUsecase = menuUsecase
 
 		coAuthClient, err := bootstrap.InitAuthClient(di.Get().Conf.CoAuthServer.Addr)
@@ -100,22 +109,121 @@ var rootCmd = &cobra.Command{
 		userUc := biz.NewUserUsecase(userAppRepo)
 		di.Get().UserUsecase = userUc
 
+		memberRepo, err := data.NewMPMemberData(di.Get().DB, di.Get().Log)
+		if err != nil {
+			return err
+		}
+
 		tagRepo := data.NewMemberTagData(di.Get().DB, di.Get().Log)
-		tagUc := biz.NewMemberTagUsecase(tagRepo, di.Get().Log, apiProxy)
+		tagUc := biz.NewMemberTagUsecase(tagRepo, di.Get().Log, apiProxy, memberRepo)
 		di.Get().MemberTagUsecase = tagUc
 
-		memberRepo := data.NewMPMemberData(di.Get().DB, di.Get().Log)
 		blockRepo := data.NewMPBlackListData(di.Get().DB, di.Get().Log)
-		memberUc := biz.NewMPMemberUsecase(di.Get().Log, memberRepo, apiProxy, blockRepo)
+		timelineRepo := data.NewMemberTimelineData(di.Get().DB, di.Get().Log)
+		timelineUc := biz.NewMemberTimelineUsecase(di.Get().Log, timelineRepo, memberRepo)
+		di.Get().MemberTimelineUsecase = timelineUc
+		identityRepo := data.NewMemberIdentityData(di.Get().DB, di.Get().Log)
+		identityUc := biz.NewMemberIdentityUsecase(di.Get().Log, identityRepo, memberRepo,
+			tagRepo, timelineRepo)
+		di.Get().MemberIdentityUsecase = identityUc
+		memberUc := biz.NewMPMemberUsecase(di.Get().Log, memberRepo, apiProxy, blockRepo,
+			timelineUc, identityUc)
 		di.Get().MPMemberUsecase = memberUc
 
+		taggingJobRepo := data.NewTaggingJobData(di.Get().DB, di.Get().Log)
+		taggingJobUc := biz.NewTaggingJobUsecase(di.Get().Log,
+			taggingJobRepo, memberRepo, memberUc)
+		di.Get().TaggingJobUsecase = taggingJobUc
+		segmentRepo := data.NewMemberSegmentData(di.Get().DB, di.Get().Log)
+		di.Get().MemberSegmentUsecase = biz.NewMemberSegmentUsecase(di.Get().Log,
+			segmentRepo, memberRepo, memberUc, taggingJobUc)
+		di.Get().MemberExportUsecase = biz.NewMemberExportUsecase(di.Get().Log,
+			memberRepo, tagRepo)
+		tagRuleRepo := data.NewTagRuleData(di.Get().DB, di.Get().Log)
+		tagRuleUc := biz.NewTagRuleUsecase(di.Get().Log, tagRuleRepo, memberRepo, memberUc)
+		di.Get().TagRuleUsecase = tagRuleUc
+		engageUc := biz.NewEngagementUsecase(di.Get().Log, memberRepo, timelineRepo)
+		di.Get().EngagementUsecase = engageUc
 		materialRepo := data.NewMPMaterialData(di.Get().DB, di.Get().Log)
+		storage, err := material.NewStorage(di.Get().Conf.Storage)
+		if err != nil {
+			return err
+		}
+		quotaUc := biz.NewMaterialQuotaUsecase(di.Get().Log, materialRepo, refUc)
+		di.Get().MaterialQuotaUsecase = quotaUc
 		materialUc := biz.NewMaterialUsecase(di.Get().Log, materialRepo, apiProxy,
-			di.Get().HttpClient)
+			storage, quotaUc, refUc)
+		// 引用素材的模块
+		refUc.Register(menuUsecase)
+		refUc.Register(materialUc)
 		di.Get().MaterialUsecase = materialUc
+		inboundRepo := data.NewInboundMediaData(di.Get().DB, di.Get().Log)
+		inboundUc := biz.NewInboundMediaUsecase(di.Get().Log, inboundRepo, materialUc)
+		di.Get().InboundMediaUsecase = inboundUc
+		if conf := di.Get().Conf.Erasure; conf != nil && conf.Enabled {
+			erasureRepo := data.NewMemberErasureData(di.Get().DB, di.Get().Log)
+			erasureUc, err := biz.NewMemberErasureUsecase(di.Get().Log,
+				erasureRepo, identityRepo, inboundUc, conf.Secret)
+			if err != nil {
+				return err
+			}
+			di.Get().MemberErasureUsecase = erasureUc
+		}
+		attributeRepo := data.NewMemberAttributeData(di.Get().DB, di.Get().Log)
+		di.Get().MemberAttributeUsecase = biz.NewMemberAttributeUsecase(di.Get().Log,
+			attributeRepo, memberRepo)
+
+		// 推送消息订阅者
+		dispatcher := message.NewDispatcher(10 * time.Second)
+		dispatcher.Subscribe(memberUc)
+		dispatcher.Subscribe(tagRuleUc)
+		dispatcher.Subscribe(timelineUc)
+		dispatcher.Subscribe(engageUc)
+		if conf := di.Get().Conf.Storage; conf != nil && conf.MirrorInbound {
+			dispatcher.Subscribe(inboundUc)
+		}
+		di.Get().MessageDispatcher = dispatcher
 
-		qrcodeUc := biz.NewMpQRCodeUsecase(di.Get().Log, platformAppRepo, tokenProxy, apiProxy)
+		qrcodeRepo := data.NewQRCodeData(di.Get().DB, di.Get().Log)
+		var fontFile string
+		var permanentLimit int64
+		if conf := di.Get().Conf.QRCode; conf != nil {
+			fontFile, permanentLimit = conf.Font, conf.PermanentLimit
+		}
+		sceneRepo := data.NewQRSceneData(di.Get().DB, di.Get().Log)
+		sceneUc := biz.NewQRSceneUsecase(di.Get().Log, sceneRepo, qrcodeRepo, memberRepo, permanentLimit)
+		di.Get().QRSceneUsecase = sceneUc
+		qrRenderer, err := qrcode.NewRenderer(fontFile)
+		if err != nil {
+			// 字体只影响说明文字，使用内置字体继续启动
+			di.Get().Log.Warn("load qrcode font error, use default font", zap.String("font", fontFile),
+				zap.Error(err))
+			if qrRenderer, err = qrcode.NewRenderer(""); err != nil {
+				return err
+			}
+		}
+		qrcodeUc := biz.NewMpQRCodeUsecase(di.Get().Log, platformAppRepo, qrcodeRepo, tokenProxy,
+			apiProxy, materialUc, sceneUc, qrRenderer)
 		di.Get().MpQRCodeUsecase = qrcodeUc
+		statsRepo := data.NewUserStatsData(di.Get().DB, di.Get().Log)
+		statsUc := biz.NewUserStatsUsecase(di.Get().Log, statsRepo, apiProxy)
+		di.Get().UserStatsUsecase = statsUc
+
+		// redis 只用于定时任务的主节点选举
+		if conf := di.Get().Conf.Schedule; conf != nil && conf.Enabled {
+			redisConf := di.Get().Conf.Redis
+			redis.ConnectRedis(redisConf.Addr, redisConf.Username, redisConf.Password,
+				redisConf.DB, di.Get().Log)
+			di.Get().Redis = redis.Redis
+		}
+
+		scheduleRepo := data.NewScheduleData(di.Get().DB, di.Get().Log)
+		jobTimeout := 30 * time.Minute
+		if conf := di.Get().Conf.Schedule; conf != nil && conf.JobTimeout > 0 {
+			jobTimeout = time.Duration(conf.JobTimeout) * time.Second
+		}
+		di.Get().ScheduleUsecase = biz.NewScheduleUsecase(di.Get().Log, scheduleRepo,
+			memberUc, tagUc, materialUc, tagRuleUc, engageUc, inboundUc, statsUc, jobTimeout)
 
 		return bootstrap.StartApp(di.Get())
 	},
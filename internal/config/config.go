@@ -4,6 +4,7 @@ import (
 	"github.com/seth16888/wxbusiness/internal/database"
 	"github.com/seth16888/wxbusiness/internal/server"
 	"github.com/seth16888/wxbusiness/pkg/logger"
+	"github.com/seth16888/wxbusiness/pkg/material"
 
 	"github.com/spf13/viper"
 )
@@ -17,6 +18,10 @@ type Conf struct {
 	TokenServer  *TokenServer  `mapstructure:"token_server"`
 	ProxyServer  *ProxyServer  `mapstructure:"proxy_server"`
 	CoAuthServer *CoAuthServer `mapstructure:"co_auth_server"`
+	Schedule     *Schedule
+	Storage      *material.StorageConfig // 素材文件存储
+	QRCode       *QRCode                 // 二维码图片渲染、永久二维码上限
+	Erasure      *Erasure                // 粉丝数据删除
 }
 
 // TokenServer token server配置
@@ -34,6 +39,26 @@ type CoAuthServer struct {
 	Addr string
 }
 
+// Schedule 定时任务配置
+type Schedule struct {
+	Enabled        bool
+	LockTTL        int `mapstructure:"lock_ttl"`        // 主节点选举锁过期时间，秒
+	ReloadInterval int `mapstructure:"reload_interval"` // 重新加载任务配置间隔，秒
+	JobTimeout     int `mapstructure:"job_timeout"`     // 单个任务超时时间，秒
+}
+
+// QRCode 二维码图片渲染配置
+type QRCode struct {
+	Font           string // 说明文字使用的 TTF/OTF 字体文件，中文需配置支持中文的字体，为空时使用内置字体
+	PermanentLimit int64  `mapstructure:"permanent_limit"` // 每个公众号永久二维码数量上限，不超过微信的 100000
+}
+
+// Erasure 粉丝数据删除配置
+type Erasure struct {
+	Enabled bool   // 是否启用粉丝数据删除接口
+	Secret  string // 生成匿名化假名和审计摘要的密钥，启用时必须配置，配置后不要修改
+}
+
 // redis配置
 type RedisConfig struct {
 	Addr     string
@@ -2,9 +2,12 @@ package data
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/seth16888/wxbusiness/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
@@ -50,6 +53,64 @@ func newDB(conf *database.DatabaseConfig, log *zap.Logger) *mongo.Client {
 	return client
 }
 
+// EnsureIndexes 创建集合索引，索引已存在时忽略
+//
+// 创建失败(如已有重复数据无法创建唯一索引)只记录日志，不影响服务启动。
+func (d *Data) EnsureIndexes(col *mongo.Collection, models ...mongo.IndexModel) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := col.Indexes().CreateMany(ctx, models); err != nil {
+		d.log.Error("create index error", zap.String("collection", col.Name()), zap.Error(err))
+	}
+}
+
+// CreateIndexes 创建集合索引，失败时返回错误
+//
+// 用于数据正确性依赖的索引(如唯一索引)，创建失败应阻止服务启动。
+func (d *Data) CreateIndexes(col *mongo.Collection, models ...mongo.IndexModel) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := col.Indexes().CreateMany(ctx, models); err != nil {
+		d.log.Error("create index error", zap.String("collection", col.Name()), zap.Error(err))
+		return fmt.Errorf("create %s index failed: %w", col.Name(), err)
+	}
+	return nil
+}
+
+// Migrate 执行一次性数据迁移，执行成功后记录到 migrations 集合，之后启动不再执行
+//
+// 多实例同时启动时可能重复执行，迁移函数需保证幂等。
+func (d *Data) Migrate(name string, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	col := d.db.Collection("migrations")
+	err := col.FindOne(ctx, bson.M{"_id": name}).Err()
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		d.log.Error("find migration error", zap.String("name", name), zap.Error(err))
+		return fmt.Errorf("migration %s failed: %w", name, err)
+	}
+
+	if err := fn(ctx); err != nil {
+		d.log.Error("migration error", zap.String("name", name), zap.Error(err))
+		return fmt.Errorf("migration %s failed: %w", name, err)
+	}
+	_, err = col.UpdateOne(ctx, bson.M{"_id": name},
+		bson.M{"$setOnInsert": bson.M{"applied_at": time.Now().Unix()}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		d.log.Error("save migration error", zap.String("name", name), zap.Error(err))
+		return fmt.Errorf("migration %s failed: %w", name, err)
+	}
+	d.log.Info("migration applied", zap.String("name", name))
+	return nil
+}
+
 // GetSkipNum get skip num
 func GetSkipNum(page, pageSize int64) int64 {
 	if page <= 0 {
@@ -0,0 +1,27 @@
+package entities
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// InboundMedia 粉丝发送的图片、语音、视频消息，文件保存在素材存储中
+// MongoDB数据库表名：inbound_media
+type InboundMedia struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`                    // MongoDB的主键字段
+	AppId         string             `bson:"app_id" json:"app_id"`                       // 平台应用ID
+	MpId          string             `bson:"mp_id" json:"mp_id"`                         // 公众号appid
+	OpenId        string             `bson:"openid" json:"openid"`                       // 发送消息的粉丝
+	MsgId         int64              `bson:"msg_id" json:"msg_id"`                       // 消息ID
+	MsgType       string             `bson:"msg_type" json:"msg_type"`                   // image, voice, video, shortvideo
+	MediaId       string             `bson:"media_id" json:"media_id"`                   // 临时素材ID，3天后失效
+	MediaId16K    string             `bson:"media_id_16k" json:"media_id_16k,omitempty"` // 16K采样率语音
+	Format        string             `bson:"format" json:"format,omitempty"`             // 语音格式
+	StorageKey    string             `bson:"storage_key" json:"storage_key"`
+	StorageKey16K string             `bson:"storage_key_16k" json:"storage_key_16k,omitempty"`
+	ContentType   string             `bson:"content_type" json:"content_type"`
+	Size          int64              `bson:"size" json:"size"`
+	Status        string             `bson:"status" json:"status"` // pending, mirrored, failed
+	Error         string             `bson:"error" json:"error,omitempty"`
+	Attempts      int                `bson:"attempts" json:"attempts"`       // 下载次数
+	ReceivedAt    int64              `bson:"received_at" json:"received_at"` // 消息发送时间
+	CreatedAt     int64              `bson:"created_at" json:"created_at"`
+	UpdatedAt     int64              `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,18 @@
+package entities
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// MemberActivity 粉丝动态：关注、扫码、消息、被动回复、标签、备注、拉黑等
+// MongoDB数据库表名：member_activities
+type MemberActivity struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"` // MongoDB的主键字段
+	AppId     string             `bson:"app_id" json:"app_id"`    // 平台应用ID
+	MpId      string             `bson:"mp_id" json:"mp_id"`      // 公众号appid
+	OpenId    string             `bson:"openid" json:"openid"`
+	Type      string             `bson:"type" json:"type"`
+	Content   string             `bson:"content" json:"content"`               // 摘要：消息内容、事件KEY、标签ID等
+	Data      map[string]string  `bson:"data,omitempty" json:"data,omitempty"` // 附加信息
+	Operator  string             `bson:"operator,omitempty" json:"operator,omitempty"`
+	MsgId     int64              `bson:"msg_id,omitempty" json:"-"` // 推送消息的MsgId，用于去重，事件和操作动态为空
+	CreatedAt int64              `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,18 @@
+package entities
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// MemberAttributeDef 粉丝自定义属性定义，属性值保存在 MPMember.Attributes
+// MongoDB数据库表名：member_attribute_defs
+type MemberAttributeDef struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"` // MongoDB的主键字段
+	AppId     string             `bson:"app_id" json:"app_id"`    // 平台应用ID
+	MpId      string             `bson:"mp_id" json:"mp_id"`      // 公众号appid
+	Key       string             `bson:"key" json:"key"`          // 属性名，创建后不可修改
+	Name      string             `bson:"name" json:"name"`        // 显示名称
+	Type      string             `bson:"type" json:"type"`        // string, number, date(2006-01-02), enum
+	Options   []string           `bson:"options" json:"options"`  // enum 可选值
+	Required  bool               `bson:"required" json:"required"`
+	CreatedAt int64              `bson:"created_at" json:"created_at"`
+	UpdatedAt int64              `bson:"updated_at" json:"updated_at"`
+}
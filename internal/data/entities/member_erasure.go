@@ -0,0 +1,26 @@
+package entities
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// MemberErasure 粉丝数据删除(匿名化)的审计记录，不保存 openid、unionid 原文
+// MongoDB数据库表名：member_erasures
+type MemberErasure struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`          // MongoDB的主键字段
+	AppId       string             `bson:"app_id" json:"app_id"`             // 发起删除的平台应用ID
+	MpId        string             `bson:"mp_id" json:"mp_id"`               // 公众号appid
+	SubjectType string             `bson:"subject_type" json:"subject_type"` // openid, unionid
+	SubjectHash string             `bson:"subject_hash" json:"subject_hash"` // openid 或 unionid 的 HMAC-SHA256
+	Mode        string             `bson:"mode" json:"mode"`                 // delete 删除, anonymize 匿名化
+	Accounts    []string           `bson:"accounts" json:"accounts"`         // 涉及的平台应用ID
+	Items       []*ErasureItem     `bson:"items" json:"items"`               // 各数据的处理结果
+	Reason      string             `bson:"reason" json:"reason"`
+	Operator    string             `bson:"operator" json:"operator"` // 操作用户UID
+	CreatedAt   int64              `bson:"created_at" json:"created_at"`
+}
+
+// ErasureItem 一类数据的处理结果
+type ErasureItem struct {
+	Collection string `bson:"collection" json:"collection"`
+	Action     string `bson:"action" json:"action"` // delete, anonymize, unlink
+	Count      int64  `bson:"count" json:"count"`
+}
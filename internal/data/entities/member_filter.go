@@ -0,0 +1,24 @@
+package entities
+
+// MemberFilter 粉丝筛选条件，也是分群(MemberSegment)保存的条件
+type MemberFilter struct {
+	TagId             int64    `json:"tag_id" form:"tag_id" bson:"tag_id"`                                        // 兼容旧参数，等同于 tag_ids 只有一个
+	TagIds            []int64  `json:"tag_ids" form:"tag_ids" bson:"tag_ids"`                                     // 标签ID列表
+	TagMatch          string   `json:"tag_match" form:"tag_match" bson:"tag_match"`                               // 标签匹配方式: or(默认) 包含任一标签, and 包含全部标签
+	ExcludeTagIds     []int64  `json:"exclude_tag_ids" form:"exclude_tag_ids" bson:"exclude_tag_ids"`             // 不包含的标签ID列表
+	Remark            string   `json:"remark" form:"remark" bson:"remark"`                                        // 备注，模糊匹配
+	NickName          string   `json:"nick_name" form:"nick_name" bson:"nick_name"`                               // 昵称，模糊匹配
+	Language          string   `json:"language" form:"language" bson:"language"`                                  // 语言
+	SubscribeScenes   []string `json:"subscribe_scenes" form:"subscribe_scenes" bson:"subscribe_scenes"`          // 关注渠道来源
+	QrScene           int64    `json:"qr_scene" form:"qr_scene" bson:"qr_scene"`                                  // 二维码扫码场景
+	QrSceneStr        string   `json:"qr_scene_str" form:"qr_scene_str" bson:"qr_scene_str"`                      // 二维码扫码场景描述
+	SubscribeTimeFrom int64    `json:"subscribe_time_from" form:"subscribe_time_from" bson:"subscribe_time_from"` // 关注时间范围，时间戳
+	SubscribeTimeTo   int64    `json:"subscribe_time_to" form:"subscribe_time_to" bson:"subscribe_time_to"`
+	LastMessageFrom   int64    `json:"last_message_from" form:"last_message_from" bson:"last_message_from"` // 最后发消息时间范围，时间戳
+	LastMessageTo     int64    `json:"last_message_to" form:"last_message_to" bson:"last_message_to"`
+	Blocked           string   `json:"blocked" form:"blocked" bson:"blocked"` // 黑名单: 空(默认) 不含黑名单, true 只查黑名单, all 全部
+	Province          string   `json:"province" form:"province" bson:"province"`
+	Attrs             []string `json:"attrs" form:"attrs" bson:"attrs"`                            // 自定义属性条件，格式 key:op:value，op: eq, ne, gt, gte, lt, lte, contains
+	EngagementMin     *int64   `json:"engagement_min" form:"engagement_min" bson:"engagement_min"` // 活跃度评分范围 0-100
+	EngagementMax     *int64   `json:"engagement_max" form:"engagement_max" bson:"engagement_max"`
+}
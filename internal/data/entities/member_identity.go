@@ -0,0 +1,22 @@
+package entities
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// MemberIdentity 按 unionid 关联同一用户下多个公众号的粉丝
+// MongoDB数据库表名：member_identities
+type MemberIdentity struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"` // MongoDB的主键字段
+	UserId    string             `bson:"user_id" json:"user_id"`  // 平台应用所属用户，身份只在同一用户的应用之间关联
+	UnionId   string             `bson:"union_id" json:"union_id"`
+	Accounts  []*IdentityAccount `bson:"accounts" json:"accounts"`
+	CreatedAt int64              `bson:"created_at" json:"created_at"`
+	UpdatedAt int64              `bson:"updated_at" json:"updated_at"`
+}
+
+// IdentityAccount 关联的公众号粉丝
+type IdentityAccount struct {
+	AppId    string `bson:"app_id" json:"app_id"` // 平台应用ID
+	MpId     string `bson:"mp_id" json:"mp_id"`   // 公众号appid
+	OpenId   string `bson:"openid" json:"openid"`
+	LinkedAt int64  `bson:"linked_at" json:"linked_at"`
+}
@@ -0,0 +1,16 @@
+package entities
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// MemberSegment 粉丝分群，保存筛选条件，可用于批量打标签、群发
+// MongoDB数据库表名：member_segments
+type MemberSegment struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"` // MongoDB的主键字段
+	AppId       string             `bson:"app_id" json:"app_id"`    // 平台应用ID
+	MpId        string             `bson:"mp_id" json:"mp_id"`      // 公众号appid
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description" json:"description"`
+	Filter      *MemberFilter      `bson:"filter" json:"filter"` // 筛选条件
+	CreatedAt   int64              `bson:"created_at" json:"created_at"`
+	UpdatedAt   int64              `bson:"updated_at" json:"updated_at"`
+}
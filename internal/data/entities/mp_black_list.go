@@ -1,12 +1,31 @@
 package entities
 
-// MPBlackList 黑名单
-// type MPBlackList struct {
-// 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"` // MongoDB的主键字段
-// 	AppId     string             `bson:"app_id" json:"app_id"`    // 平台应用ID
-// 	MpId      string             `bson:"mp_id" json:"mp_id"`      // 公众号appid
-// 	OpenId    string             `bson:"openid" json:"openid"`  // 微信用户openid
-// 	Reason    string             `bson:"reason" json:"reason"`    // 拉黑原因
-// 	CreatedAt int64              `bson:"created_at" json:"created_at"`
-// 	UpdatedAt int64              `bson:"updated_at" json:"updated_at"`
-// }
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// MPBlackList 黑名单，取消拉黑后删除
+// MongoDB数据库表名：mp_blacklists
+type MPBlackList struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`  // MongoDB的主键字段
+	AppId     string             `bson:"app_id" json:"app_id"`     // 平台应用ID
+	MpId      string             `bson:"mp_id" json:"mp_id"`       // 公众号appid
+	OpenId    string             `bson:"openid" json:"openid"`     // 微信用户openid
+	Reason    string             `bson:"reason" json:"reason"`     // 拉黑原因
+	Operator  string             `bson:"operator" json:"operator"` // 操作用户UID，同步时为空
+	Source    string             `bson:"source" json:"source"`     // manual 手动操作, sync 从微信后台同步
+	CreatedAt int64              `bson:"created_at" json:"created_at"`
+	UpdatedAt int64              `bson:"updated_at" json:"updated_at"`
+}
+
+// MPBlackListHistory 拉黑、取消拉黑记录
+// MongoDB数据库表名：mp_blacklist_histories
+type MPBlackListHistory struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"` // MongoDB的主键字段
+	AppId     string             `bson:"app_id" json:"app_id"`    // 平台应用ID
+	MpId      string             `bson:"mp_id" json:"mp_id"`      // 公众号appid
+	OpenId    string             `bson:"openid" json:"openid"`    // 微信用户openid
+	Action    string             `bson:"action" json:"action"`    // block, unblock
+	Reason    string             `bson:"reason" json:"reason"`
+	Operator  string             `bson:"operator" json:"operator"`
+	Source    string             `bson:"source" json:"source"`
+	CreatedAt int64              `bson:"created_at" json:"created_at"`
+}
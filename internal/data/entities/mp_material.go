@@ -10,10 +10,12 @@ type MPMaterial struct {
 	Type             string             `bson:"type" json:"type"`                             // 素材类型: image, voice, video, thumb, ArticleImage, news
 	IsPermanent      bool               `bson:"is_permanent" json:"is_permanent"`             // 是否永久素材
 	MediaId          string             `bson:"media_id" json:"media_id"`                     // 素材ID
+	ArticleIdx       int                `bson:"article_idx" json:"article_idx"`               // 图文素材中文章的序号，从0开始
 	ThumbMediaId     string             `bson:"thumb_media_id" json:"thumb_media_id"`         // 图文消息的封面图片素材id
 	URL              string             `bson:"url" json:"url"`                               // 图文页的URL，或者，当获取的列表是图片素材列表时，该字段是图片的URL
 	Filename         string             `bson:"filename" json:"filename"`                     // 文件名
-	Path             string             `bson:"path" json:"path"`                             // 本地文件路径
+	StorageKey       string             `bson:"storage_key" json:"storage_key"`               // 文件在素材存储中的key，从微信同步的素材为空
+	Hash             string             `bson:"hash,omitempty" json:"hash,omitempty"`         // 文件内容的 SHA-256，用于去重
 	Title            string             `bson:"title" json:"title"`                           // 图文消息标题
 	Author           string             `bson:"author" json:"author"`                         // 图文消息作者
 	Digest           string             `bson:"digest" json:"digest"`                         // 图文消息摘要
@@ -21,5 +23,34 @@ type MPMaterial struct {
 	Content          string             `bson:"content" json:"content"`                       // 图文消息的具体内容，支持HTML标签，必须少于2万字符，小于1M，且此处会去除JS,涉及图片url必须来源 "上传图文消息内的图片获取URL"接口获取。外部图片url将被过滤。
 	ContentSourceUrl string             `bson:"content_source_url" json:"content_source_url"` // 图文消息的原文地址，点击“阅读原文”的地址
 	CreatedAt        int64              `bson:"created_at" json:"created_at"`
+	ExpiresAt        int64              `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // 临时素材的过期时间
 	UpdatedAt        int64              `bson:"updated_at" json:"updated_at"`
+	SyncedAt         int64              `bson:"synced_at,omitempty" json:"synced_at,omitempty"`       // 最近一次从微信同步的时间
+	PreviousMediaIds []string           `bson:"previous_media_ids,omitempty" json:"-"`                // 临时素材重新上传前的 media_id
+	LastUsedAt       int64              `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"` // 临时素材最近一次被引用的时间
+	RefreshedAt      int64              `bson:"refreshed_at,omitempty" json:"-"`                      // 临时素材最近一次尝试重新上传的时间
+}
+
+// MaterialCount 永久素材数量，同步素材时从微信获取
+// MongoDB数据库表名：material_counts
+type MaterialCount struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	AppId      string             `bson:"app_id" json:"app_id"`
+	MpId       string             `bson:"mp_id" json:"mp_id"`
+	VoiceCount int64              `bson:"voice_count" json:"voice_count"`
+	VideoCount int64              `bson:"video_count" json:"video_count"`
+	ImageCount int64              `bson:"image_count" json:"image_count"`
+	NewsCount  int64              `bson:"news_count" json:"news_count"`
+	SyncedAt   int64              `bson:"synced_at" json:"synced_at"`
+}
+
+// MaterialRef 永久素材被引用的位置，由引用素材的模块在内容变化时更新
+// MongoDB数据库表名：material_refs
+type MaterialRef struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	AppId     string             `bson:"app_id" json:"-"`
+	MediaId   string             `bson:"media_id" json:"media_id"`
+	Source    string             `bson:"source" json:"source"`     // 引用来源: menu, news
+	Location  string             `bson:"location" json:"location"` // 菜单按钮名称、图文标题等
+	UpdatedAt int64              `bson:"updated_at" json:"updated_at"`
 }
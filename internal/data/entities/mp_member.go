@@ -28,7 +28,12 @@ type MPMember struct {
 	StarComment    int64              `bson:"star_comment" json:"star_comment"`       // 精品留言
 	PraiseCount    int64              `bson:"praise_count" json:"praise_count"`       // 点赞数
 	PraiseAmounts  int64              `bson:"praise_amounts" json:"praise_amounts"`   // 赞赏总金额：最后两位是小数点后两位，实际金额：10000表示100元
+	LastMessageAt  int64              `bson:"last_message_at" json:"last_message_at"` // 最后发消息时间
+	Engagement     int64              `bson:"engagement" json:"engagement"`           // 活跃度评分 0-100，由定时任务计算
+	EngagementAt   int64              `bson:"engagement_at" json:"engagement_at"`     // 活跃度计算时间
 	CreatedAt      int64              `bson:"created_at" json:"created_at"`           // 创建时间
 	UpdatedAt      int64              `bson:"updated_at" json:"updated_at"`           // 更新时间
 	Blocked        bool               `bson:"blocked" json:"blocked"`                 // 是否被封禁 - 黑名单
+	Attributes     map[string]any     `bson:"attributes,omitempty" json:"attributes"` // 自定义属性，见 MemberAttributeDef
+	ErasedAt       int64              `bson:"erased_at,omitempty" json:"erased_at"`   // 匿名化时间，匿名化后 openid 为假名
 }
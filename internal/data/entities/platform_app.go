@@ -15,6 +15,7 @@ type PlatformApp struct {
 	Status         int                `bson:"status" json:"status"`
 	Introduction   string             `bson:"introduction" json:"introduction"`
 	PicUrl         string             `bson:"pic_url" json:"pic_url"`
+	Schedules      []*AppSchedule     `bson:"schedules" json:"schedules"` // 定时任务配置
 	CreatedAt      int64              `bson:"created_at" json:"created_at"`
 	UpdatedAt      int64              `bson:"updated_at" json:"updated_at"`
 	DeletedAt      int64              `bson:"deleted_at" json:"deleted_at"`
@@ -0,0 +1,71 @@
+package entities
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// QRCode 生成的带参数二维码
+// MongoDB数据库表名：qrcodes
+type QRCode struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`                      // MongoDB的主键字段
+	AppId         string             `bson:"app_id" json:"app_id"`                         // 平台应用ID
+	MpId          string             `bson:"mp_id" json:"mp_id"`                           // 公众号appid
+	BatchId       string             `bson:"batch_id,omitempty" json:"batch_id,omitempty"` // 批量生成时的批次ID
+	Scene         string             `bson:"scene" json:"scene"`                           // 场景值
+	Permanent     bool               `bson:"permanent" json:"permanent"`                   // 是否永久二维码
+	Ticket        string             `bson:"ticket" json:"ticket"`
+	URL           string             `bson:"url" json:"url"` // 二维码图片解析后的地址
+	ExpireSeconds int64              `bson:"expire_seconds" json:"expire_seconds"`
+	ExpiresAt     int64              `bson:"expires_at" json:"expires_at"` // 临时二维码的过期时间，永久二维码为 0
+	Label         string             `bson:"label" json:"label"`           // 名称，如门店、活动
+	Owner         string             `bson:"owner" json:"owner"`           // 负责人
+	CreatedAt     int64              `bson:"created_at" json:"created_at"`
+}
+
+// QRCodeBatch 批量生成二维码的任务
+// MongoDB数据库表名：qrcode_batches
+type QRCodeBatch struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`  // MongoDB的主键字段
+	AppId         string             `bson:"app_id" json:"app_id"`     // 平台应用ID
+	MpId          string             `bson:"mp_id" json:"mp_id"`       // 公众号appid
+	Template      string             `bson:"template" json:"template"` // 场景值模板，{n} 替换为序号
+	Start         int                `bson:"start" json:"start"`       // 起始序号
+	Permanent     bool               `bson:"permanent" json:"permanent"`
+	ExpireSeconds int64              `bson:"expire_seconds" json:"expire_seconds"`
+	Label         string             `bson:"label" json:"label"`
+	Owner         string             `bson:"owner" json:"owner"`
+	Status        string             `bson:"status" json:"status"` // pending, running, success, failed
+	Total         int                `bson:"total" json:"total"`
+	Processed     int                `bson:"processed" json:"processed"`
+	Succeeded     int                `bson:"succeeded" json:"succeeded"`
+	Failed        int                `bson:"failed" json:"failed"`
+	Failures      []*QRCodeFailure   `bson:"failures" json:"failures"` // 生成失败的场景值及原因
+	Error         string             `bson:"error" json:"error"`
+	CreatedAt     int64              `bson:"created_at" json:"created_at"`
+	StartedAt     int64              `bson:"started_at" json:"started_at"`
+	FinishedAt    int64              `bson:"finished_at" json:"finished_at"`
+	UpdatedAt     int64              `bson:"updated_at" json:"updated_at"`
+}
+
+// QRCodeFailure 生成失败的二维码
+type QRCodeFailure struct {
+	Scene  string `bson:"scene" json:"scene"`
+	Reason string `bson:"reason" json:"reason"`
+}
+
+// QRScene 登记的场景值，同一场景值只能用于一个渠道，且只能用于永久或临时二维码中的一种
+// MongoDB数据库表名：qrcode_scenes
+type QRScene struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`                // MongoDB的主键字段
+	AppId         string             `bson:"app_id" json:"app_id"`                   // 平台应用ID
+	Scene         string             `bson:"scene" json:"scene"`                     // 场景值，对应粉丝的 qr_scene_str
+	Name          string             `bson:"name" json:"name"`                       // 渠道名称，如门店、活动
+	Description   string             `bson:"description" json:"description"`         // 渠道说明
+	Owner         string             `bson:"owner" json:"owner"`                     // 负责人
+	Permanent     bool               `bson:"permanent" json:"permanent"`             // 是否用于永久二维码
+	Issued        int64              `bson:"issued" json:"issued"`                   // 已生成的二维码数量
+	FirstIssuedAt int64              `bson:"first_issued_at" json:"first_issued_at"` // 首次生成二维码的时间，0 表示只登记未生成
+	QuotaReserved bool               `bson:"quota_reserved" json:"-"`                // 是否已占用永久二维码数量
+	ReservedAt    int64              `bson:"reserved_at,omitempty" json:"-"`         // 占用数量的时间
+	LastIssuedAt  int64              `bson:"last_issued_at" json:"last_issued_at"`
+	CreatedAt     int64              `bson:"created_at" json:"created_at"`
+	UpdatedAt     int64              `bson:"updated_at" json:"updated_at"`
+}
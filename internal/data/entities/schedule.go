@@ -0,0 +1,27 @@
+package entities
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// AppSchedule 平台应用定时任务配置，保存在 PlatformApp.Schedules 中
+type AppSchedule struct {
+	Job     string `bson:"job" json:"job"`         // 任务类型: member, tag, blacklist, material, stats, tag_rule, engagement, temporary_media, inbound_media
+	Spec    string `bson:"spec" json:"spec"`       // cron 表达式，如: 0 3 * * *
+	Enabled bool   `bson:"enabled" json:"enabled"` // 是否启用
+}
+
+// ScheduleRun 定时任务最近一次执行结果
+// MongoDB数据库表名：schedule_runs
+type ScheduleRun struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`      // MongoDB的主键字段
+	AppId      string             `bson:"app_id" json:"app_id"`         // 平台应用ID
+	MpId       string             `bson:"mp_id" json:"mp_id"`           // 公众号appid
+	Job        string             `bson:"job" json:"job"`               // 任务类型
+	Spec       string             `bson:"spec" json:"spec"`             // 执行时的 cron 表达式
+	Instance   string             `bson:"instance" json:"instance"`     // 执行任务的实例
+	Status     string             `bson:"status" json:"status"`         // 执行状态: running, success, failed
+	Error      string             `bson:"error" json:"error"`           // 失败原因
+	StartedAt  int64              `bson:"started_at" json:"started_at"` // 开始时间
+	FinishedAt int64              `bson:"finished_at" json:"finished_at"`
+	Duration   int64              `bson:"duration" json:"duration"` // 耗时，毫秒
+	UpdatedAt  int64              `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,21 @@
+package entities
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// TagRule 自动打标签规则
+// MongoDB数据库表名：member_tag_rules
+type TagRule struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"` // MongoDB的主键字段
+	AppId       string             `bson:"app_id" json:"app_id"`    // 平台应用ID
+	MpId        string             `bson:"mp_id" json:"mp_id"`      // 公众号appid
+	Name        string             `bson:"name" json:"name"`
+	TagId       int64              `bson:"tag_id" json:"tag_id"`
+	Type        string             `bson:"type" json:"type"`     // qr_scene, keyword, menu_click, province, inactive
+	Value       string             `bson:"value" json:"value"`   // 场景值、关键词、菜单KEY、省份
+	Days        int                `bson:"days" json:"days"`     // inactive: 未互动天数
+	Action      string             `bson:"action" json:"action"` // add 打标签, remove 取消标签
+	Enabled     bool               `bson:"enabled" json:"enabled"`
+	LastSweptAt int64              `bson:"last_swept_at" json:"last_swept_at"` // 最近一次批量执行时间
+	CreatedAt   int64              `bson:"created_at" json:"created_at"`
+	UpdatedAt   int64              `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,30 @@
+package entities
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// TaggingJob 按上传的openid文件批量打标签的任务
+// MongoDB数据库表名：member_tagging_jobs
+type TaggingJob struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"` // MongoDB的主键字段
+	AppId      string             `bson:"app_id" json:"app_id"`    // 平台应用ID
+	MpId       string             `bson:"mp_id" json:"mp_id"`      // 公众号appid
+	TagId      int64              `bson:"tag_id" json:"tag_id"`
+	Filename   string             `bson:"filename" json:"filename"` // 上传的文件名
+	Status     string             `bson:"status" json:"status"`     // pending, running, success, failed
+	Total      int                `bson:"total" json:"total"`       // 文件中的openid数量，已去重
+	Processed  int                `bson:"processed" json:"processed"`
+	Succeeded  int                `bson:"succeeded" json:"succeeded"`
+	Failed     int                `bson:"failed" json:"failed"`
+	Failures   []*TaggingFailure  `bson:"failures" json:"failures"` // 失败的openid及原因
+	Error      string             `bson:"error" json:"error"`
+	CreatedAt  int64              `bson:"created_at" json:"created_at"`
+	StartedAt  int64              `bson:"started_at" json:"started_at"`
+	FinishedAt int64              `bson:"finished_at" json:"finished_at"`
+	UpdatedAt  int64              `bson:"updated_at" json:"updated_at"`
+}
+
+// TaggingFailure 打标签失败的openid
+type TaggingFailure struct {
+	OpenId string `bson:"openid" json:"openid"`
+	Reason string `bson:"reason" json:"reason"`
+}
@@ -0,0 +1,16 @@
+package entities
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// UserStats 公众号每日用户数据，从微信数据统计接口导入
+// MongoDB数据库表名：mp_user_stats
+type UserStats struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`            // MongoDB的主键字段
+	AppId        string             `bson:"app_id" json:"app_id"`               // 平台应用ID
+	MpId         string             `bson:"mp_id" json:"mp_id"`                 // 公众号appid
+	RefDate      string             `bson:"ref_date" json:"ref_date"`           // 数据日期，如 2024-01-02
+	NewUser      int64              `bson:"new_user" json:"new_user"`           // 新增关注，各来源合计
+	CancelUser   int64              `bson:"cancel_user" json:"cancel_user"`     // 取消关注，各来源合计
+	CumulateUser int64              `bson:"cumulate_user" json:"cumulate_user"` // 总用户量
+	UpdatedAt    int64              `bson:"updated_at" json:"updated_at"`
+}
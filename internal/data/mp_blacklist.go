@@ -5,6 +5,8 @@ import (
 
 	"github.com/seth16888/wxbusiness/internal/biz"
 	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model"
+	"github.com/seth16888/wxbusiness/internal/model/request"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -12,26 +14,56 @@ import (
 )
 
 type MPBlackListData struct {
-	col  *mongo.Collection
-	data *Data
-	log  *zap.Logger
+	col        *mongo.Collection // mp_members
+	recordCol  *mongo.Collection
+	historyCol *mongo.Collection
+	data       *Data
+	log        *zap.Logger
 }
 
-// Block 批量封禁
-func (m *MPBlackListData) Block(c context.Context, appId string, openids []string) error {
+// Block 批量封禁，记录拉黑原因和操作记录
+func (m *MPBlackListData) Block(c context.Context, appId string, openids []string,
+	meta *entities.MPBlackList,
+) error {
+	if len(openids) == 0 {
+		return nil
+	}
 	// 查询
-  filter:= bson.M{
-    "app_id": appId,
-    "openid": bson.M{"$in": openids},
-  }
-  update := bson.M{
-    "$set": bson.M{
-      "blocked": true,
-    },
-  }
+	filter := bson.M{
+		"app_id": appId,
+		"openid": bson.M{"$in": openids},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"blocked": true,
+		},
+	}
 
-  _, err := m.col.UpdateMany(c, filter, update)
-  return err
+	_, err := m.col.UpdateMany(c, filter, update)
+	if err != nil {
+		return err
+	}
+
+	models := make([]mongo.WriteModel, 0, len(openids))
+	for _, openid := range openids {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"app_id": appId, "openid": openid}).
+			SetUpdate(bson.M{
+				"$set": bson.M{
+					"mp_id":      meta.MpId,
+					"reason":     meta.Reason,
+					"operator":   meta.Operator,
+					"source":     meta.Source,
+					"updated_at": meta.UpdatedAt,
+				},
+				"$setOnInsert": bson.M{"created_at": meta.CreatedAt},
+			}).
+			SetUpsert(true))
+	}
+	if _, err := m.recordCol.BulkWrite(c, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
+	}
+	return m.addHistory(c, appId, openids, "block", meta)
 }
 
 // Query 查询黑名单
@@ -55,25 +87,184 @@ func (m *MPBlackListData) Query(c context.Context, appId string) ([]*entities.MP
   return blacklists, nil
 }
 
-// Unblock 批量解封
-func (m *MPBlackListData) Unblock(c context.Context, appId string, openids []string) error {
+// Unblock 批量解封，删除拉黑记录
+func (m *MPBlackListData) Unblock(c context.Context, appId string, openids []string,
+	meta *entities.MPBlackList,
+) error {
+	if len(openids) == 0 {
+		return nil
+	}
 	// 查询
-  filter:= bson.M{
-    "app_id": appId,
-    "openid": bson.M{"$in": openids},
-  }
-  update := bson.M{
-    "$set": bson.M{
-      "blocked": false,
-    },
-  }
+	filter := bson.M{
+		"app_id": appId,
+		"openid": bson.M{"$in": openids},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"blocked": false,
+		},
+	}
+
+	_, err := m.col.UpdateMany(c, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if _, err := m.recordCol.DeleteMany(c, filter); err != nil {
+		return err
+	}
+	return m.addHistory(c, appId, openids, "unblock", meta)
+}
+
+func (m *MPBlackListData) addHistory(c context.Context, appId string, openids []string,
+	action string, meta *entities.MPBlackList,
+) error {
+	docs := make([]any, 0, len(openids))
+	for _, openid := range openids {
+		docs = append(docs, &entities.MPBlackListHistory{
+			AppId:     appId,
+			MpId:      meta.MpId,
+			OpenId:    openid,
+			Action:    action,
+			Reason:    meta.Reason,
+			Operator:  meta.Operator,
+			Source:    meta.Source,
+			CreatedAt: meta.UpdatedAt,
+		})
+	}
+	_, err := m.historyCol.InsertMany(c, docs)
+	return err
+}
+
+// QueryRecords 查询黑名单记录
+func (m *MPBlackListData) QueryRecords(c context.Context, appId string) ([]*entities.MPBlackList, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := m.recordCol.Find(c, bson.M{"app_id": appId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	records := make([]*entities.MPBlackList, 0)
+	if err := cursor.All(c, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// QueryHistory 分页查询操作记录
+func (m *MPBlackListData) QueryHistory(c context.Context, appId string,
+	params *request.BlackListHistoryQuery,
+) (*model.PageResult[*entities.MPBlackListHistory], error) {
+	filter := bson.M{"app_id": appId}
+	if params.OpenId != "" {
+		filter["openid"] = params.OpenId
+	}
+	if params.Action != "" {
+		filter["action"] = params.Action
+	}
+	total, err := m.historyCol.CountDocuments(c, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := params.PageSize
+	if limit <= 0 {
+		limit = 10
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(GetSkipNum(params.PageNo, limit)).
+		SetLimit(limit)
+	cursor, err := m.historyCol.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	pagingData := model.NewPageResult[*entities.MPBlackListHistory]()
+	pagingData.Total = total
+	if err := cursor.All(c, &pagingData.List); err != nil {
+		return nil, err
+	}
+	return pagingData, nil
+}
+
+// BlockedOpenIds 本地已拉黑的openid
+//
+// 以拉黑记录为准，本地没有粉丝信息的openid也有记录；同时包含记录拉黑原因之前已标记封禁的粉丝。
+func (m *MPBlackListData) BlockedOpenIds(c context.Context, appId string) ([]string, error) {
+	records, err := m.recordCol.Distinct(c, "openid", bson.M{"app_id": appId})
+	if err != nil {
+		return nil, err
+	}
+	members, err := m.col.Distinct(c, "openid", bson.M{"app_id": appId, "blocked": true})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(records))
+	openids := make([]string, 0, len(records))
+	for _, v := range append(records, members...) {
+		if openid, ok := v.(string); ok && !seen[openid] {
+			seen[openid] = true
+			openids = append(openids, openid)
+		}
+	}
+	return openids, nil
+}
+
+// RecordedOpenIds implements biz.MPBlackListRepo.
+func (m *MPBlackListData) RecordedOpenIds(c context.Context, appId string,
+	openids []string,
+) ([]string, error) {
+	if len(openids) == 0 {
+		return nil, nil
+	}
+	values, err := m.recordCol.Distinct(c, "openid", bson.M{"app_id": appId, "openid": bson.M{"$in": openids}})
+	if err != nil {
+		return nil, err
+	}
+	recorded := make([]string, 0, len(values))
+	for _, v := range values {
+		if openid, ok := v.(string); ok {
+			recorded = append(recorded, openid)
+		}
+	}
+	return recorded, nil
+}
 
-  _, err := m.col.UpdateMany(c, filter, update)
-  return err
+// MarkMembersBlocked implements biz.MPBlackListRepo.
+func (m *MPBlackListData) MarkMembersBlocked(c context.Context, appId string,
+	openids []string,
+) (int64, error) {
+	if len(openids) == 0 {
+		return 0, nil
+	}
+	filter := bson.M{"app_id": appId, "openid": bson.M{"$in": openids}, "blocked": bson.M{"$ne": true}}
+	res, err := m.col.UpdateMany(c, filter, bson.M{"$set": bson.M{"blocked": true}})
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
 }
 
 // NewMPBlackListData
 func NewMPBlackListData(data *Data, log *zap.Logger) biz.MPBlackListRepo {
 	collection := data.db.Collection("mp_members")
-	return &MPBlackListData{log: log, data: data, col: collection}
+	recordCol := data.db.Collection("mp_blacklists")
+	data.EnsureIndexes(recordCol, mongo.IndexModel{
+		Keys:    bson.D{{Key: "app_id", Value: 1}, {Key: "openid", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("uniq_app_openid"),
+	})
+	historyCol := data.db.Collection("mp_blacklist_histories")
+	data.EnsureIndexes(historyCol, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "openid", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	return &MPBlackListData{
+		log:        log,
+		data:       data,
+		col:        collection,
+		recordCol:  recordCol,
+		historyCol: historyCol,
+	}
 }
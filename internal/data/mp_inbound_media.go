@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type InboundMediaData struct {
+	col  *mongo.Collection
+	data *Data
+	log  *zap.Logger
+}
+
+// Create implements biz.InboundMediaRepo.
+func (m *InboundMediaData) Create(c context.Context, media *entities.InboundMedia) (bool, error) {
+	filter := bson.M{"app_id": media.AppId, "msg_id": media.MsgId}
+	update := bson.M{"$setOnInsert": media}
+	result, err := m.col.UpdateOne(c, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	if oid, ok := result.UpsertedID.(primitive.ObjectID); ok {
+		media.ID = oid
+		return true, nil
+	}
+	return false, nil
+}
+
+// Update implements biz.InboundMediaRepo.
+func (m *InboundMediaData) Update(c context.Context, media *entities.InboundMedia) error {
+	update := bson.M{"$set": bson.M{
+		"storage_key":     media.StorageKey,
+		"storage_key_16k": media.StorageKey16K,
+		"content_type":    media.ContentType,
+		"size":            media.Size,
+		"status":          media.Status,
+		"error":           media.Error,
+		"attempts":        media.Attempts,
+		"updated_at":      media.UpdatedAt,
+	}}
+	_, err := m.col.UpdateByID(c, media.ID, update)
+	return err
+}
+
+// Get implements biz.InboundMediaRepo.
+func (m *InboundMediaData) Get(c context.Context, appId string, id string,
+) (*entities.InboundMedia, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var media entities.InboundMedia
+	if err := m.col.FindOne(c, bson.M{"_id": objectID, "app_id": appId}).Decode(&media); err != nil {
+		return nil, err
+	}
+	return &media, nil
+}
+
+// Query implements biz.InboundMediaRepo.
+func (m *InboundMediaData) Query(c context.Context, appId string,
+	params *request.InboundMediaQuery,
+) (*model.PageResult[*entities.InboundMedia], error) {
+	filter := bson.M{"app_id": appId}
+	if params.OpenId != "" {
+		filter["openid"] = params.OpenId
+	}
+	if params.MsgType != "" {
+		filter["msg_type"] = params.MsgType
+	}
+	total, err := m.col.CountDocuments(c, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := params.PageSize
+	if limit <= 0 {
+		limit = 20
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "received_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(GetSkipNum(params.PageNo, limit)).
+		SetLimit(limit)
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	pagingData := model.NewPageResult[*entities.InboundMedia]()
+	pagingData.Total = total
+	if err := cursor.All(c, &pagingData.List); err != nil {
+		return nil, err
+	}
+	return pagingData, nil
+}
+
+// FindByMembers implements biz.InboundMediaRepo.
+func (m *InboundMediaData) FindByMembers(c context.Context, targets []*biz.ErasureTarget,
+) ([]*entities.InboundMedia, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	opts := options.Find().SetProjection(bson.M{"storage_key": 1, "storage_key_16k": 1})
+	cursor, err := m.col.Find(c, targetsFilter(targets, "openid"), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	var list []*entities.InboundMedia
+	if err := cursor.All(c, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// FindRetry implements biz.InboundMediaRepo.
+func (m *InboundMediaData) FindRetry(c context.Context, appId string, receivedAfter,
+	pendingBefore int64, maxAttempts int, limit int64,
+) ([]*entities.InboundMedia, error) {
+	filter := bson.M{
+		"app_id":      appId,
+		"received_at": bson.M{"$gt": receivedAfter},
+		"attempts":    bson.M{"$lt": maxAttempts},
+		"$or": bson.A{
+			bson.M{"status": biz.InboundFailed},
+			bson.M{"status": biz.InboundPending, "updated_at": bson.M{"$lt": pendingBefore}},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "attempts", Value: 1}, {Key: "received_at", Value: 1}}).
+		SetLimit(limit)
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	list := make([]*entities.InboundMedia, 0)
+	if err := cursor.All(c, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// DeleteMany implements biz.InboundMediaRepo.
+func (m *InboundMediaData) DeleteMany(c context.Context, ids []string) (int64, error) {
+	objectIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return 0, err
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+	result, err := m.col.DeleteMany(c, bson.M{"_id": bson.M{"$in": objectIDs}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// NewInboundMediaData creates a new InboundMediaData.
+func NewInboundMediaData(data *Data, log *zap.Logger) biz.InboundMediaRepo {
+	collection := data.db.Collection("inbound_media")
+	data.EnsureIndexes(collection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "app_id", Value: 1}, {Key: "msg_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "openid", Value: 1}, {Key: "received_at", Value: -1}},
+	}, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "status", Value: 1}, {Key: "received_at", Value: 1}},
+	})
+	return &InboundMediaData{col: collection, data: data, log: log}
+}
@@ -5,7 +5,9 @@ import (
 
 	"github.com/seth16888/wxbusiness/internal/biz"
 	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
@@ -18,22 +20,107 @@ type MPMaterialData struct {
 }
 
 // SaveMany implements biz.MaterialRepo.
+//
+// article_idx 为 0 时也匹配没有该字段的旧记录
 func (m *MPMaterialData) SaveMany(c context.Context, materials []*entities.MPMaterial) error {
-	// mongoDB insert many, if not exist, insert it.
-  for _, material := range materials {
-    filter := bson.M{
-      "app_id":       material.AppId,
-      "media_id":     material.MediaId,
-    }
-    update := bson.M{
-      "$set": material,
-    }
-    _, err := m.col.UpdateOne(c, filter, update, options.Update().SetUpsert(true))
-    if err!= nil {
-      return err
-    }
-  }
-  return nil
+	if len(materials) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(materials))
+	for _, material := range materials {
+		filter := bson.M{
+			"app_id":   material.AppId,
+			"media_id": material.MediaId,
+		}
+		if material.ArticleIdx == 0 {
+			filter["article_idx"] = bson.M{"$in": bson.A{0, nil}}
+		} else {
+			filter["article_idx"] = material.ArticleIdx
+		}
+		update := bson.M{
+			"$set": bson.M{
+				"mp_id":              material.MpId,
+				"type":               material.Type,
+				"is_permanent":       material.IsPermanent,
+				"article_idx":        material.ArticleIdx,
+				"thumb_media_id":     material.ThumbMediaId,
+				"url":                material.URL,
+				"filename":           material.Filename,
+				"title":              material.Title,
+				"author":             material.Author,
+				"digest":             material.Digest,
+				"show_cover_pic":     material.ShowCoverPic,
+				"content":            material.Content,
+				"content_source_url": material.ContentSourceUrl,
+				"updated_at":         material.UpdatedAt,
+				"synced_at":          material.SyncedAt,
+			},
+			"$setOnInsert": bson.M{"created_at": material.CreatedAt},
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(filter).SetUpdate(update).SetUpsert(true))
+	}
+	_, err := m.col.BulkWrite(c, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// FindStale implements biz.MaterialRepo.
+//
+// 同步开始后上传的素材创建时间晚于 syncedAt，不会被当作已删除
+func (m *MPMaterialData) FindStale(c context.Context, appId string, mediaType string,
+	syncedAt int64,
+) ([]*entities.MPMaterial, error) {
+	filter := bson.M{
+		"app_id":       appId,
+		"type":         mediaType,
+		"is_permanent": true,
+		"created_at":   bson.M{"$lt": syncedAt},
+		"$or": bson.A{
+			bson.M{"synced_at": bson.M{"$lt": syncedAt}},
+			bson.M{"synced_at": bson.M{"$exists": false}},
+		},
+	}
+	opts := options.Find().SetProjection(bson.M{"media_id": 1, "article_idx": 1, "storage_key": 1})
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	materials := make([]*entities.MPMaterial, 0)
+	if err := cursor.All(c, &materials); err != nil {
+		return nil, err
+	}
+	return materials, nil
+}
+
+// SaveCount implements biz.MaterialRepo.
+func (m *MPMaterialData) SaveCount(c context.Context, count *entities.MaterialCount) error {
+	filter := bson.M{"app_id": count.AppId}
+	update := bson.M{"$set": bson.M{
+		"mp_id":       count.MpId,
+		"voice_count": count.VoiceCount,
+		"video_count": count.VideoCount,
+		"image_count": count.ImageCount,
+		"news_count":  count.NewsCount,
+		"synced_at":   count.SyncedAt,
+	}}
+	_, err := m.data.db.Collection("material_counts").
+		UpdateOne(c, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// GetCount implements biz.MaterialRepo.
+func (m *MPMaterialData) GetCount(c context.Context, appId string) (*entities.MaterialCount, error) {
+	var count entities.MaterialCount
+	err := m.data.db.Collection("material_counts").FindOne(c, bson.M{"app_id": appId}).Decode(&count)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &count, nil
 }
 
 // Find implements biz.MaterialRepo.
@@ -64,6 +151,253 @@ func (m *MPMaterialData) Find(c context.Context, appId string,
 	return materials, nil
 }
 
+// FindByHash implements biz.MaterialRepo.
+func (m *MPMaterialData) FindByHash(c context.Context, appId string, mediaType string,
+	isPermanent bool, hash string, validAfter int64,
+) (*entities.MPMaterial, error) {
+	filter := bson.M{
+		"app_id":       appId,
+		"hash":         hash,
+		"type":         mediaType,
+		"is_permanent": isPermanent,
+	}
+	if !isPermanent {
+		filter["expires_at"] = bson.M{"$gt": validAfter}
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	var material entities.MPMaterial
+	err := m.col.FindOne(c, filter, opts).Decode(&material)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &material, nil
+}
+
+// Get implements biz.MaterialRepo.
+func (m *MPMaterialData) Get(c context.Context, appId string, ref string) (*entities.MPMaterial, error) {
+	// 临时素材重新上传后，之前的 media_id 仍然可以找到素材
+	filter := bson.M{"app_id": appId, "$or": bson.A{
+		bson.M{"media_id": ref},
+		bson.M{"previous_media_ids": ref},
+	}}
+	if objectID, err := primitive.ObjectIDFromHex(ref); err == nil {
+		filter = bson.M{"app_id": appId, "_id": objectID}
+	}
+	var material entities.MPMaterial
+	if err := m.col.FindOne(c, filter).Decode(&material); err != nil {
+		return nil, err
+	}
+	return &material, nil
+}
+
+// FindExpiring implements biz.MaterialRepo.
+//
+// 没有 expires_at 的旧记录按创建时间计算
+func (m *MPMaterialData) FindExpiring(c context.Context, appId string, before int64,
+	usedAfter int64, limit int64,
+) ([]*entities.MPMaterial, error) {
+	filter := bson.M{
+		"app_id":       appId,
+		"is_permanent": false,
+		"storage_key":  bson.M{"$gt": ""},
+		"last_used_at": bson.M{"$gte": usedAfter},
+		"$or":          expiresBefore(before),
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "refreshed_at", Value: 1}, {Key: "expires_at", Value: 1}}).
+		SetLimit(limit)
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	materials := make([]*entities.MPMaterial, 0)
+	if err := cursor.All(c, &materials); err != nil {
+		return nil, err
+	}
+	return materials, nil
+}
+
+// expiresBefore 在 before 之前过期的条件，没有 expires_at 的旧记录按创建时间计算
+func expiresBefore(before int64) bson.A {
+	return bson.A{
+		bson.M{"expires_at": bson.M{"$lte": before}},
+		bson.M{"expires_at": bson.M{"$exists": false}, "created_at": bson.M{"$lte": before - 3*86400}},
+	}
+}
+
+// maxPreviousMediaIds 保留重新上传前的 media_id 数量，临时素材约 3 天重新上传一次
+const maxPreviousMediaIds = 30
+
+// UpdateMedia implements biz.MaterialRepo.
+func (m *MPMaterialData) UpdateMedia(c context.Context, material *entities.MPMaterial,
+	previousMediaId string,
+) error {
+	update := bson.M{"$set": bson.M{
+		"media_id":       material.MediaId,
+		"thumb_media_id": material.ThumbMediaId,
+		"url":            material.URL,
+		"created_at":     material.CreatedAt,
+		"expires_at":     material.ExpiresAt,
+		"updated_at":     material.UpdatedAt,
+		"refreshed_at":   material.RefreshedAt,
+	}}
+	if previousMediaId != "" && previousMediaId != material.MediaId {
+		update["$push"] = bson.M{"previous_media_ids": bson.M{
+			"$each":  bson.A{previousMediaId},
+			"$slice": -maxPreviousMediaIds,
+		}}
+	}
+	_, err := m.col.UpdateByID(c, material.ID, update)
+	return err
+}
+
+// MarkRefreshFailed implements biz.MaterialRepo.
+func (m *MPMaterialData) MarkRefreshFailed(c context.Context, id string, ts int64) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	_, err = m.col.UpdateByID(c, objectID, bson.M{"$set": bson.M{"refreshed_at": ts}})
+	return err
+}
+
+// MarkUsed implements biz.MaterialRepo.
+func (m *MPMaterialData) MarkUsed(c context.Context, id string, ts int64) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	_, err = m.col.UpdateByID(c, objectID, bson.M{"$max": bson.M{"last_used_at": ts}})
+	return err
+}
+
+// PurgeExpired implements biz.MaterialRepo.
+func (m *MPMaterialData) PurgeExpired(c context.Context, appId string, before int64) (int64, error) {
+	filter := bson.M{
+		"app_id":       appId,
+		"is_permanent": false,
+		"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"storage_key": ""}, bson.M{"storage_key": nil}}},
+			bson.M{"$or": expiresBefore(before)},
+		},
+	}
+	result, err := m.col.DeleteMany(c, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// Delete implements biz.MaterialRepo.
+func (m *MPMaterialData) Delete(c context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	_, err = m.col.DeleteOne(c, bson.M{"_id": objectID})
+	return err
+}
+
+// CountPermanent implements biz.MaterialRepo.
+func (m *MPMaterialData) CountPermanent(c context.Context, appId string, mediaTypes []string,
+	createdAfter int64, uploadedOnly bool,
+) (int64, error) {
+	filter := bson.M{
+		"app_id":       appId,
+		"type":         bson.M{"$in": mediaTypes},
+		"is_permanent": true,
+		"media_id":     bson.M{"$ne": ""},
+		"article_idx":  bson.M{"$in": bson.A{0, nil}},
+		"created_at":   bson.M{"$gte": createdAfter},
+	}
+	if uploadedOnly {
+		filter["storage_key"] = bson.M{"$nin": bson.A{"", nil}}
+	}
+	return m.col.CountDocuments(c, filter)
+}
+
+// FindByMediaId implements biz.MaterialRepo.
+func (m *MPMaterialData) FindByMediaId(c context.Context, appId string, mediaId string,
+) ([]*entities.MPMaterial, error) {
+	filter := bson.M{"app_id": appId, "media_id": mediaId, "is_permanent": true}
+	cursor, err := m.col.Find(c, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	materials := make([]*entities.MPMaterial, 0)
+	if err := cursor.All(c, &materials); err != nil {
+		return nil, err
+	}
+	return materials, nil
+}
+
+// FindNewsThumbs implements biz.MaterialRepo.
+func (m *MPMaterialData) FindNewsThumbs(c context.Context, appId string,
+) ([]*entities.MPMaterial, error) {
+	filter := bson.M{
+		"app_id":         appId,
+		"type":           "news",
+		"is_permanent":   true,
+		"thumb_media_id": bson.M{"$nin": bson.A{"", nil}},
+	}
+	opts := options.Find().SetProjection(bson.M{"media_id": 1, "title": 1, "thumb_media_id": 1})
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	materials := make([]*entities.MPMaterial, 0)
+	if err := cursor.All(c, &materials); err != nil {
+		return nil, err
+	}
+	return materials, nil
+}
+
+// FindUnused implements biz.MaterialRepo.
+func (m *MPMaterialData) FindUnused(c context.Context, appId string, mediaTypes []string,
+	exclude []string, pageNo int64, pageSize int64,
+) (*model.PageResult[*entities.MPMaterial], error) {
+	filter := bson.M{
+		"app_id":       appId,
+		"type":         bson.M{"$in": mediaTypes},
+		"is_permanent": true,
+		"media_id":     bson.M{"$nin": append([]string{""}, exclude...)},
+		"article_idx":  bson.M{"$in": bson.A{0, nil}},
+	}
+	total, err := m.col.CountDocuments(c, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(GetSkipNum(pageNo, pageSize)).
+		SetLimit(pageSize)
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	pagingData := model.NewPageResult[*entities.MPMaterial]()
+	pagingData.Total = total
+	if err := cursor.All(c, &pagingData.List); err != nil {
+		return nil, err
+	}
+	return pagingData, nil
+}
+
 // Insert implements biz.MaterialRepo.
 func (m *MPMaterialData) Insert(c context.Context,
 	material *entities.MPMaterial,
@@ -76,5 +410,18 @@ func (m *MPMaterialData) Insert(c context.Context,
 // NewMPMaterialData
 func NewMPMaterialData(data *Data, log *zap.Logger) biz.MaterialRepo {
 	collection := data.db.Collection("mp_materials")
+	data.EnsureIndexes(collection, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "hash", Value: 1}},
+	}, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "is_permanent", Value: 1}, {Key: "expires_at", Value: 1}},
+	}, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "media_id", Value: 1}, {Key: "article_idx", Value: 1}},
+	}, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "previous_media_ids", Value: 1}},
+	})
+	data.EnsureIndexes(data.db.Collection("material_counts"), mongo.IndexModel{
+		Keys:    bson.D{{Key: "app_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
 	return &MPMaterialData{col: collection, data: data, log: log}
 }
@@ -0,0 +1,79 @@
+package data
+
+import (
+	"context"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type MaterialRefData struct {
+	col  *mongo.Collection
+	data *Data
+	log  *zap.Logger
+}
+
+// Replace implements biz.MaterialRefRepo.
+//
+// 先写入新的引用再删除旧的引用，中途失败时只会多出旧引用，删除素材的检查不会漏掉引用。
+func (m *MaterialRefData) Replace(c context.Context, appId string, source string,
+	refs []*entities.MaterialRef,
+) error {
+	ids := make(bson.A, 0, len(refs))
+	if len(refs) > 0 {
+		docs := make([]any, 0, len(refs))
+		for _, ref := range refs {
+			ref.ID = primitive.NewObjectID()
+			ids = append(ids, ref.ID)
+			docs = append(docs, ref)
+		}
+		if _, err := m.col.InsertMany(c, docs); err != nil {
+			return err
+		}
+	}
+	filter := bson.M{"app_id": appId, "source": source, "_id": bson.M{"$nin": ids}}
+	_, err := m.col.DeleteMany(c, filter)
+	return err
+}
+
+// List implements biz.MaterialRefRepo.
+func (m *MaterialRefData) List(c context.Context, appId string) ([]*entities.MaterialRef, error) {
+	return m.find(c, bson.M{"app_id": appId})
+}
+
+// FindByMediaId implements biz.MaterialRefRepo.
+func (m *MaterialRefData) FindByMediaId(c context.Context, appId string, mediaId string,
+) ([]*entities.MaterialRef, error) {
+	return m.find(c, bson.M{"app_id": appId, "media_id": mediaId})
+}
+
+func (m *MaterialRefData) find(c context.Context, filter bson.M) ([]*entities.MaterialRef, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "source", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	refs := make([]*entities.MaterialRef, 0)
+	if err := cursor.All(c, &refs); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// NewMaterialRefData creates a new MaterialRefData.
+func NewMaterialRefData(data *Data, log *zap.Logger) biz.MaterialRefRepo {
+	collection := data.db.Collection("material_refs")
+	data.EnsureIndexes(collection, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "media_id", Value: 1}},
+	}, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "source", Value: 1}},
+	})
+	return &MaterialRefData{col: collection, data: data, log: log}
+}
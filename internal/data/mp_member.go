@@ -2,6 +2,7 @@ package data
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/seth16888/wxbusiness/internal/biz"
@@ -9,6 +10,7 @@ import (
 	"github.com/seth16888/wxbusiness/internal/model"
 	"github.com/seth16888/wxbusiness/internal/model/request"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
@@ -25,14 +27,17 @@ func (m *MPMemberData) BatchTagging(c context.Context, appId string, ids []strin
 	filter := bson.M{"app_id": appId, "openid": bson.M{"$in": ids}}
 	update := bson.M{"$addToSet": bson.M{"tags": bson.M{"tag_id": tagId}}}
 
-	_, err := m.col.UpdateMany(c, filter, update)
+	res, err := m.col.UpdateMany(c, filter, update)
 	if err != nil {
 		return err
 	}
-	// Tag 粉丝数量+1
+	if res.ModifiedCount == 0 {
+		return nil
+	}
+	// Tag 粉丝数量增加实际新打标签的粉丝数，已有该标签的粉丝不重复计数
 	col := m.data.db.Collection("member_tags")
 	tagFilter := bson.M{"app_id": appId, "tag_id": tagId}
-	tagUpdate := bson.M{"$inc": bson.M{"count": 1}}
+	tagUpdate := bson.M{"$inc": bson.M{"count": res.ModifiedCount}}
 	_, err = col.UpdateOne(c, tagFilter, tagUpdate)
 	if err != nil {
 		return err
@@ -46,15 +51,18 @@ func (m *MPMemberData) BatchUnTagging(c context.Context, appId string, ids []str
 	filter := bson.M{"app_id": appId, "openid": bson.M{"$in": ids}}
 	update := bson.M{"$pull": bson.M{"tags": bson.M{"tag_id": tagId}}}
 
-	_, err := m.col.UpdateMany(c, filter, update)
+	res, err := m.col.UpdateMany(c, filter, update)
 	if err != nil {
 		return err
 	}
+	if res.ModifiedCount == 0 {
+		return nil
+	}
 
-	// Tag 粉丝数量-1
+	// Tag 粉丝数量减少实际取消标签的粉丝数
 	col := m.data.db.Collection("member_tags")
 	tagFilter := bson.M{"app_id": appId, "tag_id": tagId}
-	tagUpdate := bson.M{"$inc": bson.M{"count": -1}}
+	tagUpdate := bson.M{"$inc": bson.M{"count": -res.ModifiedCount}}
 	_, err = col.UpdateOne(c, tagFilter, tagUpdate)
 	if err != nil {
 		return err
@@ -62,104 +70,258 @@ func (m *MPMemberData) BatchUnTagging(c context.Context, appId string, ids []str
 	return nil
 }
 
+// CountByQrScene implements biz.MPMemberRepo.
+func (m *MPMemberData) CountByQrScene(c context.Context, appId string) (map[string]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"app_id": appId,
+			"$or":    bson.A{bson.M{"qr_scene_str": bson.M{"$nin": bson.A{"", nil}}}, bson.M{"qr_scene": bson.M{"$gt": 0}}},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$qr_scene_str", ""}},
+				"$qr_scene_str",
+				bson.M{"$toString": "$qr_scene"},
+			}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := m.col.Aggregate(c, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	counts := make(map[string]int64)
+	for cursor.Next(c) {
+		var item struct {
+			Scene string `bson:"_id"`
+			Count int64  `bson:"count"`
+		}
+		if err := cursor.Decode(&item); err != nil {
+			return nil, err
+		}
+		counts[item.Scene] = item.Count
+	}
+	return counts, cursor.Err()
+}
+
 // Save implements biz.MPMemberRepo.
+//
+// 使用 BulkWrite 按 app_id + openid 批量 upsert，微信返回的字段直接覆盖，
+// 本地维护的字段(消息数、评论数、黑名单等)只在创建时写入，备注为空时不覆盖本地备注。
+// 标签与打标签时的写法一致只保存 tag_id，Tags 为 nil 表示微信未返回标签，不覆盖本地标签。
 func (m *MPMemberData) Save(c context.Context, members []*entities.MPMember) error {
-	// 查询: app_id, openid
+	if len(members) == 0 {
+		return nil
+	}
+
 	now := time.Now().Unix()
+	models := make([]mongo.WriteModel, 0, len(members))
 	for _, member := range members {
 		filter := bson.M{"app_id": member.AppId, "openid": member.OpenId}
-		update := bson.M{"$set": bson.M{
+		set := bson.M{
 			"mp_id":           member.MpId,
 			"subscribe":       member.Subscribe,
-			"nick_name":       member.NickName,
-			"sex":             member.Sex,
 			"language":        member.Language,
-			"city":            member.City,
-			"province":        member.Province,
-			"country":         member.Country,
 			"subscribe_time":  member.SubscribeTime,
 			"union_id":        member.UnionId,
-			"remark":          member.Remark,
 			"group_id":        member.GroupId,
-			"tags":            member.Tags,
 			"subscribe_scene": member.SubscribeScene,
 			"qr_scene":        member.QrScene,
 			"qr_scene_str":    member.QrSceneStr,
+			"updated_at":      now,
+		}
+		setOnInsert := bson.M{
+			"nick_name":       member.NickName,
+			"sex":             member.Sex,
+			"city":            member.City,
+			"province":        member.Province,
+			"country":         member.Country,
 			"message_count":   member.MessageCount,
 			"comment_count":   member.CommentCount,
 			"star_comment":    member.StarComment,
 			"praise_count":    member.PraiseCount,
 			"praise_amounts":  member.PraiseAmounts,
-			"updated_at":      time.Now().Unix(), // 只更新更新时间
+			"last_message_at": member.LastMessageAt,
+			"engagement":      member.Engagement,
+			"engagement_at":   member.EngagementAt,
 			"blocked":         member.Blocked,
-		}}
-		result, err := m.col.UpdateOne(c, filter, update)
-		if err != nil {
-			return err
+			"created_at":      now,
 		}
-		if result.MatchedCount == 0 {
-			// 不存在则创建
-			member.CreatedAt = now
-			member.UpdatedAt = now
-			_, err := m.col.InsertOne(c, member)
-			if err != nil {
-				return err
+		if member.Tags != nil {
+			tags := make(bson.A, 0, len(member.Tags))
+			for _, tag := range member.Tags {
+				tags = append(tags, bson.M{"tag_id": tag.TagId})
 			}
+			set["tags"] = tags
+		} else {
+			setOnInsert["tags"] = bson.A{}
+		}
+		if member.Remark != "" {
+			set["remark"] = member.Remark
+		} else {
+			setOnInsert["remark"] = ""
 		}
+
+		model := mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(bson.M{"$set": set, "$setOnInsert": setOnInsert}).
+			SetUpsert(true)
+		models = append(models, model)
+	}
+
+	_, err := m.col.BulkWrite(c, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		m.log.Error("bulk save member error", zap.Error(err))
+		return err
 	}
 	return nil
 }
 
-// FindByAppId implements biz.MPMemberRepo.
+// Find implements biz.MPMemberRepo.
+//
+// 支持页码分页和游标分页，设置 Cursor 时使用游标分页。
 func (m *MPMemberData) Find(c context.Context, appId string,
 	params *request.MPMemberQuery,
 ) (*model.PageResult[*entities.MPMember], error) {
-	filter := bson.M{"app_id": appId, "blocked": false} // 过滤掉被封禁的用户
-	if params.TagId > 0 {
-		filter["tags.tag_id"] = params.TagId
+	filter := buildMemberFilter(appId, &params.MPMemberFilter)
+	// 统计总数
+	total, err := m.col.CountDocuments(c, filter)
+	if err != nil {
+		m.log.Error("count member error", zap.Error(err))
+		return nil, err
 	}
-	// 分页
-	skip := GetSkipNum(params.PageNo, params.PageSize)
+
+	// 排序
+	sortField, sortDir := memberSort(params)
 	limit := params.PageSize
-	ops := &options.FindOptions{
-		Skip:  &skip,
-		Limit: &limit,
+	if limit <= 0 {
+		limit = 10
 	}
-	cursor, err := m.col.Find(c, filter, ops)
+	opts := options.Find().
+		SetSort(bson.D{{Key: sortField, Value: sortDir}, {Key: "_id", Value: sortDir}}).
+		SetLimit(limit)
+
+	query := filter
+	if params.Cursor != "" {
+		value, id, err := decodeMemberCursor(params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = bson.M{"$and": bson.A{filter, cursorCondition(sortField, sortDir, value, id)}}
+	} else {
+		// 分页
+		opts.SetSkip(GetSkipNum(params.PageNo, limit))
+	}
+
+	cursor, err := m.col.Find(c, query, opts)
 	if err != nil {
 		m.log.Error("find member error", zap.Error(err))
 		return nil, err
 	}
+	defer cursor.Close(c)
 	var members []*entities.MPMember
 	if err := cursor.All(c, &members); err != nil {
 		m.log.Error("decode member error", zap.Error(err))
 		return nil, err
 	}
-	// 统计总数
-	countOps := &options.CountOptions{
-		Skip:  &skip,
-		Limit: &limit,
+
+	pagingData := model.NewPageResult[*entities.MPMember]()
+	pagingData.Total = total
+	if len(members) > 0 {
+		pagingData.List = members
+	}
+	if int64(len(members)) == limit {
+		last := members[len(members)-1]
+		pagingData.NextCursor = encodeMemberCursor(memberSortValue(last, sortField), last.ID)
 	}
-	total, err := m.col.CountDocuments(c, filter, countOps)
+
+	return pagingData, nil
+}
+
+// FindOpenIds implements biz.MPMemberRepo.
+func (m *MPMemberData) FindOpenIds(c context.Context, appId string,
+	f *request.MPMemberFilter,
+) ([]string, error) {
+	filter := buildMemberFilter(appId, f)
+	opts := options.Find().SetProjection(bson.M{"openid": 1})
+	cursor, err := m.col.Find(c, filter, opts)
 	if err != nil {
-		m.log.Error("count member error", zap.Error(err))
 		return nil, err
 	}
+	defer cursor.Close(c)
 
-	pagingData := model.NewPageResult[*entities.MPMember]()
-	if total > 0 && len(members) > 0 {
-		pagingData.Total = total
-		pagingData.List = members
+	openids := make([]string, 0)
+	for cursor.Next(c) {
+		var doc struct {
+			OpenId string `bson:"openid"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		openids = append(openids, doc.OpenId)
 	}
+	return openids, cursor.Err()
+}
 
-	return pagingData, nil
+// Iterate implements biz.MPMemberRepo.
+//
+// 使用游标逐条读取，不会一次性加载全部数据。
+func (m *MPMemberData) Iterate(c context.Context, appId string,
+	f *request.MPMemberFilter, fn func(member *entities.MPMember) error,
+) error {
+	filter := buildMemberFilter(appId, f)
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetBatchSize(500)
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(c)
+
+	for cursor.Next(c) {
+		var member entities.MPMember
+		if err := cursor.Decode(&member); err != nil {
+			return err
+		}
+		if err := fn(&member); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// ExistingOpenIds implements biz.MPMemberRepo.
+func (m *MPMemberData) ExistingOpenIds(c context.Context, appId string,
+	openids []string,
+) ([]string, error) {
+	const batch = 1000
+	result := make([]string, 0, len(openids))
+	for i := 0; i < len(openids); i += batch {
+		end := min(i+batch, len(openids))
+		filter := bson.M{"app_id": appId, "openid": bson.M{"$in": openids[i:end]}}
+		values, err := m.col.Distinct(c, "openid", filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if openid, ok := v.(string); ok {
+				result = append(result, openid)
+			}
+		}
+	}
+	return result, nil
 }
 
 // FindById implements biz.MPMemberRepo.
 func (m *MPMemberData) FindById(c context.Context, id string) (*entities.MPMember, error) {
-	filter := bson.M{"_id": id}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id format: %v", err)
+	}
+	filter := bson.M{"_id": objectID}
 	var member entities.MPMember
-	err := m.col.FindOne(c, filter).Decode(&member)
+	err = m.col.FindOne(c, filter).Decode(&member)
 	if err != nil {
 		m.log.Error("find member by id error", zap.Error(err))
 		return nil, err
@@ -168,12 +330,124 @@ func (m *MPMemberData) FindById(c context.Context, id string) (*entities.MPMembe
 	return &member, nil
 }
 
+// FindByOpenId implements biz.MPMemberRepo.
+func (m *MPMemberData) FindByOpenId(c context.Context, appId, openid string) (*entities.MPMember, error) {
+	filter := bson.M{"app_id": appId, "openid": openid}
+	var member entities.MPMember
+	err := m.col.FindOne(c, filter).Decode(&member)
+	if err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// UpdateLastMessageAt implements biz.MPMemberRepo.
+func (m *MPMemberData) UpdateLastMessageAt(c context.Context, appId, openid string, ts int64) error {
+	filter := bson.M{"app_id": appId, "openid": openid}
+	update := bson.M{"$max": bson.M{"last_message_at": ts}}
+	_, err := m.col.UpdateOne(c, filter, update)
+	return err
+}
+
+// UpdateProvince implements biz.MPMemberRepo.
+func (m *MPMemberData) UpdateProvince(c context.Context, appId, openid, province string) error {
+	filter := bson.M{"app_id": appId, "openid": openid}
+	_, err := m.col.UpdateOne(c, filter, bson.M{"$set": bson.M{"province": province}})
+	return err
+}
+
+// recentMsgIds 每个粉丝保存最近的 MsgId 数量，用于排除微信的重试推送
+const recentMsgIds = 20
+
+// IncrMessageCount implements biz.MPMemberRepo.
+func (m *MPMemberData) IncrMessageCount(c context.Context, appId, openid string, msgId int64) error {
+	filter := bson.M{"app_id": appId, "openid": openid}
+	update := bson.M{"$inc": bson.M{"message_count": 1}}
+	if msgId != 0 {
+		filter["recent_msg_ids"] = bson.M{"$ne": msgId}
+		update["$push"] = bson.M{"recent_msg_ids": bson.M{"$each": bson.A{msgId}, "$slice": -recentMsgIds}}
+	}
+	_, err := m.col.UpdateOne(c, filter, update)
+	return err
+}
+
+// UpdateEngagement implements biz.MPMemberRepo.
+func (m *MPMemberData) UpdateEngagement(c context.Context, appId string, scores map[string]int64,
+	ts int64,
+) error {
+	if len(scores) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(scores))
+	for openid, score := range scores {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"app_id": appId, "openid": openid}).
+			SetUpdate(bson.M{"$set": bson.M{"engagement": score, "engagement_at": ts}}))
+	}
+	_, err := m.col.BulkWrite(c, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// UpdateSubscribe implements biz.MPMemberRepo.
+func (m *MPMemberData) UpdateSubscribe(c context.Context, appId, openid string, subscribe int) error {
+	filter := bson.M{"app_id": appId, "openid": openid}
+	update := bson.M{"$set": bson.M{"subscribe": subscribe, "updated_at": time.Now().Unix()}}
+	_, err := m.col.UpdateOne(c, filter, update)
+	return err
+}
+
+// UpdateAttributes implements biz.MPMemberRepo.
+func (m *MPMemberData) UpdateAttributes(c context.Context, appId string,
+	updates []*biz.MemberAttributeUpdate,
+) (int64, error) {
+	now := time.Now().Unix()
+	models := make([]mongo.WriteModel, 0, len(updates))
+	for _, item := range updates {
+		set := bson.M{"updated_at": now}
+		for key, value := range item.Set {
+			set["attributes."+key] = value
+		}
+		update := bson.M{"$set": set}
+		if len(item.Unset) > 0 {
+			unset := bson.M{}
+			for _, key := range item.Unset {
+				unset["attributes."+key] = ""
+			}
+			update["$unset"] = unset
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"app_id": appId, "openid": item.OpenId}).
+			SetUpdate(update))
+	}
+	if len(models) == 0 {
+		return 0, nil
+	}
+	result, err := m.col.BulkWrite(c, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, err
+	}
+	return result.MatchedCount, nil
+}
+
+// UnsetAttribute implements biz.MPMemberRepo.
+func (m *MPMemberData) UnsetAttribute(c context.Context, appId, key string) error {
+	filter := bson.M{"app_id": appId, "attributes." + key: bson.M{"$exists": true}}
+	update := bson.M{"$unset": bson.M{"attributes." + key: ""}}
+	_, err := m.col.UpdateMany(c, filter, update)
+	return err
+}
+
 // UpdateRemark implements biz.MPMemberRepo.
 func (m *MPMemberData) UpdateRemark(c context.Context, id string, remark string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid id format: %v", err)
+	}
 	// mongoDB update
-	filter := bson.M{"_id": id}
+	filter := bson.M{"_id": objectID}
 	update := bson.M{"$set": bson.M{"remark": remark}}
-	_, err := m.col.UpdateOne(c, filter, update)
+	_, err = m.col.UpdateOne(c, filter, update)
 	if err != nil {
 		m.log.Error("update remark error", zap.Error(err))
 		return err
@@ -183,7 +457,91 @@ func (m *MPMemberData) UpdateRemark(c context.Context, id string, remark string)
 }
 
 // NewMPMemberData returns a new MPMemberData.
-func NewMPMemberData(data *Data, log *zap.Logger) biz.MPMemberRepo {
+//
+// 唯一索引 uniq_app_openid 创建失败时返回错误，避免重复粉丝数据继续写入。
+func NewMPMemberData(data *Data, log *zap.Logger) (biz.MPMemberRepo, error) {
 	collection := data.db.Collection("mp_members")
-	return &MPMemberData{col: collection, data: data, log: log}
+	uniqIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "app_id", Value: 1}, {Key: "openid", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("uniq_app_openid"),
+	}
+	// 早期数据可能存在重复粉丝，先去重再创建唯一索引
+	err := data.Migrate("mp_members_dedupe_openid", func(ctx context.Context) error {
+		return dedupeMembers(ctx, collection, log)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := data.CreateIndexes(collection, uniqIndex); err != nil {
+		return nil, err
+	}
+	data.EnsureIndexes(collection, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "engagement", Value: -1}},
+	})
+	err = data.Migrate("mp_members_backfill_sort_fields", func(ctx context.Context) error {
+		return backfillMemberSortFields(ctx, collection, log)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MPMemberData{col: collection, data: data, log: log}, nil
+}
+
+// dedupeMembers 删除同一应用下 openid 重复的粉丝，保留最近更新的一条
+func dedupeMembers(ctx context.Context, col *mongo.Collection, log *zap.Logger) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "updated_at", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"app_id": "$app_id", "openid": "$openid"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cursor, err := col.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var removed int64
+	for cursor.Next(ctx) {
+		var group struct {
+			Ids []primitive.ObjectID `bson:"ids"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return err
+		}
+		res, err := col.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": group.Ids[1:]}})
+		if err != nil {
+			return err
+		}
+		removed += res.DeletedCount
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if removed > 0 {
+		log.Info("duplicate members removed", zap.Int64("count", removed))
+	}
+	return nil
+}
+
+// backfillMemberSortFields 为早期导入、缺少排序字段的粉丝补充默认值 0
+//
+// 游标分页使用 $lt/$gt 比较排序字段，字段缺失的文档不会被匹配，会从分页结果中丢失。
+// 新写入的粉丝都包含排序字段，只需执行一次。
+func backfillMemberSortFields(ctx context.Context, col *mongo.Collection, log *zap.Logger) error {
+	for field := range memberSortFields {
+		filter := bson.M{field: bson.M{"$exists": false}}
+		res, err := col.UpdateMany(ctx, filter, bson.M{"$set": bson.M{field: int64(0)}})
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount > 0 {
+			log.Info("member field backfilled", zap.String("field", field),
+				zap.Int64("count", res.ModifiedCount))
+		}
+	}
+	return nil
 }
@@ -0,0 +1,104 @@
+package data
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type MemberAttributeData struct {
+	col  *mongo.Collection
+	data *Data
+	log  *zap.Logger
+}
+
+// Create implements biz.MemberAttributeRepo.
+func (m *MemberAttributeData) Create(c context.Context, def *entities.MemberAttributeDef) (string, error) {
+	result, err := m.col.InsertOne(c, def)
+	if err != nil {
+		return "", err
+	}
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		return oid.Hex(), nil
+	}
+	return "", fmt.Errorf("failed to get inserted id")
+}
+
+// Update implements biz.MemberAttributeRepo.
+func (m *MemberAttributeData) Update(c context.Context, appId, id string, def *entities.MemberAttributeDef) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid id format: %v", err)
+	}
+	filter := bson.M{"_id": objectID, "app_id": appId}
+	update := bson.M{"$set": bson.M{
+		"name":       def.Name,
+		"options":    def.Options,
+		"required":   def.Required,
+		"updated_at": def.UpdatedAt,
+	}}
+	result, err := m.col.UpdateOne(c, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Delete implements biz.MemberAttributeRepo.
+func (m *MemberAttributeData) Delete(c context.Context, appId, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid id format: %v", err)
+	}
+	_, err = m.col.DeleteOne(c, bson.M{"_id": objectID, "app_id": appId})
+	return err
+}
+
+// Get implements biz.MemberAttributeRepo.
+func (m *MemberAttributeData) Get(c context.Context, appId, id string) (*entities.MemberAttributeDef, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id format: %v", err)
+	}
+	var def entities.MemberAttributeDef
+	if err := m.col.FindOne(c, bson.M{"_id": objectID, "app_id": appId}).Decode(&def); err != nil {
+		return nil, err
+	}
+	return &def, nil
+}
+
+// Query implements biz.MemberAttributeRepo.
+func (m *MemberAttributeData) Query(c context.Context, appId string) ([]*entities.MemberAttributeDef, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	cursor, err := m.col.Find(c, bson.M{"app_id": appId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	defs := make([]*entities.MemberAttributeDef, 0)
+	if err := cursor.All(c, &defs); err != nil {
+		return nil, err
+	}
+	return defs, nil
+}
+
+// NewMemberAttributeData creates a new MemberAttributeData.
+func NewMemberAttributeData(data *Data, log *zap.Logger) biz.MemberAttributeRepo {
+	collection := data.db.Collection("member_attribute_defs")
+	data.EnsureIndexes(collection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "app_id", Value: 1}, {Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return &MemberAttributeData{col: collection, data: data, log: log}
+}
@@ -0,0 +1,283 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// MemberErasureData 删除粉丝数据，涉及所有保存了 openid 的集合
+type MemberErasureData struct {
+	col  *mongo.Collection // 审计记录
+	data *Data
+	log  *zap.Logger
+}
+
+func (m *MemberErasureData) collection(name string) *mongo.Collection {
+	return m.data.db.Collection(name)
+}
+
+// targetsFilter openid 字段名为 field 的查询条件
+func targetsFilter(targets []*biz.ErasureTarget, field string) bson.M {
+	conds := make(bson.A, 0, len(targets))
+	for _, target := range targets {
+		conds = append(conds, bson.M{"app_id": target.AppId, field: target.OpenId})
+	}
+	return bson.M{"$or": conds}
+}
+
+func identityFilter(targets []*biz.ErasureTarget) bson.M {
+	conds := make(bson.A, 0, len(targets))
+	for _, target := range targets {
+		conds = append(conds, bson.M{"accounts": bson.M{"$elemMatch": bson.M{
+			"app_id": target.AppId, "openid": target.OpenId,
+		}}})
+	}
+	return bson.M{"$or": conds}
+}
+
+// UnionIdTargets implements biz.MemberErasureRepo.
+func (m *MemberErasureData) UnionIdTargets(c context.Context, appId, unionId string,
+) ([]*biz.ErasureTarget, error) {
+	filter := bson.M{"app_id": appId, "union_id": unionId}
+	opts := options.Find().SetProjection(bson.M{"openid": 1})
+	cursor, err := m.collection("mp_members").Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	targets := make([]*biz.ErasureTarget, 0)
+	for cursor.Next(c) {
+		var member entities.MPMember
+		if err := cursor.Decode(&member); err != nil {
+			return nil, err
+		}
+		targets = append(targets, &biz.ErasureTarget{AppId: appId, OpenId: member.OpenId})
+	}
+	return targets, cursor.Err()
+}
+
+// Affected implements biz.MemberErasureRepo.
+func (m *MemberErasureData) Affected(c context.Context, targets []*biz.ErasureTarget, mode string,
+) ([]*entities.ErasureItem, error) {
+	steps := []struct {
+		collection string
+		action     string
+		filter     bson.M
+	}{
+		{"mp_members", mode, targetsFilter(targets, "openid")},
+		{"member_activities", biz.ErasureActionDelete, targetsFilter(targets, "openid")},
+		{"mp_blacklists", biz.ErasureActionDelete, targetsFilter(targets, "openid")},
+		{"mp_blacklist_histories", mode, targetsFilter(targets, "openid")},
+		{"member_tagging_jobs", biz.ErasureActionDelete, targetsFilter(targets, "failures.openid")},
+		{"member_identities", biz.ErasureActionUnlink, identityFilter(targets)},
+	}
+	items := make([]*entities.ErasureItem, 0, len(steps))
+	for _, step := range steps {
+		count, err := m.collection(step.collection).CountDocuments(c, step.filter)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &entities.ErasureItem{
+			Collection: step.collection,
+			Action:     step.action,
+			Count:      count,
+		})
+	}
+	return items, nil
+}
+
+// Erase implements biz.MemberErasureRepo.
+func (m *MemberErasureData) Erase(c context.Context, targets []*biz.ErasureTarget, mode string,
+) ([]*entities.ErasureItem, error) {
+	items := make([]*entities.ErasureItem, 0, 6)
+	add := func(collection, action string, count int64) {
+		items = append(items, &entities.ErasureItem{Collection: collection, Action: action, Count: count})
+	}
+
+	// 粉丝
+	if mode == biz.ErasureAnonymize {
+		count, err := m.anonymizeMembers(c, targets)
+		if err != nil {
+			return items, err
+		}
+		add("mp_members", biz.ErasureActionAnonymize, count)
+	} else {
+		result, err := m.collection("mp_members").DeleteMany(c, targetsFilter(targets, "openid"))
+		if err != nil {
+			return items, err
+		}
+		add("mp_members", biz.ErasureActionDelete, result.DeletedCount)
+	}
+
+	// 动态、黑名单
+	for _, name := range []string{"member_activities", "mp_blacklists"} {
+		result, err := m.collection(name).DeleteMany(c, targetsFilter(targets, "openid"))
+		if err != nil {
+			return items, err
+		}
+		add(name, biz.ErasureActionDelete, result.DeletedCount)
+	}
+
+	// 黑名单记录
+	histories := m.collection("mp_blacklist_histories")
+	if mode == biz.ErasureAnonymize {
+		var count int64
+		for _, target := range targets {
+			filter := bson.M{"app_id": target.AppId, "openid": target.OpenId}
+			update := bson.M{"$set": bson.M{"openid": target.Pseudonym}}
+			result, err := histories.UpdateMany(c, filter, update)
+			if err != nil {
+				return items, err
+			}
+			count += result.ModifiedCount
+		}
+		add("mp_blacklist_histories", biz.ErasureActionAnonymize, count)
+	} else {
+		result, err := histories.DeleteMany(c, targetsFilter(targets, "openid"))
+		if err != nil {
+			return items, err
+		}
+		add("mp_blacklist_histories", biz.ErasureActionDelete, result.DeletedCount)
+	}
+
+	// 批量打标签失败记录
+	var jobCount int64
+	for _, target := range targets {
+		filter := bson.M{"app_id": target.AppId, "failures.openid": target.OpenId}
+		update := bson.M{"$pull": bson.M{"failures": bson.M{"openid": target.OpenId}}}
+		result, err := m.collection("member_tagging_jobs").UpdateMany(c, filter, update)
+		if err != nil {
+			return items, err
+		}
+		jobCount += result.ModifiedCount
+	}
+	add("member_tagging_jobs", biz.ErasureActionDelete, jobCount)
+
+	// 跨公众号身份，没有关联粉丝的身份一并删除
+	identities := m.collection("member_identities")
+	var identityCount int64
+	for _, target := range targets {
+		account := bson.M{"app_id": target.AppId, "openid": target.OpenId}
+		filter := bson.M{"accounts": bson.M{"$elemMatch": account}}
+		result, err := identities.UpdateMany(c, filter, bson.M{"$pull": bson.M{"accounts": account}})
+		if err != nil {
+			return items, err
+		}
+		identityCount += result.ModifiedCount
+	}
+	if _, err := identities.DeleteMany(c, bson.M{"accounts": bson.M{"$size": 0}}); err != nil {
+		return items, err
+	}
+	add("member_identities", biz.ErasureActionUnlink, identityCount)
+
+	return items, nil
+}
+
+// anonymizeMembers 清除个人信息，openid 替换为假名
+func (m *MemberErasureData) anonymizeMembers(c context.Context, targets []*biz.ErasureTarget,
+) (int64, error) {
+	col := m.collection("mp_members")
+	now := time.Now().Unix()
+	var count int64
+	for _, target := range targets {
+		pseudonym := target.Pseudonym
+		// 再次匿名化时，用新记录替换之前匿名化的记录
+		filter := bson.M{"app_id": target.AppId, "openid": target.OpenId}
+		if n, err := col.CountDocuments(c, filter); err != nil || n == 0 {
+			if err != nil {
+				return count, err
+			}
+			continue
+		}
+		if _, err := col.DeleteOne(c, bson.M{"app_id": target.AppId, "openid": pseudonym}); err != nil {
+			return count, err
+		}
+		update := bson.M{
+			"$set": bson.M{
+				"openid":       pseudonym,
+				"nick_name":    "",
+				"sex":          0,
+				"city":         "",
+				"province":     "",
+				"country":      "",
+				"union_id":     "",
+				"remark":       "",
+				"qr_scene_str": "",
+				"erased_at":    now,
+				"updated_at":   now,
+			},
+			"$unset": bson.M{"attributes": ""},
+		}
+		result, err := col.UpdateOne(c, filter, update)
+		if err != nil {
+			return count, err
+		}
+		count += result.ModifiedCount
+	}
+	return count, nil
+}
+
+// SaveAudit implements biz.MemberErasureRepo.
+func (m *MemberErasureData) SaveAudit(c context.Context, audit *entities.MemberErasure) (string, error) {
+	result, err := m.col.InsertOne(c, audit)
+	if err != nil {
+		return "", err
+	}
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		return oid.Hex(), nil
+	}
+	return "", fmt.Errorf("failed to get inserted id")
+}
+
+// QueryAudits implements biz.MemberErasureRepo.
+func (m *MemberErasureData) QueryAudits(c context.Context, appId string,
+	params *request.PagingQuery,
+) (*model.PageResult[*entities.MemberErasure], error) {
+	filter := bson.M{"app_id": appId}
+	total, err := m.col.CountDocuments(c, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := params.PageSize
+	if limit <= 0 {
+		limit = 20
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(GetSkipNum(params.PageNo, limit)).
+		SetLimit(limit)
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	pagingData := model.NewPageResult[*entities.MemberErasure]()
+	pagingData.Total = total
+	if err := cursor.All(c, &pagingData.List); err != nil {
+		return nil, err
+	}
+	return pagingData, nil
+}
+
+// NewMemberErasureData creates a new MemberErasureData.
+func NewMemberErasureData(data *Data, log *zap.Logger) biz.MemberErasureRepo {
+	collection := data.db.Collection("member_erasures")
+	data.EnsureIndexes(collection, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	return &MemberErasureData{col: collection, data: data, log: log}
+}
@@ -0,0 +1,247 @@
+package data
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memberSortFields 允许排序的字段
+var memberSortFields = map[string]string{
+	"subscribe_time":  "subscribe_time",
+	"created_at":      "created_at",
+	"updated_at":      "updated_at",
+	"last_message_at": "last_message_at",
+	"message_count":   "message_count",
+	"engagement":      "engagement",
+}
+
+// buildMemberFilter 根据筛选条件生成粉丝查询条件
+func buildMemberFilter(appId string, f *request.MPMemberFilter) bson.M {
+	filter := bson.M{"app_id": appId}
+	if f == nil {
+		filter["blocked"] = false
+		return filter
+	}
+
+	// 黑名单，默认过滤掉被封禁的用户
+	switch f.Blocked {
+	case "all":
+	case "true":
+		filter["blocked"] = true
+	default:
+		filter["blocked"] = false
+	}
+
+	// 标签
+	conds := bson.A{}
+	// 复制一份，避免 append 写入调用方的数组
+	tagIds := append([]int64(nil), f.TagIds...)
+	if f.TagId > 0 {
+		tagIds = append(tagIds, f.TagId)
+	}
+	if len(tagIds) > 0 {
+		if f.TagMatch == "and" {
+			conds = append(conds, bson.M{"tags.tag_id": bson.M{"$all": tagIds}})
+		} else {
+			conds = append(conds, bson.M{"tags.tag_id": bson.M{"$in": tagIds}})
+		}
+	}
+	if len(f.ExcludeTagIds) > 0 {
+		conds = append(conds, bson.M{"tags.tag_id": bson.M{"$nin": f.ExcludeTagIds}})
+	}
+	for _, attr := range f.Attrs {
+		conds = append(conds, attributeCondition(attr))
+	}
+
+	if f.Remark != "" {
+		filter["remark"] = containsRegex(f.Remark)
+	}
+	if f.NickName != "" {
+		filter["nick_name"] = containsRegex(f.NickName)
+	}
+	if f.Language != "" {
+		filter["language"] = f.Language
+	}
+	if len(f.SubscribeScenes) > 0 {
+		filter["subscribe_scene"] = bson.M{"$in": f.SubscribeScenes}
+	}
+	if f.QrScene > 0 {
+		filter["qr_scene"] = f.QrScene
+	}
+	if f.QrSceneStr != "" {
+		filter["qr_scene_str"] = f.QrSceneStr
+	}
+	if f.Province != "" {
+		// 保存的是省份简称
+		province := biz.ParseProvince(f.Province)
+		if province == "" {
+			province = f.Province
+		}
+		filter["province"] = province
+	}
+	if r := timeRange(f.SubscribeTimeFrom, f.SubscribeTimeTo); r != nil {
+		filter["subscribe_time"] = r
+	}
+	if r := timeRange(f.LastMessageFrom, f.LastMessageTo); r != nil {
+		if f.LastMessageFrom > 0 {
+			filter["last_message_at"] = r
+		} else {
+			// 没有开始时间时包括从未互动、没有 last_message_at 的粉丝
+			conds = append(conds, bson.M{"$or": bson.A{
+				bson.M{"last_message_at": r},
+				bson.M{"last_message_at": nil},
+			}})
+		}
+	}
+	if f.EngagementMin != nil || f.EngagementMax != nil {
+		r := bson.M{}
+		if f.EngagementMin != nil {
+			r["$gte"] = *f.EngagementMin
+		}
+		if f.EngagementMax != nil {
+			r["$lte"] = *f.EngagementMax
+		}
+		filter["engagement"] = r
+	}
+
+	if len(conds) > 0 {
+		filter["$and"] = conds
+	}
+	return filter
+}
+
+// attributeCondition 解析自定义属性条件 key:op:value
+//
+// 数值类型的属性保存为数字，值可以解析为数字时同时匹配数字和字符串。
+// 条件应在 biz 层校验，无效条件不匹配任何粉丝，不会被忽略而扩大筛选范围。
+func attributeCondition(s string) bson.M {
+	key, op, value, err := biz.ParseAttributeCondition(s)
+	if err != nil {
+		return matchNone
+	}
+	field := "attributes." + key
+	number, err := strconv.ParseFloat(value, 64)
+	isNumber := err == nil
+
+	switch op {
+	case "eq":
+		if isNumber {
+			return bson.M{field: bson.M{"$in": bson.A{value, number}}}
+		}
+		return bson.M{field: value}
+	case "ne":
+		if isNumber {
+			return bson.M{field: bson.M{"$nin": bson.A{value, number}}}
+		}
+		return bson.M{field: bson.M{"$ne": value}}
+	case "gt", "gte", "lt", "lte":
+		// 日期为 2006-01-02 格式的字符串，可以直接比较
+		if isNumber {
+			return bson.M{field: bson.M{"$" + op: number}}
+		}
+		return bson.M{field: bson.M{"$" + op: value}}
+	case "contains":
+		return bson.M{field: containsRegex(value)}
+	}
+	return matchNone
+}
+
+// matchNone 不匹配任何文档的条件
+var matchNone = bson.M{"_id": bson.M{"$exists": false}}
+
+func containsRegex(s string) primitive.Regex {
+	return primitive.Regex{Pattern: regexp.QuoteMeta(s), Options: "i"}
+}
+
+func timeRange(from, to int64) bson.M {
+	if from <= 0 && to <= 0 {
+		return nil
+	}
+	r := bson.M{}
+	if from > 0 {
+		r["$gte"] = from
+	}
+	if to > 0 {
+		r["$lte"] = to
+	}
+	return r
+}
+
+// memberSort 返回排序字段和方向，默认按关注时间倒序
+func memberSort(params *request.MPMemberQuery) (string, int) {
+	field, ok := memberSortFields[params.SortBy]
+	if !ok {
+		field = "subscribe_time"
+	}
+	if params.SortOrder == "asc" {
+		return field, 1
+	}
+	return field, -1
+}
+
+// encodeMemberCursor 游标: 排序字段值 + _id
+func encodeMemberCursor(value int64, id primitive.ObjectID) string {
+	raw := fmt.Sprintf("%d:%s", value, id.Hex())
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeMemberCursor(cursor string) (int64, primitive.ObjectID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, primitive.NilObjectID, fmt.Errorf("invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return 0, primitive.NilObjectID, fmt.Errorf("invalid cursor")
+	}
+	value, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, primitive.NilObjectID, fmt.Errorf("invalid cursor")
+	}
+	id, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return 0, primitive.NilObjectID, fmt.Errorf("invalid cursor")
+	}
+	return value, id, nil
+}
+
+// cursorCondition 游标之后的数据
+//
+// 排序字段缺失的文档不会匹配比较条件，创建集合时由 backfillMemberSortFields 补齐。
+func cursorCondition(field string, dir int, value int64, id primitive.ObjectID) bson.M {
+	op := "$lt"
+	if dir > 0 {
+		op = "$gt"
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{field: bson.M{op: value}},
+		bson.M{field: value, "_id": bson.M{op: id}},
+	}}
+}
+
+// memberSortValue 排序字段的值，用于生成游标
+func memberSortValue(m *entities.MPMember, field string) int64 {
+	switch field {
+	case "created_at":
+		return m.CreatedAt
+	case "updated_at":
+		return m.UpdatedAt
+	case "last_message_at":
+		return m.LastMessageAt
+	case "message_count":
+		return m.MessageCount
+	case "engagement":
+		return m.Engagement
+	default:
+		return m.SubscribeTime
+	}
+}
@@ -0,0 +1,91 @@
+package data
+
+import (
+	"context"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type MemberIdentityData struct {
+	col  *mongo.Collection
+	data *Data
+	log  *zap.Logger
+}
+
+// Link implements biz.MemberIdentityRepo.
+//
+// 先移除该应用原有的关联再添加，同一应用只保留一个openid。
+func (m *MemberIdentityData) Link(c context.Context, userId string, members []*entities.MPMember,
+	ts int64,
+) error {
+	models := make([]mongo.WriteModel, 0, len(members)*2)
+	for _, member := range members {
+		if member.UnionId == "" {
+			continue
+		}
+		filter := bson.M{"user_id": userId, "union_id": member.UnionId}
+		models = append(models,
+			mongo.NewUpdateOneModel().
+				SetFilter(filter).
+				SetUpdate(bson.M{"$pull": bson.M{"accounts": bson.M{"app_id": member.AppId}}}),
+			mongo.NewUpdateOneModel().
+				SetFilter(filter).
+				SetUpdate(bson.M{
+					"$push": bson.M{"accounts": &entities.IdentityAccount{
+						AppId:    member.AppId,
+						MpId:     member.MpId,
+						OpenId:   member.OpenId,
+						LinkedAt: ts,
+					}},
+					"$set":         bson.M{"updated_at": ts},
+					"$setOnInsert": bson.M{"created_at": ts},
+				}).
+				SetUpsert(true),
+		)
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	_, err := m.col.BulkWrite(c, models, options.BulkWrite().SetOrdered(true))
+	return err
+}
+
+// GetByUnionId implements biz.MemberIdentityRepo.
+func (m *MemberIdentityData) GetByUnionId(c context.Context, userId, unionId string,
+) (*entities.MemberIdentity, error) {
+	return m.findOne(c, bson.M{"user_id": userId, "union_id": unionId})
+}
+
+// GetByOpenId implements biz.MemberIdentityRepo.
+func (m *MemberIdentityData) GetByOpenId(c context.Context, userId, openid string,
+) (*entities.MemberIdentity, error) {
+	return m.findOne(c, bson.M{"user_id": userId, "accounts.openid": openid})
+}
+
+func (m *MemberIdentityData) findOne(c context.Context, filter bson.M) (*entities.MemberIdentity, error) {
+	var identity entities.MemberIdentity
+	if err := m.col.FindOne(c, filter).Decode(&identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// NewMemberIdentityData creates a new MemberIdentityData.
+func NewMemberIdentityData(data *Data, log *zap.Logger) biz.MemberIdentityRepo {
+	collection := data.db.Collection("member_identities")
+	data.EnsureIndexes(collection,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "union_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("uniq_user_unionid"),
+		},
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "accounts.openid", Value: 1}},
+		},
+	)
+	return &MemberIdentityData{col: collection, data: data, log: log}
+}
@@ -0,0 +1,103 @@
+package data
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type MemberSegmentData struct {
+	col  *mongo.Collection
+	data *Data
+	log  *zap.Logger
+}
+
+// Create implements biz.MemberSegmentRepo.
+func (m *MemberSegmentData) Create(c context.Context, segment *entities.MemberSegment) (string, error) {
+	result, err := m.col.InsertOne(c, segment)
+	if err != nil {
+		return "", err
+	}
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		return oid.Hex(), nil
+	}
+	return "", fmt.Errorf("failed to get inserted id")
+}
+
+// Update implements biz.MemberSegmentRepo.
+func (m *MemberSegmentData) Update(c context.Context, appId, id string,
+	segment *entities.MemberSegment,
+) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid id format: %v", err)
+	}
+	filter := bson.M{"_id": objectID, "app_id": appId}
+	update := bson.M{"$set": bson.M{
+		"name":        segment.Name,
+		"description": segment.Description,
+		"filter":      segment.Filter,
+		"updated_at":  segment.UpdatedAt,
+	}}
+	result, err := m.col.UpdateOne(c, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Delete implements biz.MemberSegmentRepo.
+func (m *MemberSegmentData) Delete(c context.Context, appId, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid id format: %v", err)
+	}
+	_, err = m.col.DeleteOne(c, bson.M{"_id": objectID, "app_id": appId})
+	return err
+}
+
+// Get implements biz.MemberSegmentRepo.
+func (m *MemberSegmentData) Get(c context.Context, appId, id string) (*entities.MemberSegment, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id format: %v", err)
+	}
+	var segment entities.MemberSegment
+	err = m.col.FindOne(c, bson.M{"_id": objectID, "app_id": appId}).Decode(&segment)
+	if err != nil {
+		return nil, err
+	}
+	return &segment, nil
+}
+
+// Query implements biz.MemberSegmentRepo.
+func (m *MemberSegmentData) Query(c context.Context, appId string) ([]*entities.MemberSegment, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := m.col.Find(c, bson.M{"app_id": appId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	segments := make([]*entities.MemberSegment, 0)
+	if err := cursor.All(c, &segments); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+// NewMemberSegmentData creates a new MemberSegmentData.
+func NewMemberSegmentData(data *Data, log *zap.Logger) biz.MemberSegmentRepo {
+	collection := data.db.Collection("member_segments")
+	return &MemberSegmentData{col: collection, data: data, log: log}
+}
@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type TagRuleData struct {
+	col  *mongo.Collection
+	data *Data
+	log  *zap.Logger
+}
+
+// Create implements biz.TagRuleRepo.
+func (m *TagRuleData) Create(c context.Context, rule *entities.TagRule) (string, error) {
+	result, err := m.col.InsertOne(c, rule)
+	if err != nil {
+		return "", err
+	}
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		return oid.Hex(), nil
+	}
+	return "", fmt.Errorf("failed to get inserted id")
+}
+
+// Update implements biz.TagRuleRepo.
+func (m *TagRuleData) Update(c context.Context, appId, id string, rule *entities.TagRule) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid id format: %v", err)
+	}
+	filter := bson.M{"_id": objectID, "app_id": appId}
+	update := bson.M{"$set": bson.M{
+		"name":       rule.Name,
+		"tag_id":     rule.TagId,
+		"type":       rule.Type,
+		"value":      rule.Value,
+		"days":       rule.Days,
+		"action":     rule.Action,
+		"enabled":    rule.Enabled,
+		"updated_at": rule.UpdatedAt,
+	}}
+	result, err := m.col.UpdateOne(c, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Delete implements biz.TagRuleRepo.
+func (m *TagRuleData) Delete(c context.Context, appId, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid id format: %v", err)
+	}
+	_, err = m.col.DeleteOne(c, bson.M{"_id": objectID, "app_id": appId})
+	return err
+}
+
+// Query implements biz.TagRuleRepo.
+func (m *TagRuleData) Query(c context.Context, appId string) ([]*entities.TagRule, error) {
+	return m.find(c, bson.M{"app_id": appId})
+}
+
+// QueryEnabled implements biz.TagRuleRepo.
+func (m *TagRuleData) QueryEnabled(c context.Context, appId string) ([]*entities.TagRule, error) {
+	return m.find(c, bson.M{"app_id": appId, "enabled": true})
+}
+
+func (m *TagRuleData) find(c context.Context, filter bson.M) ([]*entities.TagRule, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	rules := make([]*entities.TagRule, 0)
+	if err := cursor.All(c, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// UpdateSweptAt implements biz.TagRuleRepo.
+func (m *TagRuleData) UpdateSweptAt(c context.Context, id string, ts int64) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid id format: %v", err)
+	}
+	_, err = m.col.UpdateByID(c, objectID, bson.M{"$set": bson.M{"last_swept_at": ts}})
+	return err
+}
+
+// NewTagRuleData creates a new TagRuleData.
+func NewTagRuleData(data *Data, log *zap.Logger) biz.TagRuleRepo {
+	collection := data.db.Collection("member_tag_rules")
+	data.EnsureIndexes(collection, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "enabled", Value: 1}},
+	})
+	return &TagRuleData{col: collection, data: data, log: log}
+}
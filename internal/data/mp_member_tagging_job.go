@@ -0,0 +1,115 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type TaggingJobData struct {
+	col  *mongo.Collection
+	data *Data
+	log  *zap.Logger
+}
+
+// Create implements biz.TaggingJobRepo.
+func (m *TaggingJobData) Create(c context.Context, job *entities.TaggingJob) (string, error) {
+	result, err := m.col.InsertOne(c, job)
+	if err != nil {
+		return "", err
+	}
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		return oid.Hex(), nil
+	}
+	return "", fmt.Errorf("failed to get inserted id")
+}
+
+// Update implements biz.TaggingJobRepo.
+func (m *TaggingJobData) Update(c context.Context, job *entities.TaggingJob,
+	failures []*entities.TaggingFailure,
+) error {
+	update := bson.M{"$set": bson.M{
+		"status":      job.Status,
+		"processed":   job.Processed,
+		"succeeded":   job.Succeeded,
+		"failed":      job.Failed,
+		"error":       job.Error,
+		"started_at":  job.StartedAt,
+		"finished_at": job.FinishedAt,
+		"updated_at":  job.UpdatedAt,
+	}}
+	// 只追加新增的失败明细，不重写整个数组
+	if len(failures) > 0 {
+		update["$push"] = bson.M{"failures": bson.M{"$each": failures}}
+	}
+	_, err := m.col.UpdateByID(c, job.ID, update)
+	return err
+}
+
+// Interrupt implements biz.TaggingJobRepo.
+func (m *TaggingJobData) Interrupt(c context.Context, before int64, reason string) (int64, error) {
+	filter := bson.M{
+		"status":     bson.M{"$in": bson.A{biz.JobStatusPending, biz.JobStatusRunning}},
+		"updated_at": bson.M{"$lt": before},
+	}
+	now := time.Now().Unix()
+	update := bson.M{"$set": bson.M{
+		"status":      biz.JobStatusFailed,
+		"error":       reason,
+		"finished_at": now,
+		"updated_at":  now,
+	}}
+	result, err := m.col.UpdateMany(c, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// Get implements biz.TaggingJobRepo.
+func (m *TaggingJobData) Get(c context.Context, appId, id string) (*entities.TaggingJob, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id format: %v", err)
+	}
+	var job entities.TaggingJob
+	err = m.col.FindOne(c, bson.M{"_id": objectID, "app_id": appId}).Decode(&job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Query implements biz.TaggingJobRepo.
+func (m *TaggingJobData) Query(c context.Context, appId string) ([]*entities.TaggingJob, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).
+		SetProjection(bson.M{"failures": 0}).SetLimit(100)
+	cursor, err := m.col.Find(c, bson.M{"app_id": appId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	jobs := make([]*entities.TaggingJob, 0)
+	if err := cursor.All(c, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// NewTaggingJobData creates a new TaggingJobData.
+func NewTaggingJobData(data *Data, log *zap.Logger) biz.TaggingJobRepo {
+	collection := data.db.Collection("member_tagging_jobs")
+	data.EnsureIndexes(collection, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}},
+	})
+	return &TaggingJobData{col: collection, data: data, log: log}
+}
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type MemberTimelineData struct {
+	col  *mongo.Collection
+	data *Data
+	log  *zap.Logger
+}
+
+// Add implements biz.MemberTimelineRepo.
+func (m *MemberTimelineData) Add(c context.Context, activities []*entities.MemberActivity) error {
+	if len(activities) == 0 {
+		return nil
+	}
+	docs := make([]any, 0, len(activities))
+	for _, activity := range activities {
+		docs = append(docs, activity)
+	}
+	_, err := m.col.InsertMany(c, docs, options.InsertMany().SetOrdered(false))
+	// 重复推送的消息已记录，其余动态仍会写入
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// Query implements biz.MemberTimelineRepo.
+func (m *MemberTimelineData) Query(c context.Context, appId, openid string,
+	params *request.MemberTimelineQuery,
+) (*model.PageResult[*entities.MemberActivity], error) {
+	filter := bson.M{"app_id": appId, "openid": openid}
+	if len(params.Types) > 0 {
+		filter["type"] = bson.M{"$in": params.Types}
+	}
+	if r := timeRange(params.From, params.To); r != nil {
+		filter["created_at"] = r
+	}
+	total, err := m.col.CountDocuments(c, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := params.PageSize
+	if limit <= 0 {
+		limit = 20
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(GetSkipNum(params.PageNo, limit)).
+		SetLimit(limit)
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	pagingData := model.NewPageResult[*entities.MemberActivity]()
+	pagingData.Total = total
+	if err := cursor.All(c, &pagingData.List); err != nil {
+		return nil, err
+	}
+	return pagingData, nil
+}
+
+// CountByMember implements biz.MemberTimelineRepo.
+func (m *MemberTimelineData) CountByMember(c context.Context, appId string, types []string,
+	since int64,
+) (map[string]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"app_id":     appId,
+			"type":       bson.M{"$in": types},
+			"created_at": bson.M{"$gte": since},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$openid", "count": bson.M{"$sum": 1}}}},
+	}
+	cursor, err := m.col.Aggregate(c, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	counts := make(map[string]int64)
+	for cursor.Next(c) {
+		var item struct {
+			OpenId string `bson:"_id"`
+			Count  int64  `bson:"count"`
+		}
+		if err := cursor.Decode(&item); err != nil {
+			return nil, err
+		}
+		counts[item.OpenId] = item.Count
+	}
+	return counts, cursor.Err()
+}
+
+// NewMemberTimelineData creates a new MemberTimelineData.
+func NewMemberTimelineData(data *Data, log *zap.Logger) biz.MemberTimelineRepo {
+	collection := data.db.Collection("member_activities")
+	data.EnsureIndexes(collection, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "openid", Value: 1}, {Key: "created_at", Value: -1}},
+	}, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "created_at", Value: -1}},
+	}, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "msg_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("uniq_app_msgid").
+			SetPartialFilterExpression(bson.M{"msg_id": bson.M{"$exists": true}}),
+	})
+	return &MemberTimelineData{col: collection, data: data, log: log}
+}
@@ -5,7 +5,9 @@ import (
 
 	"github.com/seth16888/wxbusiness/internal/biz"
 	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 )
 
@@ -16,11 +18,13 @@ type MPMenuData struct {
 }
 
 // DeleteMenu implements biz.MenuRepo.
+//
+// 每次保存菜单都会新增一条记录，删除全部记录
 func (m *MPMenuData) DeleteMenu(ctx context.Context, pId string) error {
 	filter := map[string]interface{}{
 		"app_id": pId,
 	}
-	_, err := m.col.DeleteOne(ctx, filter)
+	_, err := m.col.DeleteMany(ctx, filter)
 	return err
 }
 
@@ -40,6 +44,20 @@ func (m *MPMenuData) SaveMenu(ctx context.Context, apiMenu *entities.MPMenu) err
 	return err
 }
 
+// GetLatestMenu implements biz.MenuRepo.
+func (m *MPMenuData) GetLatestMenu(ctx context.Context, appId string) (*entities.MPMenu, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})
+	var menu entities.MPMenu
+	err := m.col.FindOne(ctx, bson.M{"app_id": appId}, opts).Decode(&menu)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &menu, nil
+}
+
 // NewMPMenuData creates a new MPMenuData
 func NewMPMenuData(log *zap.Logger, data *Data) biz.MenuRepo {
 	return &MPMenuData{
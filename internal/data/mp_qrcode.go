@@ -0,0 +1,263 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type QRCodeData struct {
+	col      *mongo.Collection
+	batchCol *mongo.Collection
+	data     *Data
+	log      *zap.Logger
+}
+
+// Create implements biz.QRCodeRepo.
+func (m *QRCodeData) Create(c context.Context, code *entities.QRCode) error {
+	result, err := m.col.InsertOne(c, code)
+	if err != nil {
+		return err
+	}
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		code.ID = oid
+	}
+	return nil
+}
+
+// Get implements biz.QRCodeRepo.
+func (m *QRCodeData) Get(c context.Context, appId string, id string) (*entities.QRCode, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id format: %v", err)
+	}
+	var code entities.QRCode
+	if err := m.col.FindOne(c, bson.M{"_id": objectID, "app_id": appId}).Decode(&code); err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+// Query implements biz.QRCodeRepo.
+func (m *QRCodeData) Query(c context.Context, appId string,
+	params *request.QRCodeQuery,
+) (*model.PageResult[*entities.QRCode], error) {
+	filter := bson.M{"app_id": appId}
+	if params.BatchId != "" {
+		filter["batch_id"] = params.BatchId
+	}
+	if params.Owner != "" {
+		filter["owner"] = params.Owner
+	}
+	if params.Permanent != nil {
+		filter["permanent"] = *params.Permanent
+	}
+	if params.Keyword != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(params.Keyword), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"scene": pattern}, bson.M{"label": pattern}}
+	}
+	total, err := m.col.CountDocuments(c, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := params.PageSize
+	if limit <= 0 {
+		limit = 20
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(GetSkipNum(params.PageNo, limit)).
+		SetLimit(limit)
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	pagingData := model.NewPageResult[*entities.QRCode]()
+	pagingData.Total = total
+	if err := cursor.All(c, &pagingData.List); err != nil {
+		return nil, err
+	}
+	return pagingData, nil
+}
+
+// FindByBatch implements biz.QRCodeRepo.
+func (m *QRCodeData) FindByBatch(c context.Context, appId string, batchId string,
+) ([]*entities.QRCode, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := m.col.Find(c, bson.M{"app_id": appId, "batch_id": batchId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	codes := make([]*entities.QRCode, 0)
+	if err := cursor.All(c, &codes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CreateBatch implements biz.QRCodeRepo.
+func (m *QRCodeData) CreateBatch(c context.Context, batch *entities.QRCodeBatch) error {
+	result, err := m.batchCol.InsertOne(c, batch)
+	if err != nil {
+		return err
+	}
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		batch.ID = oid
+	}
+	return nil
+}
+
+// UpdateBatch implements biz.QRCodeRepo.
+func (m *QRCodeData) UpdateBatch(c context.Context, batch *entities.QRCodeBatch) error {
+	update := bson.M{"$set": bson.M{
+		"status":      batch.Status,
+		"processed":   batch.Processed,
+		"succeeded":   batch.Succeeded,
+		"failed":      batch.Failed,
+		"failures":    batch.Failures,
+		"error":       batch.Error,
+		"started_at":  batch.StartedAt,
+		"finished_at": batch.FinishedAt,
+		"updated_at":  batch.UpdatedAt,
+	}}
+	_, err := m.batchCol.UpdateByID(c, batch.ID, update)
+	return err
+}
+
+// GetBatch implements biz.QRCodeRepo.
+func (m *QRCodeData) GetBatch(c context.Context, appId string, id string,
+) (*entities.QRCodeBatch, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id format: %v", err)
+	}
+	var batch entities.QRCodeBatch
+	err = m.batchCol.FindOne(c, bson.M{"_id": objectID, "app_id": appId}).Decode(&batch)
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// QueryBatches implements biz.QRCodeRepo.
+func (m *QRCodeData) QueryBatches(c context.Context, appId string) ([]*entities.QRCodeBatch, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).
+		SetProjection(bson.M{"failures": 0}).SetLimit(100)
+	cursor, err := m.batchCol.Find(c, bson.M{"app_id": appId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	batches := make([]*entities.QRCodeBatch, 0)
+	if err := cursor.All(c, &batches); err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// ClaimStaleBatch implements biz.QRCodeRepo.
+func (m *QRCodeData) ClaimStaleBatch(c context.Context, before int64, now int64,
+) (*entities.QRCodeBatch, error) {
+	filter := bson.M{
+		"status":     bson.M{"$in": bson.A{biz.JobStatusPending, biz.JobStatusRunning}},
+		"updated_at": bson.M{"$lt": before},
+	}
+	update := bson.M{"$set": bson.M{"updated_at": now}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var batch entities.QRCodeBatch
+	err := m.batchCol.FindOneAndUpdate(c, filter, update, opts).Decode(&batch)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// Scenes implements biz.QRCodeRepo.
+func (m *QRCodeData) Scenes(c context.Context, appId string) ([]*entities.QRScene, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"app_id": appId}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":             "$scene",
+			"name":            bson.M{"$max": "$label"},
+			"owner":           bson.M{"$max": "$owner"},
+			"permanent":       bson.M{"$max": "$permanent"},
+			"issued":          bson.M{"$sum": 1},
+			"first_issued_at": bson.M{"$min": "$created_at"},
+			"last_issued_at":  bson.M{"$max": "$created_at"},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+	cursor, err := m.col.Aggregate(c, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	scenes := make([]*entities.QRScene, 0)
+	for cursor.Next(c) {
+		var item struct {
+			Scene         string `bson:"_id"`
+			Name          string `bson:"name"`
+			Owner         string `bson:"owner"`
+			Permanent     bool   `bson:"permanent"`
+			Issued        int64  `bson:"issued"`
+			FirstIssuedAt int64  `bson:"first_issued_at"`
+			LastIssuedAt  int64  `bson:"last_issued_at"`
+		}
+		if err := cursor.Decode(&item); err != nil {
+			return nil, err
+		}
+		scenes = append(scenes, &entities.QRScene{
+			Scene:         item.Scene,
+			Name:          item.Name,
+			Owner:         item.Owner,
+			Permanent:     item.Permanent,
+			Issued:        item.Issued,
+			FirstIssuedAt: item.FirstIssuedAt,
+			LastIssuedAt:  item.LastIssuedAt,
+			CreatedAt:     item.FirstIssuedAt,
+		})
+	}
+	return scenes, cursor.Err()
+}
+
+// NewQRCodeData creates a new QRCodeData.
+func NewQRCodeData(data *Data, log *zap.Logger) biz.QRCodeRepo {
+	collection := data.db.Collection("qrcodes")
+	data.EnsureIndexes(collection, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "created_at", Value: -1}},
+	}, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "batch_id", Value: 1}},
+	}, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "scene", Value: 1}},
+	})
+	batchCol := data.db.Collection("qrcode_batches")
+	data.EnsureIndexes(batchCol, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}},
+	})
+	return &QRCodeData{
+		col:      collection,
+		batchCol: batchCol,
+		data:     data,
+		log:      log,
+	}
+}
@@ -0,0 +1,285 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/internal/model"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type QRSceneData struct {
+	col      *mongo.Collection
+	quotaCol *mongo.Collection // 每个应用一条，记录已占用的永久二维码数量
+	data     *Data
+	log      *zap.Logger
+}
+
+// Get implements biz.QRSceneRepo.
+func (m *QRSceneData) Get(c context.Context, appId string, scene string) (*entities.QRScene, error) {
+	var doc entities.QRScene
+	err := m.col.FindOne(c, bson.M{"app_id": appId, "scene": scene}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// GetById implements biz.QRSceneRepo.
+func (m *QRSceneData) GetById(c context.Context, appId string, id string) (*entities.QRScene, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id format: %v", err)
+	}
+	var doc entities.QRScene
+	if err := m.col.FindOne(c, bson.M{"_id": objectID, "app_id": appId}).Decode(&doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Create implements biz.QRSceneRepo.
+func (m *QRSceneData) Create(c context.Context, scene *entities.QRScene) error {
+	result, err := m.col.InsertOne(c, scene)
+	if mongo.IsDuplicateKeyError(err) {
+		return biz.ErrQRSceneConflict
+	}
+	if err != nil {
+		return err
+	}
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		scene.ID = oid
+	}
+	return nil
+}
+
+// Update implements biz.QRSceneRepo.
+func (m *QRSceneData) Update(c context.Context, scene *entities.QRScene) error {
+	filter := bson.M{"_id": scene.ID, "app_id": scene.AppId}
+	update := bson.M{"$set": bson.M{
+		"name":        scene.Name,
+		"description": scene.Description,
+		"owner":       scene.Owner,
+		"updated_at":  scene.UpdatedAt,
+	}}
+	result, err := m.col.UpdateOne(c, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// MarkIssued implements biz.QRSceneRepo.
+func (m *QRSceneData) MarkIssued(c context.Context, appId string, scene string, ts int64) error {
+	filter := bson.M{"app_id": appId, "scene": scene}
+	update := bson.M{
+		"$inc": bson.M{"issued": 1},
+		"$set": bson.M{"last_issued_at": ts, "updated_at": ts},
+	}
+	if _, err := m.col.UpdateOne(c, filter, update); err != nil {
+		return err
+	}
+	// 首次生成的时间只设置一次
+	filter["first_issued_at"] = 0
+	_, err := m.col.UpdateOne(c, filter, bson.M{"$set": bson.M{"first_issued_at": ts}})
+	return err
+}
+
+// CountIssuedPermanent implements biz.QRSceneRepo.
+func (m *QRSceneData) CountIssuedPermanent(c context.Context, appId string) (int64, error) {
+	return m.col.CountDocuments(c, bson.M{
+		"app_id":    appId,
+		"permanent": true,
+		"$or":       bson.A{bson.M{"first_issued_at": bson.M{"$gt": 0}}, bson.M{"quota_reserved": true}},
+	})
+}
+
+// Reserve implements biz.QRSceneRepo.
+func (m *QRSceneData) Reserve(c context.Context, appId string, scene string, limit int64,
+) (bool, error) {
+	if err := m.ensureQuota(c, appId); err != nil {
+		return false, err
+	}
+	filter := bson.M{
+		"app_id":          appId,
+		"scene":           scene,
+		"permanent":       true,
+		"first_issued_at": 0,
+		"quota_reserved":  bson.M{"$ne": true},
+	}
+	update := bson.M{"$set": bson.M{"quota_reserved": true, "reserved_at": time.Now().Unix()}}
+	result, err := m.col.UpdateOne(c, filter, update)
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount == 0 { // 已占用或已生成
+		return false, nil
+	}
+
+	// 只在未达到上限时增加计数
+	quota, err := m.quotaCol.UpdateOne(c, bson.M{"app_id": appId, "issued": bson.M{"$lt": limit}},
+		bson.M{"$inc": bson.M{"issued": 1}})
+	if err == nil && quota.MatchedCount > 0 {
+		return true, nil
+	}
+	if err == nil {
+		// 计数在占用期间被重置，重新统计时已包含本次占用
+		if ferr := m.quotaCol.FindOne(c, bson.M{"app_id": appId}).Err(); ferr == mongo.ErrNoDocuments {
+			return true, m.ensureQuota(c, appId)
+		}
+		err = biz.ErrQRCodeQuota
+	}
+	filter = bson.M{"app_id": appId, "scene": scene}
+	if _, uerr := m.col.UpdateOne(c, filter, bson.M{"$set": bson.M{"quota_reserved": false}}); uerr != nil {
+		m.log.Error("undo qrcode quota reservation error", zap.String("scene", scene), zap.Error(uerr))
+	}
+	return false, err
+}
+
+// ensureQuota 计数不存在时按已登记的场景值初始化
+//
+// 计数存在之前不会有占用，统计结果与插入之间不会有其他请求改变数量。
+func (m *QRSceneData) ensureQuota(c context.Context, appId string) error {
+	err := m.quotaCol.FindOne(c, bson.M{"app_id": appId}).Err()
+	if err == nil {
+		return nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return err
+	}
+	issued, err := m.CountIssuedPermanent(c, appId)
+	if err != nil {
+		return err
+	}
+	_, err = m.quotaCol.UpdateOne(c, bson.M{"app_id": appId},
+		bson.M{"$setOnInsert": bson.M{"issued": issued}}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) { // 并发初始化
+		return nil
+	}
+	return err
+}
+
+// Release implements biz.QRSceneRepo.
+func (m *QRSceneData) Release(c context.Context, appId string, scene string) error {
+	filter := bson.M{"app_id": appId, "scene": scene, "first_issued_at": 0, "quota_reserved": true}
+	result, err := m.col.UpdateOne(c, filter, bson.M{"$set": bson.M{"quota_reserved": false}})
+	if err != nil || result.ModifiedCount == 0 {
+		return err
+	}
+	_, err = m.quotaCol.UpdateOne(c, bson.M{"app_id": appId, "issued": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"issued": -1}})
+	return err
+}
+
+// ReleaseStale implements biz.QRSceneRepo.
+//
+// 早期占用的记录没有 reserved_at，同样视为过期。
+func (m *QRSceneData) ReleaseStale(c context.Context, appId string, before int64) (int64, error) {
+	filter := bson.M{
+		"app_id":          appId,
+		"first_issued_at": 0,
+		"quota_reserved":  true,
+		"$or": bson.A{
+			bson.M{"reserved_at": bson.M{"$lt": before}},
+			bson.M{"reserved_at": bson.M{"$exists": false}},
+		},
+	}
+	result, err := m.col.UpdateMany(c, filter, bson.M{"$set": bson.M{"quota_reserved": false}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// ResetQuota implements biz.QRSceneRepo.
+func (m *QRSceneData) ResetQuota(c context.Context, appId string) error {
+	_, err := m.quotaCol.DeleteOne(c, bson.M{"app_id": appId})
+	return err
+}
+
+// Query implements biz.QRSceneRepo.
+func (m *QRSceneData) Query(c context.Context, appId string,
+	params *request.QRSceneQuery,
+) (*model.PageResult[*entities.QRScene], error) {
+	filter := bson.M{"app_id": appId}
+	if params.Permanent != nil {
+		filter["permanent"] = *params.Permanent
+	}
+	if params.Keyword != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(params.Keyword), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"scene": pattern}, bson.M{"name": pattern}}
+	}
+	total, err := m.col.CountDocuments(c, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := params.PageSize
+	if limit <= 0 {
+		limit = 20
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(GetSkipNum(params.PageNo, limit)).
+		SetLimit(limit)
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	pagingData := model.NewPageResult[*entities.QRScene]()
+	pagingData.Total = total
+	if err := cursor.All(c, &pagingData.List); err != nil {
+		return nil, err
+	}
+	return pagingData, nil
+}
+
+// FindByScenes implements biz.QRSceneRepo.
+func (m *QRSceneData) FindByScenes(c context.Context, appId string, scenes []string,
+) ([]*entities.QRScene, error) {
+	cursor, err := m.col.Find(c, bson.M{"app_id": appId, "scene": bson.M{"$in": scenes}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	docs := make([]*entities.QRScene, 0)
+	if err := cursor.All(c, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// NewQRSceneData creates a new QRSceneData.
+func NewQRSceneData(data *Data, log *zap.Logger) biz.QRSceneRepo {
+	collection := data.db.Collection("qrcode_scenes")
+	data.EnsureIndexes(collection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "app_id", Value: 1}, {Key: "scene", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("uniq_app_scene"),
+	}, mongo.IndexModel{
+		Keys: bson.D{{Key: "app_id", Value: 1}, {Key: "permanent", Value: 1}, {Key: "first_issued_at", Value: 1}},
+	})
+	quotaCol := data.db.Collection("qrcode_quotas")
+	data.EnsureIndexes(quotaCol, mongo.IndexModel{
+		Keys:    bson.D{{Key: "app_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return &QRSceneData{col: collection, quotaCol: quotaCol, data: data, log: log}
+}
@@ -0,0 +1,68 @@
+package data
+
+import (
+	"context"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type UserStatsData struct {
+	col  *mongo.Collection
+	data *Data
+	log  *zap.Logger
+}
+
+// Save implements biz.UserStatsRepo.
+func (m *UserStatsData) Save(c context.Context, stats []*entities.UserStats) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(stats))
+	for _, s := range stats {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"app_id": s.AppId, "ref_date": s.RefDate}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"mp_id":         s.MpId,
+				"new_user":      s.NewUser,
+				"cancel_user":   s.CancelUser,
+				"cumulate_user": s.CumulateUser,
+				"updated_at":    s.UpdatedAt,
+			}}).
+			SetUpsert(true))
+	}
+	_, err := m.col.BulkWrite(c, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// Query implements biz.UserStatsRepo.
+func (m *UserStatsData) Query(c context.Context, appId string, begin string, end string,
+) ([]*entities.UserStats, error) {
+	filter := bson.M{"app_id": appId, "ref_date": bson.M{"$gte": begin, "$lte": end}}
+	opts := options.Find().SetSort(bson.D{{Key: "ref_date", Value: 1}})
+	cursor, err := m.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	stats := make([]*entities.UserStats, 0)
+	if err := cursor.All(c, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// NewUserStatsData creates a new UserStatsData.
+func NewUserStatsData(data *Data, log *zap.Logger) biz.UserStatsRepo {
+	collection := data.db.Collection("mp_user_stats")
+	data.EnsureIndexes(collection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "app_id", Value: 1}, {Key: "ref_date", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return &UserStatsData{col: collection, data: data, log: log}
+}
@@ -0,0 +1,99 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type ScheduleData struct {
+	col    *mongo.Collection // schedule_runs
+	appCol *mongo.Collection // platform_apps
+	data   *Data
+	log    *zap.Logger
+}
+
+// ListScheduledApps implements biz.ScheduleRepo.
+func (s *ScheduleData) ListScheduledApps(c context.Context) ([]*entities.PlatformApp, error) {
+	filter := bson.M{
+		"deleted_at": 0,
+		"schedules":  bson.M{"$elemMatch": bson.M{"enabled": true}},
+	}
+	cursor, err := s.appCol.Find(c, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	var apps []*entities.PlatformApp
+	if err := cursor.All(c, &apps); err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+// UpdateSchedules implements biz.ScheduleRepo.
+func (s *ScheduleData) UpdateSchedules(c context.Context, appId string,
+	schedules []*entities.AppSchedule,
+) error {
+	objectID, err := primitive.ObjectIDFromHex(appId)
+	if err != nil {
+		return fmt.Errorf("invalid id format: %v", err)
+	}
+	filter := bson.M{"_id": objectID}
+	update := bson.M{"$set": bson.M{"schedules": schedules, "updated_at": time.Now().Unix()}}
+	_, err = s.appCol.UpdateOne(c, filter, update)
+	return err
+}
+
+// SaveRun implements biz.ScheduleRepo.
+func (s *ScheduleData) SaveRun(c context.Context, run *entities.ScheduleRun) error {
+	filter := bson.M{"app_id": run.AppId, "job": run.Job}
+	update := bson.M{"$set": bson.M{
+		"mp_id":       run.MpId,
+		"spec":        run.Spec,
+		"instance":    run.Instance,
+		"status":      run.Status,
+		"error":       run.Error,
+		"started_at":  run.StartedAt,
+		"finished_at": run.FinishedAt,
+		"duration":    run.Duration,
+		"updated_at":  run.UpdatedAt,
+	}}
+	_, err := s.col.UpdateOne(c, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// QueryRuns implements biz.ScheduleRepo.
+func (s *ScheduleData) QueryRuns(c context.Context, appId string) ([]*entities.ScheduleRun, error) {
+	filter := bson.M{"app_id": appId}
+	cursor, err := s.col.Find(c, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	runs := make([]*entities.ScheduleRun, 0)
+	if err := cursor.All(c, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// NewScheduleData creates a new ScheduleData.
+func NewScheduleData(data *Data, log *zap.Logger) biz.ScheduleRepo {
+	return &ScheduleData{
+		col:    data.db.Collection("schedule_runs"),
+		appCol: data.db.Collection("platform_apps"),
+		data:   data,
+		log:    log,
+	}
+}
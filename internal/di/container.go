@@ -6,8 +6,11 @@ import (
 	"github.com/seth16888/wxbusiness/internal/config"
 	"github.com/seth16888/wxbusiness/internal/data"
 	"github.com/seth16888/wxbusiness/internal/handler"
+	"github.com/seth16888/wxbusiness/internal/message"
+	"github.com/seth16888/wxbusiness/internal/schedule"
 	"github.com/seth16888/wxbusiness/internal/server"
 	"github.com/seth16888/wxbusiness/pkg/jwt"
+	"github.com/seth16888/wxbusiness/pkg/redis"
 	"github.com/seth16888/wxbusiness/pkg/validator"
 	"github.com/seth16888/wxcommon/hc"
 	ak "github.com/seth16888/wxtoken/api/v1"
@@ -28,22 +31,40 @@ func Get() *Container {
 }
 
 type Container struct {
-	Conf             *config.Conf // 配置文件
-	DB               *data.Data   // 数据库连接
-	Log              *zap.Logger
-	JWT              *jwt.JWTService
-	Server           *server.Server
-	HealthHandler    *handler.HealthHandler
-	TokenClient      ak.TokenClient
-	CoAuthClient     au.CoauthClient
-	Validator        *validator.Validator
-	PortalUsecase    *biz.PortalUsecase
-	AppUsecase       *biz.AppUsecase
-	MenuUsecase      *biz.MPMenuUsecase
-	UserUsecase      *biz.UserUsecase
-	MemberTagUsecase *biz.MemberTagUsecase
-	MPMemberUsecase  *biz.MPMemberUsecase
-	MaterialUsecase  *biz.MaterialUsecase
-	MpQRCodeUsecase  *biz.MpQRCodeUsecase
-	HttpClient       *hc.Client
+	Conf                   *config.Conf // 配置文件
+	DB                     *data.Data   // 数据库连接
+	Log                    *zap.Logger
+	JWT                    *jwt.JWTService
+	Server                 *server.Server
+	HealthHandler          *handler.HealthHandler
+	TokenClient            ak.TokenClient
+	CoAuthClient           au.CoauthClient
+	Validator              *validator.Validator
+	PortalUsecase          *biz.PortalUsecase
+	AppUsecase             *biz.AppUsecase
+	MenuUsecase            *biz.MPMenuUsecase
+	UserUsecase            *biz.UserUsecase
+	MemberTagUsecase       *biz.MemberTagUsecase
+	MPMemberUsecase        *biz.MPMemberUsecase
+	MemberSegmentUsecase   *biz.MemberSegmentUsecase
+	MemberExportUsecase    *biz.MemberExportUsecase
+	TaggingJobUsecase      *biz.TaggingJobUsecase
+	TagRuleUsecase         *biz.TagRuleUsecase
+	MemberTimelineUsecase  *biz.MemberTimelineUsecase
+	MemberIdentityUsecase  *biz.MemberIdentityUsecase
+	MemberAttributeUsecase *biz.MemberAttributeUsecase
+	EngagementUsecase      *biz.EngagementUsecase
+	MemberErasureUsecase   *biz.MemberErasureUsecase
+	MessageDispatcher      *message.Dispatcher
+	MaterialUsecase        *biz.MaterialUsecase
+	MaterialQuotaUsecase   *biz.MaterialQuotaUsecase
+	MaterialRefUsecase     *biz.MaterialRefUsecase
+	InboundMediaUsecase    *biz.InboundMediaUsecase
+	MpQRCodeUsecase        *biz.MpQRCodeUsecase
+	QRSceneUsecase         *biz.QRSceneUsecase
+	UserStatsUsecase       *biz.UserStatsUsecase
+	HttpClient             *hc.Client
+	Redis                  *redis.RedisClient
+	ScheduleUsecase        *biz.ScheduleUsecase
+	Scheduler              *schedule.Scheduler
 }
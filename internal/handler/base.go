@@ -41,9 +41,9 @@ func (b *Base) BindAndValidate(c *gin.Context, validator *validator.Validator,
   if err != nil {
     return fmt.Errorf("invalid request data")
   }
-  err = validator.Validate(obj)
-  if err != nil {
-    return err
+  // Validate 返回 *ValidateError，直接赋值给 error 时 nil 也不等于 nil
+  if verr := validator.Validate(obj); verr != nil {
+    return verr
   }
 	return nil
 }
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.uber.org/zap"
+)
+
+// InboundMediaHandler 粉丝发送的图片、语音、视频
+type InboundMediaHandler struct {
+	Base
+	log *zap.Logger
+	uc  *biz.InboundMediaUsecase
+}
+
+func NewInboundMediaHandler(log *zap.Logger, uc *biz.InboundMediaUsecase) *InboundMediaHandler {
+	return &InboundMediaHandler{log: log, uc: uc}
+}
+
+// Query 媒体消息列表，可按 openid、msg_type 筛选
+func (h *InboundMediaHandler) Query(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var params request.InboundMediaQuery
+	if err := ctx.ShouldBindQuery(&params); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	result, err := h.uc.Query(c, appId, &params)
+	if err != nil {
+		ctx.JSON(500, r.Error(500, "查询媒体消息失败"))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(result))
+}
+
+// Content 下载已保存的文件，hd=true 时下载 16K 语音
+func (h *InboundMediaHandler) Content(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	id := ctx.Param("inboundId")
+	hd, _ := strconv.ParseBool(ctx.Query("hd"))
+
+	c := ctx
+	content, err := h.uc.Open(c, appId, id, hd)
+	if err != nil {
+		h.log.Debug("open inbound media error", zap.String("id", id), zap.Error(err))
+		ctx.JSON(404, r.Error(404, "文件不存在"))
+		return
+	}
+	writeMedia(ctx, content)
+}
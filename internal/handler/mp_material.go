@@ -1,26 +1,119 @@
 package handler
 
 import (
-	"path"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
 	"path/filepath"
 	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/seth16888/wxbusiness/internal/biz"
 	"github.com/seth16888/wxbusiness/internal/model/r"
 	"github.com/seth16888/wxbusiness/internal/model/request"
-	"github.com/seth16888/wxcommon/helpers"
+	"github.com/seth16888/wxbusiness/pkg/material"
 	"go.uber.org/zap"
 )
 
 type MaterialHandler struct {
 	Base
-	log *zap.Logger
-	uc  *biz.MaterialUsecase
+	log     *zap.Logger
+	uc      *biz.MaterialUsecase
+	quotaUc *biz.MaterialQuotaUsecase
 }
 
-func NewMaterialHandler(log *zap.Logger, uc *biz.MaterialUsecase) *MaterialHandler {
-	return &MaterialHandler{log: log, uc: uc}
+func NewMaterialHandler(log *zap.Logger, uc *biz.MaterialUsecase,
+	quotaUc *biz.MaterialQuotaUsecase,
+) *MaterialHandler {
+	return &MaterialHandler{log: log, uc: uc, quotaUc: quotaUc}
+}
+
+// prepareFile 读取上传的文件，识别真实类型并按素材限制转码、压缩后保存到素材存储
+//
+// 只读取文件头识别类型，图片读入内存预处理，其他类型校验后直接流式保存。
+// 返回保存的文件和上传到微信使用的文件名，转为 JPEG 时文件名的扩展名也随之修改
+func (h *MaterialHandler) prepareFile(ctx *gin.Context, appId string,
+	file *multipart.FileHeader, limit material.Limit,
+) (*biz.StoredFile, string, error) {
+	if file.Size > material.MaxSourceSize {
+		return nil, "", material.ErrTooLarge
+	}
+	f, err := file.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	head := make([]byte, material.SniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, "", err
+	}
+	head = head[:n]
+	body := io.MultiReader(bytes.NewReader(head), f)
+
+	contentType := material.SniffType(head)
+	if !material.IsImage(contentType) {
+		if err := material.Check(contentType, file.Size, limit); err != nil {
+			return nil, "", err
+		}
+		ext := material.Ext(contentType)
+		stored, err := h.uc.Store(ctx, appId, ext, body, file.Size, contentType)
+		if err != nil {
+			return nil, "", err
+		}
+		return stored, replaceExt(file.Filename, ext), nil
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, "", err
+	}
+	processed, err := material.Preprocess(data, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	filename := replaceExt(file.Filename, processed.Ext)
+	if processed.Converted {
+		h.log.Debug("material preprocessed", zap.String("filename", filename),
+			zap.Int("from", len(data)), zap.Int("to", len(processed.Data)))
+	}
+	stored, err := h.uc.Store(ctx, appId, processed.Ext, bytes.NewReader(processed.Data),
+		int64(len(processed.Data)), processed.ContentType)
+	if err != nil {
+		return nil, "", err
+	}
+	return stored, filename, nil
+}
+
+// replaceExt 文件名的扩展名与真实类型不一致时替换
+func replaceExt(filename string, ext string) string {
+	if old := filepath.Ext(filename); !strings.EqualFold(old, ext) {
+		return strings.TrimSuffix(filename, old) + ext
+	}
+	return filename
+}
+
+// prepareError 返回 prepareFile 的错误
+func (h *MaterialHandler) prepareError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, material.ErrUnsupportedType):
+		ctx.JSON(400, r.Error(400, "file type not allowed"))
+	case errors.Is(err, material.ErrTooLarge):
+		ctx.JSON(400, r.Error(400, "file size too large"))
+	default:
+		h.log.Error("prepare material file error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "save file error"))
+	}
+}
+
+// force 查询参数 force=true 时上传不去重，删除时忽略引用
+func (h *MaterialHandler) force(ctx *gin.Context) bool {
+	force, _ := strconv.ParseBool(ctx.Query("force"))
+	return force
 }
 
 // UploadTemporaryMedia
@@ -44,58 +137,17 @@ func (h *MaterialHandler) UploadTemporaryMedia(ctx *gin.Context) {
 		ctx.JSON(400, r.Error(400, "file not found"))
 		return
 	}
-	// 检查文件类型、大小
-	ext := filepath.Ext(file.Filename)
-	switch mediaType {
-	case "image":
-		if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" {
-			ctx.JSON(400, r.Error(400, "image file type not allowed"))
-			return
-		}
-		if file.Size > 10*1024*1024 {
-			ctx.JSON(400, r.Error(400, "image file size too large"))
-			return
-		}
-	case "voice":
-		if ext != ".mp3" && ext != ".amr" {
-			ctx.JSON(400, r.Error(400, "voice file type not allowed"))
-			return
-		}
-		if file.Size > 2*1024*1024 {
-			ctx.JSON(400, r.Error(400, "voice file size too large"))
-			return
-		}
-	case "video":
-		if ext != ".mp4" {
-			ctx.JSON(400, r.Error(400, "video file type not allowed"))
-			return
-		}
-		if file.Size > 10*1024*1024 {
-			ctx.JSON(400, r.Error(400, "video file size too large"))
-			return
-		}
-	case "thumb":
-		if ext != ".jpg" {
-			ctx.JSON(400, r.Error(400, "thumb file type not allowed"))
-			return
-		}
-		if file.Size > 64*1024 {
-			ctx.JSON(400, r.Error(400, "thumb file size too large"))
-			return
-		}
-	}
-
-	// 保存文件
-  filename := helpers.UUID() + ext
-	filePath := path.Join("uploads", filename)
-	if err = ctx.SaveUploadedFile(file, filePath); err != nil {
-		ctx.JSON(500, r.Error(500, "save file error"))
+	// 识别文件类型，按素材限制转码、压缩后保存
+	limit, _ := material.MediaLimit(mediaType, false)
+	stored, filename, err := h.prepareFile(ctx, appId, file, limit)
+	if err != nil {
+		h.prepareError(ctx, err)
 		return
 	}
 
   // 上传到微信
   c := ctx
-  res, err := h.uc.UploadTemporaryMedia(c, appId, mediaType, filename, filePath)
+  res, err := h.uc.UploadTemporaryMedia(c, appId, mediaType, filename, stored, h.force(ctx))
   if err!= nil {
     ctx.JSON(500, r.Error(500, err.Error()))
     return
@@ -108,6 +160,7 @@ func (h *MaterialHandler) UploadTemporaryMedia(ctx *gin.Context) {
 //
 // 本接口所上传的图片不占用公众号的素材库中图片数量的100000个的限制。
 // 图片仅支持jpg/png格式，大小必须在1MB以下。
+// 其他格式或超过大小的图片自动转为 JPEG 并压缩。
 func (h *MaterialHandler) UploadNewsImage(ctx *gin.Context) {
 	// 路径参数
 	appId, err := h.GetPID(ctx)
@@ -121,26 +174,15 @@ func (h *MaterialHandler) UploadNewsImage(ctx *gin.Context) {
 		ctx.JSON(400, r.Error(400, "file not found"))
 		return
 	}
-  // 检查文件类型、大小
-	ext := filepath.Ext(file.Filename)
-	if ext!= ".jpg" && ext!= ".png" {
-		ctx.JSON(400, r.Error(400, "image file type not allowed"))
-		return
-	}
-	if file.Size > 1*1024*1024 {
-		ctx.JSON(400, r.Error(400, "image file size too large"))
-		return
-	}
-  // 保存文件
-  filename := helpers.UUID() + ext
-	dst := path.Join("uploads", filename)
-	if err = ctx.SaveUploadedFile(file, dst); err != nil {
-		ctx.JSON(500, r.Error(500, "save file error"))
+	// 识别文件类型，按素材限制转码、压缩后保存
+	stored, filename, err := h.prepareFile(ctx, appId, file, material.NewsImageLimit)
+	if err != nil {
+		h.prepareError(ctx, err)
 		return
 	}
   // 上传到微信
   c := ctx
-  res, err := h.uc.UploadNewsImage(c, appId, filename, dst)
+  res, err := h.uc.UploadNewsImage(c, appId, filename, stored, h.force(ctx))
   if err!= nil {
     ctx.JSON(500, r.Error(500, err.Error()))
     return
@@ -157,6 +199,8 @@ func (h *MaterialHandler) UploadNewsImage(ctx *gin.Context) {
 //	语音（voice）：2M，播放长度不超过60s，mp3/wma/wav/amr格式
 //	视频（video）：10MB，支持MP4格式
 //	缩略图（thumb）：64KB，支持JPG格式
+//
+// 按文件内容识别类型，不符合要求的图片自动转为 JPEG 并压缩，任意图片都可以作为缩略图上传。
 func (h *MaterialHandler) UploadMedia(ctx *gin.Context) {
 	// 路径参数
 	appId, err := h.GetPID(ctx)
@@ -178,46 +222,6 @@ func (h *MaterialHandler) UploadMedia(ctx *gin.Context) {
 		ctx.JSON(400, r.Error(400, "file not found"))
 		return
 	}
-  // 检查文件类型、大小
-	ext := filepath.Ext(file.Filename)
-	switch mediaType {
-	case "image":
-		if ext != ".bmp" && ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" {
-			ctx.JSON(400, r.Error(400, "image file type not allowed"))
-			return
-		}
-		if file.Size > 10*1024*1024 {
-			ctx.JSON(400, r.Error(400, "image file size too large"))
-			return
-		}
-	case "voice":
-		if ext != ".mp3" && ext != ".amr" && ext!= ".wma" && ext!= ".wav" {
-			ctx.JSON(400, r.Error(400, "voice file type not allowed"))
-			return
-		}
-		if file.Size > 2*1024*1024 {
-			ctx.JSON(400, r.Error(400, "voice file size too large"))
-			return
-		}
-	case "video":
-		if ext != ".mp4" {
-			ctx.JSON(400, r.Error(400, "video file type not allowed"))
-			return
-		}
-		if file.Size > 10*1024*1024 {
-			ctx.JSON(400, r.Error(400, "video file size too large"))
-			return
-		}
-	case "thumb":
-		if ext != ".jpg" {
-			ctx.JSON(400, r.Error(400, "thumb file type not allowed"))
-			return
-		}
-		if file.Size > 64*1024 {
-			ctx.JSON(400, r.Error(400, "thumb file size too large"))
-			return
-		}
-	}
   // 如果是video，需要额外检查title和description
   videoTitle := ""
   videoIntro := ""
@@ -230,19 +234,23 @@ func (h *MaterialHandler) UploadMedia(ctx *gin.Context) {
     videoIntro = ctx.Query("introduction")
   }
 
-  // 保存文件
-  filename := helpers.UUID() + ext
-	dst := path.Join("uploads", filename)
-	if err = ctx.SaveUploadedFile(file, dst); err!= nil {
-		ctx.JSON(500, r.Error(500, "save file error"))
+	// 识别文件类型，按素材限制转码、压缩后保存
+	limit, _ := material.MediaLimit(mediaType, true)
+	stored, filename, err := h.prepareFile(ctx, appId, file, limit)
+	if err != nil {
+		h.prepareError(ctx, err)
 		return
 	}
 
   // 上传到微信
   c := ctx
-  res, err := h.uc.UploadMedia(c, appId, mediaType, filename, dst,
+  res, err := h.uc.UploadMedia(c, appId, mediaType, filename, stored, h.force(ctx),
     videoTitle, videoIntro)
   if err!= nil {
+    if errors.Is(err, biz.ErrMaterialQuota) {
+      ctx.JSON(403, r.Error(403, err.Error()))
+      return
+    }
     ctx.JSON(500, r.Error(500, err.Error()))
     return
   }
@@ -284,6 +292,8 @@ func (h *MaterialHandler) GetMaterialList(ctx *gin.Context) {
 }
 
 // DeleteMaterial 删除素材
+//
+// 素材仍被菜单等引用时返回 409 和引用位置，force=true 时强制删除并返回失效的引用
 func (h *MaterialHandler) DeleteMaterial(ctx *gin.Context) {
   // 路径参数
 	appId, err := h.GetPID(ctx)
@@ -295,15 +305,20 @@ func (h *MaterialHandler) DeleteMaterial(ctx *gin.Context) {
   mediaId := ctx.Param("mediaId")
   if mediaId == "" {
     ctx.JSON(400, r.Error(400, "media_id not found"))
+    return
   }
 
   c:=ctx
-  err = h.uc.DeleteMaterial(c, appId, mediaId)
+  res, err := h.uc.DeleteMaterial(c, appId, mediaId, h.force(ctx))
   if err!= nil {
+    if errors.Is(err, biz.ErrMaterialInUse) {
+      ctx.JSON(409, r.NewR(409, "素材正在使用中", res))
+      return
+    }
     ctx.JSON(500, r.Error(500, err.Error()))
     return
   }
-  ctx.JSON(200, r.Success())
+  ctx.JSON(200, r.SuccessData(res))
 }
 
 // Pull 拉取永久素材
@@ -340,3 +355,176 @@ func (h *MaterialHandler) Pull(ctx *gin.Context) {
   }
   ctx.JSON(200, r.Success())
 }
+
+// ResolveMediaId 返回素材当前有效的 media_id，临时素材即将过期时自动重新上传
+//
+// ref 为素材ID或 media_id
+func (h *MaterialHandler) ResolveMediaId(ctx *gin.Context) {
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	ref := ctx.Param("ref")
+	if ref == "" {
+		ctx.JSON(400, r.Error(400, "ref not found"))
+		return
+	}
+
+	res, err := h.uc.ResolveMediaId(ctx, appId, ref)
+	if err != nil {
+		if errors.Is(err, biz.ErrMaterialExpired) {
+			ctx.JSON(410, r.Error(410, "临时素材已过期"))
+			return
+		}
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(res))
+}
+
+// RefreshTemporary 立即刷新即将过期的临时素材
+func (h *MaterialHandler) RefreshTemporary(ctx *gin.Context) {
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	res, err := h.uc.RefreshTemporary(ctx, appId)
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(res))
+}
+
+// Sync 全量同步永久素材，并保存各类型素材总数
+//
+// 在请求中同步执行，适合素材较少的公众号，素材较多时应使用 material 定时任务
+func (h *MaterialHandler) Sync(ctx *gin.Context) {
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	res, err := h.uc.Sync(ctx, appId)
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(res))
+}
+
+// Count 最近一次同步时的各类型素材总数
+func (h *MaterialHandler) Count(ctx *gin.Context) {
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	res, err := h.uc.GetCount(ctx, appId)
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	if res == nil {
+		ctx.JSON(404, r.Error(404, "material not synced"))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(res))
+}
+
+// Usage 永久素材的剩余数量、今日剩余上传次数，以及被菜单等引用的素材
+func (h *MaterialHandler) Usage(ctx *gin.Context) {
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	res, err := h.quotaUc.Usage(ctx, appId)
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(res))
+}
+
+// Unused 未被引用的永久素材，可按 type 筛选，按创建时间从早到晚排序
+func (h *MaterialHandler) Unused(ctx *gin.Context) {
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var params request.UnusedMaterialQuery
+	if err := ctx.ShouldBindQuery(&params); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	if params.Type != "" && !slices.Contains([]string{"image", "thumb", "voice", "video", "news"}, params.Type) {
+		ctx.JSON(400, r.Error(400, "type not allowed"))
+		return
+	}
+
+	res, err := h.quotaUc.Unused(ctx, appId, &params)
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(res))
+}
+
+// Download 下载素材
+//
+// permanent=true 时下载永久素材，否则下载临时素材（包括语音消息的 MediaId16K）。
+// 图文素材和视频素材返回 JSON，其他类型返回文件内容。
+func (h *MaterialHandler) Download(ctx *gin.Context) {
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	mediaId := ctx.Param("mediaId")
+	if mediaId == "" {
+		ctx.JSON(400, r.Error(400, "media_id not found"))
+		return
+	}
+
+	content, err := h.uc.DownloadMedia(ctx, appId, mediaId, h.permanent(ctx))
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	writeMedia(ctx, content)
+}
+
+func (h *MaterialHandler) permanent(ctx *gin.Context) bool {
+	permanent, _ := strconv.ParseBool(ctx.Query("permanent"))
+	return permanent
+}
+
+// writeMedia 返回素材内容，并关闭 Body
+func writeMedia(ctx *gin.Context, content *biz.MediaContent) {
+	if content.Body == nil {
+		ctx.JSON(200, r.SuccessData(content.JSON))
+		return
+	}
+	defer content.Body.Close()
+
+	contentType := content.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	var headers map[string]string
+	if content.Filename != "" {
+		headers = map[string]string{
+			"Content-Disposition": mime.FormatMediaType("attachment",
+				map[string]string{"filename": content.Filename}),
+		}
+	}
+	ctx.DataFromReader(200, content.Size, contentType, content.Body, headers)
+}
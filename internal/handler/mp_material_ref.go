@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"go.uber.org/zap"
+)
+
+// MaterialRefHandler 素材引用索引
+type MaterialRefHandler struct {
+	Base
+	log *zap.Logger
+	uc  *biz.MaterialRefUsecase
+}
+
+func NewMaterialRefHandler(log *zap.Logger, uc *biz.MaterialRefUsecase) *MaterialRefHandler {
+	return &MaterialRefHandler{log: log, uc: uc}
+}
+
+// Query 素材的引用位置，不指定 media_id 时返回全部引用
+func (h *MaterialRefHandler) Query(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	mediaId := ctx.Query("media_id")
+	var refs any
+	if mediaId == "" {
+		refs, err = h.uc.List(c, appId)
+	} else {
+		refs, err = h.uc.Find(c, appId, mediaId)
+	}
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(refs))
+}
+
+// Rebuild 从菜单等模块重新建立引用索引
+func (h *MaterialRefHandler) Rebuild(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	refs, err := h.uc.Rebuild(c, appId)
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(refs))
+}
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"errors"
+
 	"github.com/gin-gonic/gin"
 	"github.com/seth16888/wxbusiness/internal/biz"
 	"github.com/seth16888/wxbusiness/internal/model/r"
@@ -39,6 +41,10 @@ func (h *MPMemberHandler) Query(ctx *gin.Context) {
 
 	c := ctx
 	members, err := h.uc.Query(c, appId, &params)
+	if errors.Is(err, biz.ErrInvalidFilter) {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
 	if err != nil {
 		h.log.Error("query tag error", zap.Error(err))
 		ctx.JSON(500, r.Error(500, "查询粉丝列表失败"))
@@ -138,6 +144,7 @@ func (h *MPMemberHandler) BatchBlock(ctx *gin.Context) {
 	}
 	type req struct {
 		OpenIds []string `json:"openids" binding:"required" msg:"openids不能为空"`
+		Reason  string   `json:"reason"`
 	}
 	var params req
 	if err := ctx.ShouldBindJSON(&params); err != nil {
@@ -150,8 +157,11 @@ func (h *MPMemberHandler) BatchBlock(ctx *gin.Context) {
 		return
 	}
 
+	// 操作用户
+	uid, _ := h.GetUserId(ctx)
+
 	c := ctx
-	if err := h.uc.BatchBlock(c, appId, params.OpenIds); err != nil {
+	if err := h.uc.BatchBlock(c, appId, params.OpenIds, params.Reason, uid); err != nil {
 		h.log.Error("batch block error", zap.Error(err))
 		ctx.JSON(500, r.Error(500, "批量拉黑失败"))
 		return
@@ -170,6 +180,7 @@ func (h *MPMemberHandler) BatchUnblock(ctx *gin.Context) {
 	}
 	type req struct {
 		OpenIds []string `json:"openids" binding:"required" msg:"openids不能为空"`
+		Reason  string   `json:"reason"`
 	}
 	var params req
 	if err := ctx.ShouldBindJSON(&params); err != nil {
@@ -182,8 +193,11 @@ func (h *MPMemberHandler) BatchUnblock(ctx *gin.Context) {
 		return
 	}
 
+	// 操作用户
+	uid, _ := h.GetUserId(ctx)
+
 	c := ctx
-	if err := h.uc.BatchUnblock(c, appId, params.OpenIds); err != nil {
+	if err := h.uc.BatchUnblock(c, appId, params.OpenIds, params.Reason, uid); err != nil {
 		h.log.Error("batch unblock error", zap.Error(err))
 		ctx.JSON(500, r.Error(500, "批量取消拉黑失败"))
 		return
@@ -276,11 +290,57 @@ func (h *MPMemberHandler) PullBlackList(ctx *gin.Context) {
 	}
 
 	c := ctx
-	if err := h.uc.PullBlackList(c, appId); err!= nil {
+	summary, err := h.uc.PullBlackList(c, appId)
+	if err != nil {
 		h.log.Error("pull black list error", zap.Error(err))
 		ctx.JSON(500, r.Error(500, "同步微信黑名单失败"))
 		return
 	}
 
-	ctx.JSON(200, r.Success())
+	ctx.JSON(200, r.SuccessData(summary))
+}
+
+// GetBlackListRecords 黑名单记录，包含拉黑原因、操作人
+func (h *MPMemberHandler) GetBlackListRecords(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	records, err := h.uc.GetBlackListRecords(c, appId)
+	if err != nil {
+		h.log.Error("get black list records error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "获取黑名单失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(records))
+}
+
+// GetBlackListHistory 拉黑、取消拉黑操作记录
+func (h *MPMemberHandler) GetBlackListHistory(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var params request.BlackListHistoryQuery
+	if err := ctx.ShouldBindQuery(&params); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	result, err := h.uc.GetBlackListHistory(c, appId, &params)
+	if err != nil {
+		h.log.Error("get black list history error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "获取黑名单操作记录失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(result))
 }
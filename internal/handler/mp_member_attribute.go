@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"github.com/seth16888/wxbusiness/pkg/validator"
+	"go.uber.org/zap"
+)
+
+// MemberAttributeHandler 粉丝自定义属性
+type MemberAttributeHandler struct {
+	Base
+	log       *zap.Logger
+	uc        *biz.MemberAttributeUsecase
+	validator *validator.Validator
+}
+
+func NewMemberAttributeHandler(log *zap.Logger, uc *biz.MemberAttributeUsecase,
+	validator *validator.Validator,
+) *MemberAttributeHandler {
+	return &MemberAttributeHandler{log: log, uc: uc, validator: validator}
+}
+
+// Create 创建属性
+func (h *MemberAttributeHandler) Create(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var req request.MemberAttributeDefReq
+	if err := h.BindAndValidate(ctx, h.validator, &req); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	id, err := h.uc.CreateDef(c, appId, &req)
+	if err != nil {
+		h.log.Error("create member attribute error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "创建属性失败: "+err.Error()))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(id))
+}
+
+// Update 更新属性
+func (h *MemberAttributeHandler) Update(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	attrId := ctx.Param("attrId")
+	if err != nil || attrId == "" {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+	var req request.MemberAttributeDefReq
+	if err := h.BindAndValidate(ctx, h.validator, &req); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	if err := h.uc.UpdateDef(c, appId, attrId, &req); err != nil {
+		h.log.Error("update member attribute error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "更新属性失败: "+err.Error()))
+		return
+	}
+
+	ctx.JSON(200, r.Success())
+}
+
+// Delete 删除属性
+func (h *MemberAttributeHandler) Delete(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	attrId := ctx.Param("attrId")
+	if err != nil || attrId == "" {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+
+	c := ctx
+	if err := h.uc.DeleteDef(c, appId, attrId); err != nil {
+		h.log.Error("delete member attribute error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "删除属性失败"))
+		return
+	}
+
+	ctx.JSON(200, r.Success())
+}
+
+// Query 属性列表
+func (h *MemberAttributeHandler) Query(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	defs, err := h.uc.QueryDefs(c, appId)
+	if err != nil {
+		h.log.Error("query member attributes error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "查询属性失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(defs))
+}
+
+// SetMemberAttributes 设置粉丝的属性值
+func (h *MemberAttributeHandler) SetMemberAttributes(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	memberId := ctx.Param("memberId")
+	if err != nil || memberId == "" {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+	var req request.UpdateMemberAttributesReq
+	if err := h.BindAndValidate(ctx, h.validator, &req); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	if err := h.uc.SetMemberAttributes(c, appId, memberId, req.Attributes); err != nil {
+		h.log.Error("set member attributes error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "设置属性失败: "+err.Error()))
+		return
+	}
+
+	ctx.JSON(200, r.Success())
+}
+
+// Import 上传CSV文件导入属性值
+func (h *MemberAttributeHandler) Import(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	// 上传文件
+	file, err := ctx.FormFile("file")
+	if err != nil || file == nil {
+		ctx.JSON(400, r.Error(400, "file not found"))
+		return
+	}
+	if strings.ToLower(filepath.Ext(file.Filename)) != ".csv" {
+		ctx.JSON(400, r.Error(400, "file type not allowed"))
+		return
+	}
+	if file.Size > 5*1024*1024 {
+		ctx.JSON(400, r.Error(400, "file size too large"))
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		ctx.JSON(400, r.Error(400, "file open error"))
+		return
+	}
+	defer f.Close()
+
+	c := ctx
+	result, err := h.uc.Import(c, appId, f)
+	if err != nil {
+		h.log.Error("import member attributes error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "导入属性失败: "+err.Error()))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(result))
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"go.uber.org/zap"
+)
+
+// EngagementHandler 粉丝活跃度
+type EngagementHandler struct {
+	Base
+	log *zap.Logger
+	uc  *biz.EngagementUsecase
+}
+
+func NewEngagementHandler(log *zap.Logger, uc *biz.EngagementUsecase) *EngagementHandler {
+	return &EngagementHandler{log: log, uc: uc}
+}
+
+// Refresh 立即重新计算粉丝活跃度
+func (h *EngagementHandler) Refresh(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	count, err := h.uc.Refresh(c, appId)
+	if err != nil {
+		h.log.Error("refresh member engagement error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "计算活跃度失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(map[string]int{"updated": count}))
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"github.com/seth16888/wxbusiness/pkg/validator"
+	"go.uber.org/zap"
+)
+
+// MemberErasureHandler 删除粉丝数据
+type MemberErasureHandler struct {
+	Base
+	log       *zap.Logger
+	uc        *biz.MemberErasureUsecase
+	validator *validator.Validator
+}
+
+func NewMemberErasureHandler(log *zap.Logger, uc *biz.MemberErasureUsecase,
+	validator *validator.Validator,
+) *MemberErasureHandler {
+	return &MemberErasureHandler{log: log, uc: uc, validator: validator}
+}
+
+// Erase 按 openid 或 unionid 删除(匿名化)粉丝数据，dry_run 时只返回将被处理的数据
+//
+// 只处理本地数据，微信后台的备注和标签不会清除，需在微信后台处理，否则下次同步会重新写入。
+func (h *MemberErasureHandler) Erase(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var req request.MemberErasureReq
+	if err := h.BindAndValidate(ctx, h.validator, &req); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	// 操作用户
+	uid, _ := h.GetUserId(ctx)
+
+	c := ctx
+	result, err := h.uc.Erase(c, appId, uid, &req)
+	if err != nil {
+		h.log.Error("erase member data error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "删除粉丝数据失败: "+err.Error()))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(result))
+}
+
+// QueryAudits 删除记录
+func (h *MemberErasureHandler) QueryAudits(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var params request.PagingQuery
+	if err := ctx.ShouldBindQuery(&params); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	result, err := h.uc.QueryAudits(c, appId, &params)
+	if err != nil {
+		h.log.Error("query erasure audits error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "查询删除记录失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(result))
+}
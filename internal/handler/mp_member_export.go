@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"github.com/seth16888/wxbusiness/pkg/export"
+	"go.uber.org/zap"
+)
+
+// MemberExportHandler 粉丝导出
+type MemberExportHandler struct {
+	Base
+	log *zap.Logger
+	uc  *biz.MemberExportUsecase
+}
+
+func NewMemberExportHandler(log *zap.Logger, uc *biz.MemberExportUsecase) *MemberExportHandler {
+	return &MemberExportHandler{log: log, uc: uc}
+}
+
+// Export 按筛选条件导出粉丝
+//
+// format: csv(默认), xlsx; columns: 逗号分隔的导出字段
+func (h *MemberExportHandler) Export(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var filter request.MPMemberFilter
+	if err := ctx.ShouldBindQuery(&filter); err != nil {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+	if err := biz.ValidateMemberFilter(&filter); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	format := ctx.DefaultQuery("format", export.FormatCSV)
+	if format != export.FormatCSV && format != export.FormatXLSX {
+		ctx.JSON(400, r.Error(400, "不支持的导出格式"))
+		return
+	}
+	var columns []string
+	if s := ctx.Query("columns"); s != "" {
+		columns = strings.Split(s, ",")
+	}
+	columns, err = h.uc.ValidateColumns(columns)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	// 先写入临时文件，导出失败时仍可返回错误，不会返回截断的文件
+	tmp, err := os.CreateTemp("", "members_*."+format)
+	if err != nil {
+		h.log.Error("create temp file error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "导出粉丝失败"))
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	c := ctx
+	if err := h.uc.Export(c, appId, &filter, columns, format, tmp); err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	info, err := tmp.Stat()
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		h.log.Error("read export file error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "导出粉丝失败"))
+		return
+	}
+
+	filename := fmt.Sprintf("members_%s.%s", time.Now().Format("20060102150405"), format)
+	ctx.DataFromReader(200, info.Size(), export.ContentType(format), tmp, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%s", filename),
+	})
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"go.uber.org/zap"
+)
+
+// MemberIdentityHandler 跨公众号用户身份
+type MemberIdentityHandler struct {
+	Base
+	log *zap.Logger
+	uc  *biz.MemberIdentityUsecase
+}
+
+func NewMemberIdentityHandler(log *zap.Logger, uc *biz.MemberIdentityUsecase) *MemberIdentityHandler {
+	return &MemberIdentityHandler{log: log, uc: uc}
+}
+
+// GetByUnionId 按 unionid 查询用户
+func (h *MemberIdentityHandler) GetByUnionId(ctx *gin.Context) {
+	unionId := ctx.Param("unionId")
+	if unionId == "" {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+
+	c := ctx
+	profile, err := h.uc.GetByUnionId(c, unionId)
+	if err != nil {
+		h.log.Error("get identity error", zap.Error(err))
+		ctx.JSON(404, r.Error(404, "用户不存在"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(profile))
+}
+
+// GetByOpenId 按任一公众号的 openid 查询用户
+func (h *MemberIdentityHandler) GetByOpenId(ctx *gin.Context) {
+	openid := ctx.Param("openid")
+	if openid == "" {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+
+	c := ctx
+	profile, err := h.uc.GetByOpenId(c, openid)
+	if err != nil {
+		h.log.Error("get identity error", zap.Error(err))
+		ctx.JSON(404, r.Error(404, "用户不存在"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(profile))
+}
+
+// Rebuild 按本地粉丝数据重新关联
+func (h *MemberIdentityHandler) Rebuild(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	count, err := h.uc.Rebuild(c, appId)
+	if err != nil {
+		h.log.Error("rebuild identity error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "关联用户身份失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(count))
+}
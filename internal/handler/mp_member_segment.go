@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"github.com/seth16888/wxbusiness/pkg/validator"
+	"go.uber.org/zap"
+)
+
+// MemberSegmentHandler 粉丝分群
+type MemberSegmentHandler struct {
+	Base
+	log       *zap.Logger
+	uc        *biz.MemberSegmentUsecase
+	validator *validator.Validator
+}
+
+func NewMemberSegmentHandler(log *zap.Logger, uc *biz.MemberSegmentUsecase,
+	validator *validator.Validator,
+) *MemberSegmentHandler {
+	return &MemberSegmentHandler{log: log, uc: uc, validator: validator}
+}
+
+// Create 创建分群
+func (h *MemberSegmentHandler) Create(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var req request.MemberSegmentReq
+	if err := h.BindAndValidate(ctx, h.validator, &req); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	if req.Name == "" {
+		ctx.JSON(400, r.Error(400, "name不能为空"))
+		return
+	}
+
+	c := ctx
+	id, err := h.uc.Create(c, appId, &req)
+	if errors.Is(err, biz.ErrInvalidFilter) {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	if err != nil {
+		h.log.Error("create segment error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "创建分群失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(id))
+}
+
+// Update 更新分群
+func (h *MemberSegmentHandler) Update(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	segmentId := ctx.Param("segmentId")
+	if err != nil || segmentId == "" {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+	var req request.MemberSegmentReq
+	if err := h.BindAndValidate(ctx, h.validator, &req); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	if req.Name == "" {
+		ctx.JSON(400, r.Error(400, "name不能为空"))
+		return
+	}
+
+	c := ctx
+	err = h.uc.Update(c, appId, segmentId, &req)
+	if errors.Is(err, biz.ErrInvalidFilter) {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	if err != nil {
+		h.log.Error("update segment error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "更新分群失败"))
+		return
+	}
+
+	ctx.JSON(200, r.Success())
+}
+
+// Delete 删除分群
+func (h *MemberSegmentHandler) Delete(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	segmentId := ctx.Param("segmentId")
+	if err != nil || segmentId == "" {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+
+	c := ctx
+	if err := h.uc.Delete(c, appId, segmentId); err != nil {
+		h.log.Error("delete segment error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "删除分群失败"))
+		return
+	}
+
+	ctx.JSON(200, r.Success())
+}
+
+// Query 分群列表
+func (h *MemberSegmentHandler) Query(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	segments, err := h.uc.Query(c, appId)
+	if err != nil {
+		h.log.Error("query segment error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "查询分群失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(segments))
+}
+
+// QueryMembers 查询分群下的粉丝
+func (h *MemberSegmentHandler) QueryMembers(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	segmentId := ctx.Param("segmentId")
+	if err != nil || segmentId == "" {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+	var params request.MPMemberQuery
+	if err := ctx.ShouldBindQuery(&params); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	members, err := h.uc.QueryMembers(c, appId, segmentId, &params)
+	if errors.Is(err, biz.ErrInvalidFilter) {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	if err != nil {
+		h.log.Error("query segment members error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "查询分群粉丝失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(members))
+}
+
+// Tagging 为分群下的全部粉丝打标签，创建后台任务，返回任务
+func (h *MemberSegmentHandler) Tagging(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	segmentId := ctx.Param("segmentId")
+	if err != nil || segmentId == "" {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+	type req struct {
+		TagId int64 `json:"tagid" binding:"required" msg:"tagid不能为空"`
+	}
+	var params req
+	if err := ctx.ShouldBindJSON(&params); err != nil || params.TagId == 0 {
+		ctx.JSON(400, r.Error(400, "tagid参数错误"))
+		return
+	}
+
+	c := ctx
+	job, err := h.uc.Tagging(c, appId, segmentId, params.TagId)
+	if errors.Is(err, biz.ErrInvalidFilter) {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	if err != nil {
+		h.log.Error("segment tagging error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "分群打标签失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(job))
+}
@@ -120,11 +120,12 @@ func (h *MemberTagHandler) Pull(ctx *gin.Context) {
   }
 
   c:= ctx
-  if err := h.uc.Pull(c, appId); err!= nil {
+  summary, err := h.uc.Pull(c, appId)
+  if err != nil {
     h.log.Error("pull tags error", zap.Error(err))
     ctx.JSON(500, r.Error(500, "拉取标签失败"))
     return
   }
 
-  ctx.JSON(200, r.Success())
+  ctx.JSON(200, r.SuccessData(summary))
 }
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"github.com/seth16888/wxbusiness/pkg/validator"
+	"go.uber.org/zap"
+)
+
+// TagRuleHandler 自动打标签规则
+type TagRuleHandler struct {
+	Base
+	log       *zap.Logger
+	uc        *biz.TagRuleUsecase
+	validator *validator.Validator
+}
+
+func NewTagRuleHandler(log *zap.Logger, uc *biz.TagRuleUsecase,
+	validator *validator.Validator,
+) *TagRuleHandler {
+	return &TagRuleHandler{log: log, uc: uc, validator: validator}
+}
+
+// Create 创建规则
+func (h *TagRuleHandler) Create(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var req request.TagRuleReq
+	if err := h.BindAndValidate(ctx, h.validator, &req); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	id, err := h.uc.Create(c, appId, &req)
+	if err != nil {
+		h.log.Error("create tag rule error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "创建规则失败: "+err.Error()))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(id))
+}
+
+// Update 更新规则
+func (h *TagRuleHandler) Update(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	ruleId := ctx.Param("ruleId")
+	if err != nil || ruleId == "" {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+	var req request.TagRuleReq
+	if err := h.BindAndValidate(ctx, h.validator, &req); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	if err := h.uc.Update(c, appId, ruleId, &req); err != nil {
+		h.log.Error("update tag rule error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "更新规则失败: "+err.Error()))
+		return
+	}
+
+	ctx.JSON(200, r.Success())
+}
+
+// Delete 删除规则
+func (h *TagRuleHandler) Delete(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	ruleId := ctx.Param("ruleId")
+	if err != nil || ruleId == "" {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+
+	c := ctx
+	if err := h.uc.Delete(c, appId, ruleId); err != nil {
+		h.log.Error("delete tag rule error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "删除规则失败"))
+		return
+	}
+
+	ctx.JSON(200, r.Success())
+}
+
+// Query 规则列表
+func (h *TagRuleHandler) Query(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	rules, err := h.uc.Query(c, appId)
+	if err != nil {
+		h.log.Error("query tag rules error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "查询规则失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(rules))
+}
+
+// Sweep 立即对已有粉丝执行规则
+func (h *TagRuleHandler) Sweep(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	if err := h.uc.Sweep(c, appId); err != nil {
+		h.log.Error("sweep tag rules error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "执行规则失败"))
+		return
+	}
+
+	ctx.JSON(200, r.Success())
+}
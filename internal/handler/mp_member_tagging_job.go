@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"go.uber.org/zap"
+)
+
+// TaggingJobHandler 按文件批量打标签
+type TaggingJobHandler struct {
+	Base
+	log *zap.Logger
+	uc  *biz.TaggingJobUsecase
+}
+
+func NewTaggingJobHandler(log *zap.Logger, uc *biz.TaggingJobUsecase) *TaggingJobHandler {
+	return &TaggingJobHandler{log: log, uc: uc}
+}
+
+// Create 上传openid文件(csv, txt)并创建批量打标签任务
+func (h *TaggingJobHandler) Create(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	tagId, err := strconv.ParseInt(ctx.PostForm("tagid"), 10, 64)
+	if err != nil || tagId <= 0 {
+		ctx.JSON(400, r.Error(400, "tagid不能为空"))
+		return
+	}
+	// 上传文件
+	file, err := ctx.FormFile("file")
+	if err != nil || file == nil {
+		ctx.JSON(400, r.Error(400, "file not found"))
+		return
+	}
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if ext != ".csv" && ext != ".txt" {
+		ctx.JSON(400, r.Error(400, "file type not allowed"))
+		return
+	}
+	if file.Size > 5*1024*1024 {
+		ctx.JSON(400, r.Error(400, "file size too large"))
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		ctx.JSON(400, r.Error(400, "file open error"))
+		return
+	}
+	defer f.Close()
+
+	openids, err := biz.ParseOpenIdFile(f)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	if len(openids) == 0 {
+		ctx.JSON(400, r.Error(400, "文件中没有openid"))
+		return
+	}
+
+	c := ctx
+	job, err := h.uc.Create(c, appId, tagId, file.Filename, openids)
+	if err != nil {
+		h.log.Error("create tagging job error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "创建批量打标签任务失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(job))
+}
+
+// Get 查询任务详情
+func (h *TaggingJobHandler) Get(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	jobId := ctx.Param("jobId")
+	if err != nil || jobId == "" {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+
+	c := ctx
+	job, err := h.uc.Get(c, appId, jobId)
+	if err != nil {
+		h.log.Error("get tagging job error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "查询任务失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(job))
+}
+
+// Query 任务列表
+func (h *TaggingJobHandler) Query(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	jobs, err := h.uc.Query(c, appId)
+	if err != nil {
+		h.log.Error("query tagging jobs error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "查询任务失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(jobs))
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.uber.org/zap"
+)
+
+// MemberTimelineHandler 粉丝动态
+type MemberTimelineHandler struct {
+	Base
+	log *zap.Logger
+	uc  *biz.MemberTimelineUsecase
+}
+
+func NewMemberTimelineHandler(log *zap.Logger, uc *biz.MemberTimelineUsecase) *MemberTimelineHandler {
+	return &MemberTimelineHandler{log: log, uc: uc}
+}
+
+// Query 查询粉丝动态
+func (h *MemberTimelineHandler) Query(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	memberId := ctx.Param("memberId")
+	if err != nil || memberId == "" {
+		ctx.JSON(400, r.Error(400, "参数错误"))
+		return
+	}
+	var params request.MemberTimelineQuery
+	if err := ctx.ShouldBindQuery(&params); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	result, err := h.uc.Query(c, appId, memberId, &params)
+	if err != nil {
+		h.log.Error("query member timeline error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "查询粉丝动态失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(result))
+}
@@ -1,10 +1,16 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
 	"github.com/gin-gonic/gin"
 	"github.com/seth16888/wxbusiness/internal/biz"
 	"github.com/seth16888/wxbusiness/internal/model/r"
 	"github.com/seth16888/wxbusiness/internal/model/request"
+	"github.com/seth16888/wxbusiness/pkg/qrcode"
 	"github.com/seth16888/wxbusiness/pkg/validator"
 	"go.uber.org/zap"
 )
@@ -23,6 +29,18 @@ func NewQRCodeHandler(log *zap.Logger, uc *biz.MpQRCodeUsecase,
   return &QRCodeHandler{log:log, uc:uc, validator: validator}
 }
 
+// qrcodeErrorCode 生成二维码失败时的状态码，场景值冲突 409，永久二维码超过上限 403
+func qrcodeErrorCode(err error) int {
+	switch {
+	case errors.Is(err, biz.ErrQRSceneConflict):
+		return 409
+	case errors.Is(err, biz.ErrQRCodeQuota):
+		return 403
+	default:
+		return 400
+	}
+}
+
 // CreateTemporary
 func (h *QRCodeHandler) CreateTemporary(ctx *gin.Context) {
   // 路径参数
@@ -41,7 +59,8 @@ func (h *QRCodeHandler) CreateTemporary(ctx *gin.Context) {
   c := ctx
   res,err := h.uc.CreateTemporary(c, appId, &req)
   if err != nil {
-    ctx.JSON(400, r.Error(400, err.Error()))
+    code := qrcodeErrorCode(err)
+    ctx.JSON(code, r.Error(int64(code), err.Error()))
     return
   }
 
@@ -66,7 +85,8 @@ func (h *QRCodeHandler) CreateLimit(ctx *gin.Context) {
   c := ctx
   res,err := h.uc.CreateLimit(c, appId, &req)
   if err != nil {
-    ctx.JSON(400, r.Error(400, err.Error()))
+    code := qrcodeErrorCode(err)
+    ctx.JSON(code, r.Error(int64(code), err.Error()))
     return
   }
 
@@ -75,12 +95,6 @@ func (h *QRCodeHandler) CreateLimit(ctx *gin.Context) {
 
 // GetURL
 func (h *QRCodeHandler) GetURL(ctx *gin.Context) {
-  // 请求参数
-  var req request.CreateQRCodeReq
-  if err := h.BindAndValidate(ctx, h.validator, &req);err!=nil {
-    ctx.JSON(400, r.Error(400, err.Error()))
-    return
-  }
   // query 参数
   ticket := ctx.Query("ticket")
   if ticket == "" {
@@ -93,3 +107,181 @@ func (h *QRCodeHandler) GetURL(ctx *gin.Context) {
 
   ctx.JSON(200, r.SuccessData(url))
 }
+
+// Query 查询已生成的二维码，可按批次、负责人筛选，keyword 匹配场景值和名称
+func (h *QRCodeHandler) Query(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var params request.QRCodeQuery
+	if err := ctx.ShouldBindQuery(&params); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	result, err := h.uc.Query(c, appId, &params)
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(result))
+}
+
+// Image 在本地渲染二维码图片，返回 PNG 或 SVG
+func (h *QRCodeHandler) Image(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var params request.QRCodeImageQuery
+	if err := ctx.ShouldBindQuery(&params); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	code, err := h.uc.Get(c, appId, ctx.Param("qrcodeId"))
+	if err != nil {
+		ctx.JSON(404, r.Error(404, "二维码不存在"))
+		return
+	}
+	img, err := h.uc.Image(c, appId, code, &params)
+	if err != nil {
+		switch {
+		case errors.Is(err, qrcode.ErrInvalidOptions):
+			ctx.JSON(400, r.Error(400, err.Error()))
+		case errors.Is(err, biz.ErrQRCodeExpired):
+			ctx.JSON(410, r.Error(410, "二维码已过期"))
+		default:
+			ctx.JSON(500, r.Error(500, err.Error()))
+		}
+		return
+	}
+	ctx.Header("Cache-Control", "private, max-age=3600")
+	ctx.Data(200, img.ContentType, img.Data)
+}
+
+// CreateBatch 批量生成二维码，任务在后台执行
+func (h *QRCodeHandler) CreateBatch(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	// 请求参数
+	var req request.CreateQRCodeBatchReq
+	if err := h.BindAndValidate(ctx, h.validator, &req); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	batch, err := h.uc.CreateBatch(c, appId, &req)
+	if err != nil {
+		code := qrcodeErrorCode(err)
+		ctx.JSON(code, r.Error(int64(code), err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(batch))
+}
+
+// QueryBatches 批次列表
+func (h *QRCodeHandler) QueryBatches(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	batches, err := h.uc.QueryBatches(c, appId)
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(batches))
+}
+
+// GetBatch 批次详情，包含失败明细
+func (h *QRCodeHandler) GetBatch(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	batchId := ctx.Param("batchId")
+
+	c := ctx
+	batch, err := h.uc.GetBatch(c, appId, batchId)
+	if err != nil {
+		ctx.JSON(404, r.Error(404, "批次不存在"))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(batch))
+}
+
+// ExportBatch 下载批次的二维码图片和清单，zip 格式
+func (h *QRCodeHandler) ExportBatch(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	batchId := ctx.Param("batchId")
+
+	var params request.QRCodeImageQuery
+	if err := ctx.ShouldBindQuery(&params); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	if _, err := h.uc.GetBatch(c, appId, batchId); err != nil {
+		ctx.JSON(404, r.Error(404, "批次不存在"))
+		return
+	}
+
+	// 先写入临时文件，导出失败时仍可返回错误，不会返回截断的文件
+	tmp, err := os.CreateTemp("", "qrcodes_*.zip")
+	if err != nil {
+		h.log.Error("create temp file error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "导出二维码失败"))
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := h.uc.ExportBatch(c, appId, batchId, &params, tmp); err != nil {
+		h.log.Error("export qrcode batch error", zap.Error(err))
+		if errors.Is(err, qrcode.ErrInvalidOptions) {
+			ctx.JSON(400, r.Error(400, err.Error()))
+			return
+		}
+		ctx.JSON(500, r.Error(500, "导出二维码失败"))
+		return
+	}
+	info, err := tmp.Stat()
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		h.log.Error("read export file error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "导出二维码失败"))
+		return
+	}
+
+	filename := fmt.Sprintf("qrcodes_%s.zip", batchId)
+	ctx.DataFromReader(200, info.Size(), "application/zip", tmp, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%s", filename),
+	})
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"github.com/seth16888/wxbusiness/pkg/validator"
+	"go.uber.org/zap"
+)
+
+// QRSceneHandler 二维码场景值登记
+type QRSceneHandler struct {
+	Base
+	log       *zap.Logger
+	uc        *biz.QRSceneUsecase
+	validator *validator.Validator
+}
+
+func NewQRSceneHandler(log *zap.Logger, uc *biz.QRSceneUsecase,
+	validator *validator.Validator,
+) *QRSceneHandler {
+	return &QRSceneHandler{log: log, uc: uc, validator: validator}
+}
+
+// Query 登记的场景值，keyword 匹配场景值和名称
+func (h *QRSceneHandler) Query(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var params request.QRSceneQuery
+	if err := ctx.ShouldBindQuery(&params); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	result, err := h.uc.Query(c, appId, &params)
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(result))
+}
+
+// Register 预先登记场景值
+func (h *QRSceneHandler) Register(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	// 请求参数
+	var req request.QRSceneReq
+	if err := h.BindAndValidate(ctx, h.validator, &req); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	scene, err := h.uc.Register(c, appId, &req)
+	if err != nil {
+		if errors.Is(err, biz.ErrQRSceneConflict) {
+			ctx.JSON(409, r.Error(409, err.Error()))
+			return
+		}
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(scene))
+}
+
+// Update 修改场景值的名称、说明和负责人
+func (h *QRSceneHandler) Update(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	// 请求参数
+	var req request.UpdateQRSceneReq
+	if err := h.BindAndValidate(ctx, h.validator, &req); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	scene, err := h.uc.Update(c, appId, ctx.Param("sceneId"), &req)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(scene))
+}
+
+// Usage 永久二维码的数量上限和已使用数量
+func (h *QRSceneHandler) Usage(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	usage, err := h.uc.Usage(c, appId)
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(usage))
+}
+
+// Channels 按关注时扫码的场景值统计粉丝数，对应到登记的渠道
+func (h *QRSceneHandler) Channels(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	channels, err := h.uc.Channels(c, appId)
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(channels))
+}
+
+// Rebuild 从已生成的二维码登记缺少的场景值，并释放过期的永久二维码数量占用
+func (h *QRSceneHandler) Rebuild(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	result, err := h.uc.Rebuild(c, appId)
+	if err != nil {
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(result))
+}
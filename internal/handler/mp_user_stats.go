@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"go.uber.org/zap"
+)
+
+// UserStatsHandler 公众号数据统计
+type UserStatsHandler struct {
+	Base
+	log *zap.Logger
+	uc  *biz.UserStatsUsecase
+}
+
+func NewUserStatsHandler(log *zap.Logger, uc *biz.UserStatsUsecase) *UserStatsHandler {
+	return &UserStatsHandler{log: log, uc: uc}
+}
+
+// Query 每日用户增减和累计用户数据，由 stats 定时任务导入
+func (h *UserStatsHandler) Query(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var params request.UserStatsQuery
+	if err := ctx.ShouldBindQuery(&params); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	stats, err := h.uc.Query(c, appId, params.Begin, params.End)
+	if err != nil {
+		if errors.Is(err, biz.ErrInvalidDateRange) {
+			ctx.JSON(400, r.Error(400, err.Error()))
+			return
+		}
+		ctx.JSON(500, r.Error(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, r.SuccessData(stats))
+}
@@ -16,15 +16,16 @@ import (
 
 type PortalHandler struct {
 	Base
-	validator *validator.Validator
-	log       *zap.Logger
-	uc        *biz.PortalUsecase
+	validator  *validator.Validator
+	log        *zap.Logger
+	uc         *biz.PortalUsecase
+	dispatcher *message.Dispatcher
 }
 
 func NewPortalHandler(log *zap.Logger, validator *validator.Validator,
-	uc *biz.PortalUsecase,
+	uc *biz.PortalUsecase, dispatcher *message.Dispatcher,
 ) *PortalHandler {
-	return &PortalHandler{log: log, validator: validator, uc: uc}
+	return &PortalHandler{log: log, validator: validator, uc: uc, dispatcher: dispatcher}
 }
 
 // Verify
@@ -145,6 +146,9 @@ func (h *PortalHandler) Portal(ctx *gin.Context) {
 		return
 	}
 
+	// 分发给订阅者：自动打标签等
+	h.dispatcher.Publish(mpAPP, msgDomain.Message())
+
 	resultChan := message.MessageWorker(msgDomain)
 	select {
 	case result := <-resultChan:
@@ -153,6 +157,7 @@ func (h *PortalHandler) Portal(ctx *gin.Context) {
 			return
 		} else {
 			logger.Debugf("reply: %s", string(result))
+			h.dispatcher.PublishReply(mpAPP, msgDomain.Message(), result)
 			ctx.Writer.Header().Set("Content-Type", "text/xml;charset=utf-8")
 			ctx.Writer.WriteHeader(200)
 			ctx.Writer.Write(result)
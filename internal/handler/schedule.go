@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/model/r"
+	"github.com/seth16888/wxbusiness/internal/model/request"
+	"github.com/seth16888/wxbusiness/pkg/validator"
+	"go.uber.org/zap"
+)
+
+// ScheduleHandler 定时任务配置
+type ScheduleHandler struct {
+	Base
+	log       *zap.Logger
+	uc        *biz.ScheduleUsecase
+	validator *validator.Validator
+}
+
+func NewScheduleHandler(log *zap.Logger, uc *biz.ScheduleUsecase,
+	validator *validator.Validator,
+) *ScheduleHandler {
+	return &ScheduleHandler{log: log, uc: uc, validator: validator}
+}
+
+// Get 获取定时任务配置及最近一次执行结果
+func (h *ScheduleHandler) Get(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	schedules, runs, err := h.uc.GetSchedules(c, appId)
+	if err != nil {
+		h.log.Error("get schedules error", zap.Error(err))
+		ctx.JSON(500, r.Error(500, "获取定时任务失败"))
+		return
+	}
+
+	ctx.JSON(200, r.SuccessData(gin.H{"schedules": schedules, "runs": runs}))
+}
+
+// Update 更新定时任务配置
+//
+// job: member 同步粉丝, tag 同步标签, blacklist 同步黑名单, material 同步永久素材, stats 导入数据统计,
+// tag_rule 执行自动打标签规则, engagement 计算粉丝活跃度, temporary_media 刷新临时素材,
+// inbound_media 重新保存粉丝媒体消息
+// spec: 标准 cron 表达式，如 "0 3 * * *"
+func (h *ScheduleHandler) Update(ctx *gin.Context) {
+	// 路径参数
+	appId, err := h.GetPID(ctx)
+	if err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+	var req request.UpdateSchedulesReq
+	if err := h.BindAndValidate(ctx, h.validator, &req); err != nil {
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	c := ctx
+	if err := h.uc.UpdateSchedules(c, appId, &req); err != nil {
+		h.log.Error("update schedules error", zap.Error(err))
+		ctx.JSON(400, r.Error(400, err.Error()))
+		return
+	}
+
+	ctx.JSON(200, r.Success())
+}
@@ -83,6 +83,11 @@ func (domain *MessageDomain) Unmarshal() error {
 	return nil
 }
 
+// Message 返回解析后的消息
+func (domain *MessageDomain) Message() *MixMessage {
+	return domain.mixMessage
+}
+
 // MarshalReply
 func (domain *MessageDomain) MarshalReply(reply interface{}) ([]byte, error) {
 	bytes, err := xml.Marshal(reply)
@@ -0,0 +1,121 @@
+package message
+
+import (
+	"context"
+	"time"
+
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/pkg/logger"
+)
+
+// Subscriber 订阅公众号推送的消息和事件
+type Subscriber interface {
+	OnMessage(c context.Context, app *entities.PlatformApp, msg *MixMessage) error
+}
+
+// ReplySubscriber 订阅被动回复的消息
+type ReplySubscriber interface {
+	OnReply(c context.Context, app *entities.PlatformApp, msg *MixMessage, reply []byte) error
+}
+
+const (
+	dispatchWorkers = 32   // 同时执行的订阅者数量
+	dispatchQueue   = 4096 // 等待执行的数量，队列满时丢弃并记录日志
+)
+
+// Dispatcher 将推送的消息分发给订阅者
+//
+// 订阅者由固定数量的 worker 在后台执行，不影响被动回复的时效。
+type Dispatcher struct {
+	subscribers []Subscriber
+	timeout     time.Duration
+	tasks       chan *task
+}
+
+type task struct {
+	app *entities.PlatformApp
+	fn  func(c context.Context) error
+}
+
+func NewDispatcher(timeout time.Duration) *Dispatcher {
+	d := &Dispatcher{timeout: timeout, tasks: make(chan *task, dispatchQueue)}
+	for i := 0; i < dispatchWorkers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// Subscribe 注册订阅者，需在启动时调用
+func (d *Dispatcher) Subscribe(s Subscriber) {
+	d.subscribers = append(d.subscribers, s)
+}
+
+// Publish 分发消息
+func (d *Dispatcher) Publish(app *entities.PlatformApp, msg *MixMessage) {
+	if d == nil || len(d.subscribers) == 0 {
+		return
+	}
+	for _, s := range d.subscribers {
+		d.run(app, func(c context.Context) error {
+			return s.OnMessage(c, app, msg)
+		})
+	}
+}
+
+// PublishReply 分发被动回复的消息
+func (d *Dispatcher) PublishReply(app *entities.PlatformApp, msg *MixMessage, reply []byte) {
+	if d == nil || len(reply) == 0 {
+		return
+	}
+	for _, s := range d.subscribers {
+		if rs, ok := s.(ReplySubscriber); ok {
+			d.run(app, func(c context.Context) error {
+				return rs.OnReply(c, app, msg, reply)
+			})
+		}
+	}
+}
+
+func (d *Dispatcher) run(app *entities.PlatformApp, fn func(c context.Context) error) {
+	select {
+	case d.tasks <- &task{app: app, fn: fn}:
+	default:
+		logger.Errorf("message dispatcher queue full, dropped: %s", app.MpId)
+	}
+}
+
+func (d *Dispatcher) work() {
+	for t := range d.tasks {
+		d.exec(t)
+	}
+}
+
+func (d *Dispatcher) exec(t *task) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("message subscriber panic: %s, %v", t.app.MpId, r)
+		}
+	}()
+	// 请求结束后 gin.Context 会被复用，使用新的 context
+	c := context.WithValue(context.Background(), "APP", t.app)
+	c = context.WithValue(c, "MP_ID", t.app.MpId)
+	c, cancel := context.WithTimeout(c, d.timeout)
+	defer cancel()
+
+	if err := t.fn(c); err != nil {
+		logger.Errorf("message subscriber error: %s, %v", t.app.MpId, err)
+	}
+}
+
+// IsInteraction 是否为粉丝的主动互动，不包括取消关注和各种任务完成通知
+func (msg *MixMessage) IsInteraction() bool {
+	if msg.MsgType != MsgTypeEvent {
+		return true
+	}
+	switch msg.Event {
+	case EventUnsubscribe, EventTemplateSendJobFinish, EventMassSendJobFinish,
+		EventWxaMediaCheck, EventPublishJobFinish:
+		return false
+	}
+	return true
+}
@@ -1,8 +1,9 @@
 package model
 
 type PageResult[T any] struct {
-	List  []T   `json:"list"`
-	Total int64 `json:"total"`
+	List       []T    `json:"list"`
+	Total      int64  `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"` // 游标分页时下一页的游标
 }
 
 func GetLimitOffset(pageNum int, pageSize int) (offset int, limit int) {
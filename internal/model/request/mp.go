@@ -3,7 +3,61 @@ package request
 // CreateQRCodeReq 创建公众号二维码
 type CreateQRCodeReq struct {
 	Exp   int    `json:"expire_seconds" binding:"omitempty,min=60,max=2592000" msg:"expire_seconds,min=60,max=2592000"`
-	Scene string `json:"scene" binding:"required" msg:"scene required"`
+	Scene string `json:"scene" binding:"required,max=64" msg:"scene required, max=64"`
+	Label string `json:"label" binding:"omitempty,max=64" msg:"label max=64"`
+	Owner string `json:"owner" binding:"omitempty,max=64" msg:"owner max=64"`
+}
+
+// CreateQRCodeBatchReq 批量生成二维码
+type CreateQRCodeBatchReq struct {
+	Permanent bool   `json:"permanent"`
+	Exp       int    `json:"expire_seconds" binding:"omitempty,min=60,max=2592000" msg:"expire_seconds,min=60,max=2592000"`
+	Template  string `json:"template" binding:"required,max=64,contains={n}" msg:"template required, must contain {n}"`
+	Start     int    `json:"start" binding:"min=0" msg:"start min=0"`
+	Count     int    `json:"count" binding:"required,min=1,max=1000" msg:"count required, 1-1000"`
+	Label     string `json:"label" binding:"omitempty,max=64" msg:"label max=64"`
+	Owner     string `json:"owner" binding:"omitempty,max=64" msg:"owner max=64"`
+}
+
+// QRCodeQuery 二维码查询
+type QRCodeQuery struct {
+	PagingQuery
+	BatchId   string `json:"batch_id" form:"batch_id"`
+	Keyword   string `json:"keyword" form:"keyword"` // 匹配场景值、名称
+	Owner     string `json:"owner" form:"owner"`
+	Permanent *bool  `json:"permanent" form:"permanent"`
+}
+
+// QRSceneReq 登记场景值
+type QRSceneReq struct {
+	Scene       string `json:"scene" binding:"required,max=64" msg:"scene required, max=64"`
+	Name        string `json:"name" binding:"required,max=64" msg:"name required, max=64"`
+	Description string `json:"description" binding:"omitempty,max=256" msg:"description max=256"`
+	Owner       string `json:"owner" binding:"omitempty,max=64" msg:"owner max=64"`
+	Permanent   bool   `json:"permanent"`
+}
+
+// UpdateQRSceneReq 修改场景值的名称和说明，场景值和二维码类型不能修改
+type UpdateQRSceneReq struct {
+	Name        string `json:"name" binding:"required,max=64" msg:"name required, max=64"`
+	Description string `json:"description" binding:"omitempty,max=256" msg:"description max=256"`
+	Owner       string `json:"owner" binding:"omitempty,max=64" msg:"owner max=64"`
+}
+
+// QRSceneQuery 场景值查询
+type QRSceneQuery struct {
+	PagingQuery
+	Keyword   string `json:"keyword" form:"keyword"` // 匹配场景值、名称
+	Permanent *bool  `json:"permanent" form:"permanent"`
+}
+
+// QRCodeImageQuery 二维码图片渲染参数
+type QRCodeImageQuery struct {
+	Format  string `json:"format" form:"format"`   // png(默认), svg
+	Size    int    `json:"size" form:"size"`       // 边长，像素，64-2048，默认430
+	Level   string `json:"level" form:"level"`     // 纠错等级 L, M(默认), Q, H，添加 logo 时建议 H
+	Logo    string `json:"logo" form:"logo"`       // 居中 logo 使用的图片素材，素材ID或 media_id
+	Caption string `json:"caption" form:"caption"` // 二维码下方的文字，支持 {scene}、{label}
 }
 
 // PullMaterialReq 拉取永久素材
@@ -12,3 +66,21 @@ type PullMaterialReq struct {
 	Offset int64  `json:"offset" binding:"required" msg:"offset required"`
 	Count  int64  `json:"count" binding:"required" msg:"count required"`
 }
+
+// UserStatsQuery 每日用户数据查询，日期格式 2006-01-02
+type UserStatsQuery struct {
+	Begin string `form:"begin"`
+	End   string `form:"end"`
+}
+
+// ScheduleItem 定时任务配置
+type ScheduleItem struct {
+	Job     string `json:"job" binding:"required" msg:"job required"`   // 任务类型，见 biz.allowedJobs
+	Spec    string `json:"spec" binding:"required" msg:"spec required"` // cron 表达式
+	Enabled bool   `json:"enabled"`
+}
+
+// UpdateSchedulesReq 更新定时任务配置
+type UpdateSchedulesReq struct {
+	Schedules []*ScheduleItem `json:"schedules"`
+}
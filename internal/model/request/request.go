@@ -1,5 +1,7 @@
 package request
 
+import "github.com/seth16888/wxbusiness/internal/data/entities"
+
 // VerifyPortalReq 开发接口验证请求
 type VerifyPortalReq struct {
 	AppID     string `form:"appId"`
@@ -57,7 +59,82 @@ type PagingQuery struct {
 	PageSize int64 `json:"page_size" form:"page_size"`
 }
 
+// MPMemberFilter 粉丝筛选条件，定义在 entities 中，分群(MemberSegment)保存相同的条件
+type MPMemberFilter = entities.MemberFilter
+
 type MPMemberQuery struct {
 	PagingQuery
-	TagId int64 `json:"tag_id" form:"tag_id"`
+	MPMemberFilter
+	SortBy    string `json:"sort_by" form:"sort_by"`       // 排序字段: subscribe_time, created_at, updated_at, last_message_at, message_count, engagement
+	SortOrder string `json:"sort_order" form:"sort_order"` // asc, desc(默认)
+	Cursor    string `json:"cursor" form:"cursor"`         // 游标分页，上一页返回的 next_cursor，设置后忽略 page_no
+}
+
+// BlackListHistoryQuery 黑名单操作记录查询
+type BlackListHistoryQuery struct {
+	PagingQuery
+	OpenId string `json:"openid" form:"openid"`
+	Action string `json:"action" form:"action"` // block, unblock
+}
+
+// MemberTimelineQuery 粉丝动态查询
+type MemberTimelineQuery struct {
+	PagingQuery
+	Types []string `json:"types" form:"types"` // 动态类型，为空时返回全部
+	From  int64    `json:"from" form:"from"`   // 时间范围，时间戳
+	To    int64    `json:"to" form:"to"`
+}
+
+// MemberAttributeDefReq 创建、更新粉丝自定义属性
+type MemberAttributeDefReq struct {
+	Key      string   `json:"key"` // 创建时必填，更新时忽略
+	Name     string   `json:"name" binding:"required" msg:"name required"`
+	Type     string   `json:"type" binding:"required" msg:"type required"` // string, number, date, enum
+	Options  []string `json:"options"`
+	Required bool     `json:"required"`
+}
+
+// UpdateMemberAttributesReq 设置粉丝自定义属性，值为 null 时删除
+type UpdateMemberAttributesReq struct {
+	Attributes map[string]any `json:"attributes" binding:"required" msg:"attributes required"`
+}
+
+// MemberErasureReq 删除粉丝数据，openid 和 unionid 二选一
+type MemberErasureReq struct {
+	OpenId  string `json:"openid"`
+	UnionId string `json:"unionid"`
+	Mode    string `json:"mode"` // delete(默认), anonymize
+	Reason  string `json:"reason" binding:"required" msg:"reason required"`
+	DryRun  bool   `json:"dry_run"` // 只列出将被处理的数据
+}
+
+// MemberSegmentReq 创建、更新粉丝分群
+type MemberSegmentReq struct {
+	Name        string         `json:"name" binding:"required" msg:"name required"`
+	Description string         `json:"description"`
+	Filter      MPMemberFilter `json:"filter"`
+}
+
+// TagRuleReq 创建、更新自动打标签规则
+type TagRuleReq struct {
+	Name    string `json:"name" binding:"required" msg:"name required"`
+	TagId   int64  `json:"tag_id" binding:"required" msg:"tag_id required"`
+	Type    string `json:"type" binding:"required" msg:"type required"` // qr_scene, keyword, menu_click, province, inactive
+	Value   string `json:"value"`                                       // 场景值、关键词、菜单KEY、省份
+	Days    int    `json:"days"`                                        // inactive: 未互动天数
+	Action  string `json:"action"`                                      // add(默认), remove
+	Enabled bool   `json:"enabled"`
+}
+
+// InboundMediaQuery 粉丝发送的媒体消息查询
+type InboundMediaQuery struct {
+	PagingQuery
+	OpenId  string `json:"openid" form:"openid"`
+	MsgType string `json:"msg_type" form:"msg_type"` // image, voice, video, shortvideo
+}
+
+// UnusedMaterialQuery 未被引用的永久素材查询
+type UnusedMaterialQuery struct {
+	PagingQuery
+	Type string `json:"type" form:"type"` // image, voice, video, thumb, news
 }
@@ -46,7 +46,8 @@ func registerRoutes(r *gin.Engine, deps *di.Container) {
 		// v1/portal/:id
 		portGrp := v1.Group("/portal")
 		{
-			portalCtr := handler.NewPortalHandler(deps.Log, deps.Validator, deps.PortalUsecase)
+			portalCtr := handler.NewPortalHandler(deps.Log, deps.Validator, deps.PortalUsecase,
+				deps.MessageDispatcher)
 			portGrp.GET("/:id", portalCtr.Verify)
 			portGrp.POST("/:id", portalCtr.Portal)
 		}
@@ -82,6 +83,25 @@ func registerRoutes(r *gin.Engine, deps *di.Container) {
 					tagGrp.DELETE("/:tagId", tagCtr.Delete)
 					tagGrp.POST("/pull", tagCtr.Pull)
 				}
+				// v1/apps/:id/tag-rules
+				tagRuleGrp := appGrp.Group("/tag-rules")
+				{
+					tagRuleCtr := handler.NewTagRuleHandler(deps.Log, deps.TagRuleUsecase, deps.Validator)
+					tagRuleGrp.GET("", tagRuleCtr.Query)
+					tagRuleGrp.POST("", tagRuleCtr.Create)
+					tagRuleGrp.PUT("/:ruleId", tagRuleCtr.Update)
+					tagRuleGrp.DELETE("/:ruleId", tagRuleCtr.Delete)
+					tagRuleGrp.POST("/sweep", tagRuleCtr.Sweep)
+				}
+				// v1/apps/:id/member-attributes
+				attrGrp := appGrp.Group("/member-attributes")
+				{
+					attrCtr := handler.NewMemberAttributeHandler(deps.Log, deps.MemberAttributeUsecase, deps.Validator)
+					attrGrp.GET("", attrCtr.Query)
+					attrGrp.POST("", attrCtr.Create)
+					attrGrp.PUT("/:attrId", attrCtr.Update)
+					attrGrp.DELETE("/:attrId", attrCtr.Delete)
+				}
 				// v1/apps/:id/members
 				memberGrp := appGrp.Group("/members")
 				{
@@ -96,18 +116,85 @@ func registerRoutes(r *gin.Engine, deps *di.Container) {
 					memberGrp.POST("/blacklist/block", memberCtr.BatchBlock)
 					memberGrp.POST("/blacklist/unblock", memberCtr.BatchUnblock)
           memberGrp.POST("/blacklist/pull", memberCtr.PullBlackList)
+					memberGrp.GET("/blacklist/records", memberCtr.GetBlackListRecords)
+					memberGrp.GET("/blacklist/history", memberCtr.GetBlackListHistory)
 					memberGrp.POST("/pull", memberCtr.Pull)
+
+					// v1/apps/:id/members/segments
+					segmentCtr := handler.NewMemberSegmentHandler(deps.Log, deps.MemberSegmentUsecase, deps.Validator)
+					memberGrp.GET("/segments", segmentCtr.Query)
+					memberGrp.POST("/segments", segmentCtr.Create)
+					memberGrp.PUT("/segments/:segmentId", segmentCtr.Update)
+					memberGrp.DELETE("/segments/:segmentId", segmentCtr.Delete)
+					memberGrp.GET("/segments/:segmentId/members", segmentCtr.QueryMembers)
+					memberGrp.POST("/segments/:segmentId/tagging", segmentCtr.Tagging)
+
+					// v1/apps/:id/members/export
+					exportCtr := handler.NewMemberExportHandler(deps.Log, deps.MemberExportUsecase)
+					memberGrp.GET("/export", exportCtr.Export)
+
+					// v1/apps/:id/members/tagging-jobs
+					taggingJobCtr := handler.NewTaggingJobHandler(deps.Log, deps.TaggingJobUsecase)
+					memberGrp.GET("/tagging-jobs", taggingJobCtr.Query)
+					memberGrp.POST("/tagging-jobs", taggingJobCtr.Create)
+					memberGrp.GET("/tagging-jobs/:jobId", taggingJobCtr.Get)
+
+					// v1/apps/:id/members/:memberId/timeline
+					timelineCtr := handler.NewMemberTimelineHandler(deps.Log, deps.MemberTimelineUsecase)
+					memberGrp.GET("/:memberId/timeline", timelineCtr.Query)
+
+					// v1/apps/:id/members/attributes
+					memberAttrCtr := handler.NewMemberAttributeHandler(deps.Log, deps.MemberAttributeUsecase, deps.Validator)
+					memberGrp.POST("/attributes/import", memberAttrCtr.Import)
+					memberGrp.PUT("/:memberId/attributes", memberAttrCtr.SetMemberAttributes)
+
+					// v1/apps/:id/members/engagement
+					engageCtr := handler.NewEngagementHandler(deps.Log, deps.EngagementUsecase)
+					memberGrp.POST("/engagement/refresh", engageCtr.Refresh)
+
+					// v1/apps/:id/members/erasure，未启用时不注册
+					if deps.MemberErasureUsecase != nil {
+						erasureCtr := handler.NewMemberErasureHandler(deps.Log, deps.MemberErasureUsecase, deps.Validator)
+						memberGrp.GET("/erasure", erasureCtr.QueryAudits)
+						memberGrp.POST("/erasure", erasureCtr.Erase)
+					}
+				}
+				// v1/apps/:id/identities
+				identityGrp := appGrp.Group("/identities")
+				{
+					identityCtr := handler.NewMemberIdentityHandler(deps.Log, deps.MemberIdentityUsecase)
+					identityGrp.GET("/unionid/:unionId", identityCtr.GetByUnionId)
+					identityGrp.GET("/openid/:openid", identityCtr.GetByOpenId)
+					identityGrp.POST("/rebuild", identityCtr.Rebuild)
 				}
 				// v1/apps/:id/materials
 				materialGrp := appGrp.Group("/materials")
 				{
-					maCtr := handler.NewMaterialHandler(deps.Log, deps.MaterialUsecase)
+					maCtr := handler.NewMaterialHandler(deps.Log, deps.MaterialUsecase,
+						deps.MaterialQuotaUsecase)
 					materialGrp.POST("/temporary", maCtr.UploadTemporaryMedia)
+					materialGrp.POST("/temporary/refresh", maCtr.RefreshTemporary)
+					materialGrp.GET("/resolve/:ref", maCtr.ResolveMediaId)
+					materialGrp.GET("/content/:mediaId", maCtr.Download)
 					materialGrp.POST("/news_image", maCtr.UploadNewsImage)
 					materialGrp.POST("/limit", maCtr.UploadMedia)
 					materialGrp.POST("/list", maCtr.GetMaterialList)
 					materialGrp.DELETE("/:mediaId", maCtr.DeleteMaterial)
           materialGrp.POST("/pull", maCtr.Pull)
+					materialGrp.POST("/sync", maCtr.Sync)
+					materialGrp.GET("/count", maCtr.Count)
+					materialGrp.GET("/usage", maCtr.Usage)
+					materialGrp.GET("/unused", maCtr.Unused)
+
+					// v1/apps/:id/materials/inbound
+					inboundCtr := handler.NewInboundMediaHandler(deps.Log, deps.InboundMediaUsecase)
+					materialGrp.GET("/inbound", inboundCtr.Query)
+					materialGrp.GET("/inbound/:inboundId/content", inboundCtr.Content)
+
+					// v1/apps/:id/materials/refs
+					refCtr := handler.NewMaterialRefHandler(deps.Log, deps.MaterialRefUsecase)
+					materialGrp.GET("/refs", refCtr.Query)
+					materialGrp.POST("/refs/rebuild", refCtr.Rebuild)
 				}
         qrcodeGrp := appGrp.Group("/qrcode")
         {
@@ -115,7 +202,35 @@ func registerRoutes(r *gin.Engine, deps *di.Container) {
           qrcodeGrp.POST("/temporary", qrcodeCtr.CreateTemporary)
           qrcodeGrp.POST("/limit", qrcodeCtr.CreateLimit)
           qrcodeGrp.GET("/url", qrcodeCtr.GetURL)
+					qrcodeGrp.GET("", qrcodeCtr.Query)
+					qrcodeGrp.GET("/batches", qrcodeCtr.QueryBatches)
+					qrcodeGrp.POST("/batches", qrcodeCtr.CreateBatch)
+					qrcodeGrp.GET("/batches/:batchId", qrcodeCtr.GetBatch)
+					qrcodeGrp.GET("/batches/:batchId/export", qrcodeCtr.ExportBatch)
+					qrcodeGrp.GET("/codes/:qrcodeId/image", qrcodeCtr.Image)
+
+					// v1/apps/:id/qrcode/scenes
+					sceneCtr := handler.NewQRSceneHandler(deps.Log, deps.QRSceneUsecase, deps.Validator)
+					qrcodeGrp.GET("/scenes", sceneCtr.Query)
+					qrcodeGrp.POST("/scenes", sceneCtr.Register)
+					qrcodeGrp.PUT("/scenes/:sceneId", sceneCtr.Update)
+					qrcodeGrp.GET("/scenes/usage", sceneCtr.Usage)
+					qrcodeGrp.GET("/scenes/channels", sceneCtr.Channels)
+					qrcodeGrp.POST("/scenes/rebuild", sceneCtr.Rebuild)
         }
+				// v1/apps/:id/stats
+				statsGrp := appGrp.Group("/stats")
+				{
+					statsCtr := handler.NewUserStatsHandler(deps.Log, deps.UserStatsUsecase)
+					statsGrp.GET("/users", statsCtr.Query)
+				}
+				// v1/apps/:id/schedules
+				scheduleGrp := appGrp.Group("/schedules")
+				{
+					scheduleCtr := handler.NewScheduleHandler(deps.Log, deps.ScheduleUsecase, deps.Validator)
+					scheduleGrp.GET("", scheduleCtr.Get)
+					scheduleGrp.PUT("", scheduleCtr.Update)
+				}
 			}
 		}
 	}
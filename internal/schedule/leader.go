@@ -0,0 +1,85 @@
+package schedule
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/seth16888/wxbusiness/pkg/redis"
+	"go.uber.org/zap"
+)
+
+// Leader 基于 redis 的主节点选举
+//
+// 多个实例竞争同一个 key，获得 key 的实例为主节点，并在过期前续期。
+// 只有主节点执行定时任务。
+type Leader struct {
+	log      *zap.Logger
+	rds      *redis.RedisClient
+	key      string
+	instance string
+	ttl      time.Duration
+	isLeader atomic.Bool
+	elected  func(ctx context.Context) // 成为主节点时调用
+}
+
+func NewLeader(log *zap.Logger, rds *redis.RedisClient, key string,
+	instance string, ttl time.Duration,
+) *Leader {
+	return &Leader{log: log, rds: rds, key: key, instance: instance, ttl: ttl}
+}
+
+// OnElected 设置成为主节点时执行的函数，需在 Run 之前设置
+func (l *Leader) OnElected(fn func(ctx context.Context)) {
+	l.elected = fn
+}
+
+// IsLeader 当前实例是否为主节点
+func (l *Leader) IsLeader() bool {
+	return l.isLeader.Load()
+}
+
+// Run 参与选举，直到 ctx 结束，结束时主动释放主节点
+func (l *Leader) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	l.campaign(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			if l.isLeader.Load() {
+				if _, err := l.rds.CompareAndDel(l.key, l.instance); err != nil {
+					l.log.Error("release leader error", zap.Error(err))
+				}
+				l.isLeader.Store(false)
+			}
+			return
+		case <-ticker.C:
+			l.campaign(ctx)
+		}
+	}
+}
+
+func (l *Leader) campaign(ctx context.Context) {
+	var ok bool
+	var err error
+	if l.isLeader.Load() {
+		ok, err = l.rds.CompareAndExpire(l.key, l.instance, l.ttl)
+	} else {
+		ok, err = l.rds.SetNX(l.key, l.instance, l.ttl)
+	}
+	if err != nil { // redis 不可用时放弃主节点，避免多个实例同时执行
+		ok = false
+	}
+
+	changed := ok != l.isLeader.Load()
+	if changed {
+		l.log.Info("schedule leader changed", zap.String("instance", l.instance),
+			zap.Bool("leader", ok))
+	}
+	l.isLeader.Store(ok)
+	if changed && ok && l.elected != nil {
+		go l.elected(ctx)
+	}
+}
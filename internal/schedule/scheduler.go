@@ -0,0 +1,198 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/seth16888/wxbusiness/internal/biz"
+	"github.com/seth16888/wxbusiness/internal/data/entities"
+	"github.com/seth16888/wxbusiness/pkg/helpers"
+	"github.com/seth16888/wxbusiness/pkg/redis"
+	"go.uber.org/zap"
+)
+
+const leaderKey = "wxbusiness:schedule:leader"
+
+// Scheduler 平台应用定时任务调度
+//
+// 每个实例都会定时加载平台应用的定时任务配置，只有选举为主节点的实例会执行任务。
+type Scheduler struct {
+	log            *zap.Logger
+	uc             *biz.ScheduleUsecase
+	leader         *Leader
+	cron           *cron.Cron
+	instance       string
+	reloadInterval time.Duration
+
+	recoveries []func(ctx context.Context) // 成为主节点时执行
+
+	mu      sync.Mutex
+	entries map[string]*entry // 平台应用ID:任务类型 => 调度项
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func NewScheduler(log *zap.Logger, uc *biz.ScheduleUsecase, rds *redis.RedisClient,
+	lockTTL time.Duration, reloadInterval time.Duration,
+) *Scheduler {
+	hostname, _ := os.Hostname()
+	instance := fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), helpers.RandomString(6))
+
+	s := &Scheduler{
+		log:            log,
+		uc:             uc,
+		leader:         NewLeader(log, rds, leaderKey, instance, lockTTL),
+		cron:           cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DiscardLogger))),
+		instance:       instance,
+		reloadInterval: reloadInterval,
+		entries:        make(map[string]*entry),
+	}
+	s.leader.OnElected(s.recover)
+	return s
+}
+
+// AddRecovery 添加成为主节点时执行的恢复任务，如继续执行服务重启时中断的后台任务，需在 Start 之前添加
+func (s *Scheduler) AddRecovery(fn func(ctx context.Context)) {
+	s.recoveries = append(s.recoveries, fn)
+}
+
+// recover 执行恢复任务，只有主节点执行，避免多个实例同时接管
+func (s *Scheduler) recover(ctx context.Context) {
+	for _, fn := range s.recoveries {
+		go fn(ctx)
+	}
+}
+
+// entry 已添加到 cron 的任务
+//
+// cron 在添加任务时包装 SkipIfStillRunning，重新添加会得到新的包装，正在执行的任务可能与新的重叠，
+// 因此 cron 表达式不变时只更新任务使用的配置，不重新添加。
+type entry struct {
+	id   cron.EntryID
+	spec string
+	job  *job
+}
+
+// job 执行一个平台应用的定时任务，配置在重新加载时更新
+type job struct {
+	s    *Scheduler
+	mu   sync.Mutex
+	app  *entities.PlatformApp
+	item *entities.AppSchedule
+}
+
+func (j *job) update(app *entities.PlatformApp, item *entities.AppSchedule) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.app, j.item = app, item
+}
+
+func (j *job) Run() {
+	if !j.s.leader.IsLeader() {
+		return
+	}
+	j.mu.Lock()
+	app, item := j.app, j.item
+	j.mu.Unlock()
+	_ = j.s.uc.RunJob(context.Background(), app, item, j.s.instance)
+}
+
+// Start 启动选举和调度
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		s.leader.Run(ctx)
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.reloadLoop(ctx)
+	}()
+
+	s.cron.Start()
+	s.log.Info("Scheduler started", zap.String("instance", s.instance))
+}
+
+// Stop 停止调度，等待正在执行的任务结束
+func (s *Scheduler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	<-s.cron.Stop().Done()
+	s.wg.Wait()
+	s.log.Info("Scheduler stopped")
+}
+
+func (s *Scheduler) reloadLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.reloadInterval)
+	defer ticker.Stop()
+
+	s.reload(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.reload(ctx)
+		}
+	}
+}
+
+// reload 重新加载平台应用的定时任务配置
+func (s *Scheduler) reload(ctx context.Context) {
+	apps, err := s.uc.ListScheduledApps(ctx)
+	if err != nil {
+		s.log.Error("load scheduled apps error", zap.Error(err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool, len(s.entries))
+	for _, app := range apps {
+		for _, item := range app.Schedules {
+			if item == nil || !item.Enabled {
+				continue
+			}
+			if err := biz.ValidateSchedule(item); err != nil {
+				s.log.Warn("skip invalid schedule", zap.String("appId", app.ID.Hex()),
+					zap.String("job", item.Job), zap.Error(err))
+				continue
+			}
+			key := app.ID.Hex() + ":" + item.Job
+			if e, ok := s.entries[key]; ok {
+				if e.spec == item.Spec {
+					e.job.update(app, item)
+					seen[key] = true
+					continue
+				}
+				s.cron.Remove(e.id)
+				delete(s.entries, key)
+			}
+			j := &job{s: s, app: app, item: item}
+			id, err := s.cron.AddJob(item.Spec, j)
+			if err != nil {
+				s.log.Error("add schedule job error", zap.String("appId", app.ID.Hex()),
+					zap.String("job", item.Job), zap.String("spec", item.Spec), zap.Error(err))
+				continue
+			}
+			s.entries[key] = &entry{id: id, spec: item.Spec, job: j}
+			seen[key] = true
+		}
+	}
+	for key, e := range s.entries {
+		if !seen[key] {
+			s.cron.Remove(e.id)
+			delete(s.entries, key)
+		}
+	}
+	s.log.Debug("schedule jobs loaded", zap.Int("count", len(s.entries)))
+}
@@ -0,0 +1,123 @@
+// Package export 以流的方式导出表格数据，支持 CSV 和 XLSX
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// RowWriter 逐行写入表格数据，写入完成后必须调用 Close，重复调用 Close 不会重复写入
+type RowWriter interface {
+	WriteRow(values []string) error
+	Close() error
+}
+
+// ContentType 返回导出格式对应的 Content-Type
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// NewRowWriter 创建导出格式对应的 RowWriter
+func NewRowWriter(format string, w io.Writer) (RowWriter, error) {
+	switch format {
+	case FormatCSV:
+		return NewCSVWriter(w)
+	case FormatXLSX:
+		return NewXLSXWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// CSVWriter CSV 导出，写入 UTF-8 BOM 以便 Excel 正确识别中文
+type CSVWriter struct {
+	w *csv.Writer
+}
+
+func NewCSVWriter(w io.Writer) (*CSVWriter, error) {
+	if _, err := w.Write([]byte("\xEF\xBB\xBF")); err != nil {
+		return nil, err
+	}
+	return &CSVWriter{w: csv.NewWriter(w)}, nil
+}
+
+func (c *CSVWriter) WriteRow(values []string) error {
+	row := make([]string, len(values))
+	for i, v := range values {
+		row[i] = escapeFormula(v)
+	}
+	return c.w.Write(row)
+}
+
+// escapeFormula 以公式字符开头的单元格前加 '，避免 Excel 打开时作为公式执行(CSV 注入)
+//
+// XLSX 单元格按字符串类型写入，不会作为公式执行，不需要处理。
+func escapeFormula(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+func (c *CSVWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// XLSXWriter XLSX 导出
+//
+// 使用 excelize StreamWriter，数据较多时由 excelize 写入临时文件，不会全部保存在内存中。
+type XLSXWriter struct {
+	out    io.Writer
+	file   *excelize.File
+	sw     *excelize.StreamWriter
+	rowNo  int
+	closed bool
+}
+
+func NewXLSXWriter(w io.Writer) (*XLSXWriter, error) {
+	f := excelize.NewFile()
+	sw, err := f.NewStreamWriter("Sheet1")
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &XLSXWriter{out: w, file: f, sw: sw}, nil
+}
+
+func (x *XLSXWriter) WriteRow(values []string) error {
+	x.rowNo++
+	cell, err := excelize.CoordinatesToCellName(1, x.rowNo)
+	if err != nil {
+		return err
+	}
+	row := make([]interface{}, len(values))
+	for i, v := range values {
+		row[i] = v
+	}
+	return x.sw.SetRow(cell, row)
+}
+
+func (x *XLSXWriter) Close() error {
+	if x.closed {
+		return nil
+	}
+	x.closed = true
+	defer x.file.Close()
+	if err := x.sw.Flush(); err != nil {
+		return err
+	}
+	return x.file.Write(x.out)
+}
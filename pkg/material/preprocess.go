@@ -0,0 +1,236 @@
+package material
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	// ErrUnsupportedType 文件类型不符合素材要求，且无法转换
+	ErrUnsupportedType = errors.New("material: unsupported file type")
+	// ErrTooLarge 文件超过大小限制，且无法压缩
+	ErrTooLarge = errors.New("material: file too large")
+)
+
+const (
+	// MaxSourceSize 预处理前源文件的最大大小
+	MaxSourceSize = 32 << 20
+	// maxPixels 可处理图片的最大像素数，避免解码时占用过多内存
+	maxPixels = 50_000_000
+	// minDimension 缩小图片时的最小边长
+	minDimension = 32
+	// SniffLen 识别文件类型需要读取的字节数
+	SniffLen = 512
+)
+
+// 重新编码 JPEG 时依次尝试的质量，仍超过大小时缩小尺寸
+var jpegQualities = []int{90, 80, 70, 55, 40}
+
+// Limit 素材的格式和大小限制
+type Limit struct {
+	Types        []string // 允许的 MIME 类型
+	MaxSize      int64
+	MaxDimension int // 转码时图片的最大边长，0 表示不限制
+}
+
+// 微信素材的格式和大小限制
+var (
+	limitTemporaryImage = Limit{Types: []string{"image/jpeg", "image/png", "image/gif"}, MaxSize: 10 << 20}
+	limitPermanentImage = Limit{
+		Types:   []string{"image/jpeg", "image/png", "image/gif", "image/bmp"},
+		MaxSize: 10 << 20,
+	}
+	limitTemporaryVoice = Limit{Types: []string{"audio/mpeg", "audio/amr"}, MaxSize: 2 << 20}
+	limitPermanentVoice = Limit{
+		Types:   []string{"audio/mpeg", "audio/amr", "audio/x-ms-wma", "audio/wave"},
+		MaxSize: 2 << 20,
+	}
+	limitVideo = Limit{Types: []string{"video/mp4"}, MaxSize: 10 << 20}
+	limitThumb = Limit{Types: []string{"image/jpeg"}, MaxSize: 64 << 10, MaxDimension: 640}
+
+	// NewsImageLimit 图文消息内的图片
+	NewsImageLimit = Limit{Types: []string{"image/jpeg", "image/png"}, MaxSize: 1 << 20}
+)
+
+// MediaLimit 返回素材类型的限制，mediaType: image, voice, video, thumb
+//
+// 缩略图只允许 JPEG，其他格式或较大的图片预处理时自动生成缩略图。
+func MediaLimit(mediaType string, permanent bool) (Limit, bool) {
+	switch mediaType {
+	case "image":
+		if permanent {
+			return limitPermanentImage, true
+		}
+		return limitTemporaryImage, true
+	case "voice":
+		if permanent {
+			return limitPermanentVoice, true
+		}
+		return limitTemporaryVoice, true
+	case "video":
+		return limitVideo, true
+	case "thumb":
+		return limitThumb, true
+	}
+	return Limit{}, false
+}
+
+// 常用素材类型的扩展名
+var mimeExts = map[string]string{
+	"image/jpeg":     ".jpg",
+	"image/png":      ".png",
+	"image/gif":      ".gif",
+	"image/bmp":      ".bmp",
+	"image/webp":     ".webp",
+	"audio/mpeg":     ".mp3",
+	"audio/amr":      ".amr",
+	"audio/wave":     ".wav",
+	"audio/x-ms-wma": ".wma",
+	"video/mp4":      ".mp4",
+}
+
+// Ext 返回 MIME 类型对应的扩展名，未知类型返回空
+func Ext(contentType string) string {
+	return mimeExts[contentType]
+}
+
+// Processed 预处理后的文件
+type Processed struct {
+	Data        []byte
+	ContentType string
+	Ext         string
+	Converted   bool // 是否经过转码或压缩
+}
+
+// SniffType 根据文件内容识别 MIME 类型，不依赖扩展名
+func SniffType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("#!AMR")):
+		return "audio/amr"
+	case bytes.HasPrefix(data, []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11}): // ASF
+		return "audio/x-ms-wma"
+	case len(data) > 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0: // 没有 ID3 标签的 MP3
+		return "audio/mpeg"
+	}
+	contentType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	return contentType
+}
+
+// Preprocess 识别文件的真实类型并按限制处理
+//
+// 图片类型不允许或超过大小时转为 JPEG，并逐步降低质量、缩小尺寸直到满足大小限制；
+// 其他类型只做校验。
+func Preprocess(data []byte, limit Limit) (*Processed, error) {
+	contentType := SniffType(data)
+	allowed := slices.Contains(limit.Types, contentType)
+	if allowed && int64(len(data)) <= limit.MaxSize {
+		return &Processed{Data: data, ContentType: contentType, Ext: mimeExts[contentType]}, nil
+	}
+	if !IsImage(contentType) {
+		if err := Check(contentType, int64(len(data)), limit); err != nil {
+			return nil, err
+		}
+	}
+	if !slices.Contains(limit.Types, "image/jpeg") {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	out, err := fitJPEG(img, limit.MaxSize, limit.MaxDimension)
+	if err != nil {
+		return nil, err
+	}
+	return &Processed{Data: out, ContentType: "image/jpeg", Ext: ".jpg", Converted: true}, nil
+}
+
+// IsImage 是否为可转码的图片类型，其他类型只需校验，不必读取整个文件
+func IsImage(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/")
+}
+
+// Check 校验文件类型和大小，不做转码
+func Check(contentType string, size int64, limit Limit) error {
+	if !slices.Contains(limit.Types, contentType) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+	if size > limit.MaxSize {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// DecodeImage 解码 JPEG、PNG、GIF 图片，像素数过大时返回 ErrTooLarge
+func DecodeImage(data []byte) (image.Image, error) {
+	return decodeImage(data)
+}
+
+func decodeImage(data []byte) (image.Image, error) {
+	conf, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, err.Error())
+	}
+	if conf.Width*conf.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image error: %w", err)
+	}
+	return img, nil
+}
+
+// fitJPEG 编码为不超过 maxSize 的 JPEG，透明部分填充白色，GIF 动图只保留第一帧
+func fitJPEG(src image.Image, maxSize int64, maxDimension int) ([]byte, error) {
+	bounds := src.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(img, img.Bounds(), src, bounds.Min, draw.Over)
+	if long := max(bounds.Dx(), bounds.Dy()); maxDimension > 0 && long > maxDimension {
+		img = scale(img, float64(maxDimension)/float64(long))
+	}
+
+	var buf bytes.Buffer
+	for {
+		for _, quality := range jpegQualities {
+			buf.Reset()
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, err
+			}
+			if int64(buf.Len()) <= maxSize {
+				return buf.Bytes(), nil
+			}
+		}
+		// 文件大小约与面积成正比，按最低质量的结果估算缩小比例，至少缩小到 3/4
+		ratio := min(0.75, math.Sqrt(float64(maxSize)/float64(buf.Len())))
+		if float64(min(img.Bounds().Dx(), img.Bounds().Dy()))*ratio < minDimension {
+			return nil, ErrTooLarge
+		}
+		img = scale(img, ratio)
+	}
+}
+
+// scale 按比例缩放图片
+func scale(img *image.RGBA, ratio float64) *image.RGBA {
+	w := max(1, int(float64(img.Bounds().Dx())*ratio))
+	h := max(1, int(float64(img.Bounds().Dy())*ratio))
+	scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return scaled
+}
@@ -0,0 +1,210 @@
+package material
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// noiseImage 生成难以压缩的随机图片
+func noiseImage(w, h int) *image.RGBA {
+	rnd := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	rnd.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xFF
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSniffType(t *testing.T) {
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, noiseImage(8, 8), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{name: "amr", data: []byte("#!AMR\n\x00\x00"), want: "audio/amr"},
+		{name: "wma", data: []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11, 0xA6, 0xD9}, want: "audio/x-ms-wma"},
+		{name: "mp3 frame", data: []byte{0xFF, 0xFB, 0x90, 0x44, 0x00}, want: "audio/mpeg"},
+		{name: "mp3 id3", data: []byte("ID3\x03\x00\x00\x00\x00\x00\x00"), want: "audio/mpeg"},
+		{
+			name: "mp4",
+			data: []byte{0, 0, 0, 0x10, 'f', 't', 'y', 'p', 'm', 'p', '4', '2', 0, 0, 0, 0},
+			want: "video/mp4",
+		},
+		{name: "png", data: encodePNG(t, noiseImage(8, 8)), want: "image/png"},
+		{name: "jpeg", data: jpg.Bytes(), want: "image/jpeg"},
+		{name: "text", data: []byte("hello"), want: "text/plain"},
+		{name: "empty", data: nil, want: "text/plain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SniffType(tt.data); got != tt.want {
+				t.Errorf("SniffType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		size        int64
+		limit       Limit
+		wantErr     error
+	}{
+		{name: "allowed", contentType: "audio/mpeg", size: 1 << 20, limit: limitTemporaryVoice},
+		{name: "max size", contentType: "audio/amr", size: 2 << 20, limit: limitTemporaryVoice},
+		{name: "too large", contentType: "audio/mpeg", size: 2<<20 + 1, limit: limitTemporaryVoice, wantErr: ErrTooLarge},
+		{name: "type not allowed", contentType: "audio/wave", size: 1, limit: limitTemporaryVoice, wantErr: ErrUnsupportedType},
+		{name: "permanent voice", contentType: "audio/wave", size: 1, limit: limitPermanentVoice},
+		{name: "video", contentType: "video/mp4", size: 1, limit: limitVideo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(tt.contentType, tt.size, tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Check() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPreprocess(t *testing.T) {
+	small := encodePNG(t, noiseImage(16, 16))
+	large := encodePNG(t, noiseImage(300, 300))
+
+	tests := []struct {
+		name          string
+		data          []byte
+		limit         Limit
+		wantType      string
+		wantConverted bool
+		wantErr       error
+	}{
+		{name: "png unchanged", data: small, limit: limitTemporaryImage, wantType: "image/png"},
+		{name: "png to jpeg thumb", data: small, limit: limitThumb, wantType: "image/jpeg", wantConverted: true},
+		{
+			name:          "large png compressed",
+			data:          large,
+			limit:         Limit{Types: []string{"image/jpeg", "image/png"}, MaxSize: 32 << 10},
+			wantType:      "image/jpeg",
+			wantConverted: true,
+		},
+		{
+			name:    "jpeg not allowed",
+			data:    large,
+			limit:   Limit{Types: []string{"image/png"}, MaxSize: 1 << 10},
+			wantErr: ErrUnsupportedType,
+		},
+		{name: "voice not allowed", data: []byte("#!AMR\n"), limit: limitVideo, wantErr: ErrUnsupportedType},
+		{
+			name:    "voice too large",
+			data:    append([]byte("#!AMR\n"), make([]byte, 16)...),
+			limit:   Limit{Types: []string{"audio/amr"}, MaxSize: 8},
+			wantErr: ErrTooLarge,
+		},
+		{name: "text", data: []byte("hello"), limit: limitPermanentImage, wantErr: ErrUnsupportedType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Preprocess(tt.data, tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Preprocess() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.ContentType != tt.wantType || got.Converted != tt.wantConverted {
+				t.Errorf("Preprocess() = %s converted %v, want %s converted %v",
+					got.ContentType, got.Converted, tt.wantType, tt.wantConverted)
+			}
+			if got.Ext != Ext(got.ContentType) {
+				t.Errorf("Ext = %q, want %q", got.Ext, Ext(got.ContentType))
+			}
+			if int64(len(got.Data)) > tt.limit.MaxSize {
+				t.Errorf("size %d exceeds %d", len(got.Data), tt.limit.MaxSize)
+			}
+			if SniffType(got.Data) != got.ContentType {
+				t.Errorf("data type = %s, want %s", SniffType(got.Data), got.ContentType)
+			}
+		})
+	}
+}
+
+func TestFitJPEG(t *testing.T) {
+	// 左半部分透明
+	transparent := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 32; x < 64; x++ {
+			transparent.Set(x, y, color.NRGBA{R: 0xFF, A: 0xFF})
+		}
+	}
+
+	tests := []struct {
+		name         string
+		img          image.Image
+		maxSize      int64
+		maxDimension int
+		wantMaxSide  int
+		wantErr      error
+	}{
+		{name: "fits", img: noiseImage(64, 64), maxSize: 1 << 20, wantMaxSide: 64},
+		{name: "shrink", img: noiseImage(400, 300), maxSize: 16 << 10, wantMaxSide: 400},
+		{name: "max dimension", img: noiseImage(400, 200), maxSize: 1 << 20, maxDimension: 100, wantMaxSide: 100},
+		{name: "transparent", img: transparent, maxSize: 1 << 20, wantMaxSide: 64},
+		{name: "impossible", img: noiseImage(64, 64), maxSize: 100, wantErr: ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := fitJPEG(tt.img, tt.maxSize, tt.maxDimension)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("fitJPEG() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if int64(len(data)) > tt.maxSize {
+				t.Errorf("size %d exceeds %d", len(data), tt.maxSize)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+			if side := max(img.Bounds().Dx(), img.Bounds().Dy()); side > tt.wantMaxSide {
+				t.Errorf("long side = %d, want <= %d", side, tt.wantMaxSide)
+			}
+			if tt.name == "transparent" {
+				// 透明部分填充白色
+				r, g, b, _ := img.At(8, 32).RGBA()
+				if r>>8 < 0xF0 || g>>8 < 0xF0 || b>>8 < 0xF0 {
+					t.Errorf("transparent pixel = %d,%d,%d, want white", r>>8, g>>8, b>>8)
+				}
+			}
+		})
+	}
+}
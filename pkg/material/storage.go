@@ -0,0 +1,70 @@
+package material
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// 存储驱动
+const (
+	DriverLocal = "local"
+	DriverS3    = "s3"
+)
+
+// ErrNotFound 文件不存在
+var ErrNotFound = errors.New("storage: object not found")
+
+// Storage 素材文件存储，文件以 key 标识，多个实例共享同一存储
+type Storage interface {
+	// Put 保存文件，size 未知时传 -1
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open 读取文件，调用方负责关闭
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Size 文件大小，文件不存在时返回 ErrNotFound
+	Size(ctx context.Context, key string) (int64, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// StorageConfig 存储配置
+type StorageConfig struct {
+	Driver string // local(默认), s3
+	Local  *LocalConfig
+	S3     *S3Config `mapstructure:"s3"`
+	// MirrorInbound 是否保存粉丝发送的图片、语音、视频
+	MirrorInbound bool `mapstructure:"mirror_inbound"`
+}
+
+// NewStorage 根据配置创建存储，未配置时使用本地目录 uploads
+func NewStorage(conf *StorageConfig) (Storage, error) {
+	if conf == nil {
+		conf = &StorageConfig{}
+	}
+	switch conf.Driver {
+	case "", DriverLocal:
+		root := "uploads"
+		if conf.Local != nil && conf.Local.Root != "" {
+			root = conf.Local.Root
+		}
+		return NewLocalStorage(root)
+	case DriverS3:
+		if conf.S3 == nil {
+			return nil, fmt.Errorf("storage: s3 config required")
+		}
+		return NewS3Storage(conf.S3)
+	default:
+		return nil, fmt.Errorf("storage: driver %s not supported", conf.Driver)
+	}
+}
+
+// cleanKey 校验 key，不允许绝对路径和跳出存储目录
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean("/" + key)[1:]
+	if key == "" || cleaned == "" || cleaned != strings.TrimPrefix(key, "./") {
+		return "", fmt.Errorf("storage: invalid key %q", key)
+	}
+	return cleaned, nil
+}
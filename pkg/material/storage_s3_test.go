@@ -0,0 +1,157 @@
+package material
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 只实现测试用到的对象接口，路径为 /bucket/key
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, types: map[string]string{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := r.URL.Path
+	switch r.Method {
+	case http.MethodPut:
+		data, err := readS3Body(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[name] = data
+		f.types[name] = r.Header.Get("Content-Type")
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[name]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+					`<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", f.types[name])
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// readS3Body 读取上传内容，支持 aws-chunked 编码
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var buf bytes.Buffer
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return buf.Bytes(), nil
+		}
+		if _, err := io.CopyN(&buf, br, size); err != nil {
+			return nil, err
+		}
+		if _, err := br.ReadString('\n'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	fake := newFakeS3()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	s, err := NewS3Storage(&S3Config{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    "bucket",
+		AccessKey: "key",
+		SecretKey: "secret",
+		PathStyle: true,
+		Prefix:    "materials/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	data := []byte("hello material")
+
+	if err := s.Put(ctx, "app/a.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if got := fake.objects["/bucket/materials/app/a.jpg"]; !bytes.Equal(got, data) {
+		t.Errorf("stored = %q, want %q", got, data)
+	}
+	if got := fake.types["/bucket/materials/app/a.jpg"]; got != "image/jpeg" {
+		t.Errorf("content type = %q, want image/jpeg", got)
+	}
+
+	size, err := s.Size(ctx, "app/a.jpg")
+	if err != nil || size != int64(len(data)) {
+		t.Errorf("Size() = %d, %v, want %d", size, err, len(data))
+	}
+
+	rc, err := s.Open(ctx, "app/a.jpg")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Open() = %q, %v, want %q", got, err, data)
+	}
+
+	if err := s.Delete(ctx, "app/a.jpg"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := s.Open(ctx, "app/a.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open() after delete error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Size(ctx, "app/a.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Size() after delete error = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Put(ctx, "../a.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg"); err == nil {
+		t.Errorf("Put() invalid key error = nil")
+	}
+}
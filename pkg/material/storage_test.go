@@ -0,0 +1,193 @@
+package material
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCleanKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{name: "simple", key: "a.jpg", want: "a.jpg"},
+		{name: "nested", key: "app/2024/a.jpg", want: "app/2024/a.jpg"},
+		{name: "dot prefix", key: "./a.jpg", want: "a.jpg"},
+		{name: "empty", key: "", wantErr: true},
+		{name: "dot", key: ".", wantErr: true},
+		{name: "absolute", key: "/etc/passwd", wantErr: true},
+		{name: "parent", key: "../a.jpg", wantErr: true},
+		{name: "parent in middle", key: "app/../../a.jpg", wantErr: true},
+		{name: "resolved parent", key: "app/../a.jpg", wantErr: true},
+		{name: "double slash", key: "app//a.jpg", wantErr: true},
+		{name: "trailing slash", key: "app/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cleanKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("cleanKey(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("cleanKey(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+// errReader 读取部分数据后返回错误
+type errReader struct {
+	data string
+	read bool
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, errors.New("read error")
+	}
+	r.read = true
+	return copy(p, r.data), nil
+}
+
+func readKey(t *testing.T, s *LocalStorage, key string) string {
+	t.Helper()
+	rc, err := s.Open(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Open(%q) error = %v", key, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read %q error = %v", key, err)
+	}
+	return string(data)
+}
+
+// assertNoTempFiles 写入结束后不应留下临时文件
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".upload-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) > 0 {
+		t.Errorf("temp files left: %v", matches)
+	}
+}
+
+func TestLocalStoragePut(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		key     string
+		r       io.Reader
+		want    string // 写入后读取到的内容，为空表示文件不存在
+		wantErr bool
+	}{
+		{
+			name: "write",
+			ctx:  context.Background(),
+			key:  "app/a.txt",
+			r:    strings.NewReader("new"),
+			want: "new",
+		},
+		{
+			name:    "read error keeps old file",
+			ctx:     context.Background(),
+			key:     "app/a.txt",
+			r:       &errReader{data: "partial"},
+			want:    "old",
+			wantErr: true,
+		},
+		{
+			name:    "canceled keeps old file",
+			ctx:     canceled,
+			key:     "app/a.txt",
+			r:       strings.NewReader("new"),
+			want:    "old",
+			wantErr: true,
+		},
+		{
+			name:    "read error without old file",
+			ctx:     context.Background(),
+			key:     "app/b.txt",
+			r:       &errReader{data: "partial"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid key",
+			ctx:     context.Background(),
+			key:     "../a.txt",
+			r:       strings.NewReader("new"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			s, err := NewLocalStorage(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Put(context.Background(), "app/a.txt", strings.NewReader("old"), 3, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.Put(tt.ctx, tt.key, tt.r, -1, "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Put() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assertNoTempFiles(t, filepath.Join(root, "app"))
+			if _, err := os.Stat(filepath.Join(filepath.Dir(root), "a.txt")); err == nil {
+				t.Errorf("file written outside root")
+			}
+
+			if _, err := cleanKey(tt.key); err != nil {
+				return
+			}
+			if tt.want == "" {
+				if _, err := s.Open(context.Background(), tt.key); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Open() error = %v, want ErrNotFound", err)
+				}
+				return
+			}
+			if got := readKey(t, s, tt.key); got != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalStorageDelete(t *testing.T) {
+	s, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := s.Put(ctx, "a.txt", strings.NewReader("data"), 4, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "a.txt"); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+	if _, err := s.Open(ctx, "a.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open() after delete error = %v, want ErrNotFound", err)
+	}
+	// 文件不存在时不返回错误
+	if err := s.Delete(ctx, "a.txt"); err != nil {
+		t.Errorf("Delete() missing file error = %v", err)
+	}
+}
@@ -1,15 +1,13 @@
 package material
 
 import (
-	"bytes"
-	"errors"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
-
-	"github.com/seth16888/wxcommon/hc"
 )
 
 // MultipartFormField 保存文件或其他字段信息
@@ -17,114 +15,167 @@ type MultipartFormField struct {
 	IsFile     bool
 	Fieldname  string
 	Value      []byte
-	FilePath   string
+	FilePath   string // 本地文件路径，FileReader 为空时使用
 	Filename   string
-	FileReader io.Reader
+	FileReader io.Reader // 文件内容，优先于 FilePath
+	Size       int64     // 文件大小，用于计算上传进度，未知时为 0
 }
 
-func UploadFile(fieldName, filePath, uri string, hc *hc.Client) ([]byte, error) {
-	return PostFileByFormData(uri, nil, filePath, fieldName, hc)
-}
+// ProgressFunc 上传进度回调，sent 为已发送的文件字节数，total 未知时为 -1
+type ProgressFunc func(sent, total int64)
 
-// 使用multipart/form-data发送文件
-func PostFileByFormData(url string, params map[string]string, file_path string, file_key string, hc *hc.Client) (respBody []byte, err error) {
-	file, err := os.Open(file_path)
+// JSONField 将 v 序列化为 JSON 作为表单字段，如视频素材的 description
+func JSONField(fieldname string, v any) (MultipartFormField, error) {
+	value, err := json.Marshal(v)
 	if err != nil {
-		return nil, errors.New("PostFileByFormData open file error")
+		return MultipartFormField{}, err
 	}
-	defer file.Close()
+	return MultipartFormField{Fieldname: fieldname, Value: value}, nil
+}
 
-	bodyBuf := &bytes.Buffer{}
-	bodyWriter := multipart.NewWriter(bodyBuf)
-	for key, val := range params {
-		_ = bodyWriter.WriteField(key, val)
-	}
+func UploadFile(ctx context.Context, fieldName, filePath, uri string, cli *http.Client) ([]byte, error) {
+	return PostFileByFormData(ctx, uri, nil, filePath, fieldName, cli)
+}
 
-	fileWrite, err := bodyWriter.CreateFormFile(file_key, file.Name())
-	if err != nil {
-		return nil, errors.New("PostFileByFormData create part error")
-	}
-	_, err = io.Copy(fileWrite, file)
-	if err != nil {
-		return nil, errors.New("PostFileByFormData read file error")
+// UploadReader 上传 r 中的文件内容
+func UploadReader(ctx context.Context, fieldName, filename string, r io.Reader, uri string,
+	cli *http.Client,
+) ([]byte, error) {
+	fields := []MultipartFormField{{IsFile: true, Fieldname: fieldName, Filename: filename, FileReader: r}}
+	return PostMultipartForm(ctx, fields, uri, cli, nil)
+}
+
+// 使用multipart/form-data发送文件
+func PostFileByFormData(ctx context.Context, url string, params map[string]string, file_path string,
+	file_key string, cli *http.Client,
+) (respBody []byte, err error) {
+	fields := make([]MultipartFormField, 0, len(params)+1)
+	for key, val := range params {
+		fields = append(fields, MultipartFormField{Fieldname: key, Value: []byte(val)})
 	}
+	fields = append(fields, MultipartFormField{IsFile: true, Fieldname: file_key, FilePath: file_path})
+	return PostMultipartForm(ctx, fields, url, cli, nil)
+}
 
-	if err = bodyWriter.Close(); err != nil {
-		return nil, errors.New("PostFileByFormData close multipart error")
+// PostMultipartForm 上传文件或其他多个字段
+//
+// 请求体通过 io.Pipe 边读边发送，不会将文件读入内存。ctx 取消时中断上传和等待响应，
+// cli 为空时使用 http.DefaultClient，progress 可以为 nil。
+func PostMultipartForm(ctx context.Context, fields []MultipartFormField, uri string, cli *http.Client,
+	progress ProgressFunc,
+) (respBody []byte, err error) {
+	if cli == nil {
+		cli = http.DefaultClient
 	}
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	bodyWriter := multipart.NewWriter(pw)
 
-	contentType := bodyWriter.FormDataContentType()
-	dataBytesReader := bytes.NewReader(bodyBuf.Bytes())
+	go func() {
+		pw.CloseWithError(writeMultipart(ctx, bodyWriter, fields, progress))
+	}()
 
-	resp, e := hc.Post(url, contentType, dataBytesReader)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", bodyWriter.FormDataContentType())
+	resp, e := cli.Do(req)
 	if e != nil {
-		err = fmt.Errorf("upload media to wx error,%s", e.Error())
-		return
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("upload media to wx error,%s", e.Error())
 	}
 	defer resp.Body.Close()
-
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("http code error : url=%s , statusCode=%d", url, resp.StatusCode)
+		return nil, fmt.Errorf("http code error : uri=%v , statusCode=%v", uri, resp.StatusCode)
 	}
-
-	respBody, err = io.ReadAll(resp.Body)
-	return
+	return io.ReadAll(resp.Body)
 }
 
-// PostMultipartForm 上传文件或其他多个字段
-func PostMultipartForm(fields []MultipartFormField, uri string, hc *hc.Client) (respBody []byte, err error) {
-	bodyBuf := &bytes.Buffer{}
-	bodyWriter := multipart.NewWriter(bodyBuf)
+// writeMultipart 写入全部字段，写入失败时返回的错误会传给请求方
+func writeMultipart(ctx context.Context, bodyWriter *multipart.Writer, fields []MultipartFormField,
+	progress ProgressFunc,
+) error {
+	counter := &progressReader{ctx: ctx, progress: progress, total: -1}
+	if progress != nil {
+		counter.total = totalSize(fields)
+	}
 
 	for _, field := range fields {
-		if field.IsFile {
-			fileWriter, e := bodyWriter.CreateFormFile(field.Fieldname, field.Filename)
-			if e != nil {
-				err = fmt.Errorf("error writing to buffer , err=%v", e)
-				return nil, err
+		if !field.IsFile {
+			if err := bodyWriter.WriteField(field.Fieldname, string(field.Value)); err != nil {
+				return err
 			}
+			continue
+		}
 
-			if field.FileReader == nil {
-				fh, e := os.Open(field.FilePath)
-				if e != nil {
-					err = fmt.Errorf("error opening file , err=%v", e)
-					return nil, err
-				}
-				_, err = io.Copy(fileWriter, fh)
-				_ = fh.Close()
-				if err != nil {
-					return nil, err
-				}
-			} else {
-				if _, err = io.Copy(fileWriter, field.FileReader); err != nil {
-					return nil, err
-				}
+		reader := field.FileReader
+		filename := field.Filename
+		if reader == nil {
+			fh, err := os.Open(field.FilePath)
+			if err != nil {
+				return fmt.Errorf("error opening file , err=%v", err)
 			}
-		} else {
-			partWriter, e := bodyWriter.CreateFormFile(field.Fieldname, field.Filename)
-			if e != nil {
-				err = e
-				return nil, err
+			defer fh.Close()
+			reader = fh
+			if filename == "" {
+				filename = fh.Name()
 			}
-			valueReader := bytes.NewReader(field.Value)
-			if _, err = io.Copy(partWriter, valueReader); err != nil {
-				return nil, err
+		}
+		fileWriter, err := bodyWriter.CreateFormFile(field.Fieldname, filename)
+		if err != nil {
+			return fmt.Errorf("error writing to buffer , err=%v", err)
+		}
+		counter.r = reader
+		if _, err := io.Copy(fileWriter, counter); err != nil {
+			return err
+		}
+	}
+	return bodyWriter.Close()
+}
+
+// totalSize 文件总大小，有文件大小未知时返回 -1
+func totalSize(fields []MultipartFormField) int64 {
+	var total int64
+	for _, field := range fields {
+		if !field.IsFile {
+			continue
+		}
+		size := field.Size
+		if size <= 0 && field.FileReader == nil {
+			if info, err := os.Stat(field.FilePath); err == nil {
+				size = info.Size()
 			}
 		}
+		if size <= 0 {
+			return -1
+		}
+		total += size
 	}
+	return total
+}
 
-	contentType := bodyWriter.FormDataContentType()
-	bodyWriter.Close()
+// progressReader 统计已读取的字节数，并在 ctx 取消后停止读取
+type progressReader struct {
+	ctx      context.Context
+	r        io.Reader
+	sent     int64
+	total    int64
+	progress ProgressFunc
+}
 
-	resp, e := hc.Post(uri, contentType, bodyBuf)
-	if e != nil {
-		err = e
-		return nil, err
+func (p *progressReader) Read(b []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("http code error : uri=%v , statusCode=%v", uri, resp.StatusCode)
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		if p.progress != nil {
+			p.progress(p.sent, p.total)
+		}
 	}
-	respBody, err = io.ReadAll(resp.Body)
-	return respBody, err
+	return n, err
 }
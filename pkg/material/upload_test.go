@@ -0,0 +1,186 @@
+package material
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// uploadServer 解析 multipart 表单，返回文件内容和其他字段
+func uploadServer(t *testing.T, files map[string][]byte, values map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			data, err := io.ReadAll(part)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if part.FileName() != "" {
+				files[part.FormName()] = data
+			} else {
+				values[part.FormName()] = string(data)
+			}
+		}
+		io.WriteString(w, `{"media_id":"m1"}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPostMultipartForm(t *testing.T) {
+	files := map[string][]byte{}
+	values := map[string]string{}
+	srv := uploadServer(t, files, values)
+
+	content := bytes.Repeat([]byte("video"), 64<<10)
+	type description struct {
+		Title        string `json:"title"`
+		Introduction string `json:"introduction"`
+	}
+	desc := description{Title: `门店 "A" <1>`, Introduction: "a\\b\nc"}
+	descField, err := JSONField("description", desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent, total int64
+	fields := []MultipartFormField{
+		{IsFile: true, Fieldname: "media", Filename: "a.mp4", FileReader: bytes.NewReader(content),
+			Size: int64(len(content))},
+		descField,
+	}
+	resp, err := PostMultipartForm(context.Background(), fields, srv.URL, nil, func(s, t int64) {
+		sent, total = s, t
+	})
+	if err != nil {
+		t.Fatalf("PostMultipartForm() error = %v", err)
+	}
+	if string(resp) != `{"media_id":"m1"}` {
+		t.Errorf("response = %s", resp)
+	}
+	if !bytes.Equal(files["media"], content) {
+		t.Errorf("file size = %d, want %d", len(files["media"]), len(content))
+	}
+	var gotDesc description
+	if err := json.Unmarshal([]byte(values["description"]), &gotDesc); err != nil {
+		t.Fatalf("description %q: %v", values["description"], err)
+	}
+	if gotDesc != desc {
+		t.Errorf("description = %+v, want %+v", gotDesc, desc)
+	}
+	if sent != int64(len(content)) || total != int64(len(content)) {
+		t.Errorf("progress = %d/%d, want %d/%d", sent, total, len(content), len(content))
+	}
+}
+
+func TestPostMultipartFormProgressTotal(t *testing.T) {
+	srv := uploadServer(t, map[string][]byte{}, map[string]string{})
+	path := filepath.Join(t.TempDir(), "a.jpg")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		fields []MultipartFormField
+		want   int64
+	}{
+		{
+			name:   "file path",
+			fields: []MultipartFormField{{IsFile: true, Fieldname: "media", FilePath: path}},
+			want:   10,
+		},
+		{
+			name: "multiple files",
+			fields: []MultipartFormField{
+				{IsFile: true, Fieldname: "media", FilePath: path},
+				{IsFile: true, Fieldname: "thumb", Filename: "b.jpg", FileReader: bytes.NewReader([]byte("abc")), Size: 3},
+			},
+			want: 13,
+		},
+		{
+			name: "unknown size",
+			fields: []MultipartFormField{
+				{IsFile: true, Fieldname: "media", Filename: "b.jpg", FileReader: bytes.NewReader([]byte("abc"))},
+			},
+			want: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var total int64
+			_, err := PostMultipartForm(context.Background(), tt.fields, srv.URL, nil, func(_, t int64) {
+				total = t
+			})
+			if err != nil {
+				t.Fatalf("PostMultipartForm() error = %v", err)
+			}
+			if total != tt.want {
+				t.Errorf("total = %d, want %d", total, tt.want)
+			}
+		})
+	}
+}
+
+// endlessReader 无限的文件内容
+type endlessReader struct{}
+
+func (endlessReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 'x'
+	}
+	return len(b), nil
+}
+
+func TestPostMultipartFormCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fields := []MultipartFormField{{IsFile: true, Fieldname: "media", Filename: "a.mp4", FileReader: endlessReader{}}}
+	_, err := PostMultipartForm(ctx, fields, srv.URL, nil, func(sent, _ int64) {
+		if sent > 1<<20 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("PostMultipartForm() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestPostMultipartFormStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	fields := []MultipartFormField{{Fieldname: "type", Value: []byte("image")}}
+	if _, err := PostMultipartForm(context.Background(), fields, srv.URL, nil, nil); err == nil {
+		t.Errorf("PostMultipartForm() error = nil, want status error")
+	}
+}
@@ -0,0 +1,331 @@
+// Package qrcode 在本地将内容编码为二维码图片，支持 PNG 和 SVG，可添加居中 logo 和底部说明文字
+package qrcode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+
+	goqrcode "github.com/skip2/go-qrcode"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+
+	DefaultSize = 430 // 与微信二维码图片的默认大小一致
+	MinSize     = 64
+	MaxSize     = 2048
+
+	maxCaption   = 64       // 说明文字的最大字符数
+	logoRatio    = 0.2      // logo 边长占二维码的最大比例，过大会影响识别
+	captionRatio = 1.0 / 14 // 说明文字字号与图片边长的比例
+	minFontSize  = 10
+)
+
+// ErrInvalidOptions 渲染参数不正确
+var ErrInvalidOptions = errors.New("qrcode: invalid options")
+
+// 纠错等级，等级越高可遮挡的面积越大，logo 建议使用 Q 或 H
+var levels = map[string]goqrcode.RecoveryLevel{
+	"L": goqrcode.Low,
+	"M": goqrcode.Medium,
+	"Q": goqrcode.High,
+	"H": goqrcode.Highest,
+}
+
+// Options 渲染参数
+type Options struct {
+	Format  string      // png(默认), svg
+	Size    int         // 二维码边长，像素，默认 DefaultSize
+	Level   string      // 纠错等级 L, M(默认), Q, H
+	Logo    image.Image // 居中显示的 logo，可为空，有 logo 时纠错等级至少为 Q
+	Caption string      // 二维码下方的说明文字，可为空
+}
+
+// Validate 校验参数并填充默认值
+func (o *Options) Validate() error {
+	if o.Format == "" {
+		o.Format = FormatPNG
+	}
+	if o.Format != FormatPNG && o.Format != FormatSVG {
+		return fmt.Errorf("%w: format %s", ErrInvalidOptions, o.Format)
+	}
+	if o.Size == 0 {
+		o.Size = DefaultSize
+	}
+	if o.Size < MinSize || o.Size > MaxSize {
+		return fmt.Errorf("%w: size not in %d-%d", ErrInvalidOptions, MinSize, MaxSize)
+	}
+	o.Level = strings.ToUpper(o.Level)
+	if o.Level == "" {
+		o.Level = "M"
+	}
+	if _, ok := levels[o.Level]; !ok {
+		return fmt.Errorf("%w: level %s", ErrInvalidOptions, o.Level)
+	}
+	if len([]rune(o.Caption)) > maxCaption {
+		return fmt.Errorf("%w: caption longer than %d", ErrInvalidOptions, maxCaption)
+	}
+	return nil
+}
+
+// ContentType 返回图片格式对应的 Content-Type
+func ContentType(format string) string {
+	if format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// Renderer 二维码渲染，PNG 的说明文字使用加载的字体绘制
+type Renderer struct {
+	font *opentype.Font
+}
+
+// NewRenderer 创建渲染器，fontFile 为说明文字使用的 TTF/OTF 字体文件，
+// TTC/OTC 字体集合使用其中的第一个字体
+//
+// 未指定时使用内置的 Go 字体，只包含拉丁字母，中文说明文字需要配置支持中文的字体。
+func NewRenderer(fontFile string) (*Renderer, error) {
+	data := goregular.TTF
+	if fontFile != "" {
+		var err error
+		if data, err = os.ReadFile(fontFile); err != nil {
+			return nil, fmt.Errorf("read font file error: %w", err)
+		}
+	}
+	// 单个字体也按只包含一个字体的集合解析
+	collection, err := opentype.ParseCollection(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse font error: %w", err)
+	}
+	f, err := collection.Font(0)
+	if err != nil {
+		return nil, fmt.Errorf("parse font error: %w", err)
+	}
+	return &Renderer{font: f}, nil
+}
+
+// Render 将 content 编码为二维码，按 opts.Format 写入 w，opts 需先调用 Validate
+func (r *Renderer) Render(w io.Writer, content string, opts *Options) error {
+	level := levels[opts.Level]
+	if opts.Logo != nil && level < goqrcode.High { // logo 遮挡部分模块
+		level = goqrcode.High
+	}
+	qr, err := goqrcode.New(content, level)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidOptions, err.Error())
+	}
+	bitmap := qr.Bitmap() // 包含四周的空白区域
+	if len(bitmap) > opts.Size {
+		return fmt.Errorf("%w: size too small for content", ErrInvalidOptions)
+	}
+	if opts.Format == FormatSVG {
+		return r.svg(w, bitmap, opts)
+	}
+	return r.png(w, bitmap, opts)
+}
+
+// layout 每个模块的像素数和二维码的起始位置，余下的像素平均分到四周
+func layout(bitmap [][]bool, size int) (module int, offset int) {
+	module = size / len(bitmap)
+	return module, (size - module*len(bitmap)) / 2
+}
+
+func captionHeight(opts *Options) int {
+	if opts.Caption == "" {
+		return 0
+	}
+	return int(float64(opts.Size)*captionRatio*1.8) + 1
+}
+
+func (r *Renderer) png(w io.Writer, bitmap [][]bool, opts *Options) error {
+	size := opts.Size
+	img := image.NewRGBA(image.Rect(0, 0, size, size+captionHeight(opts)))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	module, offset := layout(bitmap, size)
+	black := image.NewUniform(color.Black)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				rect := image.Rect(offset+x*module, offset+y*module,
+					offset+(x+1)*module, offset+(y+1)*module)
+				draw.Draw(img, rect, black, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	if opts.Logo != nil {
+		drawLogo(img, opts.Logo, size)
+	}
+	if opts.Caption != "" {
+		if err := r.drawCaption(img, opts.Caption, size); err != nil {
+			return err
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// logoRect logo 在二维码中的位置，保持宽高比，居中
+func logoRect(logo image.Rectangle, size int) image.Rectangle {
+	box := int(float64(size) * logoRatio)
+	w, h := logo.Dx(), logo.Dy()
+	if w >= h {
+		w, h = box, max(1, box*h/w)
+	} else {
+		w, h = max(1, box*w/h), box
+	}
+	x, y := (size-w)/2, (size-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// drawLogo 在二维码中央绘制 logo，四周留白色边框便于区分
+func drawLogo(img *image.RGBA, logo image.Image, size int) {
+	rect := logoRect(logo.Bounds(), size)
+	pad := max(2, rect.Dx()/10)
+	draw.Draw(img, rect.Inset(-pad), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(img, rect, logo, logo.Bounds(), draw.Over, nil)
+}
+
+// drawCaption 在二维码下方居中绘制说明文字，过长时缩小字号，仍放不下时截断
+func (r *Renderer) drawCaption(img *image.RGBA, caption string, size int) error {
+	fontSize := float64(size) * captionRatio
+	maxWidth := fixed.I(size * 9 / 10)
+	var face font.Face
+	for {
+		var err error
+		face, err = opentype.NewFace(r.font, &opentype.FaceOptions{Size: fontSize, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return err
+		}
+		if font.MeasureString(face, caption) <= maxWidth || fontSize <= minFontSize {
+			break
+		}
+		face.Close()
+		fontSize = max(minFontSize, fontSize*0.85)
+	}
+	defer face.Close()
+
+	runes := []rune(caption)
+	for len(runes) > 1 && font.MeasureString(face, string(runes)+"…") > maxWidth {
+		runes = runes[:len(runes)-1]
+	}
+	if len(runes) < len([]rune(caption)) {
+		caption = string(runes) + "…"
+	}
+
+	width := font.MeasureString(face, caption)
+	metrics := face.Metrics()
+	top := size + (img.Bounds().Dy()-size-(metrics.Ascent+metrics.Descent).Ceil())/2
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.Black),
+		Face: face,
+		Dot:  fixed.Point26_6{X: (fixed.I(size) - width) / 2, Y: fixed.I(top) + metrics.Ascent},
+	}
+	d.DrawString(caption)
+	return nil
+}
+
+func (r *Renderer) svg(w io.Writer, bitmap [][]bool, opts *Options) error {
+	size := opts.Size
+	height := size + captionHeight(opts)
+	module, offset := layout(bitmap, size)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, height, size, height)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, height)
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			// 合并同一行连续的深色模块
+			start := x
+			for x+1 < len(row) && row[x+1] {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv%dh-%dz", offset+start*module, offset+y*module,
+				(x-start+1)*module, module, (x-start+1)*module)
+		}
+	}
+	buf.WriteString(`"/>`)
+
+	if opts.Logo != nil {
+		var logo bytes.Buffer
+		if err := png.Encode(&logo, opts.Logo); err != nil {
+			return err
+		}
+		rect := logoRect(opts.Logo.Bounds(), size)
+		pad := max(2, rect.Dx()/10)
+		bg := rect.Inset(-pad)
+		fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="%d" height="%d" fill="#fff"/>`,
+			bg.Min.X, bg.Min.Y, bg.Dx(), bg.Dy())
+		fmt.Fprintf(&buf, `<image x="%d" y="%d" width="%d" height="%d" href="data:image/png;base64,%s"/>`,
+			rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy(), base64.StdEncoding.EncodeToString(logo.Bytes()))
+	}
+	if opts.Caption != "" {
+		fontSize := float64(size) * captionRatio
+		fmt.Fprintf(&buf, `<text x="%d" y="%d" font-size="%.1f" font-family="sans-serif" text-anchor="middle" dominant-baseline="middle"`,
+			size/2, size+(height-size)/2, fontSize)
+		// 按加载的字体估算宽度，超出时才压缩文字，短文字不拉伸
+		maxWidth := size * 9 / 10
+		width, err := r.measure(opts.Caption, fontSize)
+		if err != nil {
+			return err
+		}
+		if width > maxWidth {
+			fmt.Fprintf(&buf, ` textLength="%d" lengthAdjust="spacingAndGlyphs"`, maxWidth)
+		}
+		buf.WriteString(`>`)
+		buf.WriteString(escapeXML(opts.Caption))
+		buf.WriteString(`</text>`)
+	}
+	buf.WriteString(`</svg>`)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// measure 文字使用加载的字体时的宽度，像素
+func (r *Renderer) measure(s string, fontSize float64) (int, error) {
+	face, err := opentype.NewFace(r.font, &opentype.FaceOptions{Size: fontSize, DPI: 72})
+	if err != nil {
+		return 0, err
+	}
+	defer face.Close()
+	return font.MeasureString(face, s).Ceil(), nil
+}
+
+func escapeXML(s string) string {
+	var buf bytes.Buffer
+	for _, r := range s {
+		switch r {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '>':
+			buf.WriteString("&gt;")
+		case '"':
+			buf.WriteString("&quot;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,227 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		want    Options
+		wantErr bool
+	}{
+		{name: "defaults", want: Options{Format: FormatPNG, Size: DefaultSize, Level: "M"}},
+		{
+			name: "lower level",
+			opts: Options{Format: FormatSVG, Size: MinSize, Level: "q"},
+			want: Options{Format: FormatSVG, Size: MinSize, Level: "Q"},
+		},
+		{name: "invalid format", opts: Options{Format: "jpg"}, wantErr: true},
+		{name: "size too small", opts: Options{Size: MinSize - 1}, wantErr: true},
+		{name: "size too large", opts: Options{Size: MaxSize + 1}, wantErr: true},
+		{name: "invalid level", opts: Options{Level: "X"}, wantErr: true},
+		{name: "caption too long", opts: Options{Caption: strings.Repeat("门", maxCaption+1)}, wantErr: true},
+		{
+			name: "caption max length",
+			opts: Options{Caption: strings.Repeat("门", maxCaption)},
+			want: Options{Format: FormatPNG, Size: DefaultSize, Level: "M", Caption: strings.Repeat("门", maxCaption)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrInvalidOptions) {
+					t.Errorf("Validate() error = %v, want ErrInvalidOptions", err)
+				}
+				return
+			}
+			if tt.opts != tt.want {
+				t.Errorf("Validate() = %+v, want %+v", tt.opts, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentType(t *testing.T) {
+	if got := ContentType(FormatSVG); got != "image/svg+xml" {
+		t.Errorf("ContentType(svg) = %q", got)
+	}
+	if got := ContentType(FormatPNG); got != "image/png" {
+		t.Errorf("ContentType(png) = %q", got)
+	}
+}
+
+func TestLogoRect(t *testing.T) {
+	tests := []struct {
+		name string
+		logo image.Rectangle
+		size int
+		want image.Rectangle
+	}{
+		{name: "square", logo: image.Rect(0, 0, 50, 50), size: 100, want: image.Rect(40, 40, 60, 60)},
+		{name: "landscape", logo: image.Rect(0, 0, 200, 100), size: 100, want: image.Rect(40, 45, 60, 55)},
+		{name: "portrait", logo: image.Rect(0, 0, 100, 200), size: 100, want: image.Rect(45, 40, 55, 60)},
+		{name: "thin", logo: image.Rect(0, 0, 1000, 1), size: 100, want: image.Rect(40, 49, 60, 50)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := logoRect(tt.logo, tt.size); got != tt.want {
+				t.Errorf("logoRect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscapeXML(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "store_1", want: "store_1"},
+		{in: `a<b>&"c"`, want: "a&lt;b&gt;&amp;&quot;c&quot;"},
+		{in: "门店 A&B", want: "门店 A&amp;B"},
+	}
+	for _, tt := range tests {
+		if got := escapeXML(tt.in); got != tt.want {
+			t.Errorf("escapeXML(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNewRenderer(t *testing.T) {
+	fontFile := filepath.Join(t.TempDir(), "font.ttf")
+	if err := os.WriteFile(fontFile, goregular.TTF, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRenderer(fontFile); err != nil {
+		t.Errorf("NewRenderer(ttf) error = %v", err)
+	}
+	if _, err := NewRenderer(filepath.Join(t.TempDir(), "missing.ttc")); err == nil {
+		t.Errorf("NewRenderer(missing) error = nil")
+	}
+	invalid := filepath.Join(t.TempDir(), "invalid.ttf")
+	if err := os.WriteFile(invalid, []byte("not a font"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRenderer(invalid); err == nil {
+		t.Errorf("NewRenderer(invalid) error = nil")
+	}
+}
+
+func render(t *testing.T, r *Renderer, content string, opts Options) []byte {
+	t.Helper()
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := r.Render(&buf, content, &opts); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+// svgPath 二维码模块的路径，不包含 logo 和说明文字
+func svgPath(t *testing.T, data []byte) string {
+	t.Helper()
+	_, rest, ok := strings.Cut(string(data), `<path fill="#000" d="`)
+	if !ok {
+		t.Fatalf("path not found in %s", data)
+	}
+	path, _, _ := strings.Cut(rest, `"`)
+	return path
+}
+
+func TestRender(t *testing.T) {
+	r, err := NewRenderer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "http://weixin.qq.com/q/02abcdefg"
+	logo := image.NewRGBA(image.Rect(0, 0, 40, 20))
+
+	t.Run("png", func(t *testing.T) {
+		captioned := 200 + captionHeight(&Options{Size: 200, Caption: "x"})
+		tests := []struct {
+			name       string
+			opts       Options
+			wantHeight int
+		}{
+			{name: "plain", opts: Options{Size: 200}, wantHeight: 200},
+			{name: "caption", opts: Options{Size: 200, Caption: "store 1"}, wantHeight: captioned},
+			{name: "logo", opts: Options{Size: 200, Logo: logo}, wantHeight: 200},
+			{
+				name:       "long caption",
+				opts:       Options{Size: 200, Caption: strings.Repeat("long caption ", 4)},
+				wantHeight: captioned,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				img, err := png.Decode(bytes.NewReader(render(t, r, content, tt.opts)))
+				if err != nil {
+					t.Fatalf("decode error = %v", err)
+				}
+				if img.Bounds().Dx() != 200 || img.Bounds().Dy() != tt.wantHeight {
+					t.Errorf("bounds = %v, want 200x%d", img.Bounds(), tt.wantHeight)
+				}
+			})
+		}
+	})
+
+	t.Run("svg caption", func(t *testing.T) {
+		short := string(render(t, r, content, Options{Format: FormatSVG, Caption: "A&B"}))
+		if !strings.Contains(short, ">A&amp;B</text>") {
+			t.Errorf("caption not escaped: %s", short)
+		}
+		if strings.Contains(short, "textLength") {
+			t.Errorf("short caption should not be stretched")
+		}
+		long := string(render(t, r, content, Options{Format: FormatSVG, Caption: strings.Repeat("W", maxCaption)}))
+		if !strings.Contains(long, `lengthAdjust="spacingAndGlyphs"`) {
+			t.Errorf("long caption should be compressed")
+		}
+	})
+
+	t.Run("logo raises level", func(t *testing.T) {
+		withLogo := svgPath(t, render(t, r, content, Options{Format: FormatSVG, Level: "L", Logo: logo}))
+		levelQ := svgPath(t, render(t, r, content, Options{Format: FormatSVG, Level: "Q"}))
+		levelL := svgPath(t, render(t, r, content, Options{Format: FormatSVG, Level: "L"}))
+		if withLogo != levelQ {
+			t.Errorf("logo with level L should render as level Q")
+		}
+		if withLogo == levelL {
+			t.Errorf("logo with level L rendered as level L")
+		}
+		levelH := svgPath(t, render(t, r, content, Options{Format: FormatSVG, Level: "H", Logo: logo}))
+		if levelH == levelQ {
+			t.Errorf("level H should not be lowered")
+		}
+	})
+
+	t.Run("size too small", func(t *testing.T) {
+		opts := Options{Size: MinSize}
+		if err := opts.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		err := r.Render(&bytes.Buffer{}, strings.Repeat("x", 500), &opts)
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Render() error = %v, want ErrInvalidOptions", err)
+		}
+	})
+}
@@ -142,3 +142,46 @@ func (rds *RedisClient) DecrBy(key string, value int64) (int64, error) {
 		return v, nil
 	}
 }
+
+// SetNX key不存在时设置value, and expire time
+func (rds *RedisClient) SetNX(key string, value interface{}, expiration time.Duration) (bool, error) {
+	ok, err := rds.Client.SetNX(rds.Context, key, value, expiration).Result()
+	if err != nil {
+		rds.logger.Error("SetNX", zap.Error(err))
+		return false, err
+	}
+	return ok, nil
+}
+
+var compareAndExpireScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+var compareAndDelScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// CompareAndExpire key对应的value等于value时，重新设置过期时间
+func (rds *RedisClient) CompareAndExpire(key string, value string, expiration time.Duration) (bool, error) {
+	n, err := compareAndExpireScript.Run(rds.Context, rds.Client, []string{key},
+		value, expiration.Milliseconds()).Int64()
+	if err != nil {
+		rds.logger.Error("CompareAndExpire", zap.Error(err))
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// CompareAndDel key对应的value等于value时，删除key
+func (rds *RedisClient) CompareAndDel(key string, value string) (bool, error) {
+	n, err := compareAndDelScript.Run(rds.Context, rds.Client, []string{key}, value).Int64()
+	if err != nil {
+		rds.logger.Error("CompareAndDel", zap.Error(err))
+		return false, err
+	}
+	return n == 1, nil
+}